@@ -19,10 +19,7 @@ func main() {
 	ctx := context.Background()
 	log := utils.NewLogger()
 	ackChannel := make(chan kafka.Message, AckChannelBufferSize)
-	reader, err := stream.NewKafkaReader(log, ackChannel)
-	if err != nil {
-		log.WithError(err).Fatal("Could not connect to kafka")
-	}
+	reader, deadLetterQueue := newReader(log, ackChannel)
 
 	projection, err := projection.NewEnrichedEventsProjectionFromEnv(ctx, log, ackChannel)
 	if err != nil {
@@ -30,22 +27,60 @@ func main() {
 	}
 
 	intChannel := make(chan os.Signal, 1)
+	consumeCtx, cancel := context.WithCancel(ctx)
 
 	gracefulShutdown := func() {
-		for sig := range intChannel {
-			log.WithFields(logrus.Fields{
-				"signal": sig,
-			}).Info("captured signal, shutting down")
-			reader.Close(ctx)
-			projection.Close(ctx)
-		}
+		sig := <-intChannel
+		log.WithFields(logrus.Fields{
+			"signal": sig,
+		}).Info("captured signal, shutting down")
+		reader.Close(ctx)
+		// releases writes blocked while the projection is offline
+		cancel()
 	}
 
 	signal.Notify(intChannel, syscall.SIGTERM, syscall.SIGINT)
 	go gracefulShutdown()
 
-	err = reader.Consume(ctx, projection.ProcessMessage)
+	err = run(consumeCtx, reader, projection, deadLetterQueue)
 	if err != nil {
 		log.WithError(err).Fatal(err)
 	}
 }
+
+// Consumes until the reader is closed or its input is exhausted, then flushes
+// pending writes, even once ctx is cancelled
+func run(ctx context.Context, reader stream.EventStreamReader, projection *projection.EnrichedEventsProjection, deadLetterQueue stream.DeadLetterQueue) error {
+	var err error
+	// snapshot reads of batched messages are pipelined
+	if batchReader, ok := reader.(stream.BatchEventStreamReader); ok {
+		err = batchReader.ConsumeBatches(ctx, projection.ProcessMessages)
+	} else {
+		err = reader.Consume(ctx, projection.ProcessMessage)
+	}
+	if err != nil {
+		return err
+	}
+	projection.Close(context.WithoutCancel(ctx))
+	if deadLetterQueue != nil {
+		deadLetterQueue.Close()
+	}
+	return nil
+}
+
+// Reads from kafka, unless an input to replay events from is given
+func newReader(log *logrus.Logger, ackChannel chan kafka.Message) (stream.EventStreamReader, stream.DeadLetterQueue) {
+	if input := os.Getenv(stream.FileReaderInputEnv); input != "" {
+		log.WithField("input", input).Info("replaying events from input")
+		return stream.NewFileReader(log, input, ackChannel), nil
+	}
+	deadLetterQueue, err := stream.NewDeadLetterQueueFromEnv(log)
+	if err != nil {
+		log.WithError(err).Fatal("Could not create dead letter queue")
+	}
+	reader, err := stream.NewKafkaReader(log, ackChannel, deadLetterQueue)
+	if err != nil {
+		log.WithError(err).Fatal("Could not connect to kafka")
+	}
+	return reader, deadLetterQueue
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openshift-assisted/assisted-events-streams/internal/projection"
+	opensearch_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/opensearch"
+	redis_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/redis"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Running the consumer", func() {
+	const groupID = "enriched-events"
+	var (
+		ctx               context.Context
+		logger            *logrus.Logger
+		ctrl              *gomock.Controller
+		broker            *stream.MemoryBroker
+		ackChannel        chan kafka.Message
+		snapshotRepo      *redis_repo.MockSnapshotRepositoryInterface
+		enrichedEventRepo *opensearch_repo.MockEnrichedEventRepositoryInterface
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = logrus.New()
+		logger.Out = io.Discard
+		ctrl = gomock.NewController(GinkgoT())
+		broker = stream.NewMemoryBroker()
+		broker.CreateTopic("events", 1)
+		ackChannel = make(chan kafka.Message, 100)
+		snapshotRepo = redis_repo.NewMockSnapshotRepositoryInterface(ctrl)
+		enrichedEventRepo = opensearch_repo.NewMockEnrichedEventRepositoryInterface(ctrl)
+	})
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	It("should consume batches until the reader is closed and then close the projection", func() {
+		producer := stream.NewKafkaWriterFromProducer(logger, broker.Producer("events"))
+		for _, clusterID := range []string{"c1", "c2", "c3"} {
+			event := types.Event{Name: projection.ClusterState, Payload: map[string]interface{}{"id": clusterID}}
+			Expect(producer.Write(ctx, []byte(clusterID), event)).To(BeNil())
+		}
+		snapshotRepo.EXPECT().SetCluster(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(3)
+		enrichedEventRepo.EXPECT().OnWrite(gomock.Any()).Times(1)
+		enrichedEventRepo.EXPECT().Close(gomock.Any()).Times(1)
+
+		enrichedEventsProjection, err := projection.NewEnrichedEventsProjection(ctx, logger, snapshotRepo, enrichedEventRepo, nil, nil, ackChannel)
+		Expect(err).To(BeNil())
+		reader := stream.NewKafkaReaderFromConsumer(logger, broker.Consumer("events", groupID), &stream.KafkaConfig{ConsumerBatchSize: 10}, ackChannel, nil)
+
+		done := make(chan error)
+		go func() {
+			done <- run(ctx, reader, enrichedEventsProjection, nil)
+		}()
+		Eventually(func() int64 {
+			return broker.CommittedOffset(groupID, "events", 0)
+		}, 5*time.Second).Should(Equal(int64(3)))
+
+		reader.Close(ctx)
+		Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+	})
+})
@@ -19,12 +19,16 @@ func main() {
 	ctx := context.Background()
 	log := utils.NewLogger()
 	ackChannel := make(chan kafka.Message, AckChannelBufferSize)
-	reader, err := stream.NewKafkaReader(log, ackChannel)
+	deadLetterQueue, err := stream.NewDeadLetterQueueFromEnv(log)
+	if err != nil {
+		log.WithError(err).Fatal("Could not create dead letter queue")
+	}
+	reader, err := stream.NewKafkaReader(log, ackChannel, deadLetterQueue)
 	if err != nil {
 		log.WithError(err).Fatal("Could not connect to kafka")
 	}
 
-	onprem := onprem.NewOnPremEventsHydrator(ctx, log, ackChannel)
+	onprem := onprem.NewOnPremEventsHydrator(ctx, log, ackChannel, deadLetterQueue)
 	go onprem.Listen()
 	intChannel := make(chan os.Signal, 1)
 
@@ -35,6 +39,9 @@ func main() {
 			}).Info("captured signal, shutting down")
 			reader.Close(ctx)
 			onprem.Close(ctx)
+			if deadLetterQueue != nil {
+				deadLetterQueue.Close()
+			}
 		}
 	}
 
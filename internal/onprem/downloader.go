@@ -1,6 +1,7 @@
 package onprem
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -9,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/kelseyhightower/envconfig"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
 	"github.com/sirupsen/logrus"
 )
 
@@ -70,9 +72,13 @@ func (d *FileDownloader) DownloadFile(rawFileURL string) (string, error) {
 	resp, err := client.Get(rawFileURL)
 	if err != nil {
 		logger.WithError(err).Error("Failed to download file")
-		return "", err
+		return "", stream.NewRetryableError(err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		logger.WithField("status", resp.StatusCode).Error("Failed to download file")
+		return "", stream.NewRetryableError(fmt.Errorf("failed to download file: %s", resp.Status))
+	}
 
 	file, err := os.Create(filename)
 	if err != nil {
@@ -84,7 +90,7 @@ func (d *FileDownloader) DownloadFile(rawFileURL string) (string, error) {
 	_, err = io.Copy(file, resp.Body)
 	if err != nil {
 		logger.WithError(err).Error("Failed to copy file")
-		return "", err
+		return "", stream.NewRetryableError(err)
 	}
 
 	return filename, nil
@@ -0,0 +1,98 @@
+package onprem
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Hydrating on-prem events end to end", func() {
+	const (
+		sourceTopic      = "onprem-uploads"
+		destinationTopic = "onprem-events"
+		groupID          = "onprem"
+	)
+	var (
+		ctx        context.Context
+		logger     *logrus.Logger
+		broker     *stream.MemoryBroker
+		server     *httptest.Server
+		ackChannel chan kafka.Message
+		hydrator   *OnPremEventsHydrator
+		reader     *stream.KafkaReader
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = logrus.New()
+		logger.Out = io.Discard
+		broker = stream.NewMemoryBroker()
+		server = httptest.NewServer(http.FileServer(http.Dir("testdata")))
+		ackChannel = make(chan kafka.Message, 100)
+
+		hydrator = &OnPremEventsHydrator{
+			ctx:             ctx,
+			logger:          logger,
+			ackChannel:      ackChannel,
+			downloadChannel: make(chan DownloadUrlMessage, 10),
+			untarChannel:    make(chan FilenameMessage, 10),
+			done:            make(chan struct{}, 1),
+			downloader:      NewFileDownloader(logger, GinkgoT().TempDir()),
+			eventExtractor: &EventExtractor{
+				logger:           logger,
+				eventsBufferSize: 1000,
+			},
+			writer: stream.NewKafkaWriterFromProducer(logger, broker.Producer(destinationTopic)),
+		}
+		reader = stream.NewKafkaReaderFromConsumer(logger, broker.Consumer(sourceTopic, groupID), &stream.KafkaConfig{}, ackChannel, nil)
+	})
+	AfterEach(func() {
+		reader.Close(ctx)
+		hydrator.Close(ctx)
+		server.Close()
+	})
+
+	It("should write all events of the uploaded file and commit the upload message", func() {
+		value, err := json.Marshal(OnPremPayload{Url: server.URL + "/" + sampleOnpremTarfile})
+		Expect(err).To(BeNil())
+		skipped := kafka.Message{Value: []byte(`{"url":"ignored"}`), Headers: []kafka.Header{{Key: "service", Value: []byte("other")}}}
+		upload := kafka.Message{Value: value, Headers: []kafka.Header{{Key: "service", Value: []byte("assisted-installer")}}}
+		Expect(broker.Producer(sourceTopic).WriteMessages(ctx, skipped, upload)).To(BeNil())
+
+		go hydrator.Listen()
+		go func() {
+			defer GinkgoRecover()
+			Expect(reader.Consume(ctx, hydrator.ProcessMessage)).To(BeNil())
+		}()
+
+		Eventually(func() int64 {
+			return broker.CommittedOffset(groupID, sourceTopic, 0)
+		}, 10*time.Second).Should(Equal(int64(2)))
+
+		messages := broker.Messages(destinationTopic)
+		Expect(messages).To(HaveLen(expectedTotalEvents))
+		names := map[string]int{}
+		for _, msg := range messages {
+			Expect(string(msg.Key)).To(Equal(expectedClusterID))
+			event := types.Event{}
+			Expect(json.Unmarshal(msg.Value, &event)).To(BeNil())
+			Expect(event.Metadata).To(HaveKey("versions"))
+			names[event.Name]++
+		}
+		Expect(names).To(Equal(map[string]int{
+			"HostState":    expectedHostEvents,
+			"ClusterState": expectedClusterEvents,
+			"InfraEnv":     expectedInfraEnvEvents,
+			"Event":        expectedTotalEvents - expectedHostEvents - expectedClusterEvents - expectedInfraEnvEvents,
+		}))
+	})
+})
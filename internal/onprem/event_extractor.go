@@ -238,10 +238,8 @@ func (e *EventExtractor) transformResourcesToEvents(logger *logrus.Entry, eventT
 			return
 		}
 		if eventTypeMatch.eventName == "ClusterState" {
+			// hosts are kept, oversized messages are offloaded by the writer
 			resource["onprem"] = true
-			// delete hosts, as message could be too big
-			// hosts are covered by the HostState event type
-			delete(resource, "hosts")
 		}
 		event := types.Event{
 			Name:     eventTypeMatch.eventName,
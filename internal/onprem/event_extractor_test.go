@@ -85,6 +85,7 @@ func assertEventIsInRightSequence(event types.EventEnvelope, eventNumber int, ex
 		clusterID, ok := payload["id"].(string)
 		Expect(ok).To(Equal(true))
 		Expect(clusterID).To(Equal(expectedClusterID))
+		Expect(payload).To(HaveKey("hosts"))
 		return
 	}
 	if eventNumber <= (expectedClusterEvents + expectedHostEvents + expectedInfraEnvEvents) {
@@ -3,6 +3,7 @@ package onprem
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/openshift-assisted/assisted-events-streams/internal/types"
@@ -31,6 +32,11 @@ type OnPremEventsHydrator struct {
 	eventExtractor  IEventExtractor
 	downloader      IFileDownloader
 	writer          stream.EventStreamWriter
+	deadLetterQueue stream.DeadLetterQueue
+	// retries of failed downloads, extractions and dead letter queue sends,
+	// as the reader does
+	backoffConfig         stream.BackoffConfig
+	maxProcessingAttempts int
 }
 
 type OnPremPayload struct {
@@ -44,13 +50,23 @@ type ChannelsConfig struct {
 	EventChannelBufferSize    int `envconfig:"EVENT_CHANNEL_BUFFER_SIZE" default:"1000"`
 }
 
-func NewOnPremEventsHydrator(ctx context.Context, logger *logrus.Logger, ackChannel chan kafka.Message) *OnPremEventsHydrator {
+type RetryConfig struct {
+	MaxProcessingAttempts int `envconfig:"KAFKA_MAX_PROCESSING_ATTEMPTS" default:"10"`
+	stream.BackoffConfig
+}
+
+func NewOnPremEventsHydrator(ctx context.Context, logger *logrus.Logger, ackChannel chan kafka.Message, deadLetterQueue stream.DeadLetterQueue) *OnPremEventsHydrator {
 	channelsConfig := &ChannelsConfig{}
 	err := envconfig.Process("", channelsConfig)
 	if err != nil {
 		return nil
 	}
 
+	retryConfig := RetryConfig{}
+	if err := envconfig.Process("", &retryConfig); err != nil {
+		return nil
+	}
+
 	downloadChannel := make(chan DownloadUrlMessage, channelsConfig.DownloadChannelBufferSize)
 	untarChannel := make(chan FilenameMessage, channelsConfig.UntarChannelBufferSize)
 	doneChannel := make(chan struct{}, 1)
@@ -67,15 +83,18 @@ func NewOnPremEventsHydrator(ctx context.Context, logger *logrus.Logger, ackChan
 		logger.WithError(err).Fatal("error initializing kafka writer")
 	}
 	return &OnPremEventsHydrator{
-		ctx:             ctx,
-		logger:          logger,
-		done:            doneChannel,
-		ackChannel:      ackChannel,
-		downloadChannel: downloadChannel,
-		untarChannel:    untarChannel,
-		eventExtractor:  eventExtractor,
-		downloader:      downloader,
-		writer:          writer,
+		ctx:                   ctx,
+		logger:                logger,
+		done:                  doneChannel,
+		ackChannel:            ackChannel,
+		downloadChannel:       downloadChannel,
+		untarChannel:          untarChannel,
+		eventExtractor:        eventExtractor,
+		downloader:            downloader,
+		writer:                writer,
+		deadLetterQueue:       deadLetterQueue,
+		backoffConfig:         retryConfig.BackoffConfig,
+		maxProcessingAttempts: retryConfig.MaxProcessingAttempts,
 	}
 }
 
@@ -106,23 +125,33 @@ func (h *OnPremEventsHydrator) extractEvents(filename string, msg kafka.Message)
 	h.logger.WithFields(logrus.Fields{
 		"filename": filename,
 	}).Debug("extracting events for filename")
-	eventChannel, err := h.eventExtractor.ExtractEvents(filename)
+	var eventChannel chan types.EventEnvelope
+	attempts, err := h.retry(func() error {
+		var err error
+		eventChannel, err = h.eventExtractor.ExtractEvents(filename)
+		if err != nil {
+			h.logger.WithError(err).Warning("error when extracting events")
+		}
+		return err
+	})
 	if err != nil {
-		h.logger.WithError(err).Warning("error when extracting events")
+		h.failMessage(msg, err, attempts)
 		return
 	}
 
+	// hydrated events are part of the same trace as the original message
+	ctx := stream.ContextFromMessage(h.ctx, &msg)
 	for event := range eventChannel {
-		h.notifyEvent(event)
+		h.notifyEvent(ctx, event)
 	}
 	h.ackChannel <- msg
 }
 
-func (h *OnPremEventsHydrator) notifyEvent(envelope types.EventEnvelope) {
+func (h *OnPremEventsHydrator) notifyEvent(ctx context.Context, envelope types.EventEnvelope) {
 	h.logger.WithFields(logrus.Fields{
 		"cluster_id": envelope.Key,
 	}).Debug("notifying event for on-prem cluster")
-	if err := h.writer.Write(h.ctx, envelope.Key, envelope.Event); err != nil {
+	if err := h.writer.Write(ctx, envelope.Key, envelope.Event); err != nil {
 		h.logger.WithError(err).Warning("error when notifying event")
 	}
 }
@@ -131,9 +160,17 @@ func (h *OnPremEventsHydrator) downloadURL(url string, msg kafka.Message) {
 	logger := h.logger.WithField("url", url)
 	logger.Info("downloading file from url")
 
-	downloadedFilename, err := h.downloader.DownloadFile(url)
+	var downloadedFilename string
+	attempts, err := h.retry(func() error {
+		var err error
+		downloadedFilename, err = h.downloader.DownloadFile(url)
+		if err != nil {
+			logger.WithError(err).Warning("error downloading from url")
+		}
+		return err
+	})
 	if err != nil {
-		logger.WithError(err).Warning("error downloading from url")
+		h.failMessage(msg, err, attempts)
 		return
 	}
 	h.untarChannel <- FilenameMessage{Filename: downloadedFilename, Msg: msg}
@@ -150,6 +187,8 @@ func (h *OnPremEventsHydrator) ProcessMessage(ctx context.Context, msg *kafka.Me
 	err := json.Unmarshal(msg.Value, &payload)
 	if err != nil {
 		h.logger.WithError(err).WithField("msg", msg).Warning("could not decode message value")
+		// sends to the dead letter queue are retried, without holding the consumer
+		go h.failMessage(*msg, err, 1)
 
 		return nil // This is a non retry-able error, it will fail systematically
 	}
@@ -164,6 +203,67 @@ func (h *OnPremEventsHydrator) ProcessMessage(ctx context.Context, msg *kafka.Me
 	return nil
 }
 
+// Calls fn until it succeeds or fails with an error that is not retryable, up
+// to the max processing attempts. Returns the number of attempts made
+func (h *OnPremEventsHydrator) retry(fn func() error) (int, error) {
+	backoff := stream.NewExponentialBackoff(h.backoffConfig)
+	attempts := 0
+	for {
+		attempts++
+		err := fn()
+		if err == nil || !stream.IsRetryable(err) || attempts >= h.maxAttempts() {
+			return attempts, err
+		}
+		wait, ok := backoff.Next()
+		if !ok {
+			return attempts, err
+		}
+		select {
+		case <-time.After(wait):
+		case <-h.ctx.Done():
+			return attempts, err
+		}
+	}
+}
+
+func (h *OnPremEventsHydrator) maxAttempts() int {
+	if h.maxProcessingAttempts < 1 {
+		return 1
+	}
+	return h.maxProcessingAttempts
+}
+
+// Messages failing asynchronously are sent to the dead letter queue, when
+// configured, and acked so they don't hold back offset commits. Sends are
+// retried with backoff, messages are dropped if they still fail. Without dead
+// letter queue, messages are left unacked
+func (h *OnPremEventsHydrator) failMessage(msg kafka.Message, cause error, attempts int) {
+	logger := h.logger.WithFields(logrus.Fields{
+		"offset":   msg.Offset,
+		"key":      msg.Key,
+		"attempts": attempts,
+	})
+	if h.deadLetterQueue == nil {
+		logger.WithError(cause).Warning("failed processing message")
+		return
+	}
+	err := stream.RetryWithBackoff(h.ctx, h.backoffConfig, func() error {
+		err := h.deadLetterQueue.Send(h.ctx, &msg, cause, attempts)
+		if err != nil {
+			logger.WithError(err).Warning("could not send message to dead letter queue")
+		}
+		return err
+	})
+	if err != nil {
+		if h.ctx.Err() != nil {
+			// consumed again once restarted
+			return
+		}
+		logger.WithError(err).WithField("cause", cause).Error("could not send message to dead letter queue, dropping message")
+	}
+	h.ackChannel <- msg
+}
+
 func (h *OnPremEventsHydrator) enqueueDownload(fileURL string, msg kafka.Message) {
 	h.logger.WithFields(logrus.Fields{
 		"url": fileURL,
@@ -172,11 +272,6 @@ func (h *OnPremEventsHydrator) enqueueDownload(fileURL string, msg kafka.Message
 }
 
 func shouldProcess(msg *kafka.Message) bool {
-	for _, header := range msg.Headers {
-		if header.Key == "service" && string(header.Value) == "assisted-installer" {
-			return true
-		}
-	}
-
-	return false
+	service, ok := stream.GetHeader(msg, "service")
+	return ok && service == "assisted-installer"
 }
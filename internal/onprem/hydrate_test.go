@@ -2,8 +2,10 @@ package onprem
 
 import (
 	"context"
+	"errors"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
@@ -111,6 +113,138 @@ var _ = Describe("test event hydrator", func() {
 		})
 
 	})
+	When("the message carries a trace context", func() {
+		It("should propagate it to hydrated events", func() {
+			traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+			mockEventExtractor.key = []byte("foobar")
+			mockEventExtractor.event = types.Event{Name: "Event"}
+			mockDownloader.EXPECT().DownloadFile(expectedURL).Times(1).Return(expectedTarFilename, nil)
+			mockDownloader.EXPECT().Close().Times(1).Return()
+			mockWriter.EXPECT().Write(gomock.Any(), mockEventExtractor.key, mockEventExtractor.event).DoAndReturn(
+				func(ctx context.Context, key []byte, value interface{}, headers ...kafka.Header) error {
+					traceContext, ok := stream.TraceContextFromContext(ctx)
+					Expect(ok).To(BeTrue())
+					Expect(traceContext.TraceParent).To(Equal(traceParent))
+					return nil
+				}).Times(1)
+			go hydrator.Listen()
+			message := kafka.Message{
+				Key:     []byte("Foobar"),
+				Value:   []byte("Foobar"),
+				Headers: []kafka.Header{{Key: stream.HeaderTraceParent, Value: []byte(traceParent)}},
+			}
+
+			hydrator.enqueueDownload(expectedURL, message)
+
+			Expect(<-ackChannel).To(Equal(message))
+
+			hydrator.Close(ctx)
+		})
+	})
+	When("download fails and no dead letter queue is configured", func() {
+		It("should leave the message unacked", func() {
+			message := kafka.Message{
+				Key:   []byte("Foobar"),
+				Value: []byte("Foobar"),
+			}
+			mockDownloader.EXPECT().DownloadFile(expectedURL).Times(1).Return("", errors.New("not found"))
+			mockDownloader.EXPECT().Close().Times(1).Return()
+			go hydrator.Listen()
+
+			hydrator.enqueueDownload(expectedURL, message)
+
+			Consistently(ackChannel, "50ms").ShouldNot(Receive())
+
+			hydrator.Close(ctx)
+		})
+	})
+	When("download fails and a dead letter queue is configured", func() {
+		It("should send the message to the dead letter queue and ack it", func() {
+			mockDeadLetter := stream.NewMockDeadLetterQueue(ctrl)
+			hydrator.deadLetterQueue = mockDeadLetter
+			downloadErr := errors.New("not found")
+			message := kafka.Message{
+				Key:   []byte("Foobar"),
+				Value: []byte("Foobar"),
+			}
+			mockDownloader.EXPECT().DownloadFile(expectedURL).Times(1).Return("", downloadErr)
+			mockDownloader.EXPECT().Close().Times(1).Return()
+			mockDeadLetter.EXPECT().Send(ctx, &message, downloadErr, 1).Times(1).Return(nil)
+			mockWriter.EXPECT().Write(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			go hydrator.Listen()
+
+			hydrator.enqueueDownload(expectedURL, message)
+
+			msg := <-ackChannel
+			Expect(msg).To(Equal(message))
+
+			hydrator.Close(ctx)
+		})
+		It("should retry retryable failures and send the message with its attempts", func() {
+			mockDeadLetter := stream.NewMockDeadLetterQueue(ctrl)
+			hydrator.deadLetterQueue = mockDeadLetter
+			hydrator.backoffConfig = stream.BackoffConfig{InitialInterval: time.Millisecond, MaxElapsedTime: time.Second}
+			hydrator.maxProcessingAttempts = 3
+			downloadErr := stream.NewRetryableError(errors.New("service unavailable"))
+			message := kafka.Message{
+				Key:   []byte("Foobar"),
+				Value: []byte("Foobar"),
+			}
+			mockDownloader.EXPECT().DownloadFile(expectedURL).Times(3).Return("", downloadErr)
+			mockDownloader.EXPECT().Close().Times(1).Return()
+			mockDeadLetter.EXPECT().Send(ctx, &message, downloadErr, 3).Times(1).Return(nil)
+			go hydrator.Listen()
+
+			hydrator.enqueueDownload(expectedURL, message)
+
+			Eventually(ackChannel).Should(Receive(Equal(message)))
+
+			hydrator.Close(ctx)
+		})
+		It("should retry sending the message to the dead letter queue", func() {
+			mockDeadLetter := stream.NewMockDeadLetterQueue(ctrl)
+			hydrator.deadLetterQueue = mockDeadLetter
+			hydrator.backoffConfig = stream.BackoffConfig{InitialInterval: time.Millisecond, MaxElapsedTime: time.Second}
+			downloadErr := errors.New("not found")
+			message := kafka.Message{
+				Key:   []byte("Foobar"),
+				Value: []byte("Foobar"),
+			}
+			mockDownloader.EXPECT().DownloadFile(expectedURL).Times(1).Return("", downloadErr)
+			mockDownloader.EXPECT().Close().Times(1).Return()
+			gomock.InOrder(
+				mockDeadLetter.EXPECT().Send(ctx, &message, downloadErr, 1).Times(2).Return(errors.New("kafka unavailable")),
+				mockDeadLetter.EXPECT().Send(ctx, &message, downloadErr, 1).Times(1).Return(nil),
+			)
+			go hydrator.Listen()
+
+			hydrator.enqueueDownload(expectedURL, message)
+
+			Eventually(ackChannel).Should(Receive(Equal(message)))
+
+			hydrator.Close(ctx)
+		})
+		It("should ack the message once retries are exhausted", func() {
+			mockDeadLetter := stream.NewMockDeadLetterQueue(ctrl)
+			hydrator.deadLetterQueue = mockDeadLetter
+			hydrator.backoffConfig = stream.BackoffConfig{InitialInterval: time.Millisecond, Multiplier: 1, MaxElapsedTime: 20 * time.Millisecond}
+			downloadErr := errors.New("not found")
+			message := kafka.Message{
+				Key:   []byte("Foobar"),
+				Value: []byte("Foobar"),
+			}
+			mockDownloader.EXPECT().DownloadFile(expectedURL).Times(1).Return("", downloadErr)
+			mockDownloader.EXPECT().Close().Times(1).Return()
+			mockDeadLetter.EXPECT().Send(ctx, &message, downloadErr, 1).MinTimes(2).Return(errors.New("kafka unavailable"))
+			go hydrator.Listen()
+
+			hydrator.enqueueDownload(expectedURL, message)
+
+			Eventually(ackChannel).Should(Receive(Equal(message)))
+
+			hydrator.Close(ctx)
+		})
+	})
 })
 
 func TestOnPrem(t *testing.T) {
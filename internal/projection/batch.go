@@ -0,0 +1,107 @@
+package projection
+
+import (
+	"context"
+
+	"github.com/openshift-assisted/assisted-events-streams/internal/projection/process"
+	redis_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/redis"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+type prefetchedSnapshotKey struct{}
+
+// Processes messages in order, as ProcessMessage does. Snapshots of the
+// clusters of consecutive cluster events are read at once beforehand. Returns
+// the number of messages processed before failing
+func (p *EnrichedEventsProjection) ProcessMessages(ctx context.Context, msgs []*kafka.Message) (int, error) {
+	// tombstones and malformed messages are left to ProcessMessage
+	events := make([]*types.Event, len(msgs))
+	for i, msg := range msgs {
+		if isTombstone(msg) {
+			continue
+		}
+		event, err := getEventFromMessage(stream.ContextFromMessage(ctx, msg), p.codec, msg)
+		if err == nil {
+			events[i] = event
+		}
+	}
+
+	for start := 0; start < len(msgs); {
+		end := p.getPrefetchEnd(events, start)
+		var snapshots []*redis_repo.ClusterSnapshot
+		if end > start {
+			snapshots = p.prefetchSnapshots(ctx, events[start:end])
+		} else {
+			end = start + 1
+		}
+		for i := start; i < end; i++ {
+			msgCtx := stream.ContextFromMessage(ctx, msgs[i])
+			var err error
+			switch {
+			case events[i] == nil:
+				err = p.ProcessMessage(msgCtx, msgs[i])
+			case snapshots != nil:
+				err = p.ProcessEvent(context.WithValue(msgCtx, prefetchedSnapshotKey{}, snapshots[i-start]), events[i], msgs[i])
+			default:
+				err = p.ProcessEvent(msgCtx, events[i], msgs[i])
+			}
+			if err != nil {
+				return i, err
+			}
+		}
+		start = end
+	}
+	return len(msgs), nil
+}
+
+// End of the cluster events from start whose snapshots can be read
+// beforehand, as processing them does not change snapshots. Deregistrations
+// do, so they are the last ones
+func (p *EnrichedEventsProjection) getPrefetchEnd(events []*types.Event, start int) int {
+	if p.replayMode {
+		return start
+	}
+	end := start
+	for end < len(events) && isClusterBoundEvent(events[end]) {
+		end++
+		name, _ := process.GetValueFromPayload("name", events[end-1].Payload)
+		if _, ok := p.deregistrationEvents[name]; ok {
+			break
+		}
+	}
+	return end
+}
+
+func isClusterBoundEvent(event *types.Event) bool {
+	if event == nil || event.Name != ClusterEvent {
+		return false
+	}
+	_, err := process.GetValueFromPayload("cluster_id", event.Payload)
+	return err == nil
+}
+
+// Snapshots of the clusters of the events, in order. When they cannot be read
+// at once, nil is returned and each event reads its own
+func (p *EnrichedEventsProjection) prefetchSnapshots(ctx context.Context, events []*types.Event) []*redis_repo.ClusterSnapshot {
+	clusterIDs := make([]string, len(events))
+	for i, event := range events {
+		clusterIDs[i], _ = process.GetValueFromPayload("cluster_id", event.Payload)
+	}
+	snapshots, err := p.snapshotRepository.GetClusterSnapshots(ctx, clusterIDs)
+	if err != nil || len(snapshots) != len(events) {
+		p.logger.WithError(err).Warn("could not read snapshots of the batch, reading them by event")
+		return nil
+	}
+	return snapshots
+}
+
+// Snapshot read beforehand for the event being processed, if any
+func getPrefetchedSnapshot(ctx context.Context, clusterID string) (*redis_repo.ClusterSnapshot, bool) {
+	snapshot, ok := ctx.Value(prefetchedSnapshotKey{}).(*redis_repo.ClusterSnapshot)
+	if !ok || snapshot == nil || snapshot.ClusterID != clusterID {
+		return nil, false
+	}
+	return snapshot, true
+}
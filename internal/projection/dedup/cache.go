@@ -0,0 +1,114 @@
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	key         string
+	fingerprint uint64
+	source      string
+	expiresAt   time.Time
+	stored      bool
+}
+
+// Remembers documents written recently, by id and content fingerprint, so
+// that identical re-deliveries can be skipped once the first copy is stored.
+// Entries expire after ttl and the oldest ones are evicted beyond maxEntries
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// oldest first, entries share the same ttl so they expire in order
+	order   *list.List
+	skipped int64
+}
+
+func NewCache(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+// Reports whether the same document was stored within the ttl, or is being
+// stored from the same source, and the source it was first seen from.
+// Remembers it otherwise, until Stored or Forget are called
+func (c *Cache) Seen(key string, fingerprint uint64, source string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.evictExpired(now)
+	if element, ok := c.entries[key]; ok {
+		e := element.Value.(*entry)
+		if e.fingerprint == fingerprint && (e.stored || e.source == source) {
+			c.skipped++
+			return e.source, true
+		}
+		// copies from other sources are written too while the first one
+		// may still fail
+		if e.fingerprint == fingerprint {
+			return "", false
+		}
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+	c.entries[key] = c.order.PushBack(&entry{key: key, fingerprint: fingerprint, source: source, expiresAt: now.Add(c.ttl)})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Front())
+	}
+	return "", false
+}
+
+// Marks the document as stored, so that copies from other sources are skipped
+func (c *Cache) Stored(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*entry).stored = true
+	}
+}
+
+// Forgets the document, i.e. when writing it failed
+func (c *Cache) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+// Number of documents skipped since start
+func (c *Cache) Skipped() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skipped
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *Cache) evictExpired(now time.Time) {
+	for element := c.order.Front(); element != nil; element = c.order.Front() {
+		if element.Value.(*entry).expiresAt.After(now) {
+			return
+		}
+		c.remove(element)
+	}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
@@ -0,0 +1,89 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deduplicating documents", func() {
+	var (
+		cache *Cache
+		now   time.Time
+	)
+	BeforeEach(func() {
+		now = time.Date(2023, 1, 20, 2, 0, 0, 0, time.UTC)
+		cache = NewCache(time.Minute, 2)
+		cache.now = func() time.Time { return now }
+	})
+
+	seen := func(key string, fingerprint uint64) bool {
+		_, ok := cache.Seen(key, fingerprint, "events/0/1")
+		return ok
+	}
+	store := func(key string, fingerprint uint64, source string) {
+		_, ok := cache.Seen(key, fingerprint, source)
+		Expect(ok).To(BeFalse())
+		cache.Stored(key)
+	}
+
+	It("should skip identical documents", func() {
+		Expect(seen("a", 1)).To(BeFalse())
+		Expect(seen("a", 1)).To(BeTrue())
+		Expect(cache.Skipped()).To(Equal(int64(1)))
+	})
+
+	It("should not skip documents with the same id and a different content", func() {
+		Expect(seen("a", 1)).To(BeFalse())
+		Expect(seen("a", 2)).To(BeFalse())
+		Expect(seen("a", 2)).To(BeTrue())
+		Expect(cache.Len()).To(Equal(1))
+	})
+
+	It("should tell the source documents were first seen from", func() {
+		store("a", 1, "events/0/1")
+		source, ok := cache.Seen("a", 1, "events/0/2")
+		Expect(ok).To(BeTrue())
+		Expect(source).To(Equal("events/0/1"))
+	})
+
+	It("should not skip copies from other sources until the document is stored", func() {
+		_, ok := cache.Seen("a", 1, "events/0/1")
+		Expect(ok).To(BeFalse())
+		_, ok = cache.Seen("a", 1, "events/0/2")
+		Expect(ok).To(BeFalse())
+
+		cache.Stored("a")
+		source, ok := cache.Seen("a", 1, "events/0/2")
+		Expect(ok).To(BeTrue())
+		Expect(source).To(Equal("events/0/1"))
+	})
+
+	It("should forget documents once expired", func() {
+		Expect(seen("a", 1)).To(BeFalse())
+		now = now.Add(time.Minute)
+		Expect(seen("a", 1)).To(BeFalse())
+	})
+
+	It("should evict the oldest documents when full", func() {
+		Expect(seen("a", 1)).To(BeFalse())
+		Expect(seen("b", 1)).To(BeFalse())
+		Expect(seen("c", 1)).To(BeFalse())
+		Expect(cache.Len()).To(Equal(2))
+		Expect(seen("a", 1)).To(BeFalse())
+		Expect(seen("c", 1)).To(BeTrue())
+	})
+
+	It("should forget documents on demand", func() {
+		Expect(seen("a", 1)).To(BeFalse())
+		cache.Forget("a")
+		Expect(seen("a", 1)).To(BeFalse())
+	})
+})
+
+func TestDedup(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dedup cache")
+}
@@ -0,0 +1,397 @@
+package projection
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	opensearch "github.com/opensearch-project/opensearch-go"
+	opensearch_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/opensearch"
+	redis_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/redis"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Consuming events end to end", func() {
+	const (
+		clusterID = "e4a7ba3b-4b4e-4d5b-9e3c-3f2e6c1d7a10"
+		hostID    = "9d0a6c0e-1f27-4e8a-8a4f-0c5b1f3e2d11"
+		groupID   = "enriched-events"
+	)
+	var (
+		ctx        context.Context
+		logger     *logrus.Logger
+		broker     *stream.MemoryBroker
+		fakeServer *fakeOpensearch
+		ackChannel chan kafka.Message
+		projection *EnrichedEventsProjection
+		reader     *stream.KafkaReader
+		env        map[string]string
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = logrus.New()
+		logger.Out = io.Discard
+		broker = stream.NewMemoryBroker()
+		broker.CreateTopic("events", 2)
+		fakeServer = newFakeOpensearch()
+		env = map[string]string{
+			"OPENSEARCH_ADDRESS":             fakeServer.server.URL,
+			"OPENSEARCH_USERNAME":            "admin",
+			"OPENSEARCH_PASSWORD":            "admin",
+			"OPENSEARCH_INDEX_PREFIX":        "assisted-service-events-",
+			"OPENSEARCH_CONFIG_INDEX":        "assisted-service-config",
+			"OPENSEARCH_BULK_FLUSH_INTERVAL": "50ms",
+		}
+		for key, value := range env {
+			os.Setenv(key, value)
+		}
+
+		ackChannel = make(chan kafka.Message, 100)
+		client, err := fakeServer.client()
+		Expect(err).To(BeNil())
+		enrichedEventRepo := opensearch_repo.NewEnrichedEventRepository(logger, client, env["OPENSEARCH_INDEX_PREFIX"], ackChannel)
+		clusterRepo := opensearch_repo.NewClusterRepository(logger, client, "clusters", ackChannel)
+		projection, err = NewEnrichedEventsProjection(ctx, logger, newMemorySnapshotRepository(), enrichedEventRepo, clusterRepo, nil, ackChannel)
+		Expect(err).To(BeNil())
+		reader = nil
+	})
+	AfterEach(func() {
+		if reader != nil {
+			reader.Close(ctx)
+		}
+		projection.Close(ctx)
+		fakeServer.server.Close()
+		for key := range env {
+			os.Unsetenv(key)
+		}
+	})
+
+	expectEnrichedEventsIndexed := func(consume func() error) {
+		producer := stream.NewKafkaWriterFromProducer(logger, broker.Producer("events"))
+		for _, event := range []types.Event{
+			{Name: ClusterState, Payload: map[string]interface{}{"id": clusterID, "name": "e2e", "status": "ready"}},
+			{Name: HostState, Payload: map[string]interface{}{"id": hostID, "cluster_id": clusterID, "status": "known"}},
+			{Name: ClusterEvent, Payload: map[string]interface{}{
+				"cluster_id": clusterID,
+				"event_time": "2023-01-20T02:19:23.972Z",
+				"message":    "Successfully registered cluster",
+				"name":       "cluster_registration_succeeded",
+				"severity":   "info",
+			}},
+		} {
+			Expect(producer.Write(ctx, []byte(clusterID), event)).To(BeNil())
+		}
+
+		go func() {
+			defer GinkgoRecover()
+			Expect(consume()).To(BeNil())
+		}()
+
+		events := func() []indexedDocument { return fakeServer.documents("assisted-service-events-2023-01") }
+		Eventually(events, 5*time.Second).Should(HaveLen(1))
+		document := events()[0]
+		Expect(document.index).To(Equal("assisted-service-events-2023-01"))
+		Expect(document.body["cluster_id"]).To(Equal(clusterID))
+		Expect(document.body["name"]).To(Equal("cluster_registration_succeeded"))
+		Expect(document.body["cluster"]).To(HaveKeyWithValue("name", "e2e"))
+		Expect(document.body["host_summary"]).To(HaveKeyWithValue("host_count", BeNumerically("==", 1)))
+
+		clusters := func() []indexedDocument { return fakeServer.documents("clusters") }
+		Eventually(clusters, 5*time.Second).Should(HaveLen(2))
+		cluster := clusters()[1]
+		Expect(cluster.id).To(Equal(clusterID))
+		Expect(cluster.body["cluster"]).To(HaveKeyWithValue("status", "ready"))
+		Expect(cluster.body["host_summary"]).To(HaveKeyWithValue("host_count", BeNumerically("==", 1)))
+
+		messages := broker.Messages("events")
+		Expect(messages).To(HaveLen(3))
+		Eventually(func() int64 {
+			return broker.CommittedOffset(groupID, "events", messages[0].Partition)
+		}, 5*time.Second).Should(Equal(int64(3)))
+	}
+
+	It("should index enriched events and commit all offsets once indexed", func() {
+		reader = stream.NewKafkaReaderFromConsumer(logger, broker.Consumer("events", groupID), &stream.KafkaConfig{}, ackChannel, nil)
+		expectEnrichedEventsIndexed(func() error {
+			return reader.Consume(ctx, projection.ProcessMessage)
+		})
+	})
+
+	It("should index the same when consuming batches", func() {
+		reader = stream.NewKafkaReaderFromConsumer(logger, broker.Consumer("events", groupID), &stream.KafkaConfig{ConsumerBatchSize: 10}, ackChannel, nil)
+		expectEnrichedEventsIndexed(func() error {
+			return reader.ConsumeBatches(ctx, projection.ProcessMessages)
+		})
+	})
+})
+
+// Serves bulk requests, indexing every document successfully
+type fakeOpensearch struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	docs   []indexedDocument
+}
+
+type indexedDocument struct {
+	index string
+	id    string
+	body  map[string]interface{}
+}
+
+func newFakeOpensearch() *fakeOpensearch {
+	f := &fakeOpensearch{}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+func (f *fakeOpensearch) client() (*opensearch.Client, error) {
+	return opensearch.NewClient(opensearch.Config{
+		Addresses: []string{f.server.URL},
+	})
+}
+
+func (f *fakeOpensearch) documents(index string) []indexedDocument {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var documents []indexedDocument
+	for _, document := range f.docs {
+		if document.index == index {
+			documents = append(documents, document)
+		}
+	}
+	return documents
+}
+
+func (f *fakeOpensearch) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		fmt.Fprint(w, `{"version":{"number":"2.5.0","distribution":"opensearch"}}`)
+		return
+	}
+	items := []map[string]interface{}{}
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+	for scanner.Scan() {
+		action := map[string]map[string]string{}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil || !scanner.Scan() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		document := indexedDocument{
+			index: action["index"]["_index"],
+			id:    action["index"]["_id"],
+			body:  map[string]interface{}{},
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &document.body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.docs = append(f.docs, document)
+		f.mu.Unlock()
+		items = append(items, map[string]interface{}{
+			"index": map[string]interface{}{"_index": document.index, "_id": document.id, "status": http.StatusCreated},
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": false, "items": items})
+}
+
+// Keeps snapshots in memory, like the redis repository does
+type memorySnapshotRepository struct {
+	mu           sync.Mutex
+	clusters     map[string]map[string]interface{}
+	hosts        map[string]map[string]map[string]interface{}
+	infraEnvs    map[string]map[string]map[string]interface{}
+	unboundHosts map[string]map[string]map[string]interface{}
+	infraEnvByID map[string]map[string]interface{}
+}
+
+func newMemorySnapshotRepository() *memorySnapshotRepository {
+	return &memorySnapshotRepository{
+		clusters:     map[string]map[string]interface{}{},
+		hosts:        map[string]map[string]map[string]interface{}{},
+		infraEnvs:    map[string]map[string]map[string]interface{}{},
+		unboundHosts: map[string]map[string]map[string]interface{}{},
+		infraEnvByID: map[string]map[string]interface{}{},
+	}
+}
+
+func snapshotFromEvent(event *types.Event) (map[string]interface{}, error) {
+	snapshot := map[string]interface{}{}
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, json.Unmarshal(data, &snapshot)
+}
+
+func (s *memorySnapshotRepository) set(resources map[string]map[string]map[string]interface{}, parentID, id string, event *types.Event) error {
+	snapshot, err := snapshotFromEvent(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := resources[parentID]; !ok {
+		resources[parentID] = map[string]map[string]interface{}{}
+	}
+	resources[parentID][id] = snapshot
+	return nil
+}
+
+func (s *memorySnapshotRepository) list(resources map[string]map[string]map[string]interface{}, parentID string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var snapshots []map[string]interface{}
+	for _, snapshot := range resources[parentID] {
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+func (s *memorySnapshotRepository) SetCluster(ctx context.Context, clusterID string, event *types.Event) error {
+	snapshot, err := snapshotFromEvent(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clusters[clusterID] = snapshot
+	return nil
+}
+
+func (s *memorySnapshotRepository) SetHost(ctx context.Context, clusterID, hostID string, event *types.Event) error {
+	s.mu.Lock()
+	for _, hosts := range s.unboundHosts {
+		delete(hosts, hostID)
+	}
+	s.mu.Unlock()
+	return s.set(s.hosts, clusterID, hostID, event)
+}
+
+func (s *memorySnapshotRepository) SetUnboundHost(ctx context.Context, infraEnvID, hostID string, event *types.Event) error {
+	return s.set(s.unboundHosts, infraEnvID, hostID, event)
+}
+
+func (s *memorySnapshotRepository) SetInfraEnv(ctx context.Context, clusterID, infraEnvID string, event *types.Event) error {
+	snapshot, err := snapshotFromEvent(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.infraEnvByID[infraEnvID] = snapshot
+	s.mu.Unlock()
+	if clusterID == "" {
+		return nil
+	}
+	return s.set(s.infraEnvs, clusterID, infraEnvID, event)
+}
+
+func (s *memorySnapshotRepository) GetInfraEnv(ctx context.Context, infraEnvID string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infraEnv, ok := s.infraEnvByID[infraEnvID]
+	if !ok {
+		return map[string]interface{}{}, nil
+	}
+	return infraEnv, nil
+}
+
+func (s *memorySnapshotRepository) GetUnboundHosts(ctx context.Context, infraEnvID string) ([]map[string]interface{}, error) {
+	return s.list(s.unboundHosts, infraEnvID), nil
+}
+
+func (s *memorySnapshotRepository) GetCluster(ctx context.Context, clusterID string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cluster, ok := s.clusters[clusterID]
+	if !ok {
+		return map[string]interface{}{}, nil
+	}
+	return cluster, nil
+}
+
+func (s *memorySnapshotRepository) GetHosts(ctx context.Context, clusterID string) ([]map[string]interface{}, error) {
+	return s.list(s.hosts, clusterID), nil
+}
+
+func (s *memorySnapshotRepository) GetInfraEnvs(ctx context.Context, clusterID string) ([]map[string]interface{}, error) {
+	return s.list(s.infraEnvs, clusterID), nil
+}
+
+func (s *memorySnapshotRepository) DeleteCluster(ctx context.Context, clusterID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clusters, clusterID)
+	delete(s.hosts, clusterID)
+	for infraEnvID := range s.infraEnvs[clusterID] {
+		delete(s.infraEnvByID, infraEnvID)
+	}
+	delete(s.infraEnvs, clusterID)
+	return nil
+}
+
+func (s *memorySnapshotRepository) DeleteHost(ctx context.Context, clusterID, hostID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, hosts := range s.hosts {
+		delete(hosts, hostID)
+	}
+	for _, hosts := range s.unboundHosts {
+		delete(hosts, hostID)
+	}
+	return nil
+}
+
+func (s *memorySnapshotRepository) DeleteInfraEnv(ctx context.Context, infraEnvID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.infraEnvByID, infraEnvID)
+	delete(s.unboundHosts, infraEnvID)
+	for _, infraEnvs := range s.infraEnvs {
+		delete(infraEnvs, infraEnvID)
+	}
+	return nil
+}
+
+func (s *memorySnapshotRepository) GetClusterSnapshots(ctx context.Context, clusterIDs []string) ([]*redis_repo.ClusterSnapshot, error) {
+	snapshots := make([]*redis_repo.ClusterSnapshot, len(clusterIDs))
+	for i, clusterID := range clusterIDs {
+		cluster, _ := s.GetCluster(ctx, clusterID)
+		hosts, _ := s.GetHosts(ctx, clusterID)
+		infraEnvs, _ := s.GetInfraEnvs(ctx, clusterID)
+		snapshots[i] = &redis_repo.ClusterSnapshot{
+			ClusterID: clusterID,
+			Cluster:   cluster,
+			Hosts:     hosts,
+			InfraEnvs: infraEnvs,
+		}
+	}
+	return snapshots, nil
+}
+
+// No history is kept, the latest state is returned
+func (s *memorySnapshotRepository) GetClusterAt(ctx context.Context, clusterID string, t time.Time) (map[string]interface{}, error) {
+	return s.GetCluster(ctx, clusterID)
+}
+
+func (s *memorySnapshotRepository) GetHostsAt(ctx context.Context, clusterID string, t time.Time) ([]map[string]interface{}, error) {
+	return s.GetHosts(ctx, clusterID)
+}
+
+func (s *memorySnapshotRepository) GetInfraEnvsAt(ctx context.Context, clusterID string, t time.Time) ([]map[string]interface{}, error) {
+	return s.GetInfraEnvs(ctx, clusterID)
+}
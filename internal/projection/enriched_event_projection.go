@@ -3,14 +3,20 @@ package projection
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"slices"
+	"hash/fnv"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
+	"github.com/openshift-assisted/assisted-events-streams/internal/projection/dedup"
+	"github.com/openshift-assisted/assisted-events-streams/internal/projection/filter"
+	"github.com/openshift-assisted/assisted-events-streams/internal/projection/lifecycle"
 	"github.com/openshift-assisted/assisted-events-streams/internal/projection/process"
 	opensearch_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/opensearch"
 	redis_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/redis"
 	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
 	kafka "github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 )
@@ -20,18 +26,44 @@ const (
 	ClusterState  = "ClusterState"
 	HostState     = "HostState"
 	InfraEnvState = "InfraEnv"
-
-	userName = "user_name"
 )
 
 //go:generate mockgen -source=enriched_event_projection.go -package=projection -destination=mock_event_enricher.go
 
 type EventEnricherInterface interface {
 	GetEnrichedEvent(event *types.Event, cluster map[string]interface{}, hosts []map[string]interface{}, infraEnvs []map[string]interface{}) *types.EnrichedEvent
+	GetClusterDocument(clusterID string, cluster map[string]interface{}, hosts []map[string]interface{}, infraEnvs []map[string]interface{}) *types.ClusterDocument
 }
 
 type ProjectionConfig struct {
-	ExcludedUserNames []string `envconfig:"EXCLUDED_USER_NAMES" default:""`
+	// events of clusters and infra-envs owned by these users are excluded, as
+	// filter rules on cluster.user_name and infra_env.user_name evaluated
+	// after the configured ones
+	ExcludedUserNames  []string      `envconfig:"EXCLUDED_USER_NAMES" default:""`
+	ConfigPollInterval time.Duration `envconfig:"PROJECTION_CONFIG_POLL_INTERVAL" default:"30s"`
+	OfflineBufferSize  int           `envconfig:"PROJECTION_OFFLINE_BUFFER_SIZE" default:"1000"`
+	// rules are read from the config document when no file is given
+	FilterRulesFile string `envconfig:"FILTER_RULES_FILE" default:""`
+	// events after which the resource they refer to is deleted
+	ClusterDeregistrationEventNames  []string `envconfig:"CLUSTER_DEREGISTRATION_EVENT_NAMES" default:"cluster_deregistered"`
+	HostDeregistrationEventNames     []string `envconfig:"HOST_DEREGISTRATION_EVENT_NAMES" default:"host_deregistered"`
+	InfraEnvDeregistrationEventNames []string `envconfig:"INFRA_ENV_DEREGISTRATION_EVENT_NAMES" default:"infra_env_deregistered"`
+	// enrich with the state as of the event time, i.e. after resetting offsets
+	ReplayMode bool `envconfig:"PROJECTION_REPLAY_MODE" default:"false"`
+	// scheme of enriched event ids. The legacy one stays the default, so that
+	// replaying topics already indexed doesn't duplicate their documents. Set
+	// it to 2 once documents indexed so far were migrated by their legacy_id
+	EventIDVersion int `envconfig:"ENRICHED_EVENT_ID_VERSION" default:"1"`
+	// identical enriched events stored within the ttl are skipped, 0 disables it
+	DedupCacheTTL  time.Duration `envconfig:"DEDUP_CACHE_TTL" default:"5m"`
+	DedupCacheSize int           `envconfig:"DEDUP_CACHE_SIZE" default:"10000"`
+	// what to do with events of unknown names: ignore, pass-through or fail
+	UnknownEventPolicy string `envconfig:"UNKNOWN_EVENT_POLICY" default:"ignore"`
+	// policies of given event names, i.e. HostState:ignore,ManifestState:pass-through.
+	// Only known names can be processed
+	EventPolicies map[string]string `envconfig:"EVENT_POLICIES" default:""`
+	// topic events are consumed from, naming the registry subject of their values
+	EventsTopic string `envconfig:"KAFKA_EVENT_STREAM_TOPIC" default:""`
 }
 
 type EnrichedEventsProjection struct {
@@ -40,36 +72,132 @@ type EnrichedEventsProjection struct {
 	snapshotRepository      redis_repo.SnapshotRepositoryInterface
 	enrichedEventRepository opensearch_repo.EnrichedEventRepositoryInterface
 	ackChannel              chan kafka.Message
-	excludedUserNames       []string
+	eventFilter             *filter.EventFilter
+	codec                   stream.Codec
+	// event name to the kind of resource it deregisters, i.e. HostState
+	deregistrationEvents map[string]string
+	replayMode           bool
+	// optional, enriched events are always stored when not set
+	modeWatcher *ModeWatcher
+	// optional, state events are acked once the cluster document is stored
+	clusterRepository opensearch_repo.ClusterRepositoryInterface
+	// optional, installation attempts are tracked when both are set
+	lifecycleTracker    *lifecycle.Tracker
+	lifecycleRepository opensearch_repo.LifecycleRepositoryInterface
+	// optional, enriched events are always stored when not set
+	dedupCache *dedup.Cache
+	handlers   *HandlerRegistry
 }
 
-func NewEnrichedEventsProjection(logger *logrus.Logger, snapshotRepo redis_repo.SnapshotRepositoryInterface, enrichedEventRepo opensearch_repo.EnrichedEventRepositoryInterface, ackChannel chan kafka.Message) (*EnrichedEventsProjection, error) {
-	eventEnricher := process.NewEventEnricher(logger)
-
+// The latest state of clusters is stored with clusterRepo and the projection
+// mode is polled from configRepo, when given
+func NewEnrichedEventsProjection(ctx context.Context, logger *logrus.Logger, snapshotRepo redis_repo.SnapshotRepositoryInterface, enrichedEventRepo opensearch_repo.EnrichedEventRepositoryInterface, clusterRepo opensearch_repo.ClusterRepositoryInterface, configRepo opensearch_repo.ProjectionConfigRepositoryInterface, ackChannel chan kafka.Message) (*EnrichedEventsProjection, error) {
 	config := ProjectionConfig{}
 
 	err := envconfig.Process("", &config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse env: %w", err)
 	}
+	if !process.IsValidIDVersion(config.EventIDVersion) {
+		return nil, fmt.Errorf("unknown enriched event id version: %d", config.EventIDVersion)
+	}
+	handlers, err := NewHandlerRegistry(HandlerPolicy(config.UnknownEventPolicy))
+	if err != nil {
+		return nil, err
+	}
+	eventEnricher := process.NewEventEnricher(logger, config.EventIDVersion)
+	codec, err := stream.NewCodecFromEnv(config.EventsTopic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create codec: %w", err)
+	}
 
-	return &EnrichedEventsProjection{
+	eventFilter := filter.NewEventFilter(logger, config.ExcludedUserNames)
+	if config.FilterRulesFile != "" {
+		err = eventFilter.WatchFile(config.FilterRulesFile, config.ConfigPollInterval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load filter rules: %w", err)
+		}
+	}
+
+	var dedupCache *dedup.Cache
+	if config.DedupCacheTTL > 0 && config.DedupCacheSize > 0 {
+		dedupCache = dedup.NewCache(config.DedupCacheTTL, config.DedupCacheSize)
+	}
+
+	projection := &EnrichedEventsProjection{
 		logger:                  logger,
 		eventEnricher:           eventEnricher,
 		snapshotRepository:      snapshotRepo,
 		enrichedEventRepository: enrichedEventRepo,
+		clusterRepository:       clusterRepo,
 		ackChannel:              ackChannel,
-		excludedUserNames:       config.ExcludedUserNames,
-	}, nil
+		eventFilter:             eventFilter,
+		codec:                   codec,
+		deregistrationEvents:    getDeregistrationEvents(config),
+		replayMode:              config.ReplayMode,
+		dedupCache:              dedupCache,
+		handlers:                handlers,
+	}
+	err = projection.registerHandlers(handlers)
+	if err != nil {
+		return nil, err
+	}
+	if dedupCache != nil && enrichedEventRepo != nil {
+		enrichedEventRepo.OnWrite(projection.onEnrichedEventWritten)
+	}
+	for name, policy := range config.EventPolicies {
+		if err = handlers.SetPolicy(name, HandlerPolicy(policy)); err != nil {
+			return nil, err
+		}
+	}
+
+	if configRepo != nil {
+		modeWatcher := NewModeWatcher(logger, configRepo, enrichedEventRepo, config.ConfigPollInterval, config.OfflineBufferSize)
+		if config.FilterRulesFile == "" {
+			modeWatcher.OnConfig(eventFilter.OnProjectionConfig)
+		}
+		modeWatcher.Start(ctx)
+		projection.modeWatcher = modeWatcher
+	}
+	return projection, nil
+}
+
+func getDeregistrationEvents(config ProjectionConfig) map[string]string {
+	events := map[string]string{}
+	for kind, names := range map[string][]string{
+		ClusterState:  config.ClusterDeregistrationEventNames,
+		HostState:     config.HostDeregistrationEventNames,
+		InfraEnvState: config.InfraEnvDeregistrationEventNames,
+	} {
+		for _, name := range names {
+			events[name] = kind
+		}
+	}
+	return events
 }
 
 func (p *EnrichedEventsProjection) Close(ctx context.Context) {
+	if p.modeWatcher != nil {
+		p.modeWatcher.Close()
+	}
+	if p.eventFilter != nil {
+		p.eventFilter.Close()
+	}
 	p.enrichedEventRepository.Close(ctx)
+	if p.clusterRepository != nil {
+		p.clusterRepository.Close(ctx)
+	}
+	if p.lifecycleRepository != nil {
+		p.lifecycleRepository.Close(ctx)
+	}
 }
 
 func (p *EnrichedEventsProjection) ProcessMessage(ctx context.Context, msg *kafka.Message) error {
+	if isTombstone(msg) {
+		return p.ProcessTombstone(ctx, msg)
+	}
 
-	event, err := getEventFromMessage(msg)
+	event, err := getEventFromMessage(ctx, p.codec, msg)
 	if err != nil {
 		p.logger.WithError(err).WithFields(logrus.Fields{
 			"message": msg,
@@ -81,25 +209,48 @@ func (p *EnrichedEventsProjection) ProcessMessage(ctx context.Context, msg *kafk
 	return p.ProcessEvent(ctx, event, msg)
 }
 
+// Registers the handlers of the events the projection knows about
+func (p *EnrichedEventsProjection) registerHandlers(registry *HandlerRegistry) error {
+	handlers := map[string]EventHandler{
+		ClusterEvent:  p.handleClusterEvent,
+		ClusterState:  p.handleState(p.ProcessClusterState),
+		HostState:     p.handleState(p.ProcessHostState),
+		InfraEnvState: p.handleState(p.ProcessInfraEnvState),
+	}
+	for name, handler := range handlers {
+		if err := registry.Register(name, HandlerPolicyProcess, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *EnrichedEventsProjection) ProcessEvent(ctx context.Context, event *types.Event, msg *kafka.Message) error {
-	var err error
 	p.logger.WithFields(logrus.Fields{
 		"name": event.Name,
 	}).Debug("processing event")
-	switch event.Name {
-	case ClusterEvent:
-		err = p.ProcessClusterEvent(ctx, event, msg)
-	case ClusterState:
-		err = p.ProcessClusterState(ctx, event)
-	case HostState:
-		err = p.ProcessHostState(ctx, event)
-	case InfraEnvState:
-		err = p.ProcessInfraEnvState(ctx, event)
+	policy, handler, known := p.handlers.Get(event.Name)
+	if !known && p.handlers.Count(event.Name) == 1 {
+		p.logger.WithFields(logrus.Fields{
+			"name":   event.Name,
+			"policy": policy,
+		}).Warn("unknown event name, further ones are only counted")
+	}
+	var err error
+	switch policy {
+	case HandlerPolicyProcess:
+		err = handler(ctx, event, msg)
+	case HandlerPolicyIgnore:
+		p.ackMsg(msg)
+	case HandlerPolicyPassThrough:
+		enrichedEvent := p.eventEnricher.GetEnrichedEvent(event, map[string]interface{}{}, nil, nil)
+		err = p.storeEnrichedEvent(ctx, enrichedEvent, msg)
 	default:
 		return fmt.Errorf("unknown event name: %s (%s)", event.Name, event.Payload)
 	}
-	if event.Name != ClusterEvent {
-		p.ackMsg(msg)
+	// processed again once back online, deregistration included
+	if errors.Is(err, errEventPaused) {
+		return nil
 	}
 	if _, ok := err.(*process.MalformedEventError); ok {
 		p.logger.WithError(err).Warn("malformed event discarded")
@@ -109,6 +260,150 @@ func (p *EnrichedEventsProjection) ProcessEvent(ctx context.Context, event *type
 	return err
 }
 
+// Cluster events are acked once stored
+func (p *EnrichedEventsProjection) handleClusterEvent(ctx context.Context, event *types.Event, msg *kafka.Message) error {
+	err := p.ProcessClusterEvent(ctx, event, msg)
+	if err != nil {
+		return err
+	}
+	return p.ProcessDeregistration(ctx, event)
+}
+
+// State events are acked once the documents of their cluster and of the
+// installation attempts they change are stored, failed messages are retried
+func (p *EnrichedEventsProjection) handleState(processState func(context.Context, *types.Event) error) EventHandler {
+	return func(ctx context.Context, event *types.Event, msg *kafka.Message) error {
+		err := processState(ctx, event)
+		if err != nil {
+			return err
+		}
+		documents, err := p.ProcessLifecycle(ctx, event)
+		if err != nil {
+			return err
+		}
+		msgs := stream.SplitAck(msg, len(documents)+1)
+		for i, document := range documents {
+			document, msg := document, msgs[i+1]
+			err = p.writeDocument(ctx, "lifecycle/"+document.ID, msg, func(ctx context.Context) error {
+				return p.lifecycleRepository.Store(ctx, document, msg)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		msg = msgs[0]
+		var stored bool
+		if event.Name == ClusterState && isDeleted(event.Payload) {
+			stored, err = p.DeleteClusterDocument(ctx, getStateClusterID(event), msg)
+		} else {
+			stored, err = p.ProcessClusterDocument(ctx, getStateClusterID(event), msg, getChangeVersion(event.Payload))
+		}
+		if err == nil && !stored {
+			p.ackMsg(msg)
+		}
+		return err
+	}
+}
+
+func getStateClusterID(event *types.Event) string {
+	key := "cluster_id"
+	if event.Name == ClusterState {
+		key = "id"
+	}
+	clusterID, _ := process.GetValueFromPayload(key, event.Payload)
+	return clusterID
+}
+
+// Stores the latest state of the cluster. Clusters without snapshot, e.g.
+// expired or not received yet, are skipped: documents are only deleted when
+// the cluster is. The document version is at least changeVersion, the time of
+// the change that triggered the write. Returns whether the message is acked by
+// the cluster repository
+func (p *EnrichedEventsProjection) ProcessClusterDocument(ctx context.Context, clusterID string, msg *kafka.Message, changeVersion int64) (bool, error) {
+	if p.clusterRepository == nil || clusterID == "" {
+		return false, nil
+	}
+	logger := p.logger.WithFields(logrus.Fields{
+		"cluster_id": clusterID,
+	})
+	cluster, err := p.snapshotRepository.GetCluster(ctx, clusterID)
+	if err != nil {
+		return false, err
+	}
+	if len(cluster) == 0 {
+		logger.Debug("no cluster snapshot, skipping cluster document")
+		return false, nil
+	}
+	if p.excludeEvent(&filter.Document{Cluster: cluster}) {
+		return false, nil
+	}
+
+	hosts, err := p.snapshotRepository.GetHosts(ctx, clusterID)
+	if err != nil {
+		logger.WithError(err).Warn("Could not retrieve hosts")
+	}
+	infraEnvs, err := p.snapshotRepository.GetInfraEnvs(ctx, clusterID)
+	if err != nil {
+		logger.WithError(err).Warn("Could not retrieve infraEnvs")
+	}
+	clusterDocument := p.eventEnricher.GetClusterDocument(clusterID, cluster, hosts, infraEnvs)
+	// deleting the latest updated resource would lower the version otherwise
+	if clusterDocument.Version < changeVersion {
+		clusterDocument.Version = changeVersion
+	}
+	return true, p.writeDocument(ctx, "cluster/"+clusterID, msg, func(ctx context.Context) error {
+		return p.clusterRepository.Store(ctx, clusterDocument, msg)
+	})
+}
+
+// Deletes the document of a deleted cluster. Returns whether the message is
+// acked by the cluster repository
+func (p *EnrichedEventsProjection) DeleteClusterDocument(ctx context.Context, clusterID string, msg *kafka.Message) (bool, error) {
+	if p.clusterRepository == nil || clusterID == "" {
+		return false, nil
+	}
+	p.logger.WithField("cluster_id", clusterID).Debug("deleting cluster document")
+	return msg != nil, p.writeDocument(ctx, "cluster/"+clusterID, msg, func(ctx context.Context) error {
+		return p.clusterRepository.Delete(ctx, clusterID, msg)
+	})
+}
+
+// Tracks status changes of clusters and their hosts, returning the documents
+// of the installation attempts they change
+func (p *EnrichedEventsProjection) ProcessLifecycle(ctx context.Context, event *types.Event) ([]*types.LifecycleDocument, error) {
+	if p.lifecycleTracker == nil || p.lifecycleRepository == nil || isDeleted(event.Payload) {
+		return nil, nil
+	}
+	clusterID := getStateClusterID(event)
+	if clusterID == "" {
+		return nil, nil
+	}
+	switch event.Name {
+	case ClusterState:
+		return p.lifecycleTracker.TrackCluster(ctx, clusterID, event.Payload)
+	case HostState:
+		hostID, err := process.GetValueFromPayload("id", event.Payload)
+		if err != nil {
+			return nil, nil
+		}
+		return p.lifecycleTracker.TrackHost(ctx, clusterID, hostID, event.Payload)
+	}
+	return nil, nil
+}
+
+// Writes the document with the given key, acking msg. While offline only the
+// latest write of each document is kept, the messages of replaced ones are acked
+func (p *EnrichedEventsProjection) writeDocument(ctx context.Context, key string, msg *kafka.Message, write func(context.Context) error) error {
+	if p.modeWatcher != nil {
+		return p.modeWatcher.RunLatest(ctx, key, write, func() {
+			if msg != nil {
+				p.ackMsg(msg)
+			}
+		})
+	}
+	return write(ctx)
+}
+
 func (p *EnrichedEventsProjection) ackMsg(msg *kafka.Message) {
 	p.ackChannel <- *msg
 }
@@ -116,36 +411,46 @@ func (p *EnrichedEventsProjection) ackMsg(msg *kafka.Message) {
 func (p *EnrichedEventsProjection) ProcessClusterEvent(ctx context.Context, event *types.Event, msg *kafka.Message) error {
 	clusterID, err := process.GetValueFromPayload("cluster_id", event.Payload)
 	if err != nil {
-		return err
+		infraEnvID, infraEnvErr := process.GetValueFromPayload("infra_env_id", event.Payload)
+		if infraEnvErr != nil {
+			return err
+		}
+		return p.ProcessInfraEnvEvent(ctx, event, infraEnvID, msg)
 	}
 	p.logger.WithFields(logrus.Fields{
 		"name":       event.Name,
 		"cluster_id": clusterID,
 	}).Debug("processing cluster event")
 
-	cluster, err := p.snapshotRepository.GetCluster(ctx, clusterID)
+	cluster, err := p.getCluster(ctx, clusterID, event)
+	if stream.IsRetryable(err) {
+		return err
+	}
 	if err != nil {
 		p.logger.WithFields(logrus.Fields{
 			"cluster_id": clusterID,
 		}).WithError(err).Warn("Could not retrieve cluster")
 	}
 
-	// Hack: Don't process further events from specific users
-	if p.skipEvent(cluster) {
-		p.logger.WithFields(logrus.Fields{
-			"cluster_id": clusterID,
-		}).Debug("skipping event")
+	if p.excludeEvent(&filter.Document{Event: event.Payload, Cluster: cluster}) {
+		p.ackMsg(msg)
 
 		return nil
 	}
 
-	hosts, err := p.snapshotRepository.GetHosts(ctx, clusterID)
+	hosts, err := p.getHosts(ctx, clusterID, event)
+	if stream.IsRetryable(err) {
+		return err
+	}
 	if err != nil {
 		p.logger.WithFields(logrus.Fields{
 			"cluster_id": clusterID,
 		}).WithError(err).Warn("Could not retrieve hosts")
 	}
-	infraEnvs, err := p.snapshotRepository.GetInfraEnvs(ctx, clusterID)
+	infraEnvs, err := p.getInfraEnvs(ctx, clusterID, event)
+	if stream.IsRetryable(err) {
+		return err
+	}
 	if err != nil {
 		p.logger.WithFields(logrus.Fields{
 			"cluster_id": clusterID,
@@ -153,14 +458,164 @@ func (p *EnrichedEventsProjection) ProcessClusterEvent(ctx context.Context, even
 	}
 
 	enrichedEvent := p.eventEnricher.GetEnrichedEvent(event, cluster, hosts, infraEnvs)
-	err = p.enrichedEventRepository.Store(ctx, enrichedEvent, msg)
-	if err != nil {
+	err = p.storeEnrichedEvent(ctx, enrichedEvent, msg)
+	if err != nil && !errors.Is(err, errEventPaused) {
 		p.logger.WithError(err).Warn("something went wrong while trying to store the event")
 		return err
 	}
 	return nil
 }
 
+// Time as of which the event is enriched, set in replay mode only
+func (p *EnrichedEventsProjection) getReplayTime(event *types.Event) (time.Time, bool) {
+	if !p.replayMode {
+		return time.Time{}, false
+	}
+	eventTime, err := process.GetValueFromPayload("event_time", event.Payload)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, eventTime)
+	if err != nil {
+		p.logger.WithError(err).Debug("could not parse event time, using latest state")
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func (p *EnrichedEventsProjection) getCluster(ctx context.Context, clusterID string, event *types.Event) (map[string]interface{}, error) {
+	if t, ok := p.getReplayTime(event); ok {
+		return p.snapshotRepository.GetClusterAt(ctx, clusterID, t)
+	}
+	if snapshot, ok := getPrefetchedSnapshot(ctx, clusterID); ok {
+		return snapshot.Cluster, nil
+	}
+	return p.snapshotRepository.GetCluster(ctx, clusterID)
+}
+
+func (p *EnrichedEventsProjection) getHosts(ctx context.Context, clusterID string, event *types.Event) ([]map[string]interface{}, error) {
+	if t, ok := p.getReplayTime(event); ok {
+		return p.snapshotRepository.GetHostsAt(ctx, clusterID, t)
+	}
+	if snapshot, ok := getPrefetchedSnapshot(ctx, clusterID); ok {
+		return snapshot.Hosts, nil
+	}
+	return p.snapshotRepository.GetHosts(ctx, clusterID)
+}
+
+func (p *EnrichedEventsProjection) getInfraEnvs(ctx context.Context, clusterID string, event *types.Event) ([]map[string]interface{}, error) {
+	if t, ok := p.getReplayTime(event); ok {
+		return p.snapshotRepository.GetInfraEnvsAt(ctx, clusterID, t)
+	}
+	if snapshot, ok := getPrefetchedSnapshot(ctx, clusterID); ok {
+		return snapshot.InfraEnvs, nil
+	}
+	return p.snapshotRepository.GetInfraEnvs(ctx, clusterID)
+}
+
+// Enriches events of infra-envs not bound to a cluster, i.e. late binding
+// infra-envs, with the infra-env and its unbound hosts
+func (p *EnrichedEventsProjection) ProcessInfraEnvEvent(ctx context.Context, event *types.Event, infraEnvID string, msg *kafka.Message) error {
+	logger := p.logger.WithFields(logrus.Fields{
+		"name":         event.Name,
+		"infra_env_id": infraEnvID,
+	})
+	logger.Debug("processing infra-env event")
+
+	infraEnv, err := p.snapshotRepository.GetInfraEnv(ctx, infraEnvID)
+	if stream.IsRetryable(err) {
+		return err
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Could not retrieve infraEnv")
+	}
+	if p.excludeEvent(&filter.Document{Event: event.Payload, InfraEnv: infraEnv}) {
+		p.ackMsg(msg)
+
+		return nil
+	}
+	var infraEnvs []map[string]interface{}
+	if len(infraEnv) > 0 {
+		infraEnvs = append(infraEnvs, infraEnv)
+	}
+	hosts, err := p.snapshotRepository.GetUnboundHosts(ctx, infraEnvID)
+	if stream.IsRetryable(err) {
+		return err
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Could not retrieve hosts")
+	}
+
+	enrichedEvent := p.eventEnricher.GetEnrichedEvent(event, map[string]interface{}{}, hosts, infraEnvs)
+	err = p.storeEnrichedEvent(ctx, enrichedEvent, msg)
+	if err != nil && !errors.Is(err, errEventPaused) {
+		logger.WithError(err).Warn("something went wrong while trying to store the event")
+		return err
+	}
+	return nil
+}
+
+// Events are enriched with current snapshots even when offline, so that
+// buffered events are stored as they would have been. Paused events are
+// processed again once back online
+func (p *EnrichedEventsProjection) storeEnrichedEvent(ctx context.Context, enrichedEvent *types.EnrichedEvent, msg *kafka.Message) error {
+	if p.skipDuplicate(enrichedEvent, msg) {
+		return nil
+	}
+	var err error
+	if p.modeWatcher != nil {
+		err = p.modeWatcher.Store(ctx, enrichedEvent, msg, func(ctx context.Context) error {
+			return p.ProcessMessage(stream.ContextFromMessage(ctx, msg), msg)
+		})
+	} else {
+		err = p.enrichedEventRepository.Store(ctx, enrichedEvent, msg)
+	}
+	if err != nil && p.dedupCache != nil {
+		p.dedupCache.Forget(enrichedEvent.ID)
+	}
+	return err
+}
+
+// Duplicates are only skipped once the first copy is indexed
+func (p *EnrichedEventsProjection) onEnrichedEventWritten(id string, err error) {
+	if p.dedupCache == nil {
+		return
+	}
+	if err != nil {
+		p.dedupCache.Forget(id)
+		return
+	}
+	p.dedupCache.Stored(id)
+}
+
+// Skips events identical to one stored recently. Re-deliveries are acked, while
+// retries of the same message are acked once the first copy is stored
+func (p *EnrichedEventsProjection) skipDuplicate(enrichedEvent *types.EnrichedEvent, msg *kafka.Message) bool {
+	if p.dedupCache == nil {
+		return false
+	}
+	data, err := json.Marshal(enrichedEvent)
+	if err != nil {
+		return false
+	}
+	fingerprint := fnv.New64a()
+	fingerprint.Write(data)
+	source := fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+	firstSource, seen := p.dedupCache.Seen(enrichedEvent.ID, fingerprint.Sum64(), source)
+	if !seen {
+		return false
+	}
+	p.logger.WithFields(logrus.Fields{
+		"id":      enrichedEvent.ID,
+		"source":  source,
+		"skipped": p.dedupCache.Skipped(),
+	}).Debug("skipping duplicated event")
+	if firstSource != source {
+		p.ackMsg(msg)
+	}
+	return true
+}
+
 func (p *EnrichedEventsProjection) ProcessClusterState(ctx context.Context, event *types.Event) error {
 	clusterID, err := process.GetValueFromPayload("id", event.Payload)
 	if err != nil {
@@ -170,6 +625,9 @@ func (p *EnrichedEventsProjection) ProcessClusterState(ctx context.Context, even
 		"name":       event.Name,
 		"cluster_id": clusterID,
 	}).Debug("processing cluster state")
+	if isDeleted(event.Payload) {
+		return p.snapshotRepository.DeleteCluster(ctx, clusterID)
+	}
 	return p.snapshotRepository.SetCluster(ctx, clusterID, event)
 }
 
@@ -178,9 +636,23 @@ func (p *EnrichedEventsProjection) ProcessHostState(ctx context.Context, event *
 	if err != nil {
 		return err
 	}
+	if isDeleted(event.Payload) {
+		clusterID, _ := process.GetValueFromPayload("cluster_id", event.Payload)
+		return p.snapshotRepository.DeleteHost(ctx, clusterID, hostID)
+	}
 	clusterID, err := process.GetValueFromPayload("cluster_id", event.Payload)
 	if err != nil {
-		return err
+		// hosts of late binding infra-envs are bound to a cluster later on
+		infraEnvID, infraEnvErr := process.GetValueFromPayload("infra_env_id", event.Payload)
+		if infraEnvErr != nil {
+			return err
+		}
+		p.logger.WithFields(logrus.Fields{
+			"name":         event.Name,
+			"host_id":      hostID,
+			"infra_env_id": infraEnvID,
+		}).Debug("processing unbound host state")
+		return p.snapshotRepository.SetUnboundHost(ctx, infraEnvID, hostID, event)
 	}
 	p.logger.WithFields(logrus.Fields{
 		"name":       event.Name,
@@ -195,12 +667,13 @@ func (p *EnrichedEventsProjection) ProcessInfraEnvState(ctx context.Context, eve
 	if err != nil {
 		return err
 	}
-
-	clusterID, err := process.GetValueFromPayload("cluster_id", event.Payload)
-	if err != nil {
-		return err
+	if isDeleted(event.Payload) {
+		return p.snapshotRepository.DeleteInfraEnv(ctx, infraEnvID)
 	}
 
+	// empty for infra-envs not bound to a cluster
+	clusterID, _ := process.GetValueFromPayload("cluster_id", event.Payload)
+
 	p.logger.WithFields(logrus.Fields{
 		"name":         event.Name,
 		"infra_env_id": infraEnvID,
@@ -209,25 +682,213 @@ func (p *EnrichedEventsProjection) ProcessInfraEnvState(ctx context.Context, eve
 	return p.snapshotRepository.SetInfraEnv(ctx, clusterID, infraEnvID, event)
 }
 
-func (p *EnrichedEventsProjection) skipEvent(cluster map[string]interface{}) bool {
-	name, ok := cluster[userName]
+// Deletes the resource a deregistration event refers to, once the event is
+// enriched, so that it does not show up in later events
+func (p *EnrichedEventsProjection) ProcessDeregistration(ctx context.Context, event *types.Event) error {
+	name, err := process.GetValueFromPayload("name", event.Payload)
+	if err != nil {
+		return nil
+	}
+	kind, ok := p.deregistrationEvents[name]
 	if !ok {
+		return nil
+	}
+	p.logger.WithFields(logrus.Fields{
+		"name": name,
+		"kind": kind,
+	}).Debug("processing deregistration event")
+	clusterID, _ := process.GetValueFromPayload("cluster_id", event.Payload)
+	switch kind {
+	case ClusterState:
+		if clusterID == "" {
+			return nil
+		}
+		err := p.snapshotRepository.DeleteCluster(ctx, clusterID)
+		if err != nil {
+			return err
+		}
+		// the message is acked once the enriched event is stored
+		_, err = p.DeleteClusterDocument(ctx, clusterID, nil)
+		return err
+	case HostState:
+		hostID, err := process.GetValueFromPayload("host_id", event.Payload)
+		if err != nil {
+			return nil
+		}
+		return p.snapshotRepository.DeleteHost(ctx, clusterID, hostID)
+	case InfraEnvState:
+		infraEnvID, err := process.GetValueFromPayload("infra_env_id", event.Payload)
+		if err != nil {
+			return nil
+		}
+		return p.snapshotRepository.DeleteInfraEnv(ctx, infraEnvID)
+	}
+	return nil
+}
+
+// Tombstones are messages without value: the notification type header tells
+// the kind of the deleted resource, the id headers which one
+func isTombstone(msg *kafka.Message) bool {
+	if len(msg.Value) > 0 {
 		return false
 	}
+	_, ok := stream.GetHeader(msg, stream.HeaderNotificationType)
+	return ok
+}
+
+func (p *EnrichedEventsProjection) ProcessTombstone(ctx context.Context, msg *kafka.Message) error {
+	clusterID, err := p.processTombstone(ctx, msg)
+	if _, ok := err.(*process.MalformedEventError); ok {
+		p.logger.WithError(err).Warn("malformed tombstone discarded")
+		p.ackMsg(msg)
+		return nil
+	}
+	if err == nil {
+		var stored bool
+		if kind, _ := stream.GetHeader(msg, stream.HeaderNotificationType); kind == ClusterState {
+			stored, err = p.DeleteClusterDocument(ctx, clusterID, msg)
+		} else {
+			// tombstones have no payload, they are produced when the
+			// resource is deleted
+			var changeVersion int64
+			if !msg.Time.IsZero() {
+				changeVersion = msg.Time.UnixMicro()
+			}
+			stored, err = p.ProcessClusterDocument(ctx, clusterID, msg, changeVersion)
+		}
+		if err == nil && !stored {
+			p.ackMsg(msg)
+		}
+	}
+	return err
+}
+
+// Returns the id of the cluster the deleted resource belonged to, if known
+func (p *EnrichedEventsProjection) processTombstone(ctx context.Context, msg *kafka.Message) (string, error) {
+	kind, _ := stream.GetHeader(msg, stream.HeaderNotificationType)
+	clusterID, _ := stream.GetHeader(msg, stream.HeaderClusterID)
+	p.logger.WithFields(logrus.Fields{
+		"kind":       kind,
+		"cluster_id": clusterID,
+	}).Debug("processing tombstone")
+	switch kind {
+	case ClusterState:
+		if clusterID == "" {
+			clusterID = string(msg.Key)
+		}
+		if clusterID == "" {
+			return "", process.NewMalformedEventError("cluster tombstone without cluster id")
+		}
+		return clusterID, p.snapshotRepository.DeleteCluster(ctx, clusterID)
+	case HostState:
+		hostID, ok := stream.GetHeader(msg, stream.HeaderHostID)
+		if !ok || hostID == "" {
+			return "", process.NewMalformedEventError("host tombstone without host id")
+		}
+		return clusterID, p.snapshotRepository.DeleteHost(ctx, clusterID, hostID)
+	case InfraEnvState:
+		infraEnvID, ok := stream.GetHeader(msg, stream.HeaderInfraEnvID)
+		if !ok || infraEnvID == "" {
+			return "", process.NewMalformedEventError("infra-env tombstone without infra-env id")
+		}
+		return clusterID, p.snapshotRepository.DeleteInfraEnv(ctx, infraEnvID)
+	}
+	return "", process.NewMalformedEventError(fmt.Sprintf("tombstone of unknown kind: %s", kind))
+}
+
+// Time of the change carried by a state event in microseconds: when it was
+// deleted if so, last updated otherwise. 0 when unknown
+func getChangeVersion(payload interface{}) int64 {
+	fields, ok := payload.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	var version int64
+	for _, key := range []string{"updated_at", "deleted_at"} {
+		value, ok := fields[key].(string)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err == nil && t.UnixMicro() > version {
+			version = t.UnixMicro()
+		}
+	}
+	return version
+}
 
-	nameStr, ok := name.(string)
+// Soft deleted resources have a non null deleted_at, or DeletedAt when
+// serialized from the database model
+func isDeleted(payload interface{}) bool {
+	fields, ok := payload.(map[string]interface{})
 	if !ok {
 		return false
 	}
+	for _, key := range []string{"deleted_at", "DeletedAt"} {
+		deletedAt, ok := fields[key]
+		if !ok || deletedAt == nil {
+			continue
+		}
+		if value, ok := deletedAt.(string); ok && value == "" {
+			continue
+		}
+		return true
+	}
+	return false
+}
 
-	return slices.Contains(p.excludedUserNames, nameStr)
+func (p *EnrichedEventsProjection) excludeEvent(document *filter.Document) bool {
+	if p.eventFilter == nil {
+		return false
+	}
+	excluded, rule := p.eventFilter.Exclude(document)
+	if excluded {
+		p.logger.WithFields(logrus.Fields{
+			"rule": rule,
+		}).Debug("skipping event")
+	}
+	return excluded
 }
 
-func getEventFromMessage(msg *kafka.Message) (*types.Event, error) {
+// Decodes either a legacy envelope or a CloudEvent in structured or binary mode
+func getEventFromMessage(ctx context.Context, codec stream.Codec, msg *kafka.Message) (*types.Event, error) {
+	cloudEvent, ok, err := stream.ParseCloudEvent(msg)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return getEventFromCloudEvent(ctx, codec, cloudEvent)
+	}
 	event := &types.Event{}
-	err := json.Unmarshal(msg.Value, event)
+	err = decodeValue(ctx, codec, msg.Value, event)
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// Values in schema registry wire format are decoded with the codec, json
+// otherwise, so that producers can switch codec independently
+func decodeValue(ctx context.Context, codec stream.Codec, data []byte, value interface{}) error {
+	if codec != nil && stream.IsFramed(data) {
+		return codec.Unmarshal(ctx, data, value)
+	}
+	return json.Unmarshal(data, value)
+}
+
+func getEventFromCloudEvent(ctx context.Context, codec stream.Codec, cloudEvent *stream.CloudEvent) (*types.Event, error) {
+	event := &types.Event{
+		Name: cloudEvent.Type,
+	}
+	if len(cloudEvent.Data) > 0 {
+		if err := decodeValue(ctx, codec, cloudEvent.Data, &event.Payload); err != nil {
+			return nil, fmt.Errorf("failed to decode cloud event data: %w", err)
+		}
+	}
+	metadata, err := cloudEvent.DecodeMetadata()
 	if err != nil {
 		return nil, err
 	}
+	event.Metadata = metadata
 	return event, nil
 }
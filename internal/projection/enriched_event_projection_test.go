@@ -2,16 +2,23 @@ package projection
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/openshift-assisted/assisted-events-streams/internal/projection/dedup"
+	"github.com/openshift-assisted/assisted-events-streams/internal/projection/filter"
+	"github.com/openshift-assisted/assisted-events-streams/internal/projection/lifecycle"
 	opensearch_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/opensearch"
 	redis_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/redis"
 	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
 	kafka "github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 )
@@ -47,6 +54,10 @@ var _ = Describe("Process message", func() {
 			enrichedEventRepository: mockEnrichedEventRepo,
 			ackChannel:              ackChannel,
 		}
+		handlers, err := NewHandlerRegistry(HandlerPolicyIgnore)
+		Expect(err).To(BeNil())
+		Expect(projection.registerHandlers(handlers)).To(BeNil())
+		projection.handlers = handlers
 
 		mockCluster = getMockCluster()
 		mockHosts = getMockHosts()
@@ -81,6 +92,176 @@ var _ = Describe("Process message", func() {
 		})
 	})
 
+	When("Processing an event of unknown name", func() {
+		var msg *kafka.Message
+		BeforeEach(func() {
+			msg = getKafkaMessage(`{"name": "ManifestState", "payload": {"id": "foo"}}`)
+		})
+
+		It("should ack and count it by default", func() {
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(projection.handlers.Counts()).To(Equal(map[string]int64{"ManifestState": 2}))
+		})
+
+		It("should store it without cluster state when passed through", func() {
+			projection.handlers.defaultPolicy = HandlerPolicyPassThrough
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), map[string]interface{}{}, nil, nil).Times(1).Return(mockEnrichedEvent)
+			mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, msg).Times(1).Return(nil)
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+		})
+
+		It("should fail when configured to", func() {
+			projection.handlers.defaultPolicy = HandlerPolicyFail
+			Expect(projection.ProcessMessage(ctx, msg)).ToNot(BeNil())
+			Expect(ackChannel).To(BeEmpty())
+		})
+	})
+
+	When("Processing an event registered as ignored", func() {
+		It("should ack it without processing it", func() {
+			Expect(projection.handlers.Register(HostState, HandlerPolicyIgnore, nil)).To(BeNil())
+			msg := getKafkaMessage(`{"name": "HostState", "payload": {"id": "foo", "cluster_id": "bar"}}`)
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+			Expect(projection.handlers.Counts()).To(BeEmpty())
+		})
+	})
+
+	When("Processing the same cluster event twice", func() {
+		BeforeEach(func() {
+			projection.dedupCache = dedup.NewCache(time.Minute, 10)
+			mockSnapshotRepo.EXPECT().GetCluster(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(2).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHosts(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(2).Return(mockHosts, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvs(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(2).Return(mockInfraEnvs, nil)
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), mockCluster, mockHosts, mockInfraEnvs).Times(2).Return(mockEnrichedEvent)
+		})
+
+		It("should store it once and ack the re-delivery", func() {
+			msg := getKafkaMessage(getBasicClusterEventPayload())
+			redelivered := getKafkaMessage(getBasicClusterEventPayload())
+			redelivered.Offset = msg.Offset + 1
+
+			mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, msg).Times(1).Return(nil)
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			projection.onEnrichedEventWritten(mockEnrichedEvent.ID, nil)
+			Expect(projection.ProcessMessage(ctx, redelivered)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*redelivered))
+			Expect(projection.dedupCache.Skipped()).To(Equal(int64(1)))
+		})
+
+		It("should store the re-delivery when the first copy failed indexing", func() {
+			msg := getKafkaMessage(getBasicClusterEventPayload())
+			redelivered := getKafkaMessage(getBasicClusterEventPayload())
+			redelivered.Offset = msg.Offset + 1
+
+			gomock.InOrder(
+				mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, msg).Times(1).Return(nil),
+				mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, redelivered).Times(1).Return(nil),
+			)
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			projection.onEnrichedEventWritten(mockEnrichedEvent.ID, errors.New("mapper_parsing_exception"))
+			Expect(projection.ProcessMessage(ctx, redelivered)).To(BeNil())
+			Expect(ackChannel).To(BeEmpty())
+		})
+
+		It("should store the re-delivery while the first copy is not indexed yet", func() {
+			msg := getKafkaMessage(getBasicClusterEventPayload())
+			redelivered := getKafkaMessage(getBasicClusterEventPayload())
+			redelivered.Offset = msg.Offset + 1
+
+			mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, msg).Times(1).Return(nil)
+			mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, redelivered).Times(1).Return(nil)
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(projection.ProcessMessage(ctx, redelivered)).To(BeNil())
+			Expect(ackChannel).To(BeEmpty())
+		})
+
+		It("should leave the ack of a retried message to the first copy", func() {
+			msg := getKafkaMessage(getBasicClusterEventPayload())
+
+			mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, msg).Times(1).Return(nil)
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(ackChannel).To(BeEmpty())
+		})
+	})
+
+	When("Processing a cluster event in replay mode", func() {
+		It("should enrich it with the state as of the event time", func() {
+			projection.replayMode = true
+			msg := getKafkaMessage(getBasicClusterEventPayload())
+			eventTime := time.Date(2023, 1, 20, 2, 19, 23, 972000000, time.UTC)
+
+			mockSnapshotRepo.EXPECT().GetClusterAt(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", eventTime).Times(1).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHostsAt(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", eventTime).Times(1).Return(mockHosts, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvsAt(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", eventTime).Times(1).Return(mockInfraEnvs, nil)
+			mockSnapshotRepo.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(0)
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), mockCluster, mockHosts, mockInfraEnvs).Times(1).Return(mockEnrichedEvent)
+			mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, msg).Times(1).Return(nil)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+		})
+	})
+
+	When("Processing events while the projection is offline", func() {
+		It("should keep updating snapshots and store cluster events once back online", func() {
+			mockConfigRepo := opensearch_repo.NewMockProjectionConfigRepositoryInterface(ctrl)
+			projection.modeWatcher = NewModeWatcher(logger, mockConfigRepo, mockEnrichedEventRepo, time.Hour, 10)
+			defer projection.modeWatcher.Close()
+			mockConfigRepo.EXPECT().Get(ctx).Times(1).Return(types.ProjectionConfig{Mode: types.ProjectionModeOffline}, nil)
+			projection.modeWatcher.Refresh(ctx)
+
+			msg := getKafkaMessage(getBasicClusterEventPayload())
+			mockSnapshotRepo.EXPECT().GetCluster(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHosts(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockHosts, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvs(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockInfraEnvs, nil)
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), mockCluster, mockHosts, mockInfraEnvs).Times(1).Return(mockEnrichedEvent)
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+
+			stateMsg := getKafkaMessage(getClusterStatePayload())
+			mockSnapshotRepo.EXPECT().SetCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", gomock.Any()).Times(1).Return(nil)
+			Expect(projection.ProcessMessage(ctx, stateMsg)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*stateMsg))
+
+			mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), mockEnrichedEvent, msg).Times(1).Return(nil)
+			mockConfigRepo.EXPECT().Get(ctx).Times(1).Return(types.ProjectionConfig{Mode: types.ProjectionModeOnline}, nil)
+			projection.modeWatcher.Refresh(ctx)
+		})
+
+		It("should pause events once the buffer is full and keep processing states", func() {
+			mockConfigRepo := opensearch_repo.NewMockProjectionConfigRepositoryInterface(ctrl)
+			projection.modeWatcher = NewModeWatcher(logger, mockConfigRepo, mockEnrichedEventRepo, time.Hour, 1)
+			defer projection.modeWatcher.Close()
+			mockConfigRepo.EXPECT().Get(ctx).Times(1).Return(types.ProjectionConfig{Mode: types.ProjectionModeOffline}, nil)
+			projection.modeWatcher.Refresh(ctx)
+
+			// enriched again when processed once back online
+			mockSnapshotRepo.EXPECT().GetCluster(gomock.Any(), "3a930088-e49d-4584-bbd3-54a568dbe833").Times(3).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHosts(gomock.Any(), "3a930088-e49d-4584-bbd3-54a568dbe833").Times(3).Return(mockHosts, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvs(gomock.Any(), "3a930088-e49d-4584-bbd3-54a568dbe833").Times(3).Return(mockInfraEnvs, nil)
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), mockCluster, mockHosts, mockInfraEnvs).Times(3).Return(mockEnrichedEvent)
+			first := getKafkaMessage(getBasicClusterEventPayload())
+			second := getKafkaMessage(getBasicClusterEventPayload())
+			Expect(projection.ProcessMessage(ctx, first)).To(BeNil())
+			Expect(projection.ProcessMessage(ctx, second)).To(BeNil())
+
+			stateMsg := getKafkaMessage(getClusterStatePayload())
+			mockSnapshotRepo.EXPECT().SetCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", gomock.Any()).Times(1).Return(nil)
+			Expect(projection.ProcessMessage(ctx, stateMsg)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*stateMsg))
+
+			gomock.InOrder(
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), mockEnrichedEvent, first).Times(1).Return(nil),
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), mockEnrichedEvent, second).Times(1).Return(nil),
+			)
+			mockConfigRepo.EXPECT().Get(ctx).Times(1).Return(types.ProjectionConfig{Mode: types.ProjectionModeOnline}, nil)
+			projection.modeWatcher.Refresh(ctx)
+			Expect(ackChannel).To(BeEmpty())
+		})
+	})
+
 	When("Processing a cluster state event", func() {
 		It("should store a snapshot of the cluster state, but should not store enriched events", func() {
 			eventPayload := getClusterStatePayload()
@@ -100,6 +281,341 @@ var _ = Describe("Process message", func() {
 		})
 	})
 
+	When("Storing a cluster state snapshot fails", func() {
+		It("should return a retryable error without acking the message", func() {
+			msg := getKafkaMessage(getClusterStatePayload())
+
+			mockSnapshotRepo.EXPECT().SetCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", gomock.Any()).Times(1).Return(stream.NewRetryableError(errors.New("connection refused")))
+
+			err := projection.ProcessMessage(ctx, msg)
+			Expect(stream.IsRetryable(err)).To(BeTrue())
+			Expect(ackChannel).To(BeEmpty())
+		})
+	})
+
+	When("Processing a tombstone", func() {
+		It("should delete the cluster identified by the message key", func() {
+			msg := &kafka.Message{
+				Key:     []byte("391d46b5-169b-4ffb-bce4-43ebdfe66b5c"),
+				Headers: []kafka.Header{{Key: stream.HeaderNotificationType, Value: []byte(ClusterState)}},
+			}
+			mockSnapshotRepo.EXPECT().DeleteCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c").Times(1).Return(nil)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+
+		It("should delete the host identified by the headers", func() {
+			msg := &kafka.Message{
+				Key: []byte("391d46b5-169b-4ffb-bce4-43ebdfe66b5c"),
+				Headers: []kafka.Header{
+					{Key: stream.HeaderNotificationType, Value: []byte(HostState)},
+					{Key: stream.HeaderClusterID, Value: []byte("391d46b5-169b-4ffb-bce4-43ebdfe66b5c")},
+					{Key: stream.HeaderHostID, Value: []byte("c64ffb6e-e9b0-4edb-9328-43f90d293783")},
+				},
+			}
+			mockSnapshotRepo.EXPECT().DeleteHost(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", "c64ffb6e-e9b0-4edb-9328-43f90d293783").Times(1).Return(nil)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+
+		It("should discard tombstones without the resource id", func() {
+			msg := &kafka.Message{
+				Headers: []kafka.Header{{Key: stream.HeaderNotificationType, Value: []byte(InfraEnvState)}},
+			}
+			mockSnapshotRepo.EXPECT().DeleteInfraEnv(gomock.Any(), gomock.Any()).Times(0)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+
+		It("should not ack the message when deleting fails", func() {
+			msg := &kafka.Message{
+				Headers: []kafka.Header{
+					{Key: stream.HeaderNotificationType, Value: []byte(InfraEnvState)},
+					{Key: stream.HeaderInfraEnvID, Value: []byte("bf835bc3-96d4-4926-a71b-4f5829dee688")},
+				},
+			}
+			mockSnapshotRepo.EXPECT().DeleteInfraEnv(ctx, "bf835bc3-96d4-4926-a71b-4f5829dee688").Times(1).Return(stream.NewRetryableError(errors.New("connection refused")))
+
+			Expect(stream.IsRetryable(projection.ProcessMessage(ctx, msg))).To(BeTrue())
+			Expect(ackChannel).To(BeEmpty())
+		})
+	})
+
+	When("Storing the latest state of clusters", func() {
+		var mockClusterRepo *opensearch_repo.MockClusterRepositoryInterface
+		BeforeEach(func() {
+			mockClusterRepo = opensearch_repo.NewMockClusterRepositoryInterface(ctrl)
+			projection.clusterRepository = mockClusterRepo
+		})
+
+		It("should store the cluster document of a host state, leaving the ack to the repository", func() {
+			msg := getKafkaMessage(getHostStatePayload())
+			clusterDocument := &types.ClusterDocument{ID: "3a930088-e49d-4584-bbd3-54a568dbe833"}
+
+			mockSnapshotRepo.EXPECT().SetHost(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", "c64ffb6e-e9b0-4edb-9328-43f90d293783", gomock.Any()).Times(1).Return(nil)
+			mockSnapshotRepo.EXPECT().GetCluster(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHosts(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockHosts, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvs(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockInfraEnvs, nil)
+			mockEnricher.EXPECT().GetClusterDocument("3a930088-e49d-4584-bbd3-54a568dbe833", mockCluster, mockHosts, mockInfraEnvs).Times(1).Return(clusterDocument)
+			mockClusterRepo.EXPECT().Store(ctx, clusterDocument, msg).Times(1).Return(nil)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(ackChannel).To(BeEmpty())
+		})
+
+		It("should version the cluster document after the deletion of its latest updated host", func() {
+			msg := getKafkaMessage(`{"name":"HostState","payload":{"id":"c64ffb6e-e9b0-4edb-9328-43f90d293783","cluster_id":"3a930088-e49d-4584-bbd3-54a568dbe833","updated_at":"2023-01-20T03:00:00Z","deleted_at":"2023-01-20T04:00:00Z"}}`)
+			remainingVersion := time.Date(2023, 1, 20, 2, 0, 0, 0, time.UTC).UnixMicro()
+			deletionVersion := time.Date(2023, 1, 20, 4, 0, 0, 0, time.UTC).UnixMicro()
+
+			mockSnapshotRepo.EXPECT().DeleteHost(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", "c64ffb6e-e9b0-4edb-9328-43f90d293783").Times(1).Return(nil)
+			mockSnapshotRepo.EXPECT().GetCluster(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHosts(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(nil, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvs(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockInfraEnvs, nil)
+			mockEnricher.EXPECT().GetClusterDocument("3a930088-e49d-4584-bbd3-54a568dbe833", mockCluster, gomock.Any(), mockInfraEnvs).Times(1).Return(&types.ClusterDocument{ID: "3a930088-e49d-4584-bbd3-54a568dbe833", Version: remainingVersion})
+			mockClusterRepo.EXPECT().Store(ctx, gomock.Any(), msg).Times(1).DoAndReturn(func(ctx context.Context, document *types.ClusterDocument, msg *kafka.Message) error {
+				Expect(document.Version).To(Equal(deletionVersion))
+				return nil
+			})
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+		})
+
+		It("should version the cluster document after a host tombstone", func() {
+			msg := &kafka.Message{
+				Time: time.Date(2023, 1, 20, 4, 0, 0, 0, time.UTC),
+				Headers: []kafka.Header{
+					{Key: stream.HeaderNotificationType, Value: []byte(HostState)},
+					{Key: stream.HeaderClusterID, Value: []byte("3a930088-e49d-4584-bbd3-54a568dbe833")},
+					{Key: stream.HeaderHostID, Value: []byte("c64ffb6e-e9b0-4edb-9328-43f90d293783")},
+				},
+			}
+			mockSnapshotRepo.EXPECT().DeleteHost(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", "c64ffb6e-e9b0-4edb-9328-43f90d293783").Times(1).Return(nil)
+			mockSnapshotRepo.EXPECT().GetCluster(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHosts(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(nil, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvs(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockInfraEnvs, nil)
+			mockEnricher.EXPECT().GetClusterDocument(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1).Return(&types.ClusterDocument{Version: 1})
+			mockClusterRepo.EXPECT().Store(ctx, gomock.Any(), msg).Times(1).DoAndReturn(func(ctx context.Context, document *types.ClusterDocument, msg *kafka.Message) error {
+				Expect(document.Version).To(Equal(msg.Time.UnixMicro()))
+				return nil
+			})
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+		})
+
+		It("should delete the cluster document when the cluster is gone", func() {
+			msg := &kafka.Message{
+				Key:     []byte("391d46b5-169b-4ffb-bce4-43ebdfe66b5c"),
+				Headers: []kafka.Header{{Key: stream.HeaderNotificationType, Value: []byte(ClusterState)}},
+			}
+			mockSnapshotRepo.EXPECT().DeleteCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c").Times(1).Return(nil)
+			mockClusterRepo.EXPECT().Delete(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", msg).Times(1).Return(nil)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(ackChannel).To(BeEmpty())
+		})
+
+		It("should delete the cluster document when the cluster is soft deleted", func() {
+			msg := getKafkaMessage(`{"name":"ClusterState","payload":{"id":"391d46b5-169b-4ffb-bce4-43ebdfe66b5c","deleted_at":"2023-01-20T02:19:23.972Z"}}`)
+			mockSnapshotRepo.EXPECT().DeleteCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c").Times(1).Return(nil)
+			mockClusterRepo.EXPECT().Delete(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", msg).Times(1).Return(nil)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(ackChannel).To(BeEmpty())
+		})
+
+		It("should keep the cluster document when the cluster has no snapshot", func() {
+			msg := getKafkaMessage(getHostStatePayload())
+			mockSnapshotRepo.EXPECT().SetHost(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", "c64ffb6e-e9b0-4edb-9328-43f90d293783", gomock.Any()).Times(1).Return(nil)
+			mockSnapshotRepo.EXPECT().GetCluster(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(map[string]interface{}{}, nil)
+			mockClusterRepo.EXPECT().Delete(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			mockClusterRepo.EXPECT().Store(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+
+		It("should retry the message when the cluster snapshot can't be read", func() {
+			msg := getKafkaMessage(getHostStatePayload())
+			mockSnapshotRepo.EXPECT().SetHost(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", "c64ffb6e-e9b0-4edb-9328-43f90d293783", gomock.Any()).Times(1).Return(nil)
+			mockSnapshotRepo.EXPECT().GetCluster(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(map[string]interface{}{}, stream.NewRetryableError(errors.New("connection refused")))
+			mockClusterRepo.EXPECT().Delete(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			Expect(stream.IsRetryable(projection.ProcessMessage(ctx, msg))).To(BeTrue())
+			Expect(ackChannel).To(BeEmpty())
+		})
+
+		It("should ack states of resources not bound to a cluster", func() {
+			msg := getKafkaMessage(`{"name":"InfraEnv","payload":{"id":"bf835bc3-96d4-4926-a71b-4f5829dee688"}}`)
+			mockSnapshotRepo.EXPECT().SetInfraEnv(ctx, "", "bf835bc3-96d4-4926-a71b-4f5829dee688", gomock.Any()).Times(1).Return(nil)
+			mockClusterRepo.EXPECT().Store(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+
+		It("should not ack the message when the cluster document can't be stored", func() {
+			msg := getKafkaMessage(getClusterStatePayload())
+			mockSnapshotRepo.EXPECT().SetCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", gomock.Any()).Times(1).Return(nil)
+			mockSnapshotRepo.EXPECT().GetCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c").Times(1).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHosts(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c").Times(1).Return(mockHosts, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvs(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c").Times(1).Return(mockInfraEnvs, nil)
+			mockEnricher.EXPECT().GetClusterDocument(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1).Return(&types.ClusterDocument{})
+			mockClusterRepo.EXPECT().Store(ctx, gomock.Any(), msg).Times(1).Return(stream.NewRetryableError(errors.New("bulk indexer closed")))
+
+			Expect(stream.IsRetryable(projection.ProcessMessage(ctx, msg))).To(BeTrue())
+			Expect(ackChannel).To(BeEmpty())
+		})
+	})
+
+	When("Tracking cluster lifecycles", func() {
+		It("should store the installation attempt changed by the cluster state", func() {
+			mockLifecycleStateRepo := redis_repo.NewMockLifecycleRepositoryInterface(ctrl)
+			mockLifecycleRepo := opensearch_repo.NewMockLifecycleRepositoryInterface(ctrl)
+			projection.lifecycleTracker = lifecycle.NewTracker(logger, mockLifecycleStateRepo)
+			projection.lifecycleRepository = mockLifecycleRepo
+
+			msg := getKafkaMessage(`{"name":"ClusterState","payload":{"id":"391d46b5-169b-4ffb-bce4-43ebdfe66b5c","status":"installing","created_at":"2023-01-20T02:00:00Z","status_updated_at":"2023-01-20T03:00:00Z"}}`)
+			mockSnapshotRepo.EXPECT().SetCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", gomock.Any()).Times(1).Return(nil)
+			mockLifecycleStateRepo.EXPECT().Get(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c").Times(1).Return(nil, nil)
+			mockLifecycleStateRepo.EXPECT().Set(ctx, gomock.Any()).Times(1).Return(nil)
+			var lifecycleMsg *kafka.Message
+			mockLifecycleRepo.EXPECT().Store(ctx, gomock.Any(), gomock.Any()).Times(1).DoAndReturn(func(ctx context.Context, document *types.LifecycleDocument, msg *kafka.Message) error {
+				Expect(document.ID).To(Equal("391d46b5-169b-4ffb-bce4-43ebdfe66b5c_1"))
+				Expect(*document.RegistrationToInstallStartSeconds).To(Equal(3600.0))
+				lifecycleMsg = msg
+				return nil
+			})
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			// the lifecycle and the cluster document ack copies of the message
+			tracker := stream.NewOffsetTracker()
+			tracker.Track(*msg)
+			_, ok := tracker.Ack(<-ackChannel)
+			Expect(ok).To(BeFalse())
+			commit, ok := tracker.Ack(*lifecycleMsg)
+			Expect(ok).To(BeTrue())
+			Expect(commit.Offset).To(Equal(msg.Offset))
+		})
+
+		It("should not ack the message when the installation attempt can't be stored", func() {
+			mockLifecycleStateRepo := redis_repo.NewMockLifecycleRepositoryInterface(ctrl)
+			mockLifecycleRepo := opensearch_repo.NewMockLifecycleRepositoryInterface(ctrl)
+			projection.lifecycleTracker = lifecycle.NewTracker(logger, mockLifecycleStateRepo)
+			projection.lifecycleRepository = mockLifecycleRepo
+
+			msg := getKafkaMessage(`{"name":"ClusterState","payload":{"id":"391d46b5-169b-4ffb-bce4-43ebdfe66b5c","status":"installing","created_at":"2023-01-20T02:00:00Z","status_updated_at":"2023-01-20T03:00:00Z"}}`)
+			mockSnapshotRepo.EXPECT().SetCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", gomock.Any()).Times(1).Return(nil)
+			mockLifecycleStateRepo.EXPECT().Get(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c").Times(1).Return(nil, nil)
+			mockLifecycleStateRepo.EXPECT().Set(ctx, gomock.Any()).Times(1).Return(nil)
+			mockLifecycleRepo.EXPECT().Store(ctx, gomock.Any(), gomock.Any()).Times(1).Return(stream.NewRetryableError(errors.New("bulk indexer closed")))
+
+			Expect(stream.IsRetryable(projection.ProcessMessage(ctx, msg))).To(BeTrue())
+			Expect(ackChannel).To(BeEmpty())
+		})
+	})
+
+	When("Processing a deleted host state", func() {
+		It("should delete the host instead of storing it", func() {
+			msg := getKafkaMessage(`{"name":"HostState","payload":{"id":"c64ffb6e-e9b0-4edb-9328-43f90d293783","cluster_id":"3a930088-e49d-4584-bbd3-54a568dbe833","deleted_at":"2023-01-21T02:19:23.972Z"}}`)
+			mockSnapshotRepo.EXPECT().SetHost(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			mockSnapshotRepo.EXPECT().DeleteHost(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", "c64ffb6e-e9b0-4edb-9328-43f90d293783").Times(1).Return(nil)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+	})
+
+	When("Processing a deregistration event", func() {
+		It("should store the enriched event, then delete the host", func() {
+			projection.deregistrationEvents = getDeregistrationEvents(ProjectionConfig{HostDeregistrationEventNames: []string{"host_deregistered"}})
+			msg := getKafkaMessage(`{"name":"Event","payload":{"cluster_id":"3a930088-e49d-4584-bbd3-54a568dbe833","host_id":"c64ffb6e-e9b0-4edb-9328-43f90d293783","name":"host_deregistered"}}`)
+
+			mockSnapshotRepo.EXPECT().GetCluster(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHosts(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockHosts, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvs(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockInfraEnvs, nil)
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), mockCluster, mockHosts, mockInfraEnvs).Times(1).Return(mockEnrichedEvent)
+			gomock.InOrder(
+				mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, msg).Times(1).Return(nil),
+				mockSnapshotRepo.EXPECT().DeleteHost(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", "c64ffb6e-e9b0-4edb-9328-43f90d293783").Times(1).Return(nil),
+			)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+		})
+
+		It("should store the enriched event, then delete the cluster and its document", func() {
+			mockClusterRepo := opensearch_repo.NewMockClusterRepositoryInterface(ctrl)
+			projection.clusterRepository = mockClusterRepo
+			projection.deregistrationEvents = getDeregistrationEvents(ProjectionConfig{ClusterDeregistrationEventNames: []string{"cluster_deregistered"}})
+			msg := getKafkaMessage(`{"name":"Event","payload":{"cluster_id":"3a930088-e49d-4584-bbd3-54a568dbe833","name":"cluster_deregistered"}}`)
+
+			mockSnapshotRepo.EXPECT().GetCluster(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHosts(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockHosts, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvs(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(mockInfraEnvs, nil)
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), mockCluster, mockHosts, mockInfraEnvs).Times(1).Return(mockEnrichedEvent)
+			gomock.InOrder(
+				mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, msg).Times(1).Return(nil),
+				mockSnapshotRepo.EXPECT().DeleteCluster(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833").Times(1).Return(nil),
+				mockClusterRepo.EXPECT().Delete(ctx, "3a930088-e49d-4584-bbd3-54a568dbe833", nil).Times(1).Return(nil),
+			)
+
+			Expect(projection.ProcessMessage(ctx, msg)).To(BeNil())
+		})
+	})
+
+	When("Processing a cluster state encoded as CloudEvent", func() {
+		It("should store a snapshot from a structured mode message", func() {
+			msg := getKafkaMessage(`{"specversion":"1.0","id":"1","source":"/assisted-service","type":"ClusterState","datacontenttype":"application/json","metadata":"{\"versions\":{}}","data":{"id":"391d46b5-169b-4ffb-bce4-43ebdfe66b5c"}}`)
+
+			mockSnapshotRepo.EXPECT().SetCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", gomock.Any()).Times(1).Return(nil)
+
+			err := projection.ProcessMessage(ctx, msg)
+			Expect(err).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+		It("should store a snapshot from a binary mode message", func() {
+			msg := getKafkaMessage(`{"id":"391d46b5-169b-4ffb-bce4-43ebdfe66b5c"}`)
+			msg.Headers = []kafka.Header{
+				{Key: "content-type", Value: []byte("application/json")},
+				{Key: "ce_specversion", Value: []byte("1.0")},
+				{Key: "ce_id", Value: []byte("1")},
+				{Key: "ce_source", Value: []byte("/assisted-service")},
+				{Key: "ce_type", Value: []byte("ClusterState")},
+			}
+
+			mockSnapshotRepo.EXPECT().SetCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", gomock.Any()).Times(1).Return(nil)
+
+			err := projection.ProcessMessage(ctx, msg)
+			Expect(err).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+	})
+
+	When("Processing a cluster state encoded with a schema registry codec", func() {
+		It("should decode it with the codec", func() {
+			mockRegistry := stream.NewMockSchemaRegistry(ctrl)
+			schema := &stream.Schema{ID: 1, Schema: `{"type":"record","name":"ClusterState","fields":[{"name":"name","type":"string"},{"name":"payload","type":{"type":"map","values":"string"}}]}`}
+			mockRegistry.EXPECT().GetLatestSchema(ctx, "events-value").Return(schema, nil)
+			mockRegistry.EXPECT().GetSchemaByID(ctx, 1).Return(schema, nil)
+			projection.codec = stream.NewAvroCodec(mockRegistry, "events-value")
+			value, err := projection.codec.Marshal(ctx, map[string]interface{}{
+				"name":    "ClusterState",
+				"payload": map[string]interface{}{"id": "391d46b5-169b-4ffb-bce4-43ebdfe66b5c"},
+			})
+			Expect(err).To(BeNil())
+			msg := &kafka.Message{Value: value}
+
+			mockSnapshotRepo.EXPECT().SetCluster(ctx, "391d46b5-169b-4ffb-bce4-43ebdfe66b5c", gomock.Any()).Times(1).Return(nil)
+
+			err = projection.ProcessMessage(ctx, msg)
+			Expect(err).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+	})
+
 	When("Processing a host state event", func() {
 		It("should store a snapshot of the host state, but should not store enriched events", func() {
 			eventPayload := getHostStatePayload()
@@ -120,6 +636,74 @@ var _ = Describe("Process message", func() {
 		})
 	})
 
+	When("Processing a batch of messages", func() {
+		const clusterID = "3a930088-e49d-4584-bbd3-54a568dbe833"
+		var snapshot func() *redis_repo.ClusterSnapshot
+		BeforeEach(func() {
+			snapshot = func() *redis_repo.ClusterSnapshot {
+				return &redis_repo.ClusterSnapshot{ClusterID: clusterID, Cluster: mockCluster, Hosts: mockHosts, InfraEnvs: mockInfraEnvs}
+			}
+		})
+
+		getBatch := func(payloads ...string) []*kafka.Message {
+			msgs := []*kafka.Message{}
+			for i, payload := range payloads {
+				msg := getKafkaMessage(payload)
+				msg.Offset = int64(i)
+				msgs = append(msgs, msg)
+			}
+			return msgs
+		}
+
+		It("should read the snapshots of consecutive cluster events at once", func() {
+			msgs := getBatch(getBasicClusterEventPayload(), getBasicClusterEventPayload(), getHostStatePayload(), getBasicClusterEventPayload())
+			gomock.InOrder(
+				mockSnapshotRepo.EXPECT().GetClusterSnapshots(gomock.Any(), []string{clusterID, clusterID}).Times(1).Return([]*redis_repo.ClusterSnapshot{snapshot(), snapshot()}, nil),
+				mockSnapshotRepo.EXPECT().SetHost(gomock.Any(), clusterID, "c64ffb6e-e9b0-4edb-9328-43f90d293783", gomock.Any()).Times(1).Return(nil),
+				// events after a state event see the state it set
+				mockSnapshotRepo.EXPECT().GetClusterSnapshots(gomock.Any(), []string{clusterID}).Times(1).Return([]*redis_repo.ClusterSnapshot{snapshot()}, nil),
+			)
+			mockSnapshotRepo.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(0)
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), mockCluster, mockHosts, mockInfraEnvs).Times(3).Return(mockEnrichedEvent)
+			for _, i := range []int{0, 1, 3} {
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), mockEnrichedEvent, msgs[i]).Times(1).Return(nil)
+			}
+
+			processed, err := projection.ProcessMessages(ctx, msgs)
+			Expect(err).To(BeNil())
+			Expect(processed).To(Equal(4))
+			Expect(<-ackChannel).To(Equal(*msgs[2]))
+		})
+
+		It("should read snapshots by event when they cannot be read at once", func() {
+			msgs := getBatch(getBasicClusterEventPayload())
+			mockSnapshotRepo.EXPECT().GetClusterSnapshots(gomock.Any(), []string{clusterID}).Times(1).Return(nil, errors.New("connection refused"))
+			mockSnapshotRepo.EXPECT().GetCluster(gomock.Any(), clusterID).Times(1).Return(mockCluster, nil)
+			mockSnapshotRepo.EXPECT().GetHosts(gomock.Any(), clusterID).Times(1).Return(mockHosts, nil)
+			mockSnapshotRepo.EXPECT().GetInfraEnvs(gomock.Any(), clusterID).Times(1).Return(mockInfraEnvs, nil)
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), mockCluster, mockHosts, mockInfraEnvs).Times(1).Return(mockEnrichedEvent)
+			mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), mockEnrichedEvent, msgs[0]).Times(1).Return(nil)
+
+			processed, err := projection.ProcessMessages(ctx, msgs)
+			Expect(err).To(BeNil())
+			Expect(processed).To(Equal(1))
+		})
+
+		It("should return how many messages were processed before failing", func() {
+			msgs := getBatch("not json", getBasicClusterEventPayload(), getBasicClusterEventPayload())
+			mockSnapshotRepo.EXPECT().GetClusterSnapshots(gomock.Any(), []string{clusterID, clusterID}).Times(1).Return([]*redis_repo.ClusterSnapshot{snapshot(), snapshot()}, nil)
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), mockCluster, mockHosts, mockInfraEnvs).Times(2).Return(mockEnrichedEvent)
+			mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), mockEnrichedEvent, msgs[1]).Times(1).Return(nil)
+			mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), mockEnrichedEvent, msgs[2]).Times(1).Return(errors.New("bulk indexer closed"))
+
+			processed, err := projection.ProcessMessages(ctx, msgs)
+			Expect(err).ToNot(BeNil())
+			Expect(processed).To(Equal(2))
+			// the malformed message is acked
+			Expect(<-ackChannel).To(Equal(*msgs[0]))
+		})
+	})
+
 	When("Processing a infraenv event", func() {
 		It("should store a snapshot of the infraenv, but should not store enriched events", func() {
 			eventPayload := getInfraEnvPayload()
@@ -140,9 +724,72 @@ var _ = Describe("Process message", func() {
 		})
 	})
 
+	When("Processing a state of a host not bound to a cluster", func() {
+		It("should store it as an unbound host of its infraenv", func() {
+			msg := getKafkaMessage(`{"name":"HostState","payload":{"id":"c64ffb6e-e9b0-4edb-9328-43f90d293783","infra_env_id":"bf835bc3-96d4-4926-a71b-4f5829dee688","cluster_id":null}}`)
+
+			mockSnapshotRepo.EXPECT().SetUnboundHost(ctx, "bf835bc3-96d4-4926-a71b-4f5829dee688", "c64ffb6e-e9b0-4edb-9328-43f90d293783", gomock.Any()).Times(1).Return(nil)
+
+			err := projection.ProcessMessage(ctx, msg)
+			Expect(err).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+	})
+
+	When("Processing a state of a host without cluster nor infraenv", func() {
+		It("should discard it as malformed", func() {
+			msg := getKafkaMessage(`{"name":"HostState","payload":{"id":"c64ffb6e-e9b0-4edb-9328-43f90d293783"}}`)
+
+			err := projection.ProcessMessage(ctx, msg)
+			Expect(err).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+	})
+
+	When("Processing a state of an infraenv not bound to a cluster", func() {
+		It("should store it without cluster", func() {
+			msg := getKafkaMessage(`{"name":"InfraEnv","payload":{"id":"bf835bc3-96d4-4926-a71b-4f5829dee688","cluster_id":null}}`)
+
+			mockSnapshotRepo.EXPECT().SetInfraEnv(ctx, "", "bf835bc3-96d4-4926-a71b-4f5829dee688", gomock.Any()).Times(1).Return(nil)
+
+			err := projection.ProcessMessage(ctx, msg)
+			Expect(err).To(BeNil())
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+	})
+
+	When("Processing an event of an infraenv not bound to a cluster", func() {
+		It("should enrich it with the infraenv and its unbound hosts", func() {
+			msg := getKafkaMessage(`{"name":"Event","payload":{"infra_env_id":"bf835bc3-96d4-4926-a71b-4f5829dee688","name":"image_info_updated","message":"Image info updated"}}`)
+			infraEnv := map[string]interface{}{"id": "bf835bc3-96d4-4926-a71b-4f5829dee688"}
+
+			mockSnapshotRepo.EXPECT().GetInfraEnv(ctx, "bf835bc3-96d4-4926-a71b-4f5829dee688").Times(1).Return(infraEnv, nil)
+			mockSnapshotRepo.EXPECT().GetUnboundHosts(ctx, "bf835bc3-96d4-4926-a71b-4f5829dee688").Times(1).Return(mockHosts, nil)
+			mockSnapshotRepo.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(0)
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), map[string]interface{}{}, mockHosts, []map[string]interface{}{infraEnv}).Times(1).Return(mockEnrichedEvent)
+			mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, msg).Times(1).Return(nil)
+
+			err := projection.ProcessMessage(ctx, msg)
+			Expect(err).To(BeNil())
+		})
+
+		It("should retry the message when the snapshots can't be read", func() {
+			msg := getKafkaMessage(`{"name":"Event","payload":{"infra_env_id":"bf835bc3-96d4-4926-a71b-4f5829dee688","name":"image_info_updated","message":"Image info updated"}}`)
+			infraEnv := map[string]interface{}{"id": "bf835bc3-96d4-4926-a71b-4f5829dee688"}
+
+			mockSnapshotRepo.EXPECT().GetInfraEnv(ctx, "bf835bc3-96d4-4926-a71b-4f5829dee688").Times(1).Return(infraEnv, nil)
+			mockSnapshotRepo.EXPECT().GetUnboundHosts(ctx, "bf835bc3-96d4-4926-a71b-4f5829dee688").Times(1).Return(nil, stream.NewRetryableError(errors.New("connection refused")))
+			mockEnricher.EXPECT().GetEnrichedEvent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			Expect(stream.IsRetryable(projection.ProcessMessage(ctx, msg))).To(BeTrue())
+			Expect(ackChannel).To(BeEmpty())
+		})
+	})
+
 	When("Some user names are excluded", func() {
 		BeforeEach(func() {
-			projection.excludedUserNames = []string{"excluded user"}
+			projection.eventFilter = filter.NewEventFilter(logger, []string{"excluded user"})
 		})
 
 		Context("Pushing an event from another user", func() {
@@ -190,6 +837,9 @@ var _ = Describe("Process message", func() {
 				mockEnrichedEventRepo.EXPECT().Store(ctx, mockEnrichedEvent, msg).Times(0)
 				err := projection.ProcessMessage(ctx, msg)
 				Expect(err).To(BeNil())
+
+				ackedMsg := <-ackChannel
+				Expect(ackedMsg).To(Equal(*msg))
 			})
 		})
 	})
@@ -204,14 +854,15 @@ var _ = Describe("Create Projection", func() {
 		os.Unsetenv("EXCLUDED_USER_NAMES")
 
 		Context("Creating a new enriched event projection", func() {
-			projection, err := NewEnrichedEventsProjection(nil, nil, nil, nil)
+			projection, err := NewEnrichedEventsProjection(context.Background(), nil, nil, nil, nil, nil, nil)
 
 			It("Should not fail", func() {
 				Expect(err).To(BeNil())
 			})
 
-			It("Should create a projection with an empty excluded user name list", func() {
-				Expect(len(projection.excludedUserNames)).To(Equal(0))
+			It("Should create a projection excluding no user names", func() {
+				Expect(isUserNameExcluded(projection, "")).To(BeFalse())
+				Expect(isUserNameExcluded(projection, "my user")).To(BeFalse())
 			})
 		})
 	})
@@ -220,14 +871,15 @@ var _ = Describe("Create Projection", func() {
 		os.Setenv("EXCLUDED_USER_NAMES", "")
 
 		Context("Creating a new enriched event projection", func() {
-			projection, err := NewEnrichedEventsProjection(nil, nil, nil, nil)
+			projection, err := NewEnrichedEventsProjection(context.Background(), nil, nil, nil, nil, nil, nil)
 
 			It("Should not fail", func() {
 				Expect(err).To(BeNil())
 			})
 
-			It("Should create a projection with an empty excluded user name list", func() {
-				Expect(len(projection.excludedUserNames)).To(Equal(0))
+			It("Should create a projection excluding no user names", func() {
+				Expect(isUserNameExcluded(projection, "")).To(BeFalse())
+				Expect(isUserNameExcluded(projection, "my user")).To(BeFalse())
 			})
 		})
 	})
@@ -236,14 +888,15 @@ var _ = Describe("Create Projection", func() {
 		os.Setenv("EXCLUDED_USER_NAMES", "my user")
 
 		Context("Creating a new enriched event projection", func() {
-			projection, err := NewEnrichedEventsProjection(nil, nil, nil, nil)
+			projection, err := NewEnrichedEventsProjection(context.Background(), nil, nil, nil, nil, nil, nil)
 
 			It("Should not fail", func() {
 				Expect(err).To(BeNil())
 			})
 
 			It("Should create a projection with 1 excluded user name", func() {
-				Expect(projection.excludedUserNames).To(Equal([]string{"my user"}))
+				Expect(isUserNameExcluded(projection, "my user")).To(BeTrue())
+				Expect(isUserNameExcluded(projection, "another user")).To(BeFalse())
 			})
 		})
 	})
@@ -252,14 +905,17 @@ var _ = Describe("Create Projection", func() {
 		os.Setenv("EXCLUDED_USER_NAMES", "test 1,test2,  test  3 éè, test4")
 
 		Context("Creating a new enriched event projection", func() {
-			projection, err := NewEnrichedEventsProjection(nil, nil, nil, nil)
+			projection, err := NewEnrichedEventsProjection(context.Background(), nil, nil, nil, nil, nil, nil)
 
 			It("Should not fail", func() {
 				Expect(err).To(BeNil())
 			})
 
 			It("Should create a projection with 3 excluded user names", func() {
-				Expect(projection.excludedUserNames).To(ConsistOf([]string{"test 1", "test2", "  test  3 éè", " test4"}))
+				for _, name := range []string{"test 1", "test2", "  test  3 éè", " test4"} {
+					Expect(isUserNameExcluded(projection, name)).To(BeTrue())
+				}
+				Expect(isUserNameExcluded(projection, "test4")).To(BeFalse())
 			})
 		})
 	})
@@ -405,3 +1061,62 @@ func getMockEnrichedEvent() *types.EnrichedEvent {
 		ID: "bf1de182-ff4f-40a0-970d-1a3c0321de27",
 	}
 }
+
+var _ = Describe("Configuring event policies", func() {
+	AfterEach(func() {
+		os.Unsetenv("EVENT_POLICIES")
+	})
+
+	It("should apply the policies of the given names", func() {
+		os.Setenv("EVENT_POLICIES", "HostState:ignore,ManifestState:pass-through")
+		projection, err := NewEnrichedEventsProjection(context.Background(), nil, nil, nil, nil, nil, nil)
+		Expect(err).To(BeNil())
+
+		policy, _, _ := projection.handlers.Get(HostState)
+		Expect(policy).To(Equal(HandlerPolicyIgnore))
+		policy, _, known := projection.handlers.Get("ManifestState")
+		Expect(policy).To(Equal(HandlerPolicyPassThrough))
+		Expect(known).To(BeTrue())
+		policy, _, _ = projection.handlers.Get(ClusterState)
+		Expect(policy).To(Equal(HandlerPolicyProcess))
+	})
+
+	It("should fail to process names without handler", func() {
+		os.Setenv("EVENT_POLICIES", "ManifestState:process")
+		_, err := NewEnrichedEventsProjection(context.Background(), nil, nil, nil, nil, nil, nil)
+		Expect(err).To(MatchError(ContainSubstring("ManifestState")))
+	})
+})
+
+var _ = Describe("Configuring the value codec", func() {
+	var tmpdir string
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", ".registry-")
+		Expect(err).To(BeNil())
+	})
+	AfterEach(func() {
+		os.Unsetenv("KAFKA_VALUE_CODEC")
+		os.Unsetenv("SCHEMA_REGISTRY_FILE")
+		os.Unsetenv("KAFKA_EVENT_STREAM_TOPIC")
+		os.RemoveAll(tmpdir)
+	})
+
+	It("should resolve the registry subject from the events topic", func() {
+		registryFile := filepath.Join(tmpdir, "schemas.json")
+		Expect(os.WriteFile(registryFile, []byte(`[{"id":1,"subject":"events-value","version":1,"schema":"\"string\""}]`), 0600)).To(Succeed())
+		os.Setenv("KAFKA_VALUE_CODEC", "avro")
+		os.Setenv("SCHEMA_REGISTRY_FILE", registryFile)
+		os.Setenv("KAFKA_EVENT_STREAM_TOPIC", "events")
+
+		projection, err := NewEnrichedEventsProjection(context.Background(), nil, nil, nil, nil, nil, nil)
+		Expect(err).To(BeNil())
+		_, err = projection.codec.Marshal(context.Background(), "foo")
+		Expect(err).To(BeNil())
+	})
+})
+
+func isUserNameExcluded(projection *EnrichedEventsProjection, userName string) bool {
+	excluded, _ := projection.eventFilter.Exclude(&filter.Document{Cluster: map[string]interface{}{"user_name": userName}})
+	return excluded
+}
@@ -0,0 +1,294 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// rules derived from EXCLUDED_USER_NAMES, evaluated after configured rules
+	LegacyUserNamesRule         = "excluded_user_names"
+	LegacyInfraEnvUserNamesRule = "excluded_user_names_infra_env"
+)
+
+// Subject of the rules: fields are matched with paths prefixed by the
+// top level key, i.e. event.severity or cluster.org_id
+type Document struct {
+	Event    interface{}            `json:"event"`
+	Cluster  map[string]interface{} `json:"cluster"`
+	InfraEnv map[string]interface{} `json:"infra_env"`
+}
+
+type compiledRule struct {
+	name       string
+	exclude    bool
+	conditions []compiledCondition
+}
+
+type compiledCondition struct {
+	// top level key of the document, and path of the field within it
+	key    string
+	path   string
+	equals []string
+	regex  *regexp.Regexp
+}
+
+// Evaluates rules in order, the first matching rule decides whether an event
+// is excluded. Events matching no rule are included
+type EventFilter struct {
+	logger      *logrus.Logger
+	legacyRules []compiledRule
+
+	mu     sync.RWMutex
+	rules  []compiledRule
+	counts map[string]int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewEventFilter(logger *logrus.Logger, excludedUserNames []string) *EventFilter {
+	f := &EventFilter{
+		logger: logger,
+		counts: map[string]int64{},
+		done:   make(chan struct{}),
+	}
+	if len(excludedUserNames) > 0 {
+		f.legacyRules = []compiledRule{
+			{name: LegacyUserNamesRule, exclude: true, conditions: []compiledCondition{{key: "cluster", path: "user_name", equals: excludedUserNames}}},
+			{name: LegacyInfraEnvUserNamesRule, exclude: true, conditions: []compiledCondition{{key: "infra_env", path: "user_name", equals: excludedUserNames}}},
+		}
+	}
+	return f
+}
+
+// Replaces the rules, keeping the current ones if any rule is invalid
+func (f *EventFilter) SetRules(rules []types.FilterRule) error {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		c, err := compileRule(rule)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, c)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = compiled
+	f.logger.WithFields(logrus.Fields{
+		"rules":  len(compiled),
+		"counts": f.counts,
+	}).Info("filter rules loaded")
+	return nil
+}
+
+func compileRule(rule types.FilterRule) (compiledRule, error) {
+	c := compiledRule{name: rule.Name}
+	switch rule.Action {
+	case types.FilterActionExclude:
+		c.exclude = true
+	case types.FilterActionInclude:
+	default:
+		return c, fmt.Errorf("rule %s: unknown action %q", rule.Name, rule.Action)
+	}
+	if len(rule.Conditions) == 0 {
+		return c, fmt.Errorf("rule %s: no conditions", rule.Name)
+	}
+	for _, condition := range rule.Conditions {
+		key, path, _ := strings.Cut(condition.Field, ".")
+		cc := compiledCondition{
+			key:    key,
+			path:   path,
+			equals: condition.Equals,
+		}
+		if condition.Regex != "" {
+			regex, err := regexp.Compile(condition.Regex)
+			if err != nil {
+				return c, fmt.Errorf("rule %s: %w", rule.Name, err)
+			}
+			cc.regex = regex
+		}
+		if condition.Field == "" || (cc.regex == nil && len(cc.equals) == 0) {
+			return c, fmt.Errorf("rule %s: condition needs a field and either equals or regex", rule.Name)
+		}
+		c.conditions = append(c.conditions, cc)
+	}
+	return c, nil
+}
+
+// Returns whether the event should be dropped, and the rule that decided it
+func (f *EventFilter) Exclude(document *Document) (bool, string) {
+	f.mu.RLock()
+	rules := f.rules
+	f.mu.RUnlock()
+	if len(rules) == 0 && len(f.legacyRules) == 0 {
+		return false, ""
+	}
+
+	data := &documentData{document: document}
+	for _, rule := range append(slices.Clip(rules), f.legacyRules...) {
+		matches, err := rule.matches(data)
+		if err != nil {
+			f.logger.WithError(err).Warn("could not evaluate filter rules, including event")
+			return false, ""
+		}
+		if !matches {
+			continue
+		}
+		if rule.exclude {
+			f.mu.Lock()
+			f.counts[rule.name]++
+			f.mu.Unlock()
+		}
+		return rule.exclude, rule.name
+	}
+	return false, ""
+}
+
+func (r compiledRule) matches(data *documentData) (bool, error) {
+	for _, condition := range r.conditions {
+		value, err := data.get(condition.key, condition.path)
+		if err != nil {
+			return false, err
+		}
+		if !value.Exists() {
+			return false, nil
+		}
+		if len(condition.equals) > 0 && !slices.Contains(condition.equals, value.String()) {
+			return false, nil
+		}
+		if condition.regex != nil && !condition.regex.MatchString(value.String()) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Json of the parts of the document, marshaled the first time a rule reads
+// them
+type documentData struct {
+	document *Document
+	event    []byte
+	cluster  []byte
+	infraEnv []byte
+}
+
+func (d *documentData) get(key string, path string) (gjson.Result, error) {
+	var (
+		data *[]byte
+		part interface{}
+	)
+	switch key {
+	case "event":
+		data, part = &d.event, d.document.Event
+	case "cluster":
+		data, part = &d.cluster, d.document.Cluster
+	case "infra_env":
+		data, part = &d.infraEnv, d.document.InfraEnv
+	default:
+		return gjson.Result{}, nil
+	}
+	if *data == nil {
+		marshaled, err := json.Marshal(part)
+		if err != nil {
+			return gjson.Result{}, err
+		}
+		*data = marshaled
+	}
+	if path == "" {
+		return gjson.ParseBytes(*data), nil
+	}
+	return gjson.GetBytes(*data, path), nil
+}
+
+// Number of events excluded by each rule since start
+func (f *EventFilter) Counts() map[string]int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	counts := make(map[string]int64, len(f.counts))
+	for name, count := range f.counts {
+		counts[name] = count
+	}
+	return counts
+}
+
+// Loads rules from the config document, unless they are not set in it
+func (f *EventFilter) OnProjectionConfig(config types.ProjectionConfig) {
+	if config.FilterRules == nil {
+		return
+	}
+	if err := f.SetRules(*config.FilterRules); err != nil {
+		f.logger.WithError(err).Error("invalid filter rules in config document, keeping current rules")
+	}
+}
+
+// Loads rules from a JSON file, then reloads them whenever it changes
+func (f *EventFilter) WatchFile(path string, interval time.Duration) error {
+	modTime, err := f.loadFile(path)
+	if err != nil {
+		return err
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.done:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil {
+					f.logger.WithError(err).Warn("could not stat filter rules file")
+					continue
+				}
+				if info.ModTime().Equal(modTime) {
+					continue
+				}
+				loaded, err := f.loadFile(path)
+				if err != nil {
+					f.logger.WithError(err).Error("could not reload filter rules, keeping current rules")
+					continue
+				}
+				modTime = loaded
+			}
+		}
+	}()
+	return nil
+}
+
+func (f *EventFilter) loadFile(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var rules []types.FilterRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse filter rules %s: %w", path, err)
+	}
+	return info.ModTime(), f.SetRules(rules)
+}
+
+func (f *EventFilter) Close() {
+	select {
+	case <-f.done:
+	default:
+		close(f.done)
+	}
+	f.wg.Wait()
+}
@@ -0,0 +1,182 @@
+package filter
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Filtering events", func() {
+	var (
+		logger *logrus.Logger
+		f      *EventFilter
+	)
+	BeforeEach(func() {
+		logger = logrus.New()
+		logger.Out = io.Discard
+		f = NewEventFilter(logger, []string{"excluded user"})
+	})
+	AfterEach(func() {
+		f.Close()
+	})
+
+	getDocument := func(cluster map[string]interface{}) *Document {
+		return &Document{
+			Event:   map[string]interface{}{"name": "cluster_registration_succeeded", "severity": "info"},
+			Cluster: cluster,
+		}
+	}
+
+	excludes := func(document *Document) bool {
+		excluded, _ := f.Exclude(document)
+		return excluded
+	}
+
+	When("no rules are configured", func() {
+		It("should only exclude events of excluded user names", func() {
+			excluded, rule := f.Exclude(getDocument(map[string]interface{}{"user_name": "excluded user"}))
+			Expect(excluded).To(BeTrue())
+			Expect(rule).To(Equal(LegacyUserNamesRule))
+
+			excluded, _ = f.Exclude(&Document{InfraEnv: map[string]interface{}{"user_name": "excluded user"}})
+			Expect(excluded).To(BeTrue())
+
+			excluded, _ = f.Exclude(getDocument(map[string]interface{}{"user_name": "another user"}))
+			Expect(excluded).To(BeFalse())
+		})
+	})
+
+	When("rules are configured", func() {
+		BeforeEach(func() {
+			err := f.SetRules([]types.FilterRule{
+				{
+					Name:   "keep-errors-of-internal-users",
+					Action: types.FilterActionInclude,
+					Conditions: []types.FilterCondition{
+						{Field: "cluster.email_domain", Equals: []string{"redhat.com"}},
+						{Field: "event.severity", Equals: []string{"error", "critical"}},
+					},
+				},
+				{
+					Name:       "internal-users",
+					Action:     types.FilterActionExclude,
+					Conditions: []types.FilterCondition{{Field: "cluster.email_domain", Equals: []string{"redhat.com"}}},
+				},
+				{
+					Name:       "test-clusters",
+					Action:     types.FilterActionExclude,
+					Conditions: []types.FilterCondition{{Field: "cluster.name", Regex: "^ci-op-"}},
+				},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("should apply the first matching rule", func() {
+			internal := map[string]interface{}{"email_domain": "redhat.com", "name": "foo"}
+			excluded, rule := f.Exclude(getDocument(internal))
+			Expect(excluded).To(BeTrue())
+			Expect(rule).To(Equal("internal-users"))
+
+			document := getDocument(internal)
+			document.Event = map[string]interface{}{"severity": "error"}
+			excluded, rule = f.Exclude(document)
+			Expect(excluded).To(BeFalse())
+			Expect(rule).To(Equal("keep-errors-of-internal-users"))
+
+			excluded, rule = f.Exclude(getDocument(map[string]interface{}{"email_domain": "example.com", "name": "ci-op-1234"}))
+			Expect(excluded).To(BeTrue())
+			Expect(rule).To(Equal("test-clusters"))
+		})
+
+		It("should include events matching no rule", func() {
+			excluded, rule := f.Exclude(getDocument(map[string]interface{}{"email_domain": "example.com", "name": "prod"}))
+			Expect(excluded).To(BeFalse())
+			Expect(rule).To(BeEmpty())
+		})
+
+		It("should count events excluded by each rule", func() {
+			for i := 0; i < 3; i++ {
+				f.Exclude(getDocument(map[string]interface{}{"email_domain": "redhat.com"}))
+			}
+			f.Exclude(getDocument(map[string]interface{}{"user_name": "excluded user"}))
+			f.Exclude(getDocument(map[string]interface{}{"name": "prod"}))
+			Expect(f.Counts()).To(Equal(map[string]int64{
+				"internal-users":    3,
+				LegacyUserNamesRule: 1,
+			}))
+		})
+
+		It("should keep current rules when new ones are invalid", func() {
+			err := f.SetRules([]types.FilterRule{
+				{Name: "bad", Action: types.FilterActionExclude, Conditions: []types.FilterCondition{{Field: "cluster.name", Regex: "("}}},
+			})
+			Expect(err).ToNot(BeNil())
+			Expect(f.SetRules([]types.FilterRule{{Name: "no-conditions", Action: types.FilterActionExclude}})).ToNot(BeNil())
+			Expect(f.SetRules([]types.FilterRule{{Name: "bad-action", Action: "drop", Conditions: []types.FilterCondition{{Field: "event.name", Equals: []string{"foo"}}}}})).ToNot(BeNil())
+
+			excluded, _ := f.Exclude(getDocument(map[string]interface{}{"email_domain": "redhat.com"}))
+			Expect(excluded).To(BeTrue())
+		})
+
+		It("should only read the parts of the document rules refer to", func() {
+			eventFilter := NewEventFilter(logger, nil)
+			Expect(eventFilter.SetRules([]types.FilterRule{
+				{Name: "warnings", Action: types.FilterActionExclude, Conditions: []types.FilterCondition{{Field: "event.severity", Equals: []string{"warning"}}}},
+			})).To(BeNil())
+			// clusters can't be marshaled, failing rules that refer to them
+			document := &Document{
+				Event:   map[string]interface{}{"severity": "warning"},
+				Cluster: map[string]interface{}{"status": func() {}},
+			}
+			excluded, rule := eventFilter.Exclude(document)
+			Expect(excluded).To(BeTrue())
+			Expect(rule).To(Equal("warnings"))
+		})
+
+		It("should keep current rules when the config document does not set them", func() {
+			f.OnProjectionConfig(types.ProjectionConfig{Mode: types.ProjectionModeOnline})
+			excluded, _ := f.Exclude(getDocument(map[string]interface{}{"email_domain": "redhat.com"}))
+			Expect(excluded).To(BeTrue())
+
+			f.OnProjectionConfig(types.ProjectionConfig{FilterRules: &[]types.FilterRule{}})
+			excluded, _ = f.Exclude(getDocument(map[string]interface{}{"email_domain": "redhat.com"}))
+			Expect(excluded).To(BeFalse())
+		})
+	})
+
+	When("rules are loaded from a file", func() {
+		It("should reload them when the file changes", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "rules.json")
+			Expect(os.WriteFile(path, []byte(`[{"name":"warnings","action":"exclude","conditions":[{"field":"event.severity","equals":["warning"]}]}]`), 0600)).To(BeNil())
+			Expect(f.WatchFile(path, 10*time.Millisecond)).To(BeNil())
+
+			warning := &Document{Event: map[string]interface{}{"severity": "warning"}}
+			info := &Document{Event: map[string]interface{}{"severity": "info"}}
+			Expect(excludes(warning)).To(BeTrue())
+			Expect(excludes(info)).To(BeFalse())
+
+			Expect(os.WriteFile(path, []byte(`[{"name":"infos","action":"exclude","conditions":[{"field":"event.severity","equals":["info"]}]}]`), 0600)).To(BeNil())
+			Expect(os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))).To(BeNil())
+			Eventually(func() bool { return excludes(info) }).Should(BeTrue())
+			Expect(excludes(warning)).To(BeFalse())
+		})
+
+		It("should fail when the file is not valid", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "rules.json")
+			Expect(os.WriteFile(path, []byte(`{"name":"not a list"}`), 0600)).To(BeNil())
+			Expect(f.WatchFile(path, time.Minute)).ToNot(BeNil())
+		})
+	})
+})
+
+func TestFilter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Event filter")
+}
@@ -0,0 +1,118 @@
+package projection
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// What the projection does with events of a given name
+type HandlerPolicy string
+
+const (
+	// the registered handler processes the event
+	HandlerPolicyProcess HandlerPolicy = "process"
+	// the event is acked and discarded
+	HandlerPolicyIgnore HandlerPolicy = "ignore"
+	// the event is stored without the state of its cluster, host or
+	// infra-env. It's still transformed, i.e. anonymized, as enriched events are
+	HandlerPolicyPassThrough HandlerPolicy = "pass-through"
+	// processing fails, so the consumer stops at the event
+	HandlerPolicyFail HandlerPolicy = "fail"
+)
+
+// Processes an event. Unless they return an error, handlers are responsible
+// for the message to be acked
+type EventHandler func(ctx context.Context, event *types.Event, msg *kafka.Message) error
+
+type registeredHandler struct {
+	policy  HandlerPolicy
+	handler EventHandler
+}
+
+// Maps event names to the policy, and handler, they are processed with.
+// Names not registered follow the default policy, and are counted
+type HandlerRegistry struct {
+	handlers      map[string]registeredHandler
+	defaultPolicy HandlerPolicy
+
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// The default policy cannot be process, as there is no handler for unknown
+// names
+func NewHandlerRegistry(defaultPolicy HandlerPolicy) (*HandlerRegistry, error) {
+	if !isValidPolicy(defaultPolicy) || defaultPolicy == HandlerPolicyProcess {
+		return nil, fmt.Errorf("invalid policy for unknown events: %q", defaultPolicy)
+	}
+	return &HandlerRegistry{
+		handlers:      map[string]registeredHandler{},
+		defaultPolicy: defaultPolicy,
+		counts:        map[string]int64{},
+	}, nil
+}
+
+func isValidPolicy(policy HandlerPolicy) bool {
+	switch policy {
+	case HandlerPolicyProcess, HandlerPolicyIgnore, HandlerPolicyPassThrough, HandlerPolicyFail:
+		return true
+	}
+	return false
+}
+
+// Registers the policy of events with the given name. The handler is required
+// by the process policy only, and replaces any registered before
+func (r *HandlerRegistry) Register(name string, policy HandlerPolicy, handler EventHandler) error {
+	if !isValidPolicy(policy) {
+		return fmt.Errorf("invalid policy for %s events: %q", name, policy)
+	}
+	if policy == HandlerPolicyProcess && handler == nil {
+		return fmt.Errorf("missing handler for %s events", name)
+	}
+	r.handlers[name] = registeredHandler{
+		policy:  policy,
+		handler: handler,
+	}
+	return nil
+}
+
+// Changes the policy of events with the given name, keeping their handler.
+// Only names registered with a handler can be processed
+func (r *HandlerRegistry) SetPolicy(name string, policy HandlerPolicy) error {
+	return r.Register(name, policy, r.handlers[name].handler)
+}
+
+// Returns the policy and handler of events with the given name, and whether
+// the name is registered. Unknown names are counted
+func (r *HandlerRegistry) Get(name string) (HandlerPolicy, EventHandler, bool) {
+	registered, ok := r.handlers[name]
+	if ok {
+		return registered.policy, registered.handler, true
+	}
+	r.mu.Lock()
+	r.counts[name]++
+	r.mu.Unlock()
+	return r.defaultPolicy, nil, false
+}
+
+// Number of events seen with the given unknown name
+func (r *HandlerRegistry) Count(name string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[name]
+}
+
+// Number of events seen by unknown name
+func (r *HandlerRegistry) Counts() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := make(map[string]int64, len(r.counts))
+	for name, count := range r.counts {
+		counts[name] = count
+	}
+	return counts
+}
@@ -0,0 +1,76 @@
+package projection
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+var _ = Describe("Registering event handlers", func() {
+	noop := func(ctx context.Context, event *types.Event, msg *kafka.Message) error {
+		return nil
+	}
+
+	It("should only accept known policies for unknown events", func() {
+		for _, policy := range []HandlerPolicy{HandlerPolicyIgnore, HandlerPolicyPassThrough, HandlerPolicyFail} {
+			_, err := NewHandlerRegistry(policy)
+			Expect(err).To(BeNil())
+		}
+		_, err := NewHandlerRegistry(HandlerPolicyProcess)
+		Expect(err).ToNot(BeNil())
+		_, err = NewHandlerRegistry("drop")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should require a handler to process events", func() {
+		registry, err := NewHandlerRegistry(HandlerPolicyIgnore)
+		Expect(err).To(BeNil())
+		Expect(registry.Register("ClusterState", HandlerPolicyProcess, nil)).ToNot(BeNil())
+		Expect(registry.Register("ClusterState", "drop", noop)).ToNot(BeNil())
+		Expect(registry.Register("ClusterState", HandlerPolicyProcess, noop)).To(BeNil())
+
+		policy, handler, known := registry.Get("ClusterState")
+		Expect(policy).To(Equal(HandlerPolicyProcess))
+		Expect(handler).ToNot(BeNil())
+		Expect(known).To(BeTrue())
+	})
+
+	It("should keep the handler when changing the policy of a name", func() {
+		registry, err := NewHandlerRegistry(HandlerPolicyIgnore)
+		Expect(err).To(BeNil())
+		Expect(registry.Register("ClusterState", HandlerPolicyProcess, noop)).To(BeNil())
+
+		Expect(registry.SetPolicy("ClusterState", HandlerPolicyFail)).To(BeNil())
+		policy, _, known := registry.Get("ClusterState")
+		Expect(policy).To(Equal(HandlerPolicyFail))
+		Expect(known).To(BeTrue())
+
+		Expect(registry.SetPolicy("ClusterState", HandlerPolicyProcess)).To(BeNil())
+		policy, handler, _ := registry.Get("ClusterState")
+		Expect(policy).To(Equal(HandlerPolicyProcess))
+		Expect(handler).ToNot(BeNil())
+
+		Expect(registry.SetPolicy("ManifestState", HandlerPolicyPassThrough)).To(BeNil())
+		Expect(registry.SetPolicy("ManifestState", HandlerPolicyProcess)).ToNot(BeNil())
+		Expect(registry.Counts()).To(BeEmpty())
+	})
+
+	It("should follow the default policy for unknown names and count them", func() {
+		registry, err := NewHandlerRegistry(HandlerPolicyPassThrough)
+		Expect(err).To(BeNil())
+		Expect(registry.Register("ClusterState", HandlerPolicyProcess, noop)).To(BeNil())
+
+		policy, handler, known := registry.Get("ManifestState")
+		Expect(policy).To(Equal(HandlerPolicyPassThrough))
+		Expect(handler).To(BeNil())
+		Expect(known).To(BeFalse())
+		registry.Get("ManifestState")
+		registry.Get("ClusterState")
+
+		Expect(registry.Count("ManifestState")).To(Equal(int64(2)))
+		Expect(registry.Counts()).To(Equal(map[string]int64{"ManifestState": 2}))
+	})
+})
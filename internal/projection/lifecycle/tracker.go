@@ -0,0 +1,302 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	redis_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/redis"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	StatusInstalled = "installed"
+	StatusError     = "error"
+	StatusCancelled = "cancelled"
+)
+
+// statuses of a cluster before installation, that resets go back to
+var preInstallStatuses = map[string]bool{
+	"insufficient":      true,
+	"ready":             true,
+	"pending-for-input": true,
+}
+
+// statuses of a cluster being installed
+var installingStatuses = map[string]bool{
+	"preparing-for-installation":     true,
+	"installing":                     true,
+	"installing-pending-user-action": true,
+	"finalizing":                     true,
+}
+
+// statuses the installation of a cluster ends with
+var finishedStatuses = map[string]bool{
+	StatusInstalled: true,
+	StatusError:     true,
+	StatusCancelled: true,
+}
+
+// Derives installation attempts of clusters from status changes in their
+// successive states, and those of their hosts
+type Tracker struct {
+	logger     *logrus.Logger
+	repository redis_repo.LifecycleRepositoryInterface
+}
+
+func NewTracker(logger *logrus.Logger, repository redis_repo.LifecycleRepositoryInterface) *Tracker {
+	return &Tracker{
+		logger:     logger,
+		repository: repository,
+	}
+}
+
+// Returns the documents of the attempts changed by the cluster state, if any.
+// States carrying the tracked status change again, i.e. retried or redelivered
+// after the lifecycle was saved, return its documents again
+func (t *Tracker) TrackCluster(ctx context.Context, clusterID string, payload interface{}) ([]*types.LifecycleDocument, error) {
+	status, at, ok := getStatus(payload)
+	if !ok {
+		return nil, nil
+	}
+	lifecycle, err := t.repository.Get(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if lifecycle == nil {
+		registeredAt, ok := getTime(payload, "created_at", "CreatedAt")
+		if !ok {
+			registeredAt = at
+		}
+		lifecycle = NewClusterLifecycle(clusterID, registeredAt)
+	}
+	documents, changed := SetClusterStatus(lifecycle, status, at)
+	if !changed {
+		return GetClusterStatusDocuments(lifecycle, status, at), nil
+	}
+	t.logger.WithFields(logrus.Fields{
+		"cluster_id": clusterID,
+		"status":     status,
+		"attempt":    lifecycle.Attempt.Attempt,
+	}).Debug("cluster status changed")
+	return documents, t.repository.Set(ctx, lifecycle)
+}
+
+// Returns the document of the current attempt when the host state changed it,
+// or carries the tracked status change of the host again
+func (t *Tracker) TrackHost(ctx context.Context, clusterID, hostID string, payload interface{}) ([]*types.LifecycleDocument, error) {
+	status, at, ok := getStatus(payload)
+	if !ok {
+		return nil, nil
+	}
+	lifecycle, err := t.repository.Get(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	// attempts begin with the cluster state
+	if lifecycle == nil {
+		return nil, nil
+	}
+	documents, changed := SetHostStatus(lifecycle, hostID, status, at)
+	if !changed {
+		return GetHostStatusDocuments(lifecycle, hostID, status, at), nil
+	}
+	return documents, t.repository.Set(ctx, lifecycle)
+}
+
+func NewClusterLifecycle(clusterID string, registeredAt time.Time) *types.ClusterLifecycle {
+	lifecycle := &types.ClusterLifecycle{
+		ClusterID: clusterID,
+		Hosts:     map[string]types.HostLifecycle{},
+	}
+	lifecycle.Attempt = newAttempt(lifecycle, 1, registeredAt)
+	return lifecycle
+}
+
+func newAttempt(lifecycle *types.ClusterLifecycle, attempt int, registeredAt time.Time) types.LifecycleDocument {
+	return types.LifecycleDocument{
+		ID:                      fmt.Sprintf("%s_%d", lifecycle.ClusterID, attempt),
+		ClusterID:               lifecycle.ClusterID,
+		Attempt:                 attempt,
+		Resets:                  lifecycle.Resets,
+		Status:                  lifecycle.Status,
+		RegisteredAt:            registeredAt,
+		TimeInStatusSeconds:     map[string]float64{},
+		HostTimeInStatusSeconds: map[string]float64{},
+		HostCount:               len(lifecycle.Hosts),
+	}
+}
+
+// Applies a cluster status change. Returns the documents to emit, and false
+// when the status did not change or is older than the current one
+func SetClusterStatus(lifecycle *types.ClusterLifecycle, status string, at time.Time) ([]*types.LifecycleDocument, bool) {
+	if status == lifecycle.Status || at.Before(lifecycle.StatusSince) {
+		return nil, false
+	}
+	previous := lifecycle.Status
+	if previous != "" {
+		addSeconds(lifecycle.Attempt.TimeInStatusSeconds, previous, at.Sub(lifecycle.StatusSince))
+	}
+	lifecycle.Status = status
+	lifecycle.StatusSince = at
+
+	var documents []*types.LifecycleDocument
+	attempt := &lifecycle.Attempt
+	started := attempt.InstallStartedAt != nil
+	switch {
+	case installingStatuses[status] && !installingStatuses[previous]:
+		// installing again without a reset, i.e. after a failure
+		if started {
+			documents = append(documents, rollAttempt(lifecycle, at))
+			attempt = &lifecycle.Attempt
+		}
+		attempt.InstallStartedAt = &at
+		attempt.RegistrationToInstallStartSeconds = seconds(at.Sub(attempt.RegisteredAt))
+	case finishedStatuses[status] && started && attempt.InstallCompletedAt == nil:
+		attempt.InstallCompletedAt = &at
+		attempt.Result = status
+		attempt.InstallDurationSeconds = seconds(at.Sub(*attempt.InstallStartedAt))
+	case preInstallStatuses[status] && started:
+		// back to a status before installation: the attempt was reset
+		lifecycle.Resets++
+		attempt.Resets = lifecycle.Resets
+		documents = append(documents, rollAttempt(lifecycle, at))
+		attempt = &lifecycle.Attempt
+	}
+	attempt.Status = status
+	attempt.UpdatedAt = at
+	if attempt.InstallStartedAt != nil {
+		documents = append(documents, copyAttempt(attempt))
+	}
+	return documents, true
+}
+
+// Applies a host status change. Returns the document of the current attempt,
+// if started, and false when the status did not change or is older than the
+// current one
+func SetHostStatus(lifecycle *types.ClusterLifecycle, hostID, status string, at time.Time) ([]*types.LifecycleDocument, bool) {
+	host, ok := lifecycle.Hosts[hostID]
+	if ok && (status == host.Status || at.Before(host.StatusSince)) {
+		return nil, false
+	}
+	if lifecycle.Hosts == nil {
+		lifecycle.Hosts = map[string]types.HostLifecycle{}
+	}
+	attempt := &lifecycle.Attempt
+	if ok {
+		addSeconds(attempt.HostTimeInStatusSeconds, host.Status, at.Sub(host.StatusSince))
+	} else {
+		attempt.HostCount++
+	}
+	lifecycle.Hosts[hostID] = types.HostLifecycle{Status: status, StatusSince: at}
+	attempt.UpdatedAt = at
+	if attempt.InstallStartedAt == nil {
+		return nil, true
+	}
+	return []*types.LifecycleDocument{copyAttempt(attempt)}, true
+}
+
+// Returns the documents of the attempts changed by the cluster status change,
+// when it is the tracked one. Their first write may have failed after the
+// lifecycle was saved
+func GetClusterStatusDocuments(lifecycle *types.ClusterLifecycle, status string, at time.Time) []*types.LifecycleDocument {
+	if status != lifecycle.Status || !at.Equal(lifecycle.StatusSince) {
+		return nil
+	}
+	var documents []*types.LifecycleDocument
+	// attempts end with the change starting the next one
+	if previous := lifecycle.PreviousAttempt; previous != nil && previous.UpdatedAt.Equal(at) {
+		documents = append(documents, copyAttempt(previous))
+	}
+	if lifecycle.Attempt.InstallStartedAt != nil {
+		documents = append(documents, copyAttempt(&lifecycle.Attempt))
+	}
+	return documents
+}
+
+// Returns the document of the current attempt, if started, when the host
+// status change is the tracked one
+func GetHostStatusDocuments(lifecycle *types.ClusterLifecycle, hostID, status string, at time.Time) []*types.LifecycleDocument {
+	host, ok := lifecycle.Hosts[hostID]
+	if !ok || status != host.Status || !at.Equal(host.StatusSince) || lifecycle.Attempt.InstallStartedAt == nil {
+		return nil
+	}
+	return []*types.LifecycleDocument{copyAttempt(&lifecycle.Attempt)}
+}
+
+// Ends the current attempt and starts the next one. Time hosts spent in their
+// current status so far is accounted to the ended attempt
+func rollAttempt(lifecycle *types.ClusterLifecycle, at time.Time) *types.LifecycleDocument {
+	ended := lifecycle.Attempt
+	ended.UpdatedAt = at
+	for hostID, host := range lifecycle.Hosts {
+		if at.After(host.StatusSince) {
+			addSeconds(ended.HostTimeInStatusSeconds, host.Status, at.Sub(host.StatusSince))
+			lifecycle.Hosts[hostID] = types.HostLifecycle{Status: host.Status, StatusSince: at}
+		}
+	}
+	lifecycle.Attempt = newAttempt(lifecycle, ended.Attempt+1, ended.RegisteredAt)
+	lifecycle.PreviousAttempt = copyAttempt(&ended)
+	return &ended
+}
+
+func copyAttempt(attempt *types.LifecycleDocument) *types.LifecycleDocument {
+	document := *attempt
+	document.TimeInStatusSeconds = copySeconds(attempt.TimeInStatusSeconds)
+	document.HostTimeInStatusSeconds = copySeconds(attempt.HostTimeInStatusSeconds)
+	return &document
+}
+
+func copySeconds(in map[string]float64) map[string]float64 {
+	out := make(map[string]float64, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func addSeconds(secondsByStatus map[string]float64, status string, d time.Duration) {
+	if d > 0 {
+		secondsByStatus[status] += d.Seconds()
+	}
+}
+
+func seconds(d time.Duration) *float64 {
+	s := d.Seconds()
+	return &s
+}
+
+// Status of the resource and when it was set
+func getStatus(payload interface{}) (string, time.Time, bool) {
+	fields, ok := payload.(map[string]interface{})
+	if !ok {
+		return "", time.Time{}, false
+	}
+	status, ok := fields["status"].(string)
+	if !ok || status == "" {
+		return "", time.Time{}, false
+	}
+	at, ok := getTime(payload, "status_updated_at", "updated_at", "UpdatedAt")
+	return status, at, ok
+}
+
+// First of the keys holding a valid time
+func getTime(payload interface{}, keys ...string) (time.Time, bool) {
+	fields, ok := payload.(map[string]interface{})
+	if !ok {
+		return time.Time{}, false
+	}
+	for _, key := range keys {
+		value, ok := fields[key].(string)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
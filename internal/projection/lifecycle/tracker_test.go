@@ -0,0 +1,197 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	redis_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/redis"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/sirupsen/logrus"
+)
+
+const clusterID = "3a930088-e49d-4584-bbd3-54a568dbe833"
+
+var registeredAt = time.Date(2023, 1, 20, 2, 0, 0, 0, time.UTC)
+
+func at(minutes int) time.Time {
+	return registeredAt.Add(time.Duration(minutes) * time.Minute)
+}
+
+var _ = Describe("Tracking cluster lifecycles", func() {
+	var lifecycle *types.ClusterLifecycle
+	BeforeEach(func() {
+		lifecycle = NewClusterLifecycle(clusterID, registeredAt)
+	})
+
+	setClusterStatus := func(status string, minutes int) []*types.LifecycleDocument {
+		documents, changed := SetClusterStatus(lifecycle, status, at(minutes))
+		Expect(changed).To(BeTrue())
+		return documents
+	}
+
+	It("should measure an installation attempt", func() {
+		Expect(setClusterStatus("insufficient", 0)).To(BeEmpty())
+		Expect(setClusterStatus("ready", 10)).To(BeEmpty())
+		documents := setClusterStatus("preparing-for-installation", 15)
+		Expect(documents).To(HaveLen(1))
+		Expect(documents[0].ID).To(Equal(clusterID + "_1"))
+		Expect(*documents[0].RegistrationToInstallStartSeconds).To(Equal(900.0))
+
+		setClusterStatus("installing", 20)
+		documents = setClusterStatus("installed", 80)
+		Expect(documents).To(HaveLen(1))
+		Expect(documents[0].Result).To(Equal(StatusInstalled))
+		Expect(*documents[0].InstallDurationSeconds).To(Equal(3900.0))
+		Expect(documents[0].TimeInStatusSeconds).To(Equal(map[string]float64{
+			"insufficient":               600,
+			"ready":                      300,
+			"preparing-for-installation": 300,
+			"installing":                 3600,
+		}))
+	})
+
+	It("should start a new attempt after a reset", func() {
+		setClusterStatus("ready", 0)
+		setClusterStatus("installing", 10)
+		setClusterStatus("error", 20)
+		documents := setClusterStatus("insufficient", 30)
+		Expect(documents).To(HaveLen(1))
+		Expect(documents[0].Attempt).To(Equal(1))
+		Expect(documents[0].Resets).To(Equal(1))
+		Expect(documents[0].Status).To(Equal(StatusError))
+		Expect(documents[0].TimeInStatusSeconds).To(HaveKeyWithValue(StatusError, 600.0))
+
+		Expect(setClusterStatus("ready", 40)).To(BeEmpty())
+		documents = setClusterStatus("installing", 45)
+		Expect(documents).To(HaveLen(1))
+		Expect(documents[0].ID).To(Equal(clusterID + "_2"))
+		Expect(documents[0].Resets).To(Equal(1))
+		Expect(documents[0].TimeInStatusSeconds).To(Equal(map[string]float64{
+			"insufficient": 600,
+			"ready":        300,
+		}))
+	})
+
+	It("should ignore unchanged and older statuses", func() {
+		setClusterStatus("installing", 10)
+		_, changed := SetClusterStatus(lifecycle, "installing", at(20))
+		Expect(changed).To(BeFalse())
+		_, changed = SetClusterStatus(lifecycle, "ready", at(5))
+		Expect(changed).To(BeFalse())
+	})
+
+	It("should return the documents of the tracked status change again", func() {
+		setClusterStatus("ready", 0)
+		setClusterStatus("installing", 10)
+		setClusterStatus("error", 20)
+		documents := setClusterStatus("installing", 30)
+		Expect(documents).To(HaveLen(2))
+
+		Expect(GetClusterStatusDocuments(lifecycle, "installing", at(30))).To(Equal(documents))
+		Expect(GetClusterStatusDocuments(lifecycle, "error", at(20))).To(BeEmpty())
+		Expect(GetClusterStatusDocuments(lifecycle, "installing", at(40))).To(BeEmpty())
+	})
+
+	It("should sum the time hosts spend in each status", func() {
+		setClusterStatus("ready", 0)
+		for _, hostID := range []string{"host-1", "host-2"} {
+			documents, changed := SetHostStatus(lifecycle, hostID, "known", at(0))
+			Expect(changed).To(BeTrue())
+			Expect(documents).To(BeEmpty())
+		}
+		setClusterStatus("installing", 10)
+		for _, hostID := range []string{"host-1", "host-2"} {
+			_, changed := SetHostStatus(lifecycle, hostID, "installing", at(10))
+			Expect(changed).To(BeTrue())
+		}
+		documents, _ := SetHostStatus(lifecycle, "host-1", "installed", at(40))
+		Expect(documents).To(HaveLen(1))
+		Expect(documents[0].HostCount).To(Equal(2))
+		Expect(documents[0].HostTimeInStatusSeconds).To(Equal(map[string]float64{
+			"known":      1200,
+			"installing": 1800,
+		}))
+	})
+
+	When("tracking with a repository", func() {
+		var (
+			ctx           context.Context
+			mockRepo      *redis_repo.MockLifecycleRepositoryInterface
+			tracker       *Tracker
+			clusterStatus map[string]interface{}
+		)
+		BeforeEach(func() {
+			ctx = context.Background()
+			logger := logrus.New()
+			logger.Out = io.Discard
+			mockRepo = redis_repo.NewMockLifecycleRepositoryInterface(gomock.NewController(GinkgoT()))
+			tracker = NewTracker(logger, mockRepo)
+			clusterStatus = map[string]interface{}{
+				"id":                clusterID,
+				"status":            "installing",
+				"created_at":        registeredAt.Format(time.RFC3339),
+				"status_updated_at": at(30).Format(time.RFC3339),
+			}
+		})
+
+		It("should start tracking clusters from their registration", func() {
+			mockRepo.EXPECT().Get(ctx, clusterID).Times(1).Return(nil, nil)
+			mockRepo.EXPECT().Set(ctx, gomock.Any()).Times(1).Return(nil)
+
+			documents, err := tracker.TrackCluster(ctx, clusterID, clusterStatus)
+			Expect(err).To(BeNil())
+			Expect(documents).To(HaveLen(1))
+			Expect(*documents[0].RegistrationToInstallStartSeconds).To(Equal(1800.0))
+		})
+
+		It("should not track hosts of clusters not tracked yet", func() {
+			mockRepo.EXPECT().Get(ctx, clusterID).Times(1).Return(nil, nil)
+			mockRepo.EXPECT().Set(gomock.Any(), gomock.Any()).Times(0)
+
+			documents, err := tracker.TrackHost(ctx, clusterID, "host-1", clusterStatus)
+			Expect(err).To(BeNil())
+			Expect(documents).To(BeEmpty())
+		})
+
+		It("should return the documents again when the status change is retried", func() {
+			lifecycle := NewClusterLifecycle(clusterID, registeredAt)
+			documents, _ := SetClusterStatus(lifecycle, "installing", at(30))
+			mockRepo.EXPECT().Get(ctx, clusterID).Times(1).Return(lifecycle, nil)
+			mockRepo.EXPECT().Set(gomock.Any(), gomock.Any()).Times(0)
+
+			retried, err := tracker.TrackCluster(ctx, clusterID, clusterStatus)
+			Expect(err).To(BeNil())
+			Expect(retried).To(Equal(documents))
+		})
+
+		It("should return the document again when the host status change is retried", func() {
+			lifecycle := NewClusterLifecycle(clusterID, registeredAt)
+			SetClusterStatus(lifecycle, "installing", at(30))
+			documents, _ := SetHostStatus(lifecycle, "host-1", "installing", at(30))
+			mockRepo.EXPECT().Get(ctx, clusterID).Times(1).Return(lifecycle, nil)
+			mockRepo.EXPECT().Set(gomock.Any(), gomock.Any()).Times(0)
+
+			retried, err := tracker.TrackHost(ctx, clusterID, "host-1", clusterStatus)
+			Expect(err).To(BeNil())
+			Expect(retried).To(Equal(documents))
+		})
+
+		It("should return errors reading the lifecycle", func() {
+			mockRepo.EXPECT().Get(ctx, clusterID).Times(1).Return(nil, errors.New("connection refused"))
+
+			_, err := tracker.TrackCluster(ctx, clusterID, clusterStatus)
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
+
+func TestLifecycle(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cluster lifecycle")
+}
@@ -34,6 +34,20 @@ func (m *MockEventEnricherInterface) EXPECT() *MockEventEnricherInterfaceMockRec
 	return m.recorder
 }
 
+// GetClusterDocument mocks base method.
+func (m *MockEventEnricherInterface) GetClusterDocument(clusterID string, cluster map[string]interface{}, hosts, infraEnvs []map[string]interface{}) *types.ClusterDocument {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetClusterDocument", clusterID, cluster, hosts, infraEnvs)
+	ret0, _ := ret[0].(*types.ClusterDocument)
+	return ret0
+}
+
+// GetClusterDocument indicates an expected call of GetClusterDocument.
+func (mr *MockEventEnricherInterfaceMockRecorder) GetClusterDocument(clusterID, cluster, hosts, infraEnvs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClusterDocument", reflect.TypeOf((*MockEventEnricherInterface)(nil).GetClusterDocument), clusterID, cluster, hosts, infraEnvs)
+}
+
 // GetEnrichedEvent mocks base method.
 func (m *MockEventEnricherInterface) GetEnrichedEvent(event *types.Event, cluster map[string]interface{}, hosts, infraEnvs []map[string]interface{}) *types.EnrichedEvent {
 	m.ctrl.T.Helper()
@@ -0,0 +1,346 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	opensearch_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/opensearch"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	errModeWatcherClosed = errors.New("mode watcher closed")
+	// the event is processed again once buffered ones are stored
+	errEventPaused = errors.New("event paused")
+)
+
+// Marks writes run by drain, which are not buffered again
+type drainingKey struct{}
+
+// Polls the projection mode from the config index and gates writes to
+// opensearch. While offline, writes are kept unacked and run once back online:
+// the latest write of each document, and enriched events in order. Events are
+// buffered up to bufferSize, further ones are paused and processed again after
+// the buffered ones, so that writers never wait
+type ModeWatcher struct {
+	logger                  *logrus.Logger
+	configRepository        opensearch_repo.ProjectionConfigRepositoryInterface
+	enrichedEventRepository opensearch_repo.EnrichedEventRepositoryInterface
+	pollInterval            time.Duration
+	bufferSize              int
+	listeners               []func(types.ProjectionConfig)
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	mode types.ProjectionMode
+	// latest write of each document, by key in the order they were first written
+	documents     map[string]*documentWrite
+	documentOrder []string
+	buffer        []func(context.Context) error
+	paused        []func(context.Context) error
+	// pending writes are being run, new ones are kept after them
+	draining bool
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type documentWrite struct {
+	write func(context.Context) error
+	// called when replaced before being run
+	discard func()
+	running bool
+}
+
+func NewModeWatcher(logger *logrus.Logger, configRepo opensearch_repo.ProjectionConfigRepositoryInterface, enrichedEventRepo opensearch_repo.EnrichedEventRepositoryInterface, pollInterval time.Duration, bufferSize int) *ModeWatcher {
+	w := &ModeWatcher{
+		logger:                  logger,
+		configRepository:        configRepo,
+		enrichedEventRepository: enrichedEventRepo,
+		pollInterval:            pollInterval,
+		bufferSize:              bufferSize,
+		mode:                    types.ProjectionModeOnline,
+		documents:               map[string]*documentWrite{},
+		done:                    make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// Registers a function called with every polled config, before Start
+func (w *ModeWatcher) OnConfig(listener func(types.ProjectionConfig)) {
+	w.listeners = append(w.listeners, listener)
+}
+
+// Reads the current mode, then keeps polling it in background until closed
+func (w *ModeWatcher) Start(ctx context.Context) {
+	w.Refresh(ctx)
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(w.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				w.Refresh(ctx)
+			}
+		}
+	}()
+}
+
+// Reads the mode from the config index, running pending writes when online.
+// The last known mode is kept when the config cannot be read
+func (w *ModeWatcher) Refresh(ctx context.Context) {
+	config, err := w.configRepository.Get(ctx)
+	if err != nil {
+		w.logger.WithError(err).WithField("mode", w.Mode()).Warn("could not read projection config, keeping current mode")
+		return
+	}
+	for _, listener := range w.listeners {
+		listener(config)
+	}
+	mode := config.Mode
+	if mode != types.ProjectionModeOffline {
+		mode = types.ProjectionModeOnline
+	}
+
+	w.mu.Lock()
+	if mode != w.mode {
+		w.logger.WithFields(logrus.Fields{
+			"from":      w.mode,
+			"to":        mode,
+			"documents": len(w.documentOrder),
+			"buffered":  len(w.buffer),
+			"paused":    len(w.paused),
+		}).Info("projection mode changed")
+		w.mode = mode
+	}
+	if mode != types.ProjectionModeOnline || !w.pending() || w.draining || w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.draining = true
+	w.mu.Unlock()
+	w.drain(ctx)
+}
+
+func (w *ModeWatcher) Mode() types.ProjectionMode {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.mode
+}
+
+// Stores the enriched event when online, see Run
+func (w *ModeWatcher) Store(ctx context.Context, enrichedEvent *types.EnrichedEvent, msg *kafka.Message, reprocess func(context.Context) error) error {
+	return w.Run(ctx, func(ctx context.Context) error {
+		return w.enrichedEventRepository.Store(ctx, enrichedEvent, msg)
+	}, reprocess)
+}
+
+// Runs the event write when online. Otherwise it is buffered, or, when the
+// buffer is full, reprocess is kept instead and errEventPaused returned
+func (w *ModeWatcher) Run(ctx context.Context, write func(context.Context) error, reprocess func(context.Context) error) error {
+	if ctx.Value(drainingKey{}) != nil {
+		return write(ctx)
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errModeWatcherClosed
+	}
+	if !w.buffering() && !w.pending() {
+		// writes run in parallel when nothing is pending
+		w.mu.Unlock()
+		return write(ctx)
+	}
+	defer w.mu.Unlock()
+	w.drainInBackground(ctx)
+	// events are kept in order, once paused further ones are paused as well
+	if len(w.paused) == 0 && len(w.buffer) < w.bufferSize {
+		w.buffer = append(w.buffer, write)
+		return nil
+	}
+	w.paused = append(w.paused, reprocess)
+	return errEventPaused
+}
+
+// Runs the write of the document with the given key when online. Otherwise it
+// replaces the pending write of the document, which is discarded
+func (w *ModeWatcher) RunLatest(ctx context.Context, key string, write func(context.Context) error, discard func()) error {
+	if ctx.Value(drainingKey{}) != nil {
+		return write(ctx)
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errModeWatcherClosed
+	}
+	if !w.buffering() && !w.pending() {
+		w.mu.Unlock()
+		return write(ctx)
+	}
+	w.drainInBackground(ctx)
+	previous, ok := w.documents[key]
+	if !ok {
+		w.documentOrder = append(w.documentOrder, key)
+	}
+	w.documents[key] = &documentWrite{write: write, discard: discard}
+	w.mu.Unlock()
+	// a write being run is acked by its repository, or discarded once failed
+	if ok && !previous.running {
+		previous.discard()
+	}
+	return nil
+}
+
+// Whether writes are kept, while offline or draining. Must be called with the
+// lock held
+func (w *ModeWatcher) buffering() bool {
+	return w.mode == types.ProjectionModeOffline || w.draining
+}
+
+// Must be called with the lock held
+func (w *ModeWatcher) pending() bool {
+	return len(w.documentOrder) > 0 || len(w.buffer) > 0 || len(w.paused) > 0
+}
+
+// Runs pending writes left while online, without waiting for them. Must be
+// called with the lock held
+func (w *ModeWatcher) drainInBackground(ctx context.Context) {
+	if w.mode != types.ProjectionModeOnline || w.draining {
+		return
+	}
+	w.draining = true
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.drain(context.WithoutCancel(ctx))
+	}()
+}
+
+// Runs pending writes, including the ones kept meanwhile, until none is left
+// or the projection goes offline: documents first, then buffered events in
+// order and paused ones. Writes run without the lock held, so that neither
+// writers nor polling wait for them. A failed write is kept and retried on the
+// next poll, the failure is only logged: it doesn't belong to the writers
+// waiting for it. Must be called after setting draining
+func (w *ModeWatcher) drain(ctx context.Context) {
+	defer func() {
+		w.mu.Lock()
+		w.draining = false
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	}()
+	ctx = context.WithValue(ctx, drainingKey{}, true)
+	for {
+		w.mu.Lock()
+		if w.closed || w.mode == types.ProjectionModeOffline {
+			w.mu.Unlock()
+			return
+		}
+		var write func(context.Context) error
+		var document *documentWrite
+		var key string
+		switch {
+		case len(w.documentOrder) > 0:
+			key = w.documentOrder[0]
+			document = w.documents[key]
+			document.running = true
+			write = document.write
+		case len(w.buffer) > 0:
+			write = w.buffer[0]
+		case len(w.paused) > 0:
+			write = w.paused[0]
+		default:
+			w.buffer = nil
+			w.paused = nil
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+
+		err := write(ctx)
+
+		w.mu.Lock()
+		discard := w.complete(key, document, err)
+		w.mu.Unlock()
+		if discard != nil {
+			discard()
+		}
+		if err != nil {
+			w.logger.WithError(err).Warn("could not run pending writes, retrying on next poll")
+			return
+		}
+	}
+}
+
+// Removes the write run by drain, unless it failed. A failed document write
+// replaced meanwhile is returned to be discarded. Must be called with the lock
+// held
+func (w *ModeWatcher) complete(key string, document *documentWrite, err error) func() {
+	// pending writes are discarded when closing
+	if w.closed {
+		return nil
+	}
+	if document == nil {
+		queue := &w.buffer
+		if len(w.buffer) == 0 {
+			queue = &w.paused
+		}
+		if err == nil && len(*queue) > 0 {
+			(*queue)[0] = nil
+			*queue = (*queue)[1:]
+		}
+		return nil
+	}
+	document.running = false
+	if w.documents[key] != document {
+		// the latest write is still pending
+		if err != nil {
+			return document.discard
+		}
+		return nil
+	}
+	if err == nil {
+		delete(w.documents, key)
+		w.documentOrder = w.documentOrder[1:]
+	}
+	return nil
+}
+
+// Stops polling and waits for pending writes being run. Pending writes are not
+// acked, so they will be consumed again
+func (w *ModeWatcher) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	if w.pending() {
+		w.logger.WithFields(logrus.Fields{
+			"documents": len(w.documentOrder),
+			"buffered":  len(w.buffer),
+			"paused":    len(w.paused),
+		}).Warn("discarding pending writes, they will be consumed again")
+	}
+	w.documents = map[string]*documentWrite{}
+	w.documentOrder = nil
+	w.buffer = nil
+	w.paused = nil
+	// writes being run may not outlive the repositories
+	for w.draining {
+		w.cond.Wait()
+	}
+	w.mu.Unlock()
+
+	close(w.done)
+	w.wg.Wait()
+}
@@ -0,0 +1,262 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	opensearch_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/opensearch"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Watching the projection mode", func() {
+	var (
+		ctx                   context.Context
+		ctrl                  *gomock.Controller
+		mockConfigRepo        *opensearch_repo.MockProjectionConfigRepositoryInterface
+		mockEnrichedEventRepo *opensearch_repo.MockEnrichedEventRepositoryInterface
+		watcher               *ModeWatcher
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger := logrus.New()
+		logger.Out = io.Discard
+		ctrl = gomock.NewController(GinkgoT())
+		mockConfigRepo = opensearch_repo.NewMockProjectionConfigRepositoryInterface(ctrl)
+		mockEnrichedEventRepo = opensearch_repo.NewMockEnrichedEventRepositoryInterface(ctrl)
+		watcher = NewModeWatcher(logger, mockConfigRepo, mockEnrichedEventRepo, time.Hour, 2)
+	})
+	AfterEach(func() {
+		watcher.Close()
+		ctrl.Finish()
+	})
+
+	setMode := func(mode types.ProjectionMode) {
+		mockConfigRepo.EXPECT().Get(ctx).Times(1).Return(types.ProjectionConfig{Mode: mode}, nil)
+		watcher.Refresh(ctx)
+	}
+	getEvent := func(id string) (*types.EnrichedEvent, *kafka.Message) {
+		return &types.EnrichedEvent{ID: id}, &kafka.Message{Key: []byte(id)}
+	}
+	noReprocess := func(context.Context) error {
+		Fail("unexpected reprocessing")
+		return nil
+	}
+	isDraining := func() bool {
+		watcher.mu.Lock()
+		defer watcher.mu.Unlock()
+		return watcher.draining
+	}
+
+	When("online", func() {
+		It("should store events right away", func() {
+			setMode(types.ProjectionModeOnline)
+			event, msg := getEvent("1")
+			mockEnrichedEventRepo.EXPECT().Store(ctx, event, msg).Times(1).Return(nil)
+			Expect(watcher.Store(ctx, event, msg, noReprocess)).To(BeNil())
+		})
+		It("should not serialize writes", func() {
+			setMode(types.ProjectionModeOnline)
+			first, firstMsg := getEvent("1")
+			second, secondMsg := getEvent("2")
+			started := make(chan struct{})
+			release := make(chan struct{})
+			mockEnrichedEventRepo.EXPECT().Store(ctx, first, firstMsg).Times(1).DoAndReturn(func(context.Context, *types.EnrichedEvent, *kafka.Message) error {
+				close(started)
+				<-release
+				return nil
+			})
+			mockEnrichedEventRepo.EXPECT().Store(ctx, second, secondMsg).Times(1).Return(nil)
+			firstStored := make(chan error, 1)
+			go func() {
+				firstStored <- watcher.Store(ctx, first, firstMsg, noReprocess)
+			}()
+			Eventually(started).Should(BeClosed())
+			secondStored := make(chan error, 1)
+			go func() {
+				secondStored <- watcher.Store(ctx, second, secondMsg, noReprocess)
+			}()
+			Eventually(secondStored).Should(Receive(BeNil()))
+			close(release)
+			Eventually(firstStored).Should(Receive(BeNil()))
+		})
+		It("should treat an unset mode as online", func() {
+			setMode("")
+			Expect(watcher.Mode()).To(BeEquivalentTo(types.ProjectionModeOnline))
+		})
+	})
+
+	When("offline", func() {
+		It("should buffer events and store them in order when back online", func() {
+			setMode(types.ProjectionModeOffline)
+			first, firstMsg := getEvent("1")
+			second, secondMsg := getEvent("2")
+			Expect(watcher.Store(ctx, first, firstMsg, noReprocess)).To(BeNil())
+			Expect(watcher.Store(ctx, second, secondMsg, noReprocess)).To(BeNil())
+
+			gomock.InOrder(
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), first, firstMsg).Times(1).Return(nil),
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), second, secondMsg).Times(1).Return(nil),
+			)
+			setMode(types.ProjectionModeOnline)
+		})
+
+		It("should pause events once the buffer is full and process them again after buffered ones", func() {
+			setMode(types.ProjectionModeOffline)
+			for _, id := range []string{"1", "2"} {
+				event, msg := getEvent(id)
+				Expect(watcher.Store(ctx, event, msg, noReprocess)).To(BeNil())
+			}
+			reprocessed := []string{}
+			reprocess := func(id string) func(context.Context) error {
+				return func(ctx context.Context) error {
+					// stored right away, not buffered again
+					event, msg := getEvent(id)
+					reprocessed = append(reprocessed, id)
+					return watcher.Store(ctx, event, msg, noReprocess)
+				}
+			}
+			third, thirdMsg := getEvent("3")
+			Expect(watcher.Store(ctx, third, thirdMsg, reprocess("3"))).To(MatchError(errEventPaused))
+
+			gomock.InOrder(
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), &types.EnrichedEvent{ID: "1"}, gomock.Any()).Times(1).Return(nil),
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), &types.EnrichedEvent{ID: "2"}, gomock.Any()).Times(1).Return(nil),
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), third, thirdMsg).Times(1).Return(nil),
+			)
+			setMode(types.ProjectionModeOnline)
+			Expect(reprocessed).To(Equal([]string{"3"}))
+			Expect(watcher.paused).To(BeEmpty())
+		})
+
+		It("should keep the latest write of each document only", func() {
+			setMode(types.ProjectionModeOffline)
+			written := []string{}
+			discarded := []string{}
+			write := func(key, version string) {
+				Expect(watcher.RunLatest(ctx, key, func(context.Context) error {
+					written = append(written, key+"@"+version)
+					return nil
+				}, func() {
+					discarded = append(discarded, key+"@"+version)
+				})).To(Succeed())
+			}
+			write("cluster/1", "1")
+			write("cluster/2", "1")
+			write("cluster/1", "2")
+			Expect(discarded).To(Equal([]string{"cluster/1@1"}))
+
+			setMode(types.ProjectionModeOnline)
+			Expect(written).To(Equal([]string{"cluster/1@2", "cluster/2@1"}))
+			Expect(discarded).To(HaveLen(1))
+		})
+
+		It("should not hold document writes while the buffer is full", func() {
+			setMode(types.ProjectionModeOffline)
+			for _, id := range []string{"1", "2"} {
+				event, msg := getEvent(id)
+				Expect(watcher.Store(ctx, event, msg, noReprocess)).To(BeNil())
+			}
+			Expect(watcher.RunLatest(ctx, "cluster/1", func(context.Context) error { return nil }, func() {})).To(Succeed())
+			Expect(watcher.documentOrder).To(Equal([]string{"cluster/1"}))
+			Expect(watcher.paused).To(BeEmpty())
+		})
+
+		It("should keep events not stored yet when draining fails", func() {
+			setMode(types.ProjectionModeOffline)
+			first, firstMsg := getEvent("1")
+			second, secondMsg := getEvent("2")
+			Expect(watcher.Store(ctx, first, firstMsg, noReprocess)).To(BeNil())
+			Expect(watcher.Store(ctx, second, secondMsg, noReprocess)).To(BeNil())
+
+			gomock.InOrder(
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), first, firstMsg).Times(1).Return(nil),
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), second, secondMsg).Times(1).Return(errors.New("queue closed")),
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), second, secondMsg).Times(1).Return(nil),
+			)
+			setMode(types.ProjectionModeOnline)
+			setMode(types.ProjectionModeOnline)
+		})
+
+		It("should not block writers nor polling while storing buffered events", func() {
+			setMode(types.ProjectionModeOffline)
+			first, firstMsg := getEvent("1")
+			second, secondMsg := getEvent("2")
+			Expect(watcher.Store(ctx, first, firstMsg, noReprocess)).To(BeNil())
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+			gomock.InOrder(
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), first, firstMsg).Times(1).DoAndReturn(func(context.Context, *types.EnrichedEvent, *kafka.Message) error {
+					close(started)
+					<-release
+					return nil
+				}),
+				mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), second, secondMsg).Times(1).Return(nil),
+			)
+			mockConfigRepo.EXPECT().Get(ctx).Times(2).Return(types.ProjectionConfig{Mode: types.ProjectionModeOnline}, nil)
+			drained := make(chan struct{})
+			go func() {
+				defer close(drained)
+				watcher.Refresh(ctx)
+			}()
+			Eventually(started).Should(BeClosed())
+
+			// buffered after the event being stored
+			Expect(watcher.Store(ctx, second, secondMsg, noReprocess)).To(BeNil())
+			watcher.Refresh(ctx)
+			Expect(watcher.Mode()).To(BeEquivalentTo(types.ProjectionModeOnline))
+
+			close(release)
+			Eventually(drained).Should(BeClosed())
+			Expect(watcher.buffer).To(BeEmpty())
+		})
+
+		It("should stay offline when the config cannot be read", func() {
+			setMode(types.ProjectionModeOffline)
+			event, msg := getEvent("1")
+			Expect(watcher.Store(ctx, event, msg, noReprocess)).To(BeNil())
+
+			mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			mockConfigRepo.EXPECT().Get(ctx).Times(1).Return(types.ProjectionConfig{Mode: types.ProjectionModeOnline}, errors.New("connection refused"))
+			watcher.Refresh(ctx)
+			Expect(watcher.Mode()).To(BeEquivalentTo(types.ProjectionModeOffline))
+			Expect(watcher.buffer).To(HaveLen(1))
+		})
+
+		It("should not return failures of pending writes to writers", func() {
+			setMode(types.ProjectionModeOffline)
+			first, firstMsg := getEvent("1")
+			second, secondMsg := getEvent("2")
+			Expect(watcher.Store(ctx, first, firstMsg, noReprocess)).To(BeNil())
+
+			mockEnrichedEventRepo.EXPECT().Store(gomock.Any(), first, firstMsg).Times(2).Return(errors.New("queue closed"))
+			setMode(types.ProjectionModeOnline)
+			// pending writes are run in background, the new event is kept after them
+			Expect(watcher.Store(ctx, second, secondMsg, noReprocess)).To(BeNil())
+			Eventually(isDraining).Should(BeFalse())
+			Expect(watcher.buffer).To(HaveLen(2))
+		})
+
+		It("should reject writes once closed", func() {
+			setMode(types.ProjectionModeOffline)
+			watcher.Close()
+			event, msg := getEvent("1")
+			Expect(watcher.Store(ctx, event, msg, noReprocess)).To(MatchError(errModeWatcherClosed))
+		})
+	})
+
+	When("started", func() {
+		It("should read the mode before processing any event", func() {
+			mockConfigRepo.EXPECT().Get(ctx).Times(1).Return(types.ProjectionConfig{Mode: types.ProjectionModeOffline}, nil)
+			watcher.Start(ctx)
+			Expect(watcher.Mode()).To(BeEquivalentTo(types.ProjectionModeOffline))
+		})
+	})
+})
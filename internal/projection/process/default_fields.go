@@ -1,7 +1,14 @@
 package process
 
+import "strings"
+
+const (
+	clusterHostsPrefix = "cluster.hosts[*]."
+	eventHostPrefix    = "host."
+)
+
 func getDefaultFieldsToUnpack() []string {
-	return []string{
+	return withEventHostPaths([]string{
 		"cluster.feature_usage",
 		"cluster.validations_info",
 		"cluster.connectivity_majority_groups",
@@ -13,7 +20,7 @@ func getDefaultFieldsToUnpack() []string {
 		"cluster.hosts[*].images_status",
 		"cluster.hosts[*].ntp_sources",
 		"cluster.hosts[*].validations_info",
-	}
+	})
 }
 
 func getDefaultFieldsMapToListDropKey() []string {
@@ -23,27 +30,54 @@ func getDefaultFieldsMapToListDropKey() []string {
 }
 
 func getDefaultFieldsMapToList() []string {
-	return []string{
+	return withEventHostPaths([]string{
 		"cluster.connectivity_majority_groups",
 		"cluster.hosts[*].disks_info",
 		"cluster.hosts[*].images_status",
-	}
+	})
 }
 
 func getDefaultFieldsToDelete() []string {
-	return []string{
+	return withEventHostPaths([]string{
 		"cluster.pull_secret",
 		"cluster.ssh_public_key",
 		"cluster.hosts[*].infra_env.ssh_authorized_key",
 		"infra_envs[*].ssh_authorized_key",
-	}
+	})
 }
 
 func getDefaultFieldsToAnonymize() map[string]string {
-	return map[string]string{
+	fields := map[string]string{
 		"cluster.user_name":                    "cluster.user_id",
 		"cluster.hosts[*].user_name":           "cluster.hosts[*].user_id",
 		"cluster.hosts[*].infra_env.user_name": "cluster.hosts[*].infra_env.user_id",
 		"infra_envs[*].user_name":              "infra_envs[*].user_id",
 	}
+	hostFields := map[string]string{}
+	for src, dst := range fields {
+		if hostSrc, ok := toEventHostPath(src); ok {
+			hostFields[hostSrc], _ = toEventHostPath(dst)
+		}
+	}
+	for src, dst := range hostFields {
+		fields[src] = dst
+	}
+	return fields
+}
+
+// The host embedded in host events is transformed like cluster hosts
+func withEventHostPaths(paths []string) []string {
+	for _, path := range paths {
+		if hostPath, ok := toEventHostPath(path); ok {
+			paths = append(paths, hostPath)
+		}
+	}
+	return paths
+}
+
+func toEventHostPath(path string) (string, bool) {
+	if !strings.HasPrefix(path, clusterHostsPrefix) {
+		return "", false
+	}
+	return eventHostPrefix + strings.TrimPrefix(path, clusterHostsPrefix), true
 }
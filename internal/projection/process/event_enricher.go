@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 
-	"github.com/google/uuid"
 	"github.com/openshift-assisted/assisted-events-streams/internal/types"
 	"github.com/openshift-assisted/assisted-events-streams/pkg/jsonedit"
 	"github.com/sirupsen/logrus"
@@ -17,11 +16,14 @@ type EventEnricher struct {
 	fieldsMapToListDropKey []string
 	fieldsToDelete         []string
 	fieldsToAnonymize      map[string]string
+	// scheme of enriched event ids, see IDVersion1 and IDVersion2
+	idVersion int
 }
 
-func NewEventEnricher(logger *logrus.Logger) *EventEnricher {
+func NewEventEnricher(logger *logrus.Logger, idVersion int) *EventEnricher {
 	return &EventEnricher{
 		logger:                 logger,
+		idVersion:              idVersion,
 		fieldsToUnpack:         getDefaultFieldsToUnpack(),
 		fieldsMapToList:        getDefaultFieldsMapToList(),
 		fieldsMapToListDropKey: getDefaultFieldsMapToListDropKey(),
@@ -38,6 +40,29 @@ func (e *EventEnricher) GetEnrichedEvent(event *types.Event, cluster map[string]
 	return e.getTransformedEvent(enrichedEvent)
 }
 
+// Latest state of the cluster with its hosts and infra-envs, transformed the
+// same way enriched events are
+func (e *EventEnricher) GetClusterDocument(clusterID string, cluster map[string]interface{}, hosts []map[string]interface{}, infraEnvs []map[string]interface{}) *types.ClusterDocument {
+	hosts = getHostsWithEmbeddedInfraEnv(hosts, infraEnvs)
+	clusterWithHosts := make(map[string]interface{}, len(cluster)+1)
+	for k, v := range cluster {
+		clusterWithHosts[k] = v
+	}
+	clusterWithHosts["hosts"] = hosts
+	enrichedEvent := e.getTransformedEvent(&types.EnrichedEvent{
+		ClusterID: clusterID,
+		Cluster:   clusterWithHosts,
+		InfraEnvs: infraEnvs,
+	})
+	return &types.ClusterDocument{
+		ID:           clusterID,
+		Version:      getSnapshotsVersion([]map[string]interface{}{cluster}, hosts, infraEnvs),
+		HostsSummary: enrichedEvent.HostsSummary,
+		Cluster:      enrichedEvent.Cluster,
+		InfraEnvs:    enrichedEvent.InfraEnvs,
+	}
+}
+
 // Get event after having applied all required transformations
 func (e *EventEnricher) getTransformedEvent(enrichedEvent *types.EnrichedEvent) *types.EnrichedEvent {
 	originalJson, err := json.Marshal(enrichedEvent)
@@ -89,10 +114,6 @@ func (e *EventEnricher) addHostsSummaryJson(eventJson []byte) ([]byte, error) {
 
 // Get enriched event before applying any transformation
 func (e *EventEnricher) GetBaseEnrichedEvent(event *types.Event, cluster map[string]interface{}, hosts []map[string]interface{}, infraEnvs []map[string]interface{}) (*types.EnrichedEvent, error) {
-	namespace, err := uuid.FromBytes([]byte("abcdefghilmnopqr"))
-	if err != nil {
-		return nil, fmt.Errorf("Error getting uuid namespace, most likely seed is not 16 characters")
-	}
 	enrichedEvent := &types.EnrichedEvent{}
 	props, err := getProps(event.Payload)
 	if err != nil {
@@ -100,20 +121,37 @@ func (e *EventEnricher) GetBaseEnrichedEvent(event *types.Event, cluster map[str
 	}
 	enrichedEvent.Event.Properties = props
 
-	// remove ID, as it's passed as INT (only from onprem data)
+	// remove ID, as it's passed as INT (only from onprem data). It still tells
+	// apart events otherwise identical
+	var onpremID string
 	payload, ok := event.Payload.(map[string]interface{})
 	if ok {
+		if id, ok := payload["ID"]; ok && id != nil {
+			onpremID = fmt.Sprint(id)
+		}
 		delete(payload, "ID")
 	}
+	infraEnvID, _ := GetValueFromPayload("infra_env_id", payload)
 
 	eventBytes, err := json.Marshal(payload)
 	if err != nil {
 		return enrichedEvent, err
 	}
 	err = json.Unmarshal(eventBytes, enrichedEvent)
-	cluster["hosts"] = getHostsWithEmbeddedInfraEnv(hosts, infraEnvs)
+	hosts = getHostsWithEmbeddedInfraEnv(hosts, infraEnvs)
+	// events of infra-envs not bound to a cluster only embed the host they refer to
+	if enrichedEvent.ClusterID != "" || len(cluster) > 0 {
+		cluster["hosts"] = hosts
+	}
+	enrichedEvent.Host = getEventHost(enrichedEvent.HostID, hosts)
 
-	enrichedEvent.ID = uuid.NewSHA1(namespace, []byte(enrichedEvent.Message+enrichedEvent.EventTime)).String()
+	enrichedEvent.IDVersion = e.idVersion
+	if enrichedEvent.IDVersion != IDVersion1 {
+		enrichedEvent.IDVersion = IDVersion2
+		// lets migrations find documents indexed with the previous scheme
+		enrichedEvent.LegacyID = getLegacyID(enrichedEvent)
+	}
+	enrichedEvent.ID = getID(enrichedEvent.IDVersion, enrichedEvent, infraEnvID, onpremID)
 
 	enrichedEvent.Versions = e.getVersionsFromMetadata(event.Metadata, enrichedEvent.Name)
 	enrichedEvent.ReleaseTag = e.getReleaseTagFromMetadata(event.Metadata, enrichedEvent.Name)
@@ -195,6 +233,24 @@ func getHostsWithEmbeddedInfraEnv(hosts []map[string]interface{}, infraEnvs []ma
 	return hosts
 }
 
+// Host the event refers to. When its snapshot is not available, only the id is
+// known
+func getEventHost(hostID string, hosts []map[string]interface{}) map[string]interface{} {
+	if hostID == "" {
+		return nil
+	}
+	for _, host := range hosts {
+		if host["id"] == hostID {
+			eventHost := make(map[string]interface{}, len(host))
+			for k, v := range host {
+				eventHost[k] = v
+			}
+			return eventHost
+		}
+	}
+	return map[string]interface{}{"id": hostID}
+}
+
 func (e *EventEnricher) getEnrichedEventFromJson(eventJson []byte) *types.EnrichedEvent {
 	var outEvent types.EnrichedEvent
 	err := json.Unmarshal(eventJson, &outEvent)
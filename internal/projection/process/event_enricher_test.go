@@ -2,6 +2,7 @@ package process
 
 import (
 	"io"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -17,7 +18,7 @@ var _ = Describe("Process message", func() {
 	BeforeEach(func() {
 		logger = logrus.New()
 		logger.Out = io.Discard
-		enricher = NewEventEnricher(logger)
+		enricher = NewEventEnricher(logger, CurrentIDVersion)
 	})
 	When("Enrich a cluster event with fields to be transformed", func() {
 		It("gets transformed correctly", func() {
@@ -299,6 +300,74 @@ var _ = Describe("Process message", func() {
 			assertHostsSummary(enrichedEvent)
 		})
 	})
+	When("Enrich a host event", func() {
+		It("embeds the transformed host with its infra-env", func() {
+			event := getEvent("host_status_updated", "Host master-0-0: updated status from known to installing")
+			payload := event.Payload.(map[string]interface{})
+			payload["cluster_id"] = "3a930088-e49d-4584-bbd3-54a568dbe833"
+			payload["host_id"] = "9024b650-f31f-420b-b279-3296a6e7d2cc"
+			hosts := getHosts()
+			hosts[1]["role"] = "master"
+			hosts[1]["status"] = "installing"
+			enrichedEvent := enricher.GetEnrichedEvent(event, map[string]interface{}{}, hosts, getInfraEnvs())
+
+			Expect(enrichedEvent.HostID).To(Equal("9024b650-f31f-420b-b279-3296a6e7d2cc"))
+			host := enrichedEvent.Host
+			Expect(host["id"]).To(Equal("9024b650-f31f-420b-b279-3296a6e7d2cc"))
+			Expect(host["role"]).To(Equal("master"))
+			Expect(host["status"]).To(Equal("installing"))
+
+			_, ok := host["user_name"]
+			Expect(ok).To(BeFalse())
+			Expect(host["user_id"]).To(Equal("b3ce829e4327ba06e2ce8bd976ced308"))
+
+			inventory, ok := host["inventory"].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(inventory["hostname"]).To(Equal("master-0-0"))
+			disksInfo, ok := host["disks_info"].([]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(disksInfo).To(HaveLen(1))
+
+			infraEnv, ok := host["infra_env"].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(infraEnv["id"]).To(Equal("177880c8-7c2c-49d3-b7c2-42625c31eb05"))
+			_, ok = infraEnv["ssh_authorized_key"]
+			Expect(ok).To(BeFalse())
+
+			Expect(enrichedEvent.Cluster["hosts"]).To(HaveLen(2))
+		})
+
+		It("embeds the host id when the host snapshot is not available", func() {
+			event := getEvent("host_registration_succeeded", "Host master-0-0: registered to cluster")
+			event.Payload.(map[string]interface{})["host_id"] = "c0ffee00-0000-4000-8000-000000000000"
+			enrichedEvent := enricher.GetEnrichedEvent(event, map[string]interface{}{}, getHosts(), getInfraEnvs())
+
+			Expect(enrichedEvent.Host).To(Equal(map[string]interface{}{"id": "c0ffee00-0000-4000-8000-000000000000"}))
+		})
+	})
+	When("Enrich an event of an infraenv not bound to a cluster", func() {
+		It("embeds the host it refers to, but no cluster hosts", func() {
+			event := getEvent("host_registration_succeeded", "Host master-0-0: registered")
+			payload := event.Payload.(map[string]interface{})
+			payload["infra_env_id"] = "177880c8-7c2c-49d3-b7c2-42625c31eb05"
+			payload["host_id"] = "9024b650-f31f-420b-b279-3296a6e7d2cc"
+			enrichedEvent := enricher.GetEnrichedEvent(event, map[string]interface{}{}, getHosts(), getInfraEnvs()[1:])
+
+			_, ok := enrichedEvent.Cluster["hosts"]
+			Expect(ok).To(BeFalse())
+			Expect(enrichedEvent.InfraEnvs).To(HaveLen(1))
+			infraEnv, ok := enrichedEvent.Host["infra_env"].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(infraEnv["id"]).To(Equal("177880c8-7c2c-49d3-b7c2-42625c31eb05"))
+		})
+	})
+	When("Enrich a cluster event without host", func() {
+		It("does not embed any host", func() {
+			enrichedEvent := enricher.GetEnrichedEvent(getEvent("cluster_updated", "updated"), map[string]interface{}{}, getHosts(), getInfraEnvs())
+			Expect(enrichedEvent.HostID).To(BeEmpty())
+			Expect(enrichedEvent.Host).To(BeNil())
+		})
+	})
 	When("Enrich a cluster event with different release tag locations", func() {
 		It("with release tag as metadata", func() {
 			myMetadata := map[string]interface{}{
@@ -362,6 +431,87 @@ var _ = Describe("Process message", func() {
 			Expect(assistedInstallerVersion).To(Equal("registry-proxy.engineering.redhat.com/rh-osbs/openshift4-assisted-installer-rhel8:latest"))
 		})
 	})
+	When("Deriving the id of an event", func() {
+		getClusterEvent := func(clusterID string) *types.Event {
+			event := getEvent("cluster_updated", "Cluster updated")
+			event.Payload.(map[string]interface{})["cluster_id"] = clusterID
+			return event
+		}
+
+		It("tells apart events of different clusters with the same message and time", func() {
+			first := enricher.GetEnrichedEvent(getClusterEvent("3a930088-e49d-4584-bbd3-54a568dbe833"), map[string]interface{}{}, nil, nil)
+			second := enricher.GetEnrichedEvent(getClusterEvent("391d46b5-169b-4ffb-bce4-43ebdfe66b5c"), map[string]interface{}{}, nil, nil)
+			Expect(first.ID).ToNot(Equal(second.ID))
+			Expect(first.IDVersion).To(Equal(IDVersion2))
+			Expect(first.LegacyID).To(Equal(second.LegacyID))
+		})
+
+		It("is stable across re-deliveries", func() {
+			first := enricher.GetEnrichedEvent(getClusterEvent("3a930088-e49d-4584-bbd3-54a568dbe833"), map[string]interface{}{}, nil, nil)
+			second := enricher.GetEnrichedEvent(getClusterEvent("3a930088-e49d-4584-bbd3-54a568dbe833"), map[string]interface{}{}, nil, nil)
+			Expect(first.ID).To(Equal(second.ID))
+		})
+
+		It("tells apart on-prem events by their database id", func() {
+			event := getClusterEvent("3a930088-e49d-4584-bbd3-54a568dbe833")
+			event.Payload.(map[string]interface{})["ID"] = float64(1)
+			first := enricher.GetEnrichedEvent(event, map[string]interface{}{}, nil, nil)
+			event = getClusterEvent("3a930088-e49d-4584-bbd3-54a568dbe833")
+			event.Payload.(map[string]interface{})["ID"] = float64(2)
+			second := enricher.GetEnrichedEvent(event, map[string]interface{}{}, nil, nil)
+			Expect(first.ID).ToNot(Equal(second.ID))
+		})
+
+		It("keeps the legacy scheme when configured", func() {
+			legacyEnricher := NewEventEnricher(logger, IDVersion1)
+			enrichedEvent := legacyEnricher.GetEnrichedEvent(getClusterEvent("3a930088-e49d-4584-bbd3-54a568dbe833"), map[string]interface{}{}, nil, nil)
+			// ids of the legacy scheme must not change, documents are indexed with them
+			Expect(enrichedEvent.ID).To(Equal("2032d4f6-a223-548c-96df-26225b55d2c2"))
+			Expect(enrichedEvent.IDVersion).To(Equal(IDVersion1))
+			Expect(enrichedEvent.LegacyID).To(BeEmpty())
+
+			current := enricher.GetEnrichedEvent(getClusterEvent("3a930088-e49d-4584-bbd3-54a568dbe833"), map[string]interface{}{}, nil, nil)
+			Expect(current.LegacyID).To(Equal(enrichedEvent.ID))
+		})
+	})
+
+	When("Build the document of a cluster", func() {
+		It("gets transformed like enriched events", func() {
+			cluster := map[string]interface{}{
+				"id":          "myid",
+				"pull_secret": "alotoftext",
+				"user_name":   "my sensitive data",
+			}
+			document := enricher.GetClusterDocument("myid", cluster, getHosts(), getInfraEnvs())
+
+			Expect(document.ID).To(Equal("myid"))
+			Expect(document.Cluster).ToNot(HaveKey("pull_secret"))
+			Expect(document.Cluster).ToNot(HaveKey("user_name"))
+			Expect(document.Cluster).To(HaveKeyWithValue("user_id", "b3ce829e4327ba06e2ce8bd976ced308"))
+			Expect(document.Cluster["hosts"]).To(HaveLen(2))
+			for _, infraEnv := range document.InfraEnvs {
+				Expect(infraEnv).ToNot(HaveKey("ssh_authorized_key"))
+			}
+			assertHostsSummary(&types.EnrichedEvent{HostsSummary: document.HostsSummary})
+			// the snapshot is left untouched
+			Expect(cluster).ToNot(HaveKey("hosts"))
+		})
+
+		It("is versioned by the latest update of its snapshots", func() {
+			cluster := map[string]interface{}{
+				"id":         "myid",
+				"updated_at": "2023-01-27T03:40:08.998Z",
+			}
+			hosts := []map[string]interface{}{
+				{"id": "host1", "updated_at": "2023-01-27T03:41:00.000001Z"},
+				{"id": "host2", "updated_at": "not a time"},
+			}
+			document := enricher.GetClusterDocument("myid", cluster, hosts, nil)
+
+			Expect(document.Version).To(Equal(time.Date(2023, 1, 27, 3, 41, 0, 1000, time.UTC).UnixMicro()))
+			Expect(enricher.GetClusterDocument("myid", map[string]interface{}{"id": "myid"}, nil, nil).Version).To(BeZero())
+		})
+	})
 })
 
 func getEvent(name, message string) *types.Event {
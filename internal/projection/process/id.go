@@ -0,0 +1,52 @@
+package process
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+)
+
+const (
+	// ids derived from message and event time only: events of different
+	// clusters with the same message at the same time collide
+	IDVersion1 = 1
+	// ids derived from the fields identifying the event
+	IDVersion2 = 2
+
+	// latest scheme, opt-in until documents indexed with the legacy one are
+	// migrated
+	CurrentIDVersion = IDVersion2
+)
+
+var idNamespace = uuid.Must(uuid.FromBytes([]byte("abcdefghilmnopqr")))
+
+func IsValidIDVersion(version int) bool {
+	return version == IDVersion1 || version == IDVersion2
+}
+
+func getLegacyID(enrichedEvent *types.EnrichedEvent) string {
+	return uuid.NewSHA1(idNamespace, []byte(enrichedEvent.Message+enrichedEvent.EventTime)).String()
+}
+
+// Deterministic id of the event. The version is part of the hashed key, so
+// that ids of different versions never collide. onpremID is the database id
+// of events exported from on-prem deployments, if any
+func getID(version int, enrichedEvent *types.EnrichedEvent, infraEnvID string, onpremID string) string {
+	if version == IDVersion1 {
+		return getLegacyID(enrichedEvent)
+	}
+	key := strings.Join([]string{
+		fmt.Sprintf("v%d", IDVersion2),
+		enrichedEvent.ClusterID,
+		infraEnvID,
+		enrichedEvent.HostID,
+		enrichedEvent.Name,
+		enrichedEvent.EventTime,
+		enrichedEvent.RequestID,
+		enrichedEvent.Message,
+		onpremID,
+	}, "|")
+	return uuid.NewSHA1(idNamespace, []byte(key)).String()
+}
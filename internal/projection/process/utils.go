@@ -2,6 +2,7 @@ package process
 
 import (
 	"fmt"
+	"time"
 )
 
 func GetValueFromPayload(key string, payload interface{}) (string, error) {
@@ -14,3 +15,21 @@ func GetValueFromPayload(key string, payload interface{}) (string, error) {
 	}
 	return "", NewMalformedEventError(fmt.Sprintf("Error retrieving key %s from payload (%v)", key, payload))
 }
+
+// Latest update time of the snapshots in microseconds, 0 when none is set
+func getSnapshotsVersion(snapshots ...[]map[string]interface{}) int64 {
+	var version int64
+	for _, resources := range snapshots {
+		for _, snapshot := range resources {
+			value, ok := snapshot["updated_at"].(string)
+			if !ok {
+				continue
+			}
+			updatedAt, err := time.Parse(time.RFC3339Nano, value)
+			if err == nil && updatedAt.UnixMicro() > version {
+				version = updatedAt.UnixMicro()
+			}
+		}
+	}
+	return version
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/openshift-assisted/assisted-events-streams/internal/projection/lifecycle"
 	opensearch_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/opensearch"
 	redis_repo "github.com/openshift-assisted/assisted-events-streams/internal/repository/redis"
 	kafka "github.com/segmentio/kafka-go"
@@ -17,10 +18,33 @@ func NewEnrichedEventsProjectionFromEnv(ctx context.Context, logger *logrus.Logg
 		return nil, fmt.Errorf("failed to create snapshot repository: %w", err)
 	}
 
-	return NewEnrichedEventsProjection(
+	configRepository := opensearch_repo.NewProjectionConfigRepositoryFromEnv(logger)
+
+	clusterRepository := opensearch_repo.NewClusterRepositoryFromEnv(logger, ackChannel)
+
+	lifecycleRepository := opensearch_repo.NewLifecycleRepositoryFromEnv(logger, ackChannel)
+	var lifecycleTracker *lifecycle.Tracker
+	if lifecycleRepository != nil {
+		lifecycleStateRepository, err := redis_repo.NewLifecycleRepositoryFromEnv(ctx, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lifecycle repository: %w", err)
+		}
+		lifecycleTracker = lifecycle.NewTracker(logger, lifecycleStateRepository)
+	}
+
+	projection, err := NewEnrichedEventsProjection(
+		ctx,
 		logger,
 		snapshotRepository,
 		enrichedEventRepository,
+		clusterRepository,
+		configRepository,
 		ackChannel,
 	)
+	if err != nil {
+		return nil, err
+	}
+	projection.lifecycleTracker = lifecycleTracker
+	projection.lifecycleRepository = lifecycleRepository
+	return projection, nil
 }
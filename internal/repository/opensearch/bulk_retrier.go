@@ -0,0 +1,86 @@
+package opensearch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/opensearchutil"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	errRetrierClosed    = errors.New("bulk retrier closed")
+	errRetriesExhausted = errors.New("bulk item retries exhausted")
+)
+
+// Adds items that failed transiently back to the bulk indexer, once their
+// backoff wait elapses. Messages of failed items are never redelivered by a
+// live consumer group, so they must be retried to be acked eventually
+type bulkRetrier struct {
+	bulk          opensearchutil.BulkIndexer
+	logger        *logrus.Logger
+	backoffConfig stream.BackoffConfig
+	quit          chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	pending sync.WaitGroup
+}
+
+func newBulkRetrier(logger *logrus.Logger, bulk opensearchutil.BulkIndexer, backoffConfig stream.BackoffConfig) *bulkRetrier {
+	return &bulkRetrier{
+		bulk:          bulk,
+		logger:        logger,
+		backoffConfig: backoffConfig,
+		quit:          make(chan struct{}),
+	}
+}
+
+// Schedules another attempt of item. Fails when the backoff of the item is
+// exhausted, or the retrier is closed
+func (r *bulkRetrier) retry(item opensearchutil.BulkIndexerItem, backoff *stream.ExponentialBackoff) error {
+	wait, ok := backoff.Next()
+	if !ok {
+		return errRetriesExhausted
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return errRetrierClosed
+	}
+	r.pending.Add(1)
+	go func() {
+		defer r.pending.Done()
+		select {
+		case <-time.After(wait):
+		case <-r.quit:
+			return
+		}
+		if seeker, ok := item.Body.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				r.logger.WithError(err).WithField("document_id", item.DocumentID).Error("failed to rewind bulk item")
+				return
+			}
+		}
+		if err := r.bulk.Add(context.Background(), item); err != nil {
+			r.logger.WithError(err).WithField("document_id", item.DocumentID).Error("failed to retry bulk item")
+		}
+	}()
+	return nil
+}
+
+// Drops scheduled retries, their messages are left unacked to be consumed
+// again after restart. Must be called before closing the bulk indexer
+func (r *bulkRetrier) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.quit)
+	}
+	r.mu.Unlock()
+	r.pending.Wait()
+}
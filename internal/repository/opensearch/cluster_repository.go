@@ -0,0 +1,96 @@
+package opensearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	opensearch "github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchutil"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const versionTypeExternalGTE = "external_gte"
+
+// Keeps one document per cluster, with its latest state
+type ClusterRepository struct {
+	index      string
+	bulk       opensearchutil.BulkIndexer
+	retrier    *bulkRetrier
+	logger     *logrus.Logger
+	ackChannel chan kafka.Message
+}
+
+func NewClusterRepository(logger *logrus.Logger, opensearch *opensearch.Client, index string, ackChannel chan kafka.Message) *ClusterRepository {
+	bulkIndexer, err := NewBulkIndexerFromEnv(opensearch, logger)
+	if err != nil {
+		logger.WithError(err).Warning("error initializing bulk indexer")
+	}
+
+	return &ClusterRepository{
+		index:      index,
+		bulk:       bulkIndexer,
+		retrier:    newBulkRetrierFromEnv(logger, bulkIndexer),
+		logger:     logger,
+		ackChannel: ackChannel,
+	}
+}
+
+func (r *ClusterRepository) Close(ctx context.Context) {
+	r.retrier.Close()
+	r.bulk.Close(context.Background())
+}
+
+// Replaces the document of the cluster unless a more recent one is indexed,
+// the message is acked once indexed or found stale
+func (r *ClusterRepository) Store(ctx context.Context, clusterDocument *types.ClusterDocument, msg *kafka.Message) error {
+	r.logger.WithFields(logrus.Fields{
+		"cluster_id": clusterDocument.ID,
+		"version":    clusterDocument.Version,
+	}).Debug("adding cluster to bulk indexer")
+
+	jsonCluster, err := json.Marshal(clusterDocument)
+	if err != nil {
+		return err
+	}
+	item := opensearchutil.BulkIndexerItem{
+		Index:      r.index,
+		DocumentID: clusterDocument.ID,
+		Action:     "index",
+		Body:       bytes.NewReader(jsonCluster),
+	}
+	if clusterDocument.Version > 0 {
+		// documents rebuilt for host changes may keep the version of the
+		// cluster, so equal versions replace the document too
+		version := clusterDocument.Version
+		versionType := versionTypeExternalGTE
+		item.Version = &version
+		item.VersionType = &versionType
+	}
+	return r.add(ctx, msg, item)
+}
+
+// Removes the document of the cluster, the message is acked once deleted.
+// The message may be nil when it is acked by another write
+func (r *ClusterRepository) Delete(ctx context.Context, clusterID string, msg *kafka.Message) error {
+	r.logger.WithFields(logrus.Fields{
+		"cluster_id": clusterID,
+	}).Debug("adding cluster deletion to bulk indexer")
+
+	return r.add(ctx, msg, opensearchutil.BulkIndexerItem{
+		Index:      r.index,
+		DocumentID: clusterID,
+		Action:     "delete",
+	})
+}
+
+func (r *ClusterRepository) add(ctx context.Context, msg *kafka.Message, item opensearchutil.BulkIndexerItem) error {
+	err := r.bulk.Add(ctx, withAck(r.logger, r.ackChannel, r.retrier, msg, item))
+	if err != nil {
+		return stream.NewRetryableError(err)
+	}
+	return nil
+}
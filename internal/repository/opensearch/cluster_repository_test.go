@@ -0,0 +1,90 @@
+package opensearch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	opensearch "github.com/opensearch-project/opensearch-go"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Cluster repository", func() {
+	var (
+		ctx         context.Context
+		logger      *logrus.Logger
+		ackChannel  chan kafka.Message
+		bulkBody    string
+		bulkStatus  int
+		clusterRepo *ClusterRepository
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = logrus.New()
+		logger.Out = io.Discard
+		ackChannel = make(chan kafka.Message, 1)
+		bulkBody = ""
+		bulkStatus = http.StatusCreated
+		transport := &MockTransport{}
+		transport.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/" {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+			}
+			body, _ := io.ReadAll(req.Body)
+			bulkBody = string(body)
+			response := `{"errors":false,"items":[{"index":{"_id":"c1","status":201}}]}`
+			if bulkStatus == http.StatusConflict {
+				response = `{"errors":true,"items":[{"index":{"_id":"c1","status":409,"error":{"type":"version_conflict_engine_exception"}}}]}`
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(response))}, nil
+		}
+		client, err := opensearch.NewClient(opensearch.Config{Transport: transport})
+		Expect(err).To(BeNil())
+		clusterRepo = NewClusterRepository(logger, client, "clusters", ackChannel)
+	})
+
+	When("storing a versioned document", func() {
+		It("should only replace documents that are not more recent", func() {
+			msg := &kafka.Message{Offset: 1}
+			Expect(clusterRepo.Store(ctx, &types.ClusterDocument{ID: "c1", Version: 1674790808998000}, msg)).To(Succeed())
+			clusterRepo.Close(ctx)
+
+			Expect(bulkBody).To(ContainSubstring(`"version":1674790808998000`))
+			Expect(bulkBody).To(ContainSubstring(`"version_type":"external_gte"`))
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+
+		It("should ack the message when a more recent document is indexed", func() {
+			bulkStatus = http.StatusConflict
+			msg := &kafka.Message{Offset: 1}
+			Expect(clusterRepo.Store(ctx, &types.ClusterDocument{ID: "c1", Version: 1}, msg)).To(Succeed())
+			clusterRepo.Close(ctx)
+
+			Expect(<-ackChannel).To(Equal(*msg))
+		})
+	})
+
+	When("storing a document without version", func() {
+		It("should replace the document", func() {
+			Expect(clusterRepo.Store(ctx, &types.ClusterDocument{ID: "c1"}, &kafka.Message{})).To(Succeed())
+			clusterRepo.Close(ctx)
+
+			Expect(bulkBody).ToNot(ContainSubstring("version"))
+		})
+	})
+
+	When("deleting a document for a message acked elsewhere", func() {
+		It("should not ack anything", func() {
+			Expect(clusterRepo.Delete(ctx, "c1", nil)).To(Succeed())
+			clusterRepo.Close(ctx)
+
+			Expect(bulkBody).To(ContainSubstring(`"delete"`))
+			Expect(ackChannel).To(BeEmpty())
+		})
+	})
+})
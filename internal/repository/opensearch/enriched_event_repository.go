@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"time"
 
 	opensearch "github.com/opensearch-project/opensearch-go"
 	"github.com/opensearch-project/opensearch-go/opensearchutil"
 	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
 	kafka "github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 )
@@ -19,8 +22,10 @@ type EnrichedEventRepository struct {
 	indexPrefix string
 	opensearch  *opensearch.Client
 	bulk        opensearchutil.BulkIndexer
+	retrier     *bulkRetrier
 	logger      *logrus.Logger
 	ackChannel  chan kafka.Message
+	onWrite     func(id string, err error)
 }
 
 func NewEnrichedEventRepository(logger *logrus.Logger, opensearch *opensearch.Client, indexPrefix string, ackChannel chan kafka.Message) *EnrichedEventRepository {
@@ -33,15 +38,21 @@ func NewEnrichedEventRepository(logger *logrus.Logger, opensearch *opensearch.Cl
 		indexPrefix: indexPrefix,
 		opensearch:  opensearch,
 		bulk:        bulkIndexer,
+		retrier:     newBulkRetrierFromEnv(logger, bulkIndexer),
 		logger:      logger,
 		ackChannel:  ackChannel,
 	}
 }
 
 func (r *EnrichedEventRepository) Close(ctx context.Context) {
+	r.retrier.Close()
 	r.bulk.Close(context.Background())
 }
 
+func (r *EnrichedEventRepository) OnWrite(listener func(id string, err error)) {
+	r.onWrite = listener
+}
+
 func (r *EnrichedEventRepository) Store(ctx context.Context, enrichedEvent *types.EnrichedEvent, msg *kafka.Message) error {
 	r.logger.WithFields(logrus.Fields{
 		"id": enrichedEvent.ID,
@@ -53,28 +64,18 @@ func (r *EnrichedEventRepository) Store(ctx context.Context, enrichedEvent *type
 	}
 	document := bytes.NewReader(jsonEvent)
 
-	item := opensearchutil.BulkIndexerItem{
+	item := withAck(r.logger, r.ackChannel, r.retrier, msg, opensearchutil.BulkIndexerItem{
 		Index:      r.getIndexName(enrichedEvent),
 		DocumentID: enrichedEvent.ID,
 		Action:     "index",
 		Body:       document,
-		OnSuccess: func(context.Context, opensearchutil.BulkIndexerItem, opensearchutil.BulkIndexerResponseItem) {
-			r.ackChannel <- *msg
-		},
-		OnFailure: func(ctx context.Context, item opensearchutil.BulkIndexerItem, resp opensearchutil.BulkIndexerResponseItem, err error) {
-			bodyBytes, _ := io.ReadAll(item.Body)
-			r.logger.WithError(err).WithFields(logrus.Fields{
-				"document_id": item.DocumentID,
-				"item":        string(bodyBytes),
-				"action":      item.Action,
-				"index":       item.Index,
-				"response":    resp,
-			}).Error("error bulk indexing document")
-		},
+	})
+	if r.onWrite != nil {
+		item = withWriteListener(item, r.onWrite)
 	}
 	err = r.bulk.Add(ctx, item)
 	if err != nil {
-		return err
+		return stream.NewRetryableError(err)
 	}
 
 	r.logger.WithFields(logrus.Fields{
@@ -85,6 +86,91 @@ func (r *EnrichedEventRepository) Store(ctx context.Context, enrichedEvent *type
 	return nil
 }
 
+// Acks the message once the item is indexed, or when indexing it failed
+// permanently. Transient failures are retried until the backoff is exhausted.
+// Nothing is acked for nil messages
+func withAck(logger *logrus.Logger, ackChannel chan kafka.Message, retrier *bulkRetrier, msg *kafka.Message, item opensearchutil.BulkIndexerItem) opensearchutil.BulkIndexerItem {
+	var backoff *stream.ExponentialBackoff
+	ack := func() {
+		if msg != nil {
+			ackChannel <- *msg
+		}
+	}
+	item.OnSuccess = func(context.Context, opensearchutil.BulkIndexerItem, opensearchutil.BulkIndexerResponseItem) {
+		ack()
+	}
+	item.OnFailure = func(ctx context.Context, item opensearchutil.BulkIndexerItem, resp opensearchutil.BulkIndexerResponseItem, err error) {
+		// deleting a document that was never indexed is fine
+		if err == nil && item.Action == "delete" && resp.Status == http.StatusNotFound {
+			ack()
+			return
+		}
+		// a more recent version of the document is already indexed
+		if err == nil && item.Version != nil && resp.Status == http.StatusConflict {
+			logger.WithFields(logrus.Fields{
+				"document_id": item.DocumentID,
+				"version":     *item.Version,
+				"index":       item.Index,
+			}).Debug("skipping stale document")
+			ack()
+			return
+		}
+		var bodyBytes []byte
+		if item.Body != nil {
+			bodyBytes, _ = io.ReadAll(item.Body)
+		}
+		logger.WithError(err).WithFields(logrus.Fields{
+			"document_id": item.DocumentID,
+			"item":        string(bodyBytes),
+			"action":      item.Action,
+			"index":       item.Index,
+			"response":    resp,
+		}).Error("error bulk indexing document")
+		// retrying won't help with rejected documents: ack them so they don't
+		// hold back offset commits
+		if err == nil && isPermanentFailure(resp.Status) {
+			ack()
+			return
+		}
+		if backoff == nil {
+			backoff = stream.NewExponentialBackoff(retrier.backoffConfig)
+		}
+		retryErr := retrier.retry(item, backoff)
+		if retryErr == nil || errors.Is(retryErr, errRetrierClosed) {
+			// messages of items dropped while closing are consumed again
+			return
+		}
+		logger.WithError(retryErr).WithFields(logrus.Fields{
+			"document_id": item.DocumentID,
+			"index":       item.Index,
+		}).Error("giving up bulk indexing document")
+		ack()
+	}
+	return item
+}
+
+// Reports the outcome of the write to listener, before it's acked
+func withWriteListener(item opensearchutil.BulkIndexerItem, listener func(id string, err error)) opensearchutil.BulkIndexerItem {
+	onSuccess, onFailure := item.OnSuccess, item.OnFailure
+	item.OnSuccess = func(ctx context.Context, item opensearchutil.BulkIndexerItem, resp opensearchutil.BulkIndexerResponseItem) {
+		listener(item.DocumentID, nil)
+		onSuccess(ctx, item, resp)
+	}
+	item.OnFailure = func(ctx context.Context, item opensearchutil.BulkIndexerItem, resp opensearchutil.BulkIndexerResponseItem, err error) {
+		writeErr := err
+		if writeErr == nil {
+			writeErr = fmt.Errorf("indexing failed with status %d: %s", resp.Status, resp.Error.Type)
+		}
+		listener(item.DocumentID, writeErr)
+		onFailure(ctx, item, resp, err)
+	}
+	return item
+}
+
+func isPermanentFailure(status int) bool {
+	return status >= http.StatusBadRequest && status < http.StatusInternalServerError && status != http.StatusTooManyRequests
+}
+
 func (r *EnrichedEventRepository) getIndexName(event *types.EnrichedEvent) string {
 	t, _ := time.Parse(time.RFC3339, event.EventTime)
 	indexSuffix := fmt.Sprintf("%d-%02d", t.Year(), t.Month())
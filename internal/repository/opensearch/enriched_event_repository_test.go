@@ -0,0 +1,107 @@
+package opensearch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	opensearch "github.com/opensearch-project/opensearch-go"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Enriched event repository", func() {
+	var (
+		ctx               context.Context
+		ackChannel        chan kafka.Message
+		bulkResponse      string
+		enrichedEventRepo *EnrichedEventRepository
+		written           map[string]error
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger := logrus.New()
+		logger.Out = io.Discard
+		ackChannel = make(chan kafka.Message, 1)
+		bulkResponse = `{"errors":false,"items":[{"index":{"_id":"e1","status":201}}]}`
+		transport := &MockTransport{}
+		transport.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/" {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(bulkResponse))}, nil
+		}
+		client, err := opensearch.NewClient(opensearch.Config{Transport: transport})
+		Expect(err).To(BeNil())
+		enrichedEventRepo = NewEnrichedEventRepository(logger, client, "assisted-service-events-", ackChannel)
+		written = map[string]error{}
+		enrichedEventRepo.OnWrite(func(id string, err error) {
+			written[id] = err
+		})
+	})
+
+	It("should report indexed events before acking them", func() {
+		msg := &kafka.Message{Offset: 1}
+		Expect(enrichedEventRepo.Store(ctx, &types.EnrichedEvent{ID: "e1"}, msg)).To(Succeed())
+		enrichedEventRepo.Close(ctx)
+
+		Expect(<-ackChannel).To(Equal(*msg))
+		Expect(written).To(HaveKeyWithValue("e1", BeNil()))
+	})
+
+	It("should report events that failed indexing", func() {
+		bulkResponse = `{"errors":true,"items":[{"index":{"_id":"e1","status":400,"error":{"type":"mapper_parsing_exception"}}}]}`
+		Expect(enrichedEventRepo.Store(ctx, &types.EnrichedEvent{ID: "e1"}, &kafka.Message{Offset: 1})).To(Succeed())
+		enrichedEventRepo.Close(ctx)
+
+		Expect(written).To(HaveKeyWithValue("e1", MatchError(ContainSubstring("mapper_parsing_exception"))))
+	})
+
+	When("indexing fails transiently", func() {
+		env := map[string]string{
+			"OPENSEARCH_BULK_FLUSH_INTERVAL": "10ms",
+			"KAFKA_RETRY_INITIAL_INTERVAL":   "1ms",
+		}
+		BeforeEach(func() {
+			for key, value := range env {
+				os.Setenv(key, value)
+			}
+			logger := logrus.New()
+			logger.Out = io.Discard
+			requests := 0
+			transport := &MockTransport{}
+			transport.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+				if req.URL.Path == "/" {
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+				}
+				requests++
+				response := `{"errors":false,"items":[{"index":{"_id":"e1","status":201}}]}`
+				if requests == 1 {
+					response = `{"errors":true,"items":[{"index":{"_id":"e1","status":503,"error":{"type":"unavailable_shards_exception"}}}]}`
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(response))}, nil
+			}
+			client, err := opensearch.NewClient(opensearch.Config{Transport: transport})
+			Expect(err).To(BeNil())
+			enrichedEventRepo = NewEnrichedEventRepository(logger, client, "assisted-service-events-", ackChannel)
+		})
+		AfterEach(func() {
+			enrichedEventRepo.Close(ctx)
+			for key := range env {
+				os.Unsetenv(key)
+			}
+		})
+
+		It("should retry the event until it's indexed, then ack it", func() {
+			msg := &kafka.Message{Offset: 1}
+			Expect(enrichedEventRepo.Store(ctx, &types.EnrichedEvent{ID: "e1"}, msg)).To(Succeed())
+
+			Eventually(ackChannel).Should(Receive(Equal(*msg)))
+		})
+	})
+})
@@ -11,9 +11,23 @@ import (
 
 type EnrichedEventRepositoryInterface interface {
 	Store(ctx context.Context, enrichedEvent *types.EnrichedEvent, msg *kafka.Message) error
+	// Registers a function called with the outcome of each stored event,
+	// before Store
+	OnWrite(listener func(id string, err error))
+	Close(ctx context.Context)
+}
+
+type ClusterRepositoryInterface interface {
+	Store(ctx context.Context, clusterDocument *types.ClusterDocument, msg *kafka.Message) error
+	Delete(ctx context.Context, clusterID string, msg *kafka.Message) error
+	Close(ctx context.Context)
+}
+
+type LifecycleRepositoryInterface interface {
+	Store(ctx context.Context, lifecycleDocument *types.LifecycleDocument, msg *kafka.Message) error
 	Close(ctx context.Context)
 }
 
 type ProjectionConfigRepositoryInterface interface {
-	Get(ctx context.Context) types.ProjectionConfig
+	Get(ctx context.Context) (types.ProjectionConfig, error)
 }
@@ -0,0 +1,66 @@
+package opensearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	opensearch "github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchutil"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Keeps one document per cluster installation attempt. Documents are derived
+// from the tracked lifecycle and rewritten on each change
+type LifecycleRepository struct {
+	index      string
+	bulk       opensearchutil.BulkIndexer
+	retrier    *bulkRetrier
+	logger     *logrus.Logger
+	ackChannel chan kafka.Message
+}
+
+func NewLifecycleRepository(logger *logrus.Logger, opensearch *opensearch.Client, index string, ackChannel chan kafka.Message) *LifecycleRepository {
+	bulkIndexer, err := NewBulkIndexerFromEnv(opensearch, logger)
+	if err != nil {
+		logger.WithError(err).Warning("error initializing bulk indexer")
+	}
+
+	return &LifecycleRepository{
+		index:      index,
+		bulk:       bulkIndexer,
+		retrier:    newBulkRetrierFromEnv(logger, bulkIndexer),
+		logger:     logger,
+		ackChannel: ackChannel,
+	}
+}
+
+func (r *LifecycleRepository) Close(ctx context.Context) {
+	r.retrier.Close()
+	r.bulk.Close(context.Background())
+}
+
+// The message is acked once the document is indexed
+func (r *LifecycleRepository) Store(ctx context.Context, lifecycleDocument *types.LifecycleDocument, msg *kafka.Message) error {
+	r.logger.WithFields(logrus.Fields{
+		"id": lifecycleDocument.ID,
+	}).Debug("adding lifecycle to bulk indexer")
+
+	jsonLifecycle, err := json.Marshal(lifecycleDocument)
+	if err != nil {
+		return err
+	}
+	err = r.bulk.Add(ctx, withAck(r.logger, r.ackChannel, r.retrier, msg, opensearchutil.BulkIndexerItem{
+		Index:      r.index,
+		DocumentID: lifecycleDocument.ID,
+		Action:     "index",
+		Body:       bytes.NewReader(jsonLifecycle),
+	}))
+	if err != nil {
+		return stream.NewRetryableError(err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package opensearch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	opensearch "github.com/opensearch-project/opensearch-go"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Lifecycle repository", func() {
+	var (
+		ctx           context.Context
+		ackChannel    chan kafka.Message
+		bulkStatus    int
+		lifecycleRepo *LifecycleRepository
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger := logrus.New()
+		logger.Out = io.Discard
+		ackChannel = make(chan kafka.Message, 1)
+		bulkStatus = http.StatusCreated
+		transport := &MockTransport{}
+		transport.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/" {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+			}
+			response := `{"errors":false,"items":[{"index":{"_id":"c1_1","status":201}}]}`
+			if bulkStatus == http.StatusServiceUnavailable {
+				response = `{"errors":true,"items":[{"index":{"_id":"c1_1","status":503,"error":{"type":"unavailable_shards_exception"}}}]}`
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(response))}, nil
+		}
+		client, err := opensearch.NewClient(opensearch.Config{Transport: transport})
+		Expect(err).To(BeNil())
+		lifecycleRepo = NewLifecycleRepository(logger, client, "cluster-lifecycles", ackChannel)
+	})
+
+	It("should ack the message once the document is indexed", func() {
+		msg := &kafka.Message{Offset: 1}
+		Expect(lifecycleRepo.Store(ctx, &types.LifecycleDocument{ID: "c1_1"}, msg)).To(Succeed())
+		lifecycleRepo.Close(ctx)
+
+		Expect(<-ackChannel).To(Equal(*msg))
+	})
+
+	It("should leave the message unacked when indexing can be retried", func() {
+		bulkStatus = http.StatusServiceUnavailable
+		Expect(lifecycleRepo.Store(ctx, &types.LifecycleDocument{ID: "c1_1"}, &kafka.Message{Offset: 1})).To(Succeed())
+		lifecycleRepo.Close(ctx)
+
+		Expect(ackChannel).To(BeEmpty())
+	})
+})
@@ -48,6 +48,18 @@ func (mr *MockEnrichedEventRepositoryInterfaceMockRecorder) Close(ctx interface{
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockEnrichedEventRepositoryInterface)(nil).Close), ctx)
 }
 
+// OnWrite mocks base method.
+func (m *MockEnrichedEventRepositoryInterface) OnWrite(listener func(string, error)) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "OnWrite", listener)
+}
+
+// OnWrite indicates an expected call of OnWrite.
+func (mr *MockEnrichedEventRepositoryInterfaceMockRecorder) OnWrite(listener interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnWrite", reflect.TypeOf((*MockEnrichedEventRepositoryInterface)(nil).OnWrite), listener)
+}
+
 // Store mocks base method.
 func (m *MockEnrichedEventRepositoryInterface) Store(ctx context.Context, enrichedEvent *types.EnrichedEvent, msg *kafka.Message) error {
 	m.ctrl.T.Helper()
@@ -62,6 +74,118 @@ func (mr *MockEnrichedEventRepositoryInterfaceMockRecorder) Store(ctx, enrichedE
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Store", reflect.TypeOf((*MockEnrichedEventRepositoryInterface)(nil).Store), ctx, enrichedEvent, msg)
 }
 
+// MockClusterRepositoryInterface is a mock of ClusterRepositoryInterface interface.
+type MockClusterRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockClusterRepositoryInterfaceMockRecorder
+}
+
+// MockClusterRepositoryInterfaceMockRecorder is the mock recorder for MockClusterRepositoryInterface.
+type MockClusterRepositoryInterfaceMockRecorder struct {
+	mock *MockClusterRepositoryInterface
+}
+
+// NewMockClusterRepositoryInterface creates a new mock instance.
+func NewMockClusterRepositoryInterface(ctrl *gomock.Controller) *MockClusterRepositoryInterface {
+	mock := &MockClusterRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockClusterRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockClusterRepositoryInterface) EXPECT() *MockClusterRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockClusterRepositoryInterface) Close(ctx context.Context) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Close", ctx)
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockClusterRepositoryInterfaceMockRecorder) Close(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockClusterRepositoryInterface)(nil).Close), ctx)
+}
+
+// Delete mocks base method.
+func (m *MockClusterRepositoryInterface) Delete(ctx context.Context, clusterID string, msg *kafka.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, clusterID, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockClusterRepositoryInterfaceMockRecorder) Delete(ctx, clusterID, msg interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockClusterRepositoryInterface)(nil).Delete), ctx, clusterID, msg)
+}
+
+// Store mocks base method.
+func (m *MockClusterRepositoryInterface) Store(ctx context.Context, clusterDocument *types.ClusterDocument, msg *kafka.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Store", ctx, clusterDocument, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Store indicates an expected call of Store.
+func (mr *MockClusterRepositoryInterfaceMockRecorder) Store(ctx, clusterDocument, msg interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Store", reflect.TypeOf((*MockClusterRepositoryInterface)(nil).Store), ctx, clusterDocument, msg)
+}
+
+// MockLifecycleRepositoryInterface is a mock of LifecycleRepositoryInterface interface.
+type MockLifecycleRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockLifecycleRepositoryInterfaceMockRecorder
+}
+
+// MockLifecycleRepositoryInterfaceMockRecorder is the mock recorder for MockLifecycleRepositoryInterface.
+type MockLifecycleRepositoryInterfaceMockRecorder struct {
+	mock *MockLifecycleRepositoryInterface
+}
+
+// NewMockLifecycleRepositoryInterface creates a new mock instance.
+func NewMockLifecycleRepositoryInterface(ctrl *gomock.Controller) *MockLifecycleRepositoryInterface {
+	mock := &MockLifecycleRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockLifecycleRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLifecycleRepositoryInterface) EXPECT() *MockLifecycleRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockLifecycleRepositoryInterface) Close(ctx context.Context) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Close", ctx)
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockLifecycleRepositoryInterfaceMockRecorder) Close(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockLifecycleRepositoryInterface)(nil).Close), ctx)
+}
+
+// Store mocks base method.
+func (m *MockLifecycleRepositoryInterface) Store(ctx context.Context, lifecycleDocument *types.LifecycleDocument, msg *kafka.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Store", ctx, lifecycleDocument, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Store indicates an expected call of Store.
+func (mr *MockLifecycleRepositoryInterfaceMockRecorder) Store(ctx, lifecycleDocument, msg interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Store", reflect.TypeOf((*MockLifecycleRepositoryInterface)(nil).Store), ctx, lifecycleDocument, msg)
+}
+
 // MockProjectionConfigRepositoryInterface is a mock of ProjectionConfigRepositoryInterface interface.
 type MockProjectionConfigRepositoryInterface struct {
 	ctrl     *gomock.Controller
@@ -86,11 +210,12 @@ func (m *MockProjectionConfigRepositoryInterface) EXPECT() *MockProjectionConfig
 }
 
 // Get mocks base method.
-func (m *MockProjectionConfigRepositoryInterface) Get(ctx context.Context) types.ProjectionConfig {
+func (m *MockProjectionConfigRepositoryInterface) Get(ctx context.Context) (types.ProjectionConfig, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Get", ctx)
 	ret0, _ := ret[0].(types.ProjectionConfig)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // Get indicates an expected call of Get.
@@ -3,6 +3,7 @@ package opensearch
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	opensearch "github.com/opensearch-project/opensearch-go"
@@ -27,22 +28,27 @@ func NewProjectionConfigRepository(logger *logrus.Logger, opensearchClient *open
 	}
 }
 
-func (r *ProjectionConfigRepository) Get(ctx context.Context) types.ProjectionConfig {
+// Returns the default config when the document is not set, and an error when
+// it cannot be read, so callers can keep the last known one
+func (r *ProjectionConfigRepository) Get(ctx context.Context) (types.ProjectionConfig, error) {
 	defaultConfig := types.ProjectionConfig{
 		Mode: types.ProjectionModeOnline,
 	}
 	res, err := r.opensearchClient.Get(r.index, r.docId)
-	if res != nil && !res.IsError() && res.StatusCode < http.StatusBadRequest && err == nil {
-		var rawRecord types.OpensearchRawConfigDocument
-		err = json.NewDecoder(res.Body).Decode(&rawRecord)
-		if err != nil {
-			r.logger.WithError(err).Warn("projection config retrieved but could not parse it, providing default")
-			return defaultConfig
-		}
-		return types.ProjectionConfig{
-			Mode: rawRecord.Source.Mode,
-		}
+	if err != nil {
+		return defaultConfig, fmt.Errorf("failed to get projection config: %w", err)
 	}
-	r.logger.WithError(err).Warn("could not retrieve projection config, providing default")
-	return defaultConfig
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return defaultConfig, nil
+	}
+	if res.IsError() {
+		return defaultConfig, fmt.Errorf("failed to get projection config: %s", res.Status())
+	}
+	var rawRecord types.OpensearchRawConfigDocument
+	err = json.NewDecoder(res.Body).Decode(&rawRecord)
+	if err != nil {
+		return defaultConfig, fmt.Errorf("failed to parse projection config: %w", err)
+	}
+	return rawRecord.Source, nil
 }
@@ -31,7 +31,13 @@ func getMockOpensearchClient(mockResponseBody string, statusCode int) *opensearc
 			Body:       io.NopCloser(strings.NewReader(mockResponseBody)),
 		},
 	}
-	transport.RoundTripFn = func(req *http.Request) (*http.Response, error) { return transport.Response, nil }
+	transport.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+		// the client checks the product with an info request first
+		if req.URL.Path == "/" {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		return transport.Response, nil
+	}
 
 	client, err := opensearch.NewClient(opensearch.Config{
 		Transport: &transport,
@@ -60,14 +66,27 @@ var _ = Describe("Getting and setting config object", func() {
 		ctx = context.Background()
 	})
 	When("getting config when not set", func() {
-		It("should return error but default config", func() {
+		It("should return the default config", func() {
 			mockResponse := `{"_index" : "assisted-event-streams-config","_type":"_doc","_id":"abc","found" : false}`
 			configRepo := getProjectionConfigRepoWithMockOpensearchResponse(mockResponse, http.StatusNotFound)
-			cfg := configRepo.Get(ctx)
+			cfg, err := configRepo.Get(ctx)
+			Expect(err).To(BeNil())
 			Expect(cfg.Mode).To(BeEquivalentTo(types.ProjectionModeOnline))
 		})
 	})
 
+	When("getting config fails", func() {
+		It("should return an error", func() {
+			configRepo := getProjectionConfigRepoWithMockOpensearchResponse(`{"error": "unavailable"}`, http.StatusServiceUnavailable)
+			_, err := configRepo.Get(ctx)
+			Expect(err).ToNot(BeNil())
+
+			configRepo = getProjectionConfigRepoWithMockOpensearchResponse(`not json`, http.StatusOK)
+			_, err = configRepo.Get(ctx)
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
 	When("getting config when set", func() {
 		It("should return whatever is set, and no error", func() {
 			mockResponse := `
@@ -84,7 +103,8 @@ var _ = Describe("Getting and setting config object", func() {
   }
 }`
 			configRepo := getProjectionConfigRepoWithMockOpensearchResponse(mockResponse, http.StatusOK)
-			cfg := configRepo.Get(ctx)
+			cfg, err := configRepo.Get(ctx)
+			Expect(err).To(BeNil())
 			Expect(cfg.Mode).To(BeEquivalentTo(types.ProjectionModeOffline))
 
 			mockResponse = `
@@ -101,8 +121,38 @@ var _ = Describe("Getting and setting config object", func() {
   }
 }`
 			configRepo = getProjectionConfigRepoWithMockOpensearchResponse(mockResponse, http.StatusOK)
-			cfg = configRepo.Get(ctx)
+			cfg, err = configRepo.Get(ctx)
+			Expect(err).To(BeNil())
 			Expect(cfg.Mode).To(BeEquivalentTo(types.ProjectionModeOnline))
+			Expect(cfg.FilterRules).To(BeNil())
+		})
+	})
+
+	When("getting config with filter rules", func() {
+		It("should return the rules", func() {
+			mockResponse := `
+{
+  "_index" : "assisted-event-streams-config",
+  "_id" : "projection_config",
+  "found" : true,
+  "_source" : {
+    "mode" : "online",
+    "filter_rules" : [
+      {"name": "internal-users", "action": "exclude", "conditions": [{"field": "cluster.email_domain", "equals": ["redhat.com"]}]}
+    ]
+  }
+}`
+			configRepo := getProjectionConfigRepoWithMockOpensearchResponse(mockResponse, http.StatusOK)
+			cfg, err := configRepo.Get(ctx)
+			Expect(err).To(BeNil())
+			Expect(cfg.FilterRules).ToNot(BeNil())
+			Expect(*cfg.FilterRules).To(Equal([]types.FilterRule{
+				{
+					Name:       "internal-users",
+					Action:     types.FilterActionExclude,
+					Conditions: []types.FilterCondition{{Field: "cluster.email_domain", Equals: []string{"redhat.com"}}},
+				},
+			}))
 		})
 	})
 
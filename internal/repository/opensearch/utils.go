@@ -10,6 +10,7 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	opensearch "github.com/opensearch-project/opensearch-go"
 	"github.com/opensearch-project/opensearch-go/opensearchutil"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
 	kafka "github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 )
@@ -28,6 +29,12 @@ type OpensearchEnvConfig struct {
 	IndexPrefix           string        `envconfig:"OPENSEARCH_INDEX_PREFIX" required:"true"`
 	ConfigIndex           string        `envconfig:"OPENSEARCH_CONFIG_INDEX" required:"true"`
 	DocId                 string        `envconfig:"OPENSEARCH_CONFIG_DOC_ID" default:"projection_config"`
+	// latest state of each cluster, disabled unless set
+	ClustersIndex string `envconfig:"OPENSEARCH_CLUSTERS_INDEX" default:""`
+	// installation attempts of each cluster, disabled unless set
+	LifecycleIndex string `envconfig:"OPENSEARCH_LIFECYCLE_INDEX" default:""`
+	// retries of bulk items failing transiently
+	stream.BackoffConfig
 }
 
 func getConfigFromEnv(logger *logrus.Logger) *OpensearchEnvConfig {
@@ -80,6 +87,31 @@ func NewEnrichedEventRepositoryFromEnv(logger *logrus.Logger, ackChannel chan ka
 	return NewEnrichedEventRepository(logger, opensearch, envConfig.IndexPrefix, ackChannel)
 }
 
+// Returns nil when the clusters index is disabled
+func NewClusterRepositoryFromEnv(logger *logrus.Logger, ackChannel chan kafka.Message) ClusterRepositoryInterface {
+	envConfig := getConfigFromEnv(logger)
+	if envConfig.ClustersIndex == "" {
+		return nil
+	}
+	opensearch := NewOpensearchClientFromEnv(logger)
+	return NewClusterRepository(logger, opensearch, envConfig.ClustersIndex, ackChannel)
+}
+
+// Returns nil when the lifecycle index is disabled
+func NewLifecycleRepositoryFromEnv(logger *logrus.Logger, ackChannel chan kafka.Message) LifecycleRepositoryInterface {
+	envConfig := getConfigFromEnv(logger)
+	if envConfig.LifecycleIndex == "" {
+		return nil
+	}
+	opensearch := NewOpensearchClientFromEnv(logger)
+	return NewLifecycleRepository(logger, opensearch, envConfig.LifecycleIndex, ackChannel)
+}
+
+func NewProjectionConfigRepositoryFromEnv(logger *logrus.Logger) *ProjectionConfigRepository {
+	opensearch := NewOpensearchClientFromEnv(logger)
+	return NewProjectionConfigRepository(logger, opensearch)
+}
+
 func NewBulkIndexerFromEnv(opensearch *opensearch.Client, logger *logrus.Logger) (opensearchutil.BulkIndexer, error) {
 	envConfig := getConfigFromEnv(logger)
 	return opensearchutil.NewBulkIndexer(opensearchutil.BulkIndexerConfig{
@@ -101,3 +133,8 @@ func NewBulkIndexerFromEnv(opensearch *opensearch.Client, logger *logrus.Logger)
 		},
 	})
 }
+
+func newBulkRetrierFromEnv(logger *logrus.Logger, bulk opensearchutil.BulkIndexer) *bulkRetrier {
+	envConfig := getConfigFromEnv(logger)
+	return newBulkRetrier(logger, bulk, envConfig.BackoffConfig)
+}
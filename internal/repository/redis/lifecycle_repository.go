@@ -0,0 +1,72 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
+	"github.com/sirupsen/logrus"
+)
+
+// lifecycle tracked for each cluster
+const LifecycleRedisKeyPrefix = "lifecycle_"
+
+//go:generate mockgen -source=lifecycle_repository.go -package=redis -destination=mock_lifecycle_repository.go
+
+type LifecycleRepositoryInterface interface {
+	// nil when the cluster is not tracked yet
+	Get(ctx context.Context, clusterID string) (*types.ClusterLifecycle, error)
+	Set(ctx context.Context, lifecycle *types.ClusterLifecycle) error
+}
+
+type LifecycleRepository struct {
+	logger     *logrus.Logger
+	redis      redis.Cmdable
+	expiration time.Duration
+}
+
+func NewLifecycleRepository(logger *logrus.Logger, redis redis.Cmdable, expiration time.Duration) *LifecycleRepository {
+	return &LifecycleRepository{
+		logger:     logger,
+		redis:      redis,
+		expiration: expiration,
+	}
+}
+
+func getLifecycleKey(clusterID string) string {
+	return LifecycleRedisKeyPrefix + clusterID
+}
+
+func (r *LifecycleRepository) Get(ctx context.Context, clusterID string) (*types.ClusterLifecycle, error) {
+	raw, err := r.redis.Get(ctx, getLifecycleKey(clusterID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, stream.NewRetryableError(fmt.Errorf("failed to get lifecycle: %w", err))
+	}
+	lifecycle := &types.ClusterLifecycle{}
+	if err := json.Unmarshal(raw, lifecycle); err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"cluster_id": clusterID,
+		}).Warn("discarding unreadable lifecycle")
+		return nil, nil
+	}
+	return lifecycle, nil
+}
+
+func (r *LifecycleRepository) Set(ctx context.Context, lifecycle *types.ClusterLifecycle) error {
+	raw, err := json.Marshal(lifecycle)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lifecycle: %w", err)
+	}
+	err = r.redis.Set(ctx, getLifecycleKey(lifecycle.ClusterID), raw, r.expiration).Err()
+	if err != nil {
+		return stream.NewRetryableError(fmt.Errorf("failed to set lifecycle: %w", err))
+	}
+	return nil
+}
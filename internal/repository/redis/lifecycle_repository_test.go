@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/go-redis/redismock/v8"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Tracking lifecycles", func() {
+	const clusterID = "c42cfc1d-411a-4cdb-953b-a8e0f3f82375"
+	var (
+		ctx           context.Context
+		lifecycleRepo *LifecycleRepository
+		mock          redismock.ClientMock
+	)
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.Out = io.Discard
+		ctx = context.Background()
+		client, clientMock := redismock.NewClientMock()
+		mock = clientMock
+		lifecycleRepo = NewLifecycleRepository(logger, client, defaultDuration)
+	})
+	AfterEach(func() {
+		Expect(mock.ExpectationsWereMet()).To(BeNil())
+	})
+
+	It("should store the lifecycle of a cluster and read it back", func() {
+		lifecycle := &types.ClusterLifecycle{
+			ClusterID:   clusterID,
+			Status:      "installing",
+			StatusSince: time.Date(2023, 1, 20, 2, 19, 23, 0, time.UTC),
+			Attempt:     types.LifecycleDocument{Attempt: 1},
+		}
+		raw, err := json.Marshal(lifecycle)
+		Expect(err).To(BeNil())
+		mock.ExpectSet("lifecycle_"+clusterID, raw, defaultDuration).SetVal("OK")
+		Expect(lifecycleRepo.Set(ctx, lifecycle)).To(BeNil())
+
+		mock.ExpectGet("lifecycle_" + clusterID).SetVal(string(raw))
+		stored, err := lifecycleRepo.Get(ctx, clusterID)
+		Expect(err).To(BeNil())
+		Expect(stored).To(Equal(lifecycle))
+	})
+
+	It("should return nothing for clusters not tracked yet", func() {
+		mock.ExpectGet("lifecycle_" + clusterID).RedisNil()
+		stored, err := lifecycleRepo.Get(ctx, clusterID)
+		Expect(err).To(BeNil())
+		Expect(stored).To(BeNil())
+	})
+
+	It("should return a retryable error when reading fails", func() {
+		mock.ExpectGet("lifecycle_" + clusterID).SetErr(errors.New("connection refused"))
+		_, err := lifecycleRepo.Get(ctx, clusterID)
+		Expect(stream.IsRetryable(err)).To(BeTrue())
+	})
+})
@@ -7,6 +7,7 @@ package redis
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	types "github.com/openshift-assisted/assisted-events-streams/internal/types"
@@ -35,6 +36,48 @@ func (m *MockSnapshotRepositoryInterface) EXPECT() *MockSnapshotRepositoryInterf
 	return m.recorder
 }
 
+// DeleteCluster mocks base method.
+func (m *MockSnapshotRepositoryInterface) DeleteCluster(ctx context.Context, clusterID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCluster", ctx, clusterID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCluster indicates an expected call of DeleteCluster.
+func (mr *MockSnapshotRepositoryInterfaceMockRecorder) DeleteCluster(ctx, clusterID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCluster", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).DeleteCluster), ctx, clusterID)
+}
+
+// DeleteHost mocks base method.
+func (m *MockSnapshotRepositoryInterface) DeleteHost(ctx context.Context, clusterID, hostID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteHost", ctx, clusterID, hostID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteHost indicates an expected call of DeleteHost.
+func (mr *MockSnapshotRepositoryInterfaceMockRecorder) DeleteHost(ctx, clusterID, hostID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteHost", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).DeleteHost), ctx, clusterID, hostID)
+}
+
+// DeleteInfraEnv mocks base method.
+func (m *MockSnapshotRepositoryInterface) DeleteInfraEnv(ctx context.Context, infraEnvID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteInfraEnv", ctx, infraEnvID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteInfraEnv indicates an expected call of DeleteInfraEnv.
+func (mr *MockSnapshotRepositoryInterfaceMockRecorder) DeleteInfraEnv(ctx, infraEnvID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteInfraEnv", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).DeleteInfraEnv), ctx, infraEnvID)
+}
+
 // GetCluster mocks base method.
 func (m *MockSnapshotRepositoryInterface) GetCluster(ctx context.Context, clusterID string) (map[string]interface{}, error) {
 	m.ctrl.T.Helper()
@@ -50,6 +93,36 @@ func (mr *MockSnapshotRepositoryInterfaceMockRecorder) GetCluster(ctx, clusterID
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCluster", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).GetCluster), ctx, clusterID)
 }
 
+// GetClusterAt mocks base method.
+func (m *MockSnapshotRepositoryInterface) GetClusterAt(ctx context.Context, clusterID string, t time.Time) (map[string]interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetClusterAt", ctx, clusterID, t)
+	ret0, _ := ret[0].(map[string]interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetClusterAt indicates an expected call of GetClusterAt.
+func (mr *MockSnapshotRepositoryInterfaceMockRecorder) GetClusterAt(ctx, clusterID, t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClusterAt", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).GetClusterAt), ctx, clusterID, t)
+}
+
+// GetClusterSnapshots mocks base method.
+func (m *MockSnapshotRepositoryInterface) GetClusterSnapshots(ctx context.Context, clusterIDs []string) ([]*ClusterSnapshot, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetClusterSnapshots", ctx, clusterIDs)
+	ret0, _ := ret[0].([]*ClusterSnapshot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetClusterSnapshots indicates an expected call of GetClusterSnapshots.
+func (mr *MockSnapshotRepositoryInterfaceMockRecorder) GetClusterSnapshots(ctx, clusterIDs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClusterSnapshots", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).GetClusterSnapshots), ctx, clusterIDs)
+}
+
 // GetHosts mocks base method.
 func (m *MockSnapshotRepositoryInterface) GetHosts(ctx context.Context, clusterID string) ([]map[string]interface{}, error) {
 	m.ctrl.T.Helper()
@@ -65,6 +138,36 @@ func (mr *MockSnapshotRepositoryInterfaceMockRecorder) GetHosts(ctx, clusterID i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHosts", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).GetHosts), ctx, clusterID)
 }
 
+// GetHostsAt mocks base method.
+func (m *MockSnapshotRepositoryInterface) GetHostsAt(ctx context.Context, clusterID string, t time.Time) ([]map[string]interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHostsAt", ctx, clusterID, t)
+	ret0, _ := ret[0].([]map[string]interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHostsAt indicates an expected call of GetHostsAt.
+func (mr *MockSnapshotRepositoryInterfaceMockRecorder) GetHostsAt(ctx, clusterID, t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHostsAt", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).GetHostsAt), ctx, clusterID, t)
+}
+
+// GetInfraEnv mocks base method.
+func (m *MockSnapshotRepositoryInterface) GetInfraEnv(ctx context.Context, infraEnvID string) (map[string]interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetInfraEnv", ctx, infraEnvID)
+	ret0, _ := ret[0].(map[string]interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInfraEnv indicates an expected call of GetInfraEnv.
+func (mr *MockSnapshotRepositoryInterfaceMockRecorder) GetInfraEnv(ctx, infraEnvID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInfraEnv", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).GetInfraEnv), ctx, infraEnvID)
+}
+
 // GetInfraEnvs mocks base method.
 func (m *MockSnapshotRepositoryInterface) GetInfraEnvs(ctx context.Context, clusterID string) ([]map[string]interface{}, error) {
 	m.ctrl.T.Helper()
@@ -80,6 +183,36 @@ func (mr *MockSnapshotRepositoryInterfaceMockRecorder) GetInfraEnvs(ctx, cluster
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInfraEnvs", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).GetInfraEnvs), ctx, clusterID)
 }
 
+// GetInfraEnvsAt mocks base method.
+func (m *MockSnapshotRepositoryInterface) GetInfraEnvsAt(ctx context.Context, clusterID string, t time.Time) ([]map[string]interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetInfraEnvsAt", ctx, clusterID, t)
+	ret0, _ := ret[0].([]map[string]interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInfraEnvsAt indicates an expected call of GetInfraEnvsAt.
+func (mr *MockSnapshotRepositoryInterfaceMockRecorder) GetInfraEnvsAt(ctx, clusterID, t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInfraEnvsAt", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).GetInfraEnvsAt), ctx, clusterID, t)
+}
+
+// GetUnboundHosts mocks base method.
+func (m *MockSnapshotRepositoryInterface) GetUnboundHosts(ctx context.Context, infraEnvID string) ([]map[string]interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUnboundHosts", ctx, infraEnvID)
+	ret0, _ := ret[0].([]map[string]interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUnboundHosts indicates an expected call of GetUnboundHosts.
+func (mr *MockSnapshotRepositoryInterfaceMockRecorder) GetUnboundHosts(ctx, infraEnvID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnboundHosts", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).GetUnboundHosts), ctx, infraEnvID)
+}
+
 // SetCluster mocks base method.
 func (m *MockSnapshotRepositoryInterface) SetCluster(ctx context.Context, clusterID string, event *types.Event) error {
 	m.ctrl.T.Helper()
@@ -121,3 +254,17 @@ func (mr *MockSnapshotRepositoryInterfaceMockRecorder) SetInfraEnv(ctx, clusterI
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetInfraEnv", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).SetInfraEnv), ctx, clusterID, infraEnvID, event)
 }
+
+// SetUnboundHost mocks base method.
+func (m *MockSnapshotRepositoryInterface) SetUnboundHost(ctx context.Context, infraEnvID, hostID string, event *types.Event) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUnboundHost", ctx, infraEnvID, hostID, event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetUnboundHost indicates an expected call of SetUnboundHost.
+func (mr *MockSnapshotRepositoryInterfaceMockRecorder) SetUnboundHost(ctx, infraEnvID, hostID, event interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUnboundHost", reflect.TypeOf((*MockSnapshotRepositoryInterface)(nil).SetUnboundHost), ctx, infraEnvID, hostID, event)
+}
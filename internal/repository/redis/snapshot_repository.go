@@ -4,10 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,56 +18,413 @@ const (
 	ClustersRedisHKey        = "clusters"
 	HostsRedisHKeyPrefix     = "hosts_"
 	InfraEnvsRedisHKeyPrefix = "infraenvs_"
+	// hosts and infra-envs indexed by their own id, bound to a cluster or not
+	HostRedisKeyPrefix     = "host_"
+	InfraEnvRedisKeyPrefix = "infraenv_"
+	// hosts of an infra-env that are not bound to a cluster yet
+	UnboundHostsRedisHKeyPrefix = "unbound_hosts_"
+	// version of the latest snapshot written, by resource. Update times and
+	// sequences are not comparable, they are kept in separate keys
+	VersionRedisKeyPrefix         = "version_"
+	SequenceVersionRedisKeyPrefix = "version_sequence_"
+	// snapshots by update time, by resource, and resources ever bound to a cluster
+	HistoryRedisKeyPrefix = "history_"
 )
 
+// Writes the value only when its version is not older than the stored one.
+// KEYS[1] is the version key, the other keys are written with ARGV[3] as value:
+// hashes in the field given for each key, plain keys when the field is empty.
+// ARGV[1] is the version, empty when there is none, and ARGV[2] the expiration
+// in milliseconds.
+// When rebinding, the arguments following the fields are the field of the
+// resource in cluster and infra-env hashes and the prefixes of these hashes.
+// KEYS[2] then holds the previous snapshot of the resource, which is removed
+// from the hash it was bound to unless written to it again. Keys of the hash
+// are derived from the snapshot, which a cluster would reject: only a
+// standalone server is supported
+var setIfNotStaleScript = redis.NewScript(`
+local rebindField = ARGV[#KEYS + 3]
+local previous = false
+if rebindField then
+	previous = redis.call('GET', KEYS[2])
+end
+if ARGV[1] ~= '' then
+	local current = tonumber(redis.call('GET', KEYS[1]))
+	if current and current > tonumber(ARGV[1]) then
+		return 0
+	end
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+end
+local written = {}
+for i = 2, #KEYS do
+	local field = ARGV[i + 2]
+	if field == '' then
+		redis.call('SET', KEYS[i], ARGV[3], 'PX', ARGV[2])
+	else
+		redis.call('HSET', KEYS[i], field, ARGV[3])
+		redis.call('PEXPIRE', KEYS[i], ARGV[2])
+		written[KEYS[i]] = true
+	end
+end
+if previous then
+	-- an unexpected snapshot is overwritten anyway, treat it as unbound
+	local ok, b = pcall(cjson.decode, previous)
+	local previousKey
+	if ok and type(b) == 'table' then
+		local clusterKeyPrefix, infraEnvKeyPrefix = ARGV[#KEYS + 4], ARGV[#KEYS + 5]
+		if type(b.cluster_id) == 'string' and b.cluster_id ~= '' then
+			previousKey = clusterKeyPrefix .. b.cluster_id
+		elseif infraEnvKeyPrefix ~= '' and type(b.infra_env_id) == 'string' and b.infra_env_id ~= '' then
+			previousKey = infraEnvKeyPrefix .. b.infra_env_id
+		end
+	end
+	if previousKey and not written[previousKey] then
+		redis.call('HDEL', previousKey, rebindField)
+	end
+end
+return 1
+`)
+
 //go:generate mockgen -source=snapshot_repository.go -package=redis -destination=mock_snapshot_repository.go
 
 type SnapshotRepositoryInterface interface {
 	SetCluster(ctx context.Context, clusterID string, event *types.Event) error
 	SetHost(ctx context.Context, clusterID, hostID string, event *types.Event) error
+	SetUnboundHost(ctx context.Context, infraEnvID, hostID string, event *types.Event) error
+	// clusterID is empty for infra-envs not bound to a cluster
 	SetInfraEnv(ctx context.Context, clusterID, infraEnvID string, event *types.Event) error
 	GetCluster(ctx context.Context, clusterID string) (map[string]interface{}, error)
 	GetHosts(ctx context.Context, clusterID string) ([]map[string]interface{}, error)
 	GetInfraEnvs(ctx context.Context, clusterID string) ([]map[string]interface{}, error)
+	GetInfraEnv(ctx context.Context, infraEnvID string) (map[string]interface{}, error)
+	GetUnboundHosts(ctx context.Context, infraEnvID string) ([]map[string]interface{}, error)
+	// deleting a cluster deletes its hosts and infra-envs as well
+	DeleteCluster(ctx context.Context, clusterID string) error
+	// clusterID may be empty, the host is looked up by id
+	DeleteHost(ctx context.Context, clusterID, hostID string) error
+	// deleting an infra-env deletes its unbound hosts as well
+	DeleteInfraEnv(ctx context.Context, infraEnvID string) error
+	// state as of the given time, when history is kept
+	GetClusterAt(ctx context.Context, clusterID string, t time.Time) (map[string]interface{}, error)
+	GetHostsAt(ctx context.Context, clusterID string, t time.Time) ([]map[string]interface{}, error)
+	GetInfraEnvsAt(ctx context.Context, clusterID string, t time.Time) ([]map[string]interface{}, error)
+	// reads the snapshots of all clusters at once, in the order of the ids
+	GetClusterSnapshots(ctx context.Context, clusterIDs []string) ([]*ClusterSnapshot, error)
+}
+
+// Latest state of a cluster, as read with GetCluster, GetHosts and
+// GetInfraEnvs
+type ClusterSnapshot struct {
+	ClusterID string
+	Cluster   map[string]interface{}
+	Hosts     []map[string]interface{}
+	InfraEnvs []map[string]interface{}
+}
+
+// Where a resource snapshot is bound, as found in its payload
+type binding struct {
+	ClusterID  string `json:"cluster_id"`
+	InfraEnvID string `json:"infra_env_id"`
+}
+
+// Snapshot written to a hash field, or to a plain key when field is empty
+type snapshotTarget struct {
+	key   string
+	field string
+}
+
+// Hashes a resource moved from is found in by the binding of its previous
+// snapshot, stored in the first target. Resources not bound to infra-envs
+// have no infra-env key prefix
+type rebinding struct {
+	field             string
+	clusterKeyPrefix  string
+	infraEnvKeyPrefix string
 }
 
 type SnapshotRepository struct {
-	logger     *logrus.Logger
-	redis      redis.Cmdable
-	expiration time.Duration
+	logger      *logrus.Logger
+	redis       redis.Cmdable
+	expiration  time.Duration
+	staleWrites int64
+	// snapshots kept in history by resource, none when 0
+	historySize int
 }
 
-func NewSnapshotRepository(logger *logrus.Logger, redis redis.Cmdable, expiration time.Duration) *SnapshotRepository {
+func NewSnapshotRepository(logger *logrus.Logger, redis redis.Cmdable, expiration time.Duration, historySize int) *SnapshotRepository {
 	return &SnapshotRepository{
-		logger:     logger,
-		redis:      redis,
-		expiration: expiration,
+		logger:      logger,
+		redis:       redis,
+		expiration:  expiration,
+		historySize: historySize,
 	}
 }
 
-func (s *SnapshotRepository) hset(ctx context.Context, key string, field string, event *types.Event) error {
-	var err error
+func marshalPayload(event *types.Event) ([]byte, error) {
 	eventBytes := []byte("")
 	if event != nil {
+		var err error
 		eventBytes, err = json.Marshal(event.Payload)
 		if err != nil {
-			return fmt.Errorf("failed to marshal payload: %w", err)
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
 		}
 	}
+	return eventBytes, nil
+}
+
+func (s *SnapshotRepository) hset(ctx context.Context, key string, field string, event *types.Event) error {
+	eventBytes, err := marshalPayload(event)
+	if err != nil {
+		return err
+	}
 
 	err = s.redis.HSet(ctx, key, field, eventBytes).Err()
 	if err != nil {
-		return fmt.Errorf("failed to set key: %w", err)
+		return stream.NewRetryableError(fmt.Errorf("failed to set key: %w", err))
 	}
 
 	err = s.redis.Expire(ctx, key, s.expiration).Err()
 	if err != nil {
-		return fmt.Errorf("failed to set ttl: %w", err)
+		return stream.NewRetryableError(fmt.Errorf("failed to set ttl: %w", err))
 	}
 
 	return nil
 }
 
+func (s *SnapshotRepository) set(ctx context.Context, key string, event *types.Event) error {
+	eventBytes, err := marshalPayload(event)
+	if err != nil {
+		return err
+	}
+	err = s.redis.Set(ctx, key, eventBytes, s.expiration).Err()
+	if err != nil {
+		return stream.NewRetryableError(fmt.Errorf("failed to set key: %w", err))
+	}
+	return nil
+}
+
+func (s *SnapshotRepository) hdel(ctx context.Context, key string, field string) error {
+	err := s.redis.HDel(ctx, key, field).Err()
+	if err != nil {
+		return stream.NewRetryableError(fmt.Errorf("failed to delete field: %w", err))
+	}
+	return nil
+}
+
+func (s *SnapshotRepository) del(ctx context.Context, keys ...string) error {
+	err := s.redis.Del(ctx, keys...).Err()
+	if err != nil {
+		return stream.NewRetryableError(fmt.Errorf("failed to delete keys: %w", err))
+	}
+	return nil
+}
+
+func (s *SnapshotRepository) srem(ctx context.Context, key string, member string) error {
+	err := s.redis.SRem(ctx, key, member).Err()
+	if err != nil {
+		return stream.NewRetryableError(fmt.Errorf("failed to remove member: %w", err))
+	}
+	return nil
+}
+
+func (s *SnapshotRepository) hkeys(ctx context.Context, key string) ([]string, error) {
+	fields, err := s.redis.HKeys(ctx, key).Result()
+	if err != nil {
+		return nil, stream.NewRetryableError(fmt.Errorf("failed to get fields: %w", err))
+	}
+	return fields, nil
+}
+
+// Writes the snapshot to all targets, unless a newer version of the resource
+// was written already. Returns false for stale writes. When rebinding, the
+// resource is moved in the same script
+func (s *SnapshotRepository) write(ctx context.Context, resource string, targets []snapshotTarget, event *types.Event, rebind *rebinding) (bool, error) {
+	version, versionKeyPrefix, ok := getVersion(event)
+	if !ok && rebind == nil {
+		for _, target := range targets {
+			var err error
+			if target.field == "" {
+				err = s.set(ctx, target.key, event)
+			} else {
+				err = s.hset(ctx, target.key, target.field, event)
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	eventBytes, err := marshalPayload(event)
+	if err != nil {
+		return false, err
+	}
+	var versionArg interface{} = version
+	if !ok {
+		// written unconditionally
+		versionKeyPrefix, versionArg = VersionRedisKeyPrefix, ""
+	}
+	versionKey := versionKeyPrefix + resource
+	keys := []string{versionKey}
+	args := []interface{}{versionArg, s.expiration.Milliseconds(), eventBytes}
+	for _, target := range targets {
+		keys = append(keys, target.key)
+		args = append(args, target.field)
+	}
+	if rebind != nil {
+		args = append(args, rebind.field, rebind.clusterKeyPrefix, rebind.infraEnvKeyPrefix)
+	}
+	written, err := setIfNotStaleScript.Run(ctx, s.redis, keys, args...).Int64()
+	if err != nil {
+		return false, stream.NewRetryableError(fmt.Errorf("failed to set key: %w", err))
+	}
+	if written == 0 {
+		staleWrites := atomic.AddInt64(&s.staleWrites, 1)
+		s.logger.WithFields(logrus.Fields{
+			"key":          versionKey,
+			"version":      version,
+			"stale_writes": staleWrites,
+		}).Info("skipping stale snapshot")
+		return false, nil
+	}
+	return true, nil
+}
+
+// Number of snapshot writes skipped because a newer version was stored
+func (s *SnapshotRepository) StaleWrites() int64 {
+	return atomic.LoadInt64(&s.staleWrites)
+}
+
+// Version of the snapshot and the prefix of the key it's compared with: the
+// sequence from metadata when set, otherwise the update time in microseconds
+func getVersion(event *types.Event) (int64, string, bool) {
+	if event == nil {
+		return 0, "", false
+	}
+	switch sequence := event.Metadata["sequence"].(type) {
+	case float64:
+		return int64(sequence), SequenceVersionRedisKeyPrefix, true
+	case int64:
+		return sequence, SequenceVersionRedisKeyPrefix, true
+	case int:
+		return int64(sequence), SequenceVersionRedisKeyPrefix, true
+	}
+	updatedAt, ok := getUpdatedAt(event)
+	return updatedAt, VersionRedisKeyPrefix, ok
+}
+
+// Update time of the snapshot in microseconds
+func getUpdatedAt(event *types.Event) (int64, bool) {
+	if event == nil {
+		return 0, false
+	}
+	payload, ok := event.Payload.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	for _, key := range []string{"updated_at", "UpdatedAt"} {
+		value, ok := payload[key].(string)
+		if !ok {
+			continue
+		}
+		updatedAt, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			continue
+		}
+		return updatedAt.UnixMicro(), true
+	}
+	return 0, false
+}
+
+// Adds the snapshot to the history of the resource, stale ones included so
+// that replays fill gaps, and indexes the resource in its cluster
+func (s *SnapshotRepository) addHistory(ctx context.Context, historyKey, clusterIndexKey, id string, event *types.Event) error {
+	if s.historySize <= 0 {
+		return nil
+	}
+	updatedAt, ok := getUpdatedAt(event)
+	if !ok {
+		return nil
+	}
+	eventBytes, err := marshalPayload(event)
+	if err != nil {
+		return err
+	}
+	err = s.redis.ZAdd(ctx, historyKey, &redis.Z{Score: float64(updatedAt), Member: string(eventBytes)}).Err()
+	if err == nil {
+		err = s.redis.ZRemRangeByRank(ctx, historyKey, 0, -int64(s.historySize)-1).Err()
+	}
+	if err == nil {
+		err = s.redis.Expire(ctx, historyKey, s.expiration).Err()
+	}
+	if err == nil && clusterIndexKey != "" {
+		err = s.redis.SAdd(ctx, clusterIndexKey, id).Err()
+		if err == nil {
+			err = s.redis.Expire(ctx, clusterIndexKey, s.expiration).Err()
+		}
+	}
+	if err != nil {
+		return stream.NewRetryableError(fmt.Errorf("failed to add history: %w", err))
+	}
+	return nil
+}
+
+// Latest snapshot in history updated at or before t
+func (s *SnapshotRepository) getAt(ctx context.Context, historyKey string, t time.Time) (map[string]interface{}, bool, error) {
+	snapshot := map[string]interface{}{}
+	snapshotsRaw, err := s.redis.ZRevRangeByScore(ctx, historyKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(t.UnixMicro(), 10),
+		Count: 1,
+	}).Result()
+	if err != nil {
+		return snapshot, false, stream.NewRetryableError(fmt.Errorf("failed to get history: %w", err))
+	}
+	if len(snapshotsRaw) == 0 {
+		return snapshot, false, nil
+	}
+	err = json.Unmarshal([]byte(snapshotsRaw[0]), &snapshot)
+	return snapshot, err == nil, err
+}
+
+// Snapshots as of t of the resources ever bound to the cluster, that were
+// bound to it at that time
+func (s *SnapshotRepository) getAllAt(ctx context.Context, clusterIndexKey string, getHistoryKey func(id string) string, clusterID string, t time.Time) ([]map[string]interface{}, bool, error) {
+	var snapshots []map[string]interface{}
+	ids, err := s.redis.SMembers(ctx, clusterIndexKey).Result()
+	if err != nil {
+		return snapshots, false, stream.NewRetryableError(fmt.Errorf("failed to get history index: %w", err))
+	}
+	if len(ids) == 0 {
+		return snapshots, false, nil
+	}
+	for _, id := range ids {
+		snapshot, ok, err := s.getAt(ctx, getHistoryKey(id), t)
+		if err != nil {
+			return snapshots, false, err
+		}
+		if ok && snapshot["cluster_id"] == clusterID {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, true, nil
+}
+
+// Binding of the snapshot stored at key, empty if there is none
+func (s *SnapshotRepository) getBinding(ctx context.Context, key string) (binding, error) {
+	b := binding{}
+	raw, err := s.redis.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return b, nil
+	}
+	if err != nil {
+		return b, stream.NewRetryableError(fmt.Errorf("failed to get key: %w", err))
+	}
+	// an unexpected snapshot is overwritten anyway, treat it as unbound
+	_ = json.Unmarshal(raw, &b)
+	return b, nil
+}
+
 func getClustersHKey() string {
 	return ClustersRedisHKey
 }
@@ -77,60 +437,325 @@ func getInfraEnvsHKey(clusterID string) string {
 	return InfraEnvsRedisHKeyPrefix + clusterID
 }
 
+func getHostKey(hostID string) string {
+	return HostRedisKeyPrefix + hostID
+}
+
+func getInfraEnvKey(infraEnvID string) string {
+	return InfraEnvRedisKeyPrefix + infraEnvID
+}
+
+func getUnboundHostsHKey(infraEnvID string) string {
+	return UnboundHostsRedisHKeyPrefix + infraEnvID
+}
+
+// Names the cluster in version and history keys
+func getClusterResource(clusterID string) string {
+	return "cluster_" + clusterID
+}
+
+func getClusterHistoryKey(clusterID string) string {
+	return HistoryRedisKeyPrefix + getClusterResource(clusterID)
+}
+
+func getHostHistoryKey(hostID string) string {
+	return HistoryRedisKeyPrefix + getHostKey(hostID)
+}
+
+func getInfraEnvHistoryKey(infraEnvID string) string {
+	return HistoryRedisKeyPrefix + getInfraEnvKey(infraEnvID)
+}
+
+func getHostsHistoryKey(clusterID string) string {
+	return HistoryRedisKeyPrefix + getHostsHKey(clusterID)
+}
+
+func getInfraEnvsHistoryKey(clusterID string) string {
+	return HistoryRedisKeyPrefix + getInfraEnvsHKey(clusterID)
+}
+
 func (s *SnapshotRepository) SetCluster(ctx context.Context, clusterID string, event *types.Event) error {
-	return s.hset(ctx, getClustersHKey(), clusterID, event)
+	if err := s.addHistory(ctx, getClusterHistoryKey(clusterID), "", clusterID, event); err != nil {
+		return err
+	}
+	_, err := s.write(ctx, getClusterResource(clusterID), []snapshotTarget{{key: getClustersHKey(), field: clusterID}}, event, nil)
+	return err
 }
 
+// Stores the host of a cluster. A host previously stored as unbound or in
+// another cluster is moved, so that it's only found in this cluster
 func (s *SnapshotRepository) SetHost(ctx context.Context, clusterID, hostID string, event *types.Event) error {
-	return s.hset(ctx, getHostsHKey(clusterID), hostID, event)
+	return s.setHost(ctx, binding{ClusterID: clusterID}, hostID, event)
+}
+
+// Stores a host registered to an infra-env that is not bound to a cluster
+func (s *SnapshotRepository) SetUnboundHost(ctx context.Context, infraEnvID, hostID string, event *types.Event) error {
+	return s.setHost(ctx, binding{InfraEnvID: infraEnvID}, hostID, event)
 }
 
+func (s *SnapshotRepository) setHost(ctx context.Context, b binding, hostID string, event *types.Event) error {
+	clusterIndexKey := ""
+	if b.ClusterID != "" {
+		clusterIndexKey = getHostsHistoryKey(b.ClusterID)
+	}
+	if err := s.addHistory(ctx, getHostHistoryKey(hostID), clusterIndexKey, hostID, event); err != nil {
+		return err
+	}
+	target := snapshotTarget{key: getHostsHKey(b.ClusterID), field: hostID}
+	if b.ClusterID == "" {
+		target.key = getUnboundHostsHKey(b.InfraEnvID)
+	}
+	_, err := s.write(ctx, getHostKey(hostID), []snapshotTarget{{key: getHostKey(hostID)}, target}, event, &rebinding{
+		field:             hostID,
+		clusterKeyPrefix:  HostsRedisHKeyPrefix,
+		infraEnvKeyPrefix: UnboundHostsRedisHKeyPrefix,
+	})
+	return err
+}
+
+// Stores the infra-env by id and, when bound, in its cluster. An infra-env
+// previously bound to another cluster is removed from it
 func (s *SnapshotRepository) SetInfraEnv(ctx context.Context, clusterID, infraEnvID string, event *types.Event) error {
-	return s.hset(ctx, getInfraEnvsHKey(clusterID), infraEnvID, event)
+	clusterIndexKey := ""
+	if clusterID != "" {
+		clusterIndexKey = getInfraEnvsHistoryKey(clusterID)
+	}
+	if err := s.addHistory(ctx, getInfraEnvHistoryKey(infraEnvID), clusterIndexKey, infraEnvID, event); err != nil {
+		return err
+	}
+	targets := []snapshotTarget{{key: getInfraEnvKey(infraEnvID)}}
+	if clusterID != "" {
+		targets = append(targets, snapshotTarget{key: getInfraEnvsHKey(clusterID), field: infraEnvID})
+	}
+	_, err := s.write(ctx, getInfraEnvKey(infraEnvID), targets, event, &rebinding{
+		field:            infraEnvID,
+		clusterKeyPrefix: InfraEnvsRedisHKeyPrefix,
+	})
+	return err
+}
+
+// Deletes the cluster along with its hosts and infra-envs, and their history
+func (s *SnapshotRepository) DeleteCluster(ctx context.Context, clusterID string) error {
+	hostIDs, err := s.hkeys(ctx, getHostsHKey(clusterID))
+	if err != nil {
+		return err
+	}
+	infraEnvIDs, err := s.hkeys(ctx, getInfraEnvsHKey(clusterID))
+	if err != nil {
+		return err
+	}
+	keys := []string{
+		getHostsHKey(clusterID),
+		getInfraEnvsHKey(clusterID),
+		getClusterHistoryKey(clusterID),
+		getHostsHistoryKey(clusterID),
+		getInfraEnvsHistoryKey(clusterID),
+	}
+	for _, hostID := range hostIDs {
+		keys = append(keys, getHostKey(hostID), getHostHistoryKey(hostID))
+	}
+	for _, infraEnvID := range infraEnvIDs {
+		keys = append(keys, getInfraEnvKey(infraEnvID), getInfraEnvHistoryKey(infraEnvID))
+	}
+	if err = s.del(ctx, keys...); err != nil {
+		return err
+	}
+	return s.hdel(ctx, getClustersHKey(), clusterID)
+}
+
+// Deletes the host from the cluster, or infra-env, it is stored in
+func (s *SnapshotRepository) DeleteHost(ctx context.Context, clusterID, hostID string) error {
+	previous, err := s.getBinding(ctx, getHostKey(hostID))
+	if err != nil {
+		return err
+	}
+	if clusterID != "" && clusterID != previous.ClusterID {
+		if err = s.hdel(ctx, getHostsHKey(clusterID), hostID); err != nil {
+			return err
+		}
+	}
+	if previous.ClusterID != "" {
+		if err = s.hdel(ctx, getHostsHKey(previous.ClusterID), hostID); err != nil {
+			return err
+		}
+	} else if previous.InfraEnvID != "" {
+		if err = s.hdel(ctx, getUnboundHostsHKey(previous.InfraEnvID), hostID); err != nil {
+			return err
+		}
+	}
+	return s.del(ctx, getHostKey(hostID))
+}
+
+// Deletes the infra-env from its cluster, along with its unbound hosts, and
+// their history
+func (s *SnapshotRepository) DeleteInfraEnv(ctx context.Context, infraEnvID string) error {
+	previous, err := s.getBinding(ctx, getInfraEnvKey(infraEnvID))
+	if err != nil {
+		return err
+	}
+	hostIDs, err := s.hkeys(ctx, getUnboundHostsHKey(infraEnvID))
+	if err != nil {
+		return err
+	}
+	keys := []string{getInfraEnvKey(infraEnvID), getUnboundHostsHKey(infraEnvID), getInfraEnvHistoryKey(infraEnvID)}
+	for _, hostID := range hostIDs {
+		keys = append(keys, getHostKey(hostID), getHostHistoryKey(hostID))
+	}
+	if err = s.del(ctx, keys...); err != nil {
+		return err
+	}
+	if previous.ClusterID == "" {
+		return nil
+	}
+	if err = s.hdel(ctx, getInfraEnvsHKey(previous.ClusterID), infraEnvID); err != nil {
+		return err
+	}
+	return s.srem(ctx, getInfraEnvsHistoryKey(previous.ClusterID), infraEnvID)
 }
 
 func (s *SnapshotRepository) GetCluster(ctx context.Context, clusterID string) (map[string]interface{}, error) {
+	return getClusterFromCmd(s.redis.HGet(ctx, getClustersHKey(), clusterID))
+}
+
+// Empty if not found
+func getClusterFromCmd(cmd *redis.StringCmd) (map[string]interface{}, error) {
 	cluster := map[string]interface{}{}
-	clusterRaw, err := s.redis.HGet(ctx, getClustersHKey(), clusterID).Bytes()
-	if err != nil {
+	clusterRaw, err := cmd.Bytes()
+	if err == redis.Nil {
 		return cluster, nil
 	}
+	if err != nil {
+		return cluster, stream.NewRetryableError(fmt.Errorf("failed to get cluster: %w", err))
+	}
 	err = json.Unmarshal(clusterRaw, &cluster)
 	return cluster, err
 }
 
 func (s *SnapshotRepository) GetHosts(ctx context.Context, clusterID string) ([]map[string]interface{}, error) {
-	var hosts []map[string]interface{}
-	hostsRaw, err := s.redis.HGetAll(ctx, getHostsHKey(clusterID)).Result()
+	return s.hgetAll(ctx, getHostsHKey(clusterID))
+}
+
+func (s *SnapshotRepository) GetInfraEnvs(ctx context.Context, clusterID string) ([]map[string]interface{}, error) {
+	return s.hgetAll(ctx, getInfraEnvsHKey(clusterID))
+}
+
+// Hosts of the infra-env not bound to a cluster
+func (s *SnapshotRepository) GetUnboundHosts(ctx context.Context, infraEnvID string) ([]map[string]interface{}, error) {
+	return s.hgetAll(ctx, getUnboundHostsHKey(infraEnvID))
+}
+
+// Infra-env by id, whether it's bound to a cluster or not. Empty if not found
+func (s *SnapshotRepository) GetInfraEnv(ctx context.Context, infraEnvID string) (map[string]interface{}, error) {
+	infraEnv := map[string]interface{}{}
+	infraEnvRaw, err := s.redis.Get(ctx, getInfraEnvKey(infraEnvID)).Bytes()
+	if err == redis.Nil {
+		return infraEnv, nil
+	}
+	if err != nil {
+		return infraEnv, stream.NewRetryableError(fmt.Errorf("failed to get infra-env: %w", err))
+	}
+	err = json.Unmarshal(infraEnvRaw, &infraEnv)
+	return infraEnv, err
+}
+
+// Cluster as of t, or the latest one when there is no history for it
+func (s *SnapshotRepository) GetClusterAt(ctx context.Context, clusterID string, t time.Time) (map[string]interface{}, error) {
+	cluster, ok, err := s.getAt(ctx, getClusterHistoryKey(clusterID), t)
+	if err != nil {
+		return cluster, err
+	}
+	if !ok {
+		return s.GetCluster(ctx, clusterID)
+	}
+	return cluster, nil
+}
+
+// Hosts bound to the cluster as of t, or the latest ones when there is no
+// history for the cluster
+func (s *SnapshotRepository) GetHostsAt(ctx context.Context, clusterID string, t time.Time) ([]map[string]interface{}, error) {
+	hosts, ok, err := s.getAllAt(ctx, getHostsHistoryKey(clusterID), getHostHistoryKey, clusterID, t)
 	if err != nil {
 		return hosts, err
 	}
-	for _, v := range hostsRaw {
-		hostState := map[string]interface{}{}
-		err := json.Unmarshal([]byte(v), &hostState)
-		if err != nil {
-			// maybe log error but return other objects?
-			return hosts, err
-		}
-		hosts = append(hosts, hostState)
+	if !ok {
+		return s.GetHosts(ctx, clusterID)
 	}
 	return hosts, nil
 }
 
-func (s *SnapshotRepository) GetInfraEnvs(ctx context.Context, clusterID string) ([]map[string]interface{}, error) {
-	var infraEnvs []map[string]interface{}
-	infraEnv, err := s.redis.HGetAll(ctx, getInfraEnvsHKey(clusterID)).Result()
+// Infra-envs bound to the cluster as of t, or the latest ones when there is
+// no history for the cluster
+func (s *SnapshotRepository) GetInfraEnvsAt(ctx context.Context, clusterID string, t time.Time) ([]map[string]interface{}, error) {
+	infraEnvs, ok, err := s.getAllAt(ctx, getInfraEnvsHistoryKey(clusterID), getInfraEnvHistoryKey, clusterID, t)
 	if err != nil {
 		return infraEnvs, err
 	}
-	for _, v := range infraEnv {
-		infraEnvState := map[string]interface{}{}
-		err := json.Unmarshal([]byte(v), &infraEnvState)
+	if !ok {
+		return s.GetInfraEnvs(ctx, clusterID)
+	}
+	return infraEnvs, nil
+}
+
+// Issues the reads of all clusters in a single pipeline. Ids may repeat, each
+// snapshot is decoded separately so that callers can modify it
+func (s *SnapshotRepository) GetClusterSnapshots(ctx context.Context, clusterIDs []string) ([]*ClusterSnapshot, error) {
+	if len(clusterIDs) == 0 {
+		return nil, nil
+	}
+	type clusterCmds struct {
+		cluster   *redis.StringCmd
+		hosts     *redis.StringStringMapCmd
+		infraEnvs *redis.StringStringMapCmd
+	}
+	cmds := make([]clusterCmds, len(clusterIDs))
+	// missing clusters fail their command with redis.Nil, errors are checked
+	// for each command instead
+	_, _ = s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, clusterID := range clusterIDs {
+			cmds[i] = clusterCmds{
+				cluster:   pipe.HGet(ctx, getClustersHKey(), clusterID),
+				hosts:     pipe.HGetAll(ctx, getHostsHKey(clusterID)),
+				infraEnvs: pipe.HGetAll(ctx, getInfraEnvsHKey(clusterID)),
+			}
+		}
+		return nil
+	})
+	snapshots := make([]*ClusterSnapshot, len(clusterIDs))
+	for i, clusterID := range clusterIDs {
+		snapshot := &ClusterSnapshot{ClusterID: clusterID}
+		var err error
+		if snapshot.Cluster, err = getClusterFromCmd(cmds[i].cluster); err != nil {
+			return nil, err
+		}
+		if snapshot.Hosts, err = getAllFromCmd(cmds[i].hosts); err != nil {
+			return nil, err
+		}
+		if snapshot.InfraEnvs, err = getAllFromCmd(cmds[i].infraEnvs); err != nil {
+			return nil, err
+		}
+		snapshots[i] = snapshot
+	}
+	return snapshots, nil
+}
+
+func (s *SnapshotRepository) hgetAll(ctx context.Context, key string) ([]map[string]interface{}, error) {
+	return getAllFromCmd(s.redis.HGetAll(ctx, key))
+}
+
+func getAllFromCmd(cmd *redis.StringStringMapCmd) ([]map[string]interface{}, error) {
+	var snapshots []map[string]interface{}
+	snapshotsRaw, err := cmd.Result()
+	if err != nil {
+		return snapshots, stream.NewRetryableError(fmt.Errorf("failed to get snapshots: %w", err))
+	}
+	for _, v := range snapshotsRaw {
+		snapshot := map[string]interface{}{}
+		err := json.Unmarshal([]byte(v), &snapshot)
 		if err != nil {
 			// maybe log error but return other objects?
-			return infraEnvs, err
+			return snapshots, err
 		}
-		infraEnvs = append(infraEnvs, infraEnvState)
+		snapshots = append(snapshots, snapshot)
 	}
-	return infraEnvs, nil
+	return snapshots, nil
 }
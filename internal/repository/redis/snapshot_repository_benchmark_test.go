@@ -0,0 +1,91 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// Clusters with a few hosts and an infra-env each, written to the server
+// configured with VALKEY_ADDRESS. They are removed once the benchmark is done
+func setBenchmarkSnapshots(b *testing.B, ctx context.Context, client *redis.Client, clusters int) []string {
+	clusterIDs := make([]string, clusters)
+	keys := []string{}
+	for i := range clusterIDs {
+		clusterID := fmt.Sprintf("benchmark-cluster-%d", i)
+		clusterIDs[i] = clusterID
+		pipe := client.Pipeline()
+		pipe.HSet(ctx, getClustersHKey(), clusterID, fmt.Sprintf(`{"id":"%s","status":"installing","openshift_version":"4.12"}`, clusterID))
+		for j := 0; j < 3; j++ {
+			hostID := fmt.Sprintf("%s-host-%d", clusterID, j)
+			pipe.HSet(ctx, getHostsHKey(clusterID), hostID, fmt.Sprintf(`{"id":"%s","cluster_id":"%s","status":"installing"}`, hostID, clusterID))
+		}
+		infraEnvID := clusterID + "-infraenv"
+		pipe.HSet(ctx, getInfraEnvsHKey(clusterID), infraEnvID, fmt.Sprintf(`{"id":"%s","cluster_id":"%s","type":"full-iso"}`, infraEnvID, clusterID))
+		if _, err := pipe.Exec(ctx); err != nil {
+			b.Fatal(err)
+		}
+		keys = append(keys, getHostsHKey(clusterID), getInfraEnvsHKey(clusterID))
+	}
+	b.Cleanup(func() {
+		client.HDel(ctx, getClustersHKey(), clusterIDs...)
+		client.Del(ctx, keys...)
+	})
+	return clusterIDs
+}
+
+// Reads the snapshots of a batch of cluster events, one cluster at a time as
+// each event does, and in a single pipeline
+func BenchmarkGetClusterSnapshots(b *testing.B) {
+	addr := os.Getenv("VALKEY_ADDRESS")
+	if addr == "" {
+		b.Skip("VALKEY_ADDRESS is not set")
+	}
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.Out = io.Discard
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: os.Getenv("VALKEY_PASSWORD"),
+	})
+	defer client.Close()
+	if err := client.Ping(ctx).Err(); err != nil {
+		b.Skipf("cannot reach %s: %v", addr, err)
+	}
+	clusterIDs := setBenchmarkSnapshots(b, ctx, client, 50)
+	snapshotRepo := NewSnapshotRepository(logger, client, time.Hour, 0)
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, clusterID := range clusterIDs {
+				if _, err := snapshotRepo.GetCluster(ctx, clusterID); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := snapshotRepo.GetHosts(ctx, clusterID); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := snapshotRepo.GetInfraEnvs(ctx, clusterID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("pipelined", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			snapshots, err := snapshotRepo.GetClusterSnapshots(ctx, clusterIDs)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(snapshots[len(snapshots)-1].Hosts) != 3 {
+				b.Fatal("missing hosts")
+			}
+		}
+	})
+}
@@ -15,6 +15,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/openshift-assisted/assisted-events-streams/internal/types"
+	"github.com/openshift-assisted/assisted-events-streams/pkg/stream"
 	"github.com/sirupsen/logrus"
 )
 
@@ -27,6 +28,7 @@ var _ = Describe("Setting objects", func() {
 		redis        *redis.Client
 		mock         redismock.ClientMock
 		logger       *logrus.Logger
+		ttl          = defaultDuration.Milliseconds()
 	)
 	BeforeEach(func() {
 		logger = logrus.New()
@@ -98,6 +100,7 @@ var _ = Describe("Setting objects", func() {
 				event,
 			)
 			Expect(err).To(MatchError(expectedError))
+			Expect(stream.IsRetryable(err)).To(BeTrue())
 			Expect(mock.ExpectationsWereMet()).To(BeNil())
 
 		})
@@ -109,10 +112,11 @@ var _ = Describe("Setting objects", func() {
 				Name: "FooBar",
 			}
 			eventBytes, _ := json.Marshal(event.Payload)
-			cmp := fmt.Sprintf("%v", eventBytes)
 
-			mock.Regexp().ExpectHSet("hosts_c42cfc1d-411a-4cdb-953b-a8e0f3f82375", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", regexp.QuoteMeta(cmp)).SetVal(1)
-			mock.Regexp().ExpectExpire("hosts_c42cfc1d-411a-4cdb-953b-a8e0f3f82375", defaultDuration).SetVal(true)
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(),
+				[]string{"version_host_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "host_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "hosts_c42cfc1d-411a-4cdb-953b-a8e0f3f82375"},
+				"", ttl, eventBytes, "", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "hosts_", "unbound_hosts_",
+			).SetVal(int64(1))
 
 			err := snapshotRepo.SetHost(
 				ctx,
@@ -131,10 +135,11 @@ var _ = Describe("Setting objects", func() {
 				Name: "FooBar",
 			}
 			eventBytes, _ := json.Marshal(event.Payload)
-			cmp := fmt.Sprintf("%v", eventBytes)
 
-			mock.Regexp().ExpectHSet("infraenvs_c42cfc1d-411a-4cdb-953b-a8e0f3f82375", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", regexp.QuoteMeta(cmp)).SetVal(1)
-			mock.Regexp().ExpectExpire("infraenvs_c42cfc1d-411a-4cdb-953b-a8e0f3f82375", defaultDuration).SetVal(true)
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(),
+				[]string{"version_infraenv_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "infraenv_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "infraenvs_c42cfc1d-411a-4cdb-953b-a8e0f3f82375"},
+				"", ttl, eventBytes, "", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "infraenvs_", "",
+			).SetVal(int64(1))
 
 			err := snapshotRepo.SetInfraEnv(
 				ctx,
@@ -147,6 +152,491 @@ var _ = Describe("Setting objects", func() {
 		})
 	})
 
+	When("setting an infraenv not bound to a cluster", func() {
+		It("should only index it by id", func() {
+			event := &types.Event{Payload: map[string]interface{}{"id": "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "cluster_id": nil}}
+			eventBytes, _ := json.Marshal(event.Payload)
+
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(),
+				[]string{"version_infraenv_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "infraenv_8eb96308-9d8b-4293-a4ef-f68dfed549e4"},
+				"", ttl, eventBytes, "", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "infraenvs_", "",
+			).SetVal(int64(1))
+
+			err := snapshotRepo.SetInfraEnv(ctx, "", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", event)
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).To(BeNil())
+		})
+	})
+
+	When("binding an infraenv to another cluster", func() {
+		It("should rebind it in the same script", func() {
+			event := &types.Event{Payload: map[string]interface{}{"id": "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "cluster_id": "c42cfc1d-411a-4cdb-953b-a8e0f3f82375"}}
+			eventBytes, _ := json.Marshal(event.Payload)
+
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(),
+				[]string{"version_infraenv_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "infraenv_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "infraenvs_c42cfc1d-411a-4cdb-953b-a8e0f3f82375"},
+				"", ttl, eventBytes, "", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "infraenvs_", "",
+			).SetVal(int64(1))
+
+			err := snapshotRepo.SetInfraEnv(ctx, "c42cfc1d-411a-4cdb-953b-a8e0f3f82375", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", event)
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).To(BeNil())
+		})
+	})
+
+	When("setting a host not bound to a cluster", func() {
+		It("should index it by id and by infraenv", func() {
+			event := &types.Event{Payload: map[string]interface{}{"id": "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "infra_env_id": "5a6b2f1e-3b5e-4a44-9f1e-1c2d3e4f5a6b"}}
+			eventBytes, _ := json.Marshal(event.Payload)
+
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(),
+				[]string{"version_host_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "host_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "unbound_hosts_5a6b2f1e-3b5e-4a44-9f1e-1c2d3e4f5a6b"},
+				"", ttl, eventBytes, "", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "hosts_", "unbound_hosts_",
+			).SetVal(int64(1))
+
+			err := snapshotRepo.SetUnboundHost(ctx, "5a6b2f1e-3b5e-4a44-9f1e-1c2d3e4f5a6b", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", event)
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).To(BeNil())
+		})
+	})
+
+	When("an unbound host binds to a cluster", func() {
+		It("should move it from its infraenv to the cluster in the same script", func() {
+			event := &types.Event{Payload: map[string]interface{}{
+				"id":           "8eb96308-9d8b-4293-a4ef-f68dfed549e4",
+				"infra_env_id": "5a6b2f1e-3b5e-4a44-9f1e-1c2d3e4f5a6b",
+				"cluster_id":   "c42cfc1d-411a-4cdb-953b-a8e0f3f82375",
+			}}
+			eventBytes, _ := json.Marshal(event.Payload)
+
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(),
+				[]string{"version_host_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "host_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "hosts_c42cfc1d-411a-4cdb-953b-a8e0f3f82375"},
+				"", ttl, eventBytes, "", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "hosts_", "unbound_hosts_",
+			).SetVal(int64(1))
+
+			err := snapshotRepo.SetHost(ctx, "c42cfc1d-411a-4cdb-953b-a8e0f3f82375", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", event)
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).To(BeNil())
+		})
+	})
+
+	When("the host cannot be written", func() {
+		It("should return a retryable error", func() {
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(),
+				[]string{"version_host_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "host_8eb96308-9d8b-4293-a4ef-f68dfed549e4", "hosts_c42cfc1d-411a-4cdb-953b-a8e0f3f82375"},
+				"", ttl, []byte("null"), "", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "hosts_", "unbound_hosts_",
+			).SetErr(errors.New("connection refused"))
+
+			err := snapshotRepo.SetHost(ctx, "c42cfc1d-411a-4cdb-953b-a8e0f3f82375", "8eb96308-9d8b-4293-a4ef-f68dfed549e4", &types.Event{})
+			Expect(stream.IsRetryable(err)).To(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Getting objects", func() {
+	var (
+		ctx          context.Context
+		snapshotRepo *SnapshotRepository
+		mock         redismock.ClientMock
+	)
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.Out = io.Discard
+		ctx = context.Background()
+		var client *redis.Client
+		client, mock = redismock.NewClientMock()
+		snapshotRepo = NewSnapshotRepository(logger, client, defaultDuration, 0)
+	})
+	AfterEach(func() {
+		Expect(mock.ExpectationsWereMet()).To(BeNil())
+	})
+
+	When("getting an infraenv by id", func() {
+		It("should return its snapshot", func() {
+			mock.ExpectGet("infraenv_8eb96308-9d8b-4293-a4ef-f68dfed549e4").SetVal(`{"id":"8eb96308-9d8b-4293-a4ef-f68dfed549e4","type":"full-iso"}`)
+
+			infraEnv, err := snapshotRepo.GetInfraEnv(ctx, "8eb96308-9d8b-4293-a4ef-f68dfed549e4")
+			Expect(err).To(BeNil())
+			Expect(infraEnv).To(Equal(map[string]interface{}{"id": "8eb96308-9d8b-4293-a4ef-f68dfed549e4", "type": "full-iso"}))
+		})
+
+		It("should return an empty snapshot when not found", func() {
+			mock.ExpectGet("infraenv_8eb96308-9d8b-4293-a4ef-f68dfed549e4").RedisNil()
+
+			infraEnv, err := snapshotRepo.GetInfraEnv(ctx, "8eb96308-9d8b-4293-a4ef-f68dfed549e4")
+			Expect(err).To(BeNil())
+			Expect(infraEnv).To(BeEmpty())
+		})
+
+		It("should return a retryable error when it cannot be read", func() {
+			mock.ExpectGet("infraenv_8eb96308-9d8b-4293-a4ef-f68dfed549e4").SetErr(errors.New("connection refused"))
+
+			_, err := snapshotRepo.GetInfraEnv(ctx, "8eb96308-9d8b-4293-a4ef-f68dfed549e4")
+			Expect(stream.IsRetryable(err)).To(BeTrue())
+		})
+	})
+
+	When("getting a cluster", func() {
+		It("should return an empty snapshot when not found", func() {
+			mock.ExpectHGet("clusters", "c42cfc1d-411a-4cdb-953b-a8e0f3f82375").RedisNil()
+
+			cluster, err := snapshotRepo.GetCluster(ctx, "c42cfc1d-411a-4cdb-953b-a8e0f3f82375")
+			Expect(err).To(BeNil())
+			Expect(cluster).To(BeEmpty())
+		})
+
+		It("should return a retryable error when it cannot be read", func() {
+			mock.ExpectHGet("clusters", "c42cfc1d-411a-4cdb-953b-a8e0f3f82375").SetErr(errors.New("connection refused"))
+
+			_, err := snapshotRepo.GetCluster(ctx, "c42cfc1d-411a-4cdb-953b-a8e0f3f82375")
+			Expect(stream.IsRetryable(err)).To(BeTrue())
+		})
+	})
+
+	When("getting the unbound hosts of an infraenv", func() {
+		It("should return their snapshots", func() {
+			mock.ExpectHGetAll("unbound_hosts_5a6b2f1e-3b5e-4a44-9f1e-1c2d3e4f5a6b").SetVal(map[string]string{
+				"8eb96308-9d8b-4293-a4ef-f68dfed549e4": `{"id":"8eb96308-9d8b-4293-a4ef-f68dfed549e4"}`,
+			})
+
+			hosts, err := snapshotRepo.GetUnboundHosts(ctx, "5a6b2f1e-3b5e-4a44-9f1e-1c2d3e4f5a6b")
+			Expect(err).To(BeNil())
+			Expect(hosts).To(Equal([]map[string]interface{}{{"id": "8eb96308-9d8b-4293-a4ef-f68dfed549e4"}}))
+		})
+
+		It("should return a retryable error when they cannot be read", func() {
+			mock.ExpectHGetAll("unbound_hosts_5a6b2f1e-3b5e-4a44-9f1e-1c2d3e4f5a6b").SetErr(errors.New("connection refused"))
+
+			_, err := snapshotRepo.GetUnboundHosts(ctx, "5a6b2f1e-3b5e-4a44-9f1e-1c2d3e4f5a6b")
+			Expect(stream.IsRetryable(err)).To(BeTrue())
+		})
+	})
+	When("getting the snapshots of several clusters", func() {
+		const (
+			clusterID      = "c42cfc1d-411a-4cdb-953b-a8e0f3f82375"
+			otherClusterID = "3a930088-e49d-4584-bbd3-54a568dbe833"
+		)
+
+		It("should read them in a single pipeline, in order", func() {
+			for _, id := range []string{clusterID, otherClusterID, clusterID} {
+				mock.ExpectHGet("clusters", id).SetVal(fmt.Sprintf(`{"id":"%s"}`, id))
+				mock.ExpectHGetAll("hosts_" + id).SetVal(map[string]string{"h": `{"id":"h"}`})
+				mock.ExpectHGetAll("infraenvs_" + id).SetVal(map[string]string{})
+			}
+
+			snapshots, err := snapshotRepo.GetClusterSnapshots(ctx, []string{clusterID, otherClusterID, clusterID})
+			Expect(err).To(BeNil())
+			Expect(snapshots).To(HaveLen(3))
+			Expect(snapshots[0].ClusterID).To(Equal(clusterID))
+			Expect(snapshots[0].Cluster).To(Equal(map[string]interface{}{"id": clusterID}))
+			Expect(snapshots[0].Hosts).To(Equal([]map[string]interface{}{{"id": "h"}}))
+			Expect(snapshots[0].InfraEnvs).To(BeEmpty())
+			Expect(snapshots[1].ClusterID).To(Equal(otherClusterID))
+			Expect(snapshots[1].Cluster).To(Equal(map[string]interface{}{"id": otherClusterID}))
+			// snapshots of the same cluster can be modified independently
+			snapshots[0].Cluster["hosts"] = snapshots[0].Hosts
+			Expect(snapshots[2].Cluster).To(Equal(map[string]interface{}{"id": clusterID}))
+		})
+
+		It("should fail when infraenvs cannot be read", func() {
+			mock.ExpectHGet("clusters", clusterID).SetVal(`{}`)
+			mock.ExpectHGetAll("hosts_" + clusterID).SetVal(map[string]string{})
+			mock.ExpectHGetAll("infraenvs_" + clusterID).SetErr(errors.New("connection refused"))
+
+			_, err := snapshotRepo.GetClusterSnapshots(ctx, []string{clusterID})
+			Expect(stream.IsRetryable(err)).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("Setting versioned objects", func() {
+	const (
+		clusterID = "c42cfc1d-411a-4cdb-953b-a8e0f3f82375"
+		hostID    = "8eb96308-9d8b-4293-a4ef-f68dfed549e4"
+	)
+	var (
+		ctx          context.Context
+		snapshotRepo *SnapshotRepository
+		mock         redismock.ClientMock
+		ttl          = defaultDuration.Milliseconds()
+	)
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.Out = io.Discard
+		ctx = context.Background()
+		var client *redis.Client
+		client, mock = redismock.NewClientMock()
+		snapshotRepo = NewSnapshotRepository(logger, client, defaultDuration, 0)
+	})
+	AfterEach(func() {
+		Expect(mock.ExpectationsWereMet()).To(BeNil())
+	})
+
+	When("the payload has an update time", func() {
+		It("should write the cluster conditionally on its version", func() {
+			event := &types.Event{Payload: map[string]interface{}{"id": clusterID, "updated_at": "2023-01-20T02:19:23.972689Z"}}
+			eventBytes, _ := json.Marshal(event.Payload)
+			version := time.Date(2023, 1, 20, 2, 19, 23, 972689000, time.UTC).UnixMicro()
+
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(), []string{"version_cluster_" + clusterID, "clusters"}, version, ttl, eventBytes, clusterID).SetVal(int64(1))
+
+			Expect(snapshotRepo.SetCluster(ctx, clusterID, event)).To(BeNil())
+			Expect(snapshotRepo.StaleWrites()).To(BeZero())
+		})
+
+		It("should count stale writes", func() {
+			event := &types.Event{Payload: map[string]interface{}{"id": clusterID, "UpdatedAt": "2023-01-20T02:19:23Z"}}
+			eventBytes, _ := json.Marshal(event.Payload)
+			version := time.Date(2023, 1, 20, 2, 19, 23, 0, time.UTC).UnixMicro()
+
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(), []string{"version_cluster_" + clusterID, "clusters"}, version, ttl, eventBytes, clusterID).SetVal(int64(0))
+
+			Expect(snapshotRepo.SetCluster(ctx, clusterID, event)).To(BeNil())
+			Expect(snapshotRepo.StaleWrites()).To(Equal(int64(1)))
+		})
+	})
+
+	When("the metadata has a sequence", func() {
+		It("should use it as version, compared with other sequences only", func() {
+			event := &types.Event{
+				Payload:  map[string]interface{}{"id": clusterID, "updated_at": "2023-01-20T02:19:23Z"},
+				Metadata: map[string]interface{}{"sequence": float64(42)},
+			}
+			eventBytes, _ := json.Marshal(event.Payload)
+
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(), []string{"version_sequence_cluster_" + clusterID, "clusters"}, int64(42), ttl, eventBytes, clusterID).SetVal(int64(1))
+
+			Expect(snapshotRepo.SetCluster(ctx, clusterID, event)).To(BeNil())
+		})
+	})
+
+	When("a stale host moves to another cluster", func() {
+		It("should not remove it from its current cluster", func() {
+			event := &types.Event{Payload: map[string]interface{}{"id": hostID, "cluster_id": clusterID, "updated_at": "2023-01-20T02:19:23Z"}}
+			eventBytes, _ := json.Marshal(event.Payload)
+			version := time.Date(2023, 1, 20, 2, 19, 23, 0, time.UTC).UnixMicro()
+
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(), []string{"version_host_" + hostID, "host_" + hostID, "hosts_" + clusterID}, version, ttl, eventBytes, "", hostID, hostID, "hosts_", "unbound_hosts_").SetVal(int64(0))
+
+			Expect(snapshotRepo.SetHost(ctx, clusterID, hostID, event)).To(BeNil())
+			Expect(snapshotRepo.StaleWrites()).To(Equal(int64(1)))
+		})
+	})
+
+	When("running the script fails", func() {
+		It("should return a retryable error", func() {
+			event := &types.Event{Payload: map[string]interface{}{"id": clusterID, "updated_at": "2023-01-20T02:19:23Z"}}
+			eventBytes, _ := json.Marshal(event.Payload)
+			version := time.Date(2023, 1, 20, 2, 19, 23, 0, time.UTC).UnixMicro()
+
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(), []string{"version_cluster_" + clusterID, "clusters"}, version, ttl, eventBytes, clusterID).SetErr(errors.New("connection refused"))
+
+			Expect(stream.IsRetryable(snapshotRepo.SetCluster(ctx, clusterID, event))).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("Keeping history", func() {
+	const (
+		clusterID = "c42cfc1d-411a-4cdb-953b-a8e0f3f82375"
+		hostID    = "8eb96308-9d8b-4293-a4ef-f68dfed549e4"
+		otherID   = "5a6b2f1e-3b5e-4a44-9f1e-1c2d3e4f5a6b"
+	)
+	var (
+		ctx          context.Context
+		snapshotRepo *SnapshotRepository
+		mock         redismock.ClientMock
+		updatedAt    = time.Date(2023, 1, 20, 2, 19, 23, 0, time.UTC)
+	)
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.Out = io.Discard
+		ctx = context.Background()
+		var client *redis.Client
+		client, mock = redismock.NewClientMock()
+		snapshotRepo = NewSnapshotRepository(logger, client, defaultDuration, 2)
+	})
+	AfterEach(func() {
+		Expect(mock.ExpectationsWereMet()).To(BeNil())
+	})
+
+	When("setting a cluster", func() {
+		It("should add it to its bounded history", func() {
+			event := &types.Event{Payload: map[string]interface{}{"id": clusterID, "updated_at": updatedAt.Format(time.RFC3339)}}
+			eventBytes, _ := json.Marshal(event.Payload)
+
+			mock.ExpectZAdd("history_cluster_"+clusterID, &redis.Z{Score: float64(updatedAt.UnixMicro()), Member: string(eventBytes)}).SetVal(1)
+			mock.ExpectZRemRangeByRank("history_cluster_"+clusterID, 0, -3).SetVal(0)
+			mock.ExpectExpire("history_cluster_"+clusterID, defaultDuration).SetVal(true)
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(), []string{"version_cluster_" + clusterID, "clusters"}, updatedAt.UnixMicro(), defaultDuration.Milliseconds(), eventBytes, clusterID).SetVal(int64(0))
+
+			Expect(snapshotRepo.SetCluster(ctx, clusterID, event)).To(BeNil())
+		})
+	})
+
+	When("setting a host", func() {
+		It("should add it to its history and index it in the cluster", func() {
+			event := &types.Event{Payload: map[string]interface{}{"id": hostID, "cluster_id": clusterID, "updated_at": updatedAt.Format(time.RFC3339)}}
+			eventBytes, _ := json.Marshal(event.Payload)
+
+			mock.ExpectZAdd("history_host_"+hostID, &redis.Z{Score: float64(updatedAt.UnixMicro()), Member: string(eventBytes)}).SetVal(1)
+			mock.ExpectZRemRangeByRank("history_host_"+hostID, 0, -3).SetVal(0)
+			mock.ExpectExpire("history_host_"+hostID, defaultDuration).SetVal(true)
+			mock.ExpectSAdd("history_hosts_"+clusterID, hostID).SetVal(1)
+			mock.ExpectExpire("history_hosts_"+clusterID, defaultDuration).SetVal(true)
+			mock.ExpectEvalSha(setIfNotStaleScript.Hash(), []string{"version_host_" + hostID, "host_" + hostID, "hosts_" + clusterID}, updatedAt.UnixMicro(), defaultDuration.Milliseconds(), eventBytes, "", hostID, hostID, "hosts_", "unbound_hosts_").SetVal(int64(1))
+
+			Expect(snapshotRepo.SetHost(ctx, clusterID, hostID, event)).To(BeNil())
+		})
+	})
+
+	When("getting a cluster at a point in time", func() {
+		It("should return the latest snapshot updated before then", func() {
+			at := updatedAt.Add(time.Hour)
+			mock.ExpectZRevRangeByScore("history_cluster_"+clusterID, &redis.ZRangeBy{Min: "-inf", Max: fmt.Sprint(at.UnixMicro()), Count: 1}).SetVal([]string{`{"id":"c42cfc1d-411a-4cdb-953b-a8e0f3f82375","status":"insufficient"}`})
+
+			cluster, err := snapshotRepo.GetClusterAt(ctx, clusterID, at)
+			Expect(err).To(BeNil())
+			Expect(cluster).To(HaveKeyWithValue("status", "insufficient"))
+		})
+
+		It("should return the latest snapshot when there is no history", func() {
+			mock.ExpectZRevRangeByScore("history_cluster_"+clusterID, &redis.ZRangeBy{Min: "-inf", Max: fmt.Sprint(updatedAt.UnixMicro()), Count: 1}).SetVal([]string{})
+			mock.ExpectHGet("clusters", clusterID).SetVal(`{"id":"c42cfc1d-411a-4cdb-953b-a8e0f3f82375","status":"installed"}`)
+
+			cluster, err := snapshotRepo.GetClusterAt(ctx, clusterID, updatedAt)
+			Expect(err).To(BeNil())
+			Expect(cluster).To(HaveKeyWithValue("status", "installed"))
+		})
+
+		It("should fail with a retryable error when the history cannot be read", func() {
+			mock.ExpectZRevRangeByScore("history_cluster_"+clusterID, &redis.ZRangeBy{Min: "-inf", Max: fmt.Sprint(updatedAt.UnixMicro()), Count: 1}).SetErr(errors.New("connection refused"))
+
+			_, err := snapshotRepo.GetClusterAt(ctx, clusterID, updatedAt)
+			Expect(stream.IsRetryable(err)).To(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(BeNil())
+		})
+	})
+
+	When("getting hosts at a point in time", func() {
+		It("should only return hosts bound to the cluster then", func() {
+			mock.ExpectSMembers("history_hosts_" + clusterID).SetVal([]string{hostID, otherID})
+			mock.ExpectZRevRangeByScore("history_host_"+hostID, &redis.ZRangeBy{Min: "-inf", Max: fmt.Sprint(updatedAt.UnixMicro()), Count: 1}).SetVal([]string{fmt.Sprintf(`{"id":"%s","cluster_id":"%s"}`, hostID, clusterID)})
+			mock.ExpectZRevRangeByScore("history_host_"+otherID, &redis.ZRangeBy{Min: "-inf", Max: fmt.Sprint(updatedAt.UnixMicro()), Count: 1}).SetVal([]string{fmt.Sprintf(`{"id":"%s","cluster_id":"another-cluster"}`, otherID)})
+
+			hosts, err := snapshotRepo.GetHostsAt(ctx, clusterID, updatedAt)
+			Expect(err).To(BeNil())
+			Expect(hosts).To(Equal([]map[string]interface{}{{"id": hostID, "cluster_id": clusterID}}))
+		})
+
+		It("should return the latest hosts when there is no history", func() {
+			mock.ExpectSMembers("history_hosts_" + clusterID).SetVal([]string{})
+			mock.ExpectHGetAll("hosts_" + clusterID).SetVal(map[string]string{hostID: fmt.Sprintf(`{"id":"%s"}`, hostID)})
+
+			hosts, err := snapshotRepo.GetHostsAt(ctx, clusterID, updatedAt)
+			Expect(err).To(BeNil())
+			Expect(hosts).To(Equal([]map[string]interface{}{{"id": hostID}}))
+		})
+
+		It("should fail with a retryable error when the history of a host cannot be read", func() {
+			mock.ExpectSMembers("history_hosts_" + clusterID).SetVal([]string{hostID})
+			mock.ExpectZRevRangeByScore("history_host_"+hostID, &redis.ZRangeBy{Min: "-inf", Max: fmt.Sprint(updatedAt.UnixMicro()), Count: 1}).SetErr(errors.New("connection refused"))
+
+			_, err := snapshotRepo.GetHostsAt(ctx, clusterID, updatedAt)
+			Expect(stream.IsRetryable(err)).To(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Deleting objects", func() {
+	const (
+		clusterID  = "c42cfc1d-411a-4cdb-953b-a8e0f3f82375"
+		hostID     = "8eb96308-9d8b-4293-a4ef-f68dfed549e4"
+		infraEnvID = "5a6b2f1e-3b5e-4a44-9f1e-1c2d3e4f5a6b"
+	)
+	var (
+		ctx          context.Context
+		snapshotRepo *SnapshotRepository
+		mock         redismock.ClientMock
+	)
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.Out = io.Discard
+		ctx = context.Background()
+		var client *redis.Client
+		client, mock = redismock.NewClientMock()
+		snapshotRepo = NewSnapshotRepository(logger, client, defaultDuration, 0)
+	})
+	AfterEach(func() {
+		Expect(mock.ExpectationsWereMet()).To(BeNil())
+	})
+
+	When("deleting a cluster", func() {
+		It("should delete its hosts and infraenvs as well, along with their history", func() {
+			mock.ExpectHKeys("hosts_" + clusterID).SetVal([]string{hostID})
+			mock.ExpectHKeys("infraenvs_" + clusterID).SetVal([]string{infraEnvID})
+			mock.ExpectDel(
+				"hosts_"+clusterID,
+				"infraenvs_"+clusterID,
+				"history_cluster_"+clusterID,
+				"history_hosts_"+clusterID,
+				"history_infraenvs_"+clusterID,
+				"host_"+hostID,
+				"history_host_"+hostID,
+				"infraenv_"+infraEnvID,
+				"history_infraenv_"+infraEnvID,
+			).SetVal(4)
+			mock.ExpectHDel("clusters", clusterID).SetVal(1)
+
+			Expect(snapshotRepo.DeleteCluster(ctx, clusterID)).To(BeNil())
+		})
+
+		It("should return a retryable error when redis fails", func() {
+			mock.ExpectHKeys("hosts_" + clusterID).SetErr(errors.New("connection refused"))
+
+			Expect(stream.IsRetryable(snapshotRepo.DeleteCluster(ctx, clusterID))).To(BeTrue())
+		})
+	})
+
+	When("deleting a host", func() {
+		It("should delete it from its cluster", func() {
+			mock.ExpectGet("host_" + hostID).SetVal(fmt.Sprintf(`{"id":"%s","cluster_id":"%s"}`, hostID, clusterID))
+			mock.ExpectHDel("hosts_"+clusterID, hostID).SetVal(1)
+			mock.ExpectDel("host_" + hostID).SetVal(1)
+
+			Expect(snapshotRepo.DeleteHost(ctx, "", hostID)).To(BeNil())
+		})
+
+		It("should delete it from its infraenv when unbound", func() {
+			mock.ExpectGet("host_" + hostID).SetVal(fmt.Sprintf(`{"id":"%s","infra_env_id":"%s"}`, hostID, infraEnvID))
+			mock.ExpectHDel("unbound_hosts_"+infraEnvID, hostID).SetVal(1)
+			mock.ExpectDel("host_" + hostID).SetVal(1)
+
+			Expect(snapshotRepo.DeleteHost(ctx, "", hostID)).To(BeNil())
+		})
+
+		It("should delete it from the given cluster when not indexed by id", func() {
+			mock.ExpectGet("host_" + hostID).RedisNil()
+			mock.ExpectHDel("hosts_"+clusterID, hostID).SetVal(1)
+			mock.ExpectDel("host_" + hostID).SetVal(0)
+
+			Expect(snapshotRepo.DeleteHost(ctx, clusterID, hostID)).To(BeNil())
+		})
+	})
+
+	When("deleting an infraenv", func() {
+		It("should delete it from its cluster along with its unbound hosts, and their history", func() {
+			mock.ExpectGet("infraenv_" + infraEnvID).SetVal(fmt.Sprintf(`{"id":"%s","cluster_id":"%s"}`, infraEnvID, clusterID))
+			mock.ExpectHKeys("unbound_hosts_" + infraEnvID).SetVal([]string{hostID})
+			mock.ExpectDel("infraenv_"+infraEnvID, "unbound_hosts_"+infraEnvID, "history_infraenv_"+infraEnvID, "host_"+hostID, "history_host_"+hostID).SetVal(5)
+			mock.ExpectHDel("infraenvs_"+clusterID, infraEnvID).SetVal(1)
+			mock.ExpectSRem("history_infraenvs_"+clusterID, infraEnvID).SetVal(1)
+
+			Expect(snapshotRepo.DeleteInfraEnv(ctx, infraEnvID)).To(BeNil())
+		})
+	})
 })
 
 func TestRepositories(t *testing.T) {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -12,6 +13,8 @@ import (
 
 const defaultExpirationStr = "720h" // 30 days
 
+// Client of a standalone server, snapshots are written by scripts touching
+// keys of several resources which a cluster would reject
 func NewRedisClientFromEnv(ctx context.Context, logger *logrus.Logger) *redis.Client {
 	addr := os.Getenv("VALKEY_ADDRESS")
 	client := redis.NewClient(&redis.Options{
@@ -27,9 +30,7 @@ func NewRedisClientFromEnv(ctx context.Context, logger *logrus.Logger) *redis.Cl
 	return client
 }
 
-func NewSnapshotRepositoryFromEnv(ctx context.Context, logger *logrus.Logger) (*SnapshotRepository, error) {
-	redis := NewRedisClientFromEnv(ctx, logger)
-
+func getExpirationFromEnv() (time.Duration, error) {
 	expirationStr := os.Getenv("VALKEY_EXPIRATION")
 	if expirationStr == "" {
 		expirationStr = defaultExpirationStr
@@ -37,8 +38,36 @@ func NewSnapshotRepositoryFromEnv(ctx context.Context, logger *logrus.Logger) (*
 
 	expiration, err := time.ParseDuration(expirationStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse expiration duration: %w", err)
+		return 0, fmt.Errorf("failed to parse expiration duration: %w", err)
+	}
+	return expiration, nil
+}
+
+func NewSnapshotRepositoryFromEnv(ctx context.Context, logger *logrus.Logger) (*SnapshotRepository, error) {
+	redis := NewRedisClientFromEnv(ctx, logger)
+
+	expiration, err := getExpirationFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	historySize := 0
+	if historySizeStr := os.Getenv("VALKEY_HISTORY_SIZE"); historySizeStr != "" {
+		historySize, err = strconv.Atoi(historySizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse history size: %w", err)
+		}
 	}
 
-	return NewSnapshotRepository(logger, redis, expiration), nil
+	return NewSnapshotRepository(logger, redis, expiration, historySize), nil
+}
+
+func NewLifecycleRepositoryFromEnv(ctx context.Context, logger *logrus.Logger) (*LifecycleRepository, error) {
+	redis := NewRedisClientFromEnv(ctx, logger)
+
+	expiration, err := getExpirationFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewLifecycleRepository(logger, redis, expiration), nil
 }
@@ -32,6 +32,24 @@ type EnrichedEvent struct {
 	InfraEnvs    []map[string]interface{} `json:"infra_envs,omitempty"`
 	Versions     map[string]interface{}   `json:"versions"`
 	ReleaseTag   *string                  `json:"release_tag,omitempty"`
+	// set for host events: the host the event refers to, with its infra-env
+	HostID string                 `json:"host_id,omitempty"`
+	Host   map[string]interface{} `json:"host,omitempty"`
+	// scheme the id is derived with. Documents with later schemes keep the id
+	// of the first one, so that they can be migrated
+	IDVersion int    `json:"id_version,omitempty"`
+	LegacyID  string `json:"legacy_id,omitempty"`
+}
+
+// Latest state of a cluster, one document per cluster
+type ClusterDocument struct {
+	ID string `json:"id"`
+	// latest update time, in microseconds, of the snapshots the document is
+	// built from. Documents are only replaced by ones at least as recent
+	Version      int64                    `json:"-"`
+	HostsSummary *HostsSummary            `json:"host_summary"`
+	Cluster      map[string]interface{}   `json:"cluster"`
+	InfraEnvs    []map[string]interface{} `json:"infra_envs,omitempty"`
 }
 
 type EmbeddedEvent struct {
@@ -0,0 +1,22 @@
+package types
+
+const (
+	FilterActionInclude = "include"
+	FilterActionExclude = "exclude"
+)
+
+// Events matching all the conditions of a rule are included or excluded
+// according to its action
+type FilterRule struct {
+	Name       string            `json:"name"`
+	Action     string            `json:"action"`
+	Conditions []FilterCondition `json:"conditions"`
+}
+
+// Matches the value at a path such as cluster.org_id or event.severity,
+// either against a list of values or a regular expression
+type FilterCondition struct {
+	Field  string   `json:"field"`
+	Equals []string `json:"equals,omitempty"`
+	Regex  string   `json:"regex,omitempty"`
+}
@@ -0,0 +1,45 @@
+package types
+
+import "time"
+
+// Installation attempt of a cluster: from registration, or from the reset
+// ending the previous attempt, until the next reset
+type LifecycleDocument struct {
+	// cluster id and attempt number
+	ID           string    `json:"id"`
+	ClusterID    string    `json:"cluster_id"`
+	Attempt      int       `json:"attempt"`
+	Resets       int       `json:"resets"`
+	Status       string    `json:"status"`
+	RegisteredAt time.Time `json:"registered_at"`
+	// set once the installation starts, and ends with either installed, error
+	// or cancelled as result
+	InstallStartedAt                  *time.Time         `json:"install_started_at,omitempty"`
+	InstallCompletedAt                *time.Time         `json:"install_completed_at,omitempty"`
+	Result                            string             `json:"result,omitempty"`
+	RegistrationToInstallStartSeconds *float64           `json:"registration_to_install_start_seconds,omitempty"`
+	InstallDurationSeconds            *float64           `json:"install_duration_seconds,omitempty"`
+	TimeInStatusSeconds               map[string]float64 `json:"time_in_status_seconds"`
+	// summed over all hosts of the cluster
+	HostTimeInStatusSeconds map[string]float64 `json:"host_time_in_status_seconds"`
+	HostCount               int                `json:"host_count"`
+	UpdatedAt               time.Time          `json:"updated_at"`
+}
+
+// Tracked across state changes of a cluster and its hosts
+type ClusterLifecycle struct {
+	ClusterID   string                   `json:"cluster_id"`
+	Status      string                   `json:"status"`
+	StatusSince time.Time                `json:"status_since"`
+	Resets      int                      `json:"resets"`
+	Hosts       map[string]HostLifecycle `json:"hosts"`
+	// current attempt, possibly not started yet
+	Attempt LifecycleDocument `json:"attempt"`
+	// last ended attempt, emitted again when the change ending it is retried
+	PreviousAttempt *LifecycleDocument `json:"previous_attempt,omitempty"`
+}
+
+type HostLifecycle struct {
+	Status      string    `json:"status"`
+	StatusSince time.Time `json:"status_since"`
+}
@@ -7,6 +7,8 @@ const (
 
 type ProjectionConfig struct {
 	Mode ProjectionMode
+	// nil when not set, so that rules are not dropped on a missing document
+	FilterRules *[]FilterRule `json:"filter_rules,omitempty"`
 }
 
 type ProjectionMode string
@@ -0,0 +1,504 @@
+package stream
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// Avro binary encoding of values, driven by schemas resolved from the registry.
+// Values are read as encoding/json reads them, i.e. struct fields by their
+// json names, without being encoded as json. Unions are plain values, as in
+// standard json rather than avro json
+type AvroCodec struct {
+	registry SchemaRegistry
+	subject  string
+
+	mu      sync.Mutex
+	schemas map[int]*avroSchema
+}
+
+type avroSchema struct {
+	codec *goavro.Codec
+	// parsed schema, and its named types by full name
+	schema interface{}
+	names  map[string]map[string]interface{}
+}
+
+func (s *avroSchema) encode(value interface{}) ([]byte, error) {
+	native, err := s.native(s.schema, "", toGeneric(reflect.ValueOf(value)))
+	if err != nil {
+		return nil, err
+	}
+	return s.codec.BinaryFromNative(nil, native)
+}
+
+func (s *avroSchema) decode(payload []byte) ([]byte, error) {
+	native, remaining, err := s.codec.NativeFromBinary(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(remaining) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes", len(remaining))
+	}
+	return s.codec.TextualFromNative(nil, native)
+}
+
+func NewAvroCodec(registry SchemaRegistry, subject string) *AvroCodec {
+	return &AvroCodec{
+		registry: registry,
+		subject:  subject,
+		schemas:  map[int]*avroSchema{},
+	}
+}
+
+func (c *AvroCodec) Name() string {
+	return CodecAvro
+}
+
+func (c *AvroCodec) ContentType() string {
+	return AvroMimeType
+}
+
+// Encodes value with the latest schema of the subject
+func (c *AvroCodec) Marshal(ctx context.Context, value interface{}) ([]byte, error) {
+	schema, err := c.registry.GetLatestSchema(ctx, c.subject)
+	if err != nil {
+		return nil, err
+	}
+	avro, err := c.parse(schema)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := avro.encode(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode value with schema %d: %w", schema.ID, err)
+	}
+	return frame(schema.ID, payload), nil
+}
+
+// Decodes data with the schema it was written with
+func (c *AvroCodec) Unmarshal(ctx context.Context, data []byte, value interface{}) error {
+	schemaID, payload, err := unframe(data)
+	if err != nil {
+		return err
+	}
+	schema, err := c.registry.GetSchemaByID(ctx, schemaID)
+	if err != nil {
+		return err
+	}
+	avro, err := c.parse(schema)
+	if err != nil {
+		return err
+	}
+	textual, err := avro.decode(payload)
+	if err != nil {
+		return fmt.Errorf("failed to decode value with schema %d: %w", schemaID, err)
+	}
+	return json.Unmarshal(textual, value)
+}
+
+func (c *AvroCodec) parse(schema *Schema) (*avroSchema, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if avro, ok := c.schemas[schema.ID]; ok {
+		return avro, nil
+	}
+	if schema.Type() != SchemaTypeAvro {
+		return nil, fmt.Errorf("schema %d is %s, not %s", schema.ID, schema.Type(), SchemaTypeAvro)
+	}
+	codec, err := goavro.NewCodecForStandardJSONFull(schema.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %d: %w", schema.ID, err)
+	}
+	avro := &avroSchema{
+		codec: codec,
+		names: map[string]map[string]interface{}{},
+	}
+	if err := json.Unmarshal([]byte(schema.Schema), &avro.schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %d: %w", schema.ID, err)
+	}
+	avro.addNames(avro.schema, "")
+	c.schemas[schema.ID] = avro
+	return avro, nil
+}
+
+// Full name of a named type, given the namespace it's defined in
+func avroFullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// Namespace of the named type, types defined within it default to it
+func avroNamespace(definition map[string]interface{}, namespace string) string {
+	name, _ := definition["name"].(string)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i]
+	}
+	if ns, ok := definition["namespace"].(string); ok {
+		return ns
+	}
+	return namespace
+}
+
+func (s *avroSchema) addNames(schema interface{}, namespace string) {
+	switch schema := schema.(type) {
+	case []interface{}:
+		for _, member := range schema {
+			s.addNames(member, namespace)
+		}
+	case map[string]interface{}:
+		if name, ok := schema["name"].(string); ok {
+			namespace = avroNamespace(schema, namespace)
+			s.names[avroFullName(name[strings.LastIndex(name, ".")+1:], namespace)] = schema
+		}
+		switch schema["type"] {
+		case "record", "error":
+			fields, _ := schema["fields"].([]interface{})
+			for _, field := range fields {
+				if field, ok := field.(map[string]interface{}); ok {
+					s.addNames(field["type"], namespace)
+				}
+			}
+		case "array":
+			s.addNames(schema["items"], namespace)
+		case "map":
+			s.addNames(schema["values"], namespace)
+		default:
+			s.addNames(schema["type"], namespace)
+		}
+	}
+}
+
+// Definition of a type referenced by name, and the namespace it's defined in
+func (s *avroSchema) resolve(schema interface{}, namespace string) (interface{}, string) {
+	name, ok := schema.(string)
+	if !ok {
+		return schema, namespace
+	}
+	definition, ok := s.names[avroFullName(name, namespace)]
+	if !ok {
+		definition, ok = s.names[name]
+	}
+	if !ok {
+		return schema, namespace
+	}
+	return definition, avroNamespace(definition, namespace)
+}
+
+// Goavro native form of a generic value. Only unions need to know the member
+// their value is of, other values are checked by goavro when encoding
+func (s *avroSchema) native(schema interface{}, namespace string, value interface{}) (interface{}, error) {
+	schema, namespace = s.resolve(schema, namespace)
+	switch schema := schema.(type) {
+	case []interface{}:
+		return s.nativeUnion(schema, namespace, value)
+	case map[string]interface{}:
+		switch schema["type"] {
+		case "record", "error":
+			record, ok := value.(map[string]interface{})
+			if !ok {
+				return value, nil
+			}
+			namespace = avroNamespace(schema, namespace)
+			fields, _ := schema["fields"].([]interface{})
+			for _, field := range fields {
+				field, _ := field.(map[string]interface{})
+				name, _ := field["name"].(string)
+				fieldValue, ok := record[name]
+				if !ok {
+					continue
+				}
+				native, err := s.native(field["type"], namespace, fieldValue)
+				if err != nil {
+					return nil, fmt.Errorf("field %q: %w", name, err)
+				}
+				record[name] = native
+			}
+			return record, nil
+		case "array":
+			items, ok := value.([]interface{})
+			if !ok {
+				return value, nil
+			}
+			for i, item := range items {
+				native, err := s.native(schema["items"], namespace, item)
+				if err != nil {
+					return nil, err
+				}
+				items[i] = native
+			}
+			return items, nil
+		case "map":
+			values, ok := value.(map[string]interface{})
+			if !ok {
+				return value, nil
+			}
+			for key, item := range values {
+				native, err := s.native(schema["values"], namespace, item)
+				if err != nil {
+					return nil, err
+				}
+				values[key] = native
+			}
+			return values, nil
+		case "enum", "fixed":
+			return value, nil
+		default:
+			// primitive types with attributes, i.e. logical types
+			return s.native(schema["type"], namespace, value)
+		}
+	}
+	return value, nil
+}
+
+// Wraps the value with the name of the first member it matches, as standard
+// json decoding does
+func (s *avroSchema) nativeUnion(members []interface{}, namespace string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	for _, member := range members {
+		definition, memberNamespace := s.resolve(member, namespace)
+		if !s.matches(definition, memberNamespace, value) {
+			continue
+		}
+		native, err := s.native(definition, memberNamespace, value)
+		if err != nil {
+			return nil, err
+		}
+		return goavro.Union(avroTypeName(definition, memberNamespace), native), nil
+	}
+	return nil, fmt.Errorf("no member of union %v matches %T", members, value)
+}
+
+// Name goavro knows a union member by
+func avroTypeName(definition interface{}, namespace string) string {
+	switch definition := definition.(type) {
+	case string:
+		return definition
+	case map[string]interface{}:
+		typeName, _ := definition["type"].(string)
+		switch typeName {
+		case "record", "error", "enum", "fixed":
+			name, _ := definition["name"].(string)
+			return avroFullName(name[strings.LastIndex(name, ".")+1:], avroNamespace(definition, namespace))
+		}
+		if logicalType, ok := definition["logicalType"].(string); ok {
+			return typeName + "." + logicalType
+		}
+		return typeName
+	}
+	return fmt.Sprint(definition)
+}
+
+// Whether the generic value can be of the type
+func (s *avroSchema) matches(schema interface{}, namespace string, value interface{}) bool {
+	typeName, ok := schema.(string)
+	var definition map[string]interface{}
+	if !ok {
+		definition, _ = schema.(map[string]interface{})
+		typeName, _ = definition["type"].(string)
+	}
+	switch typeName {
+	case "null":
+		return value == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "int", "long", "float", "double":
+		switch value.(type) {
+		case int64, float64:
+			return true
+		}
+		return false
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "bytes", "fixed":
+		switch value.(type) {
+		case []byte, string:
+			return true
+		}
+		return false
+	case "enum":
+		symbol, ok := value.(string)
+		if !ok {
+			return false
+		}
+		symbols, _ := definition["symbols"].([]interface{})
+		for _, candidate := range symbols {
+			if candidate == symbol {
+				return true
+			}
+		}
+		return false
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "map":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "record", "error":
+		record, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		// fields without default must be set
+		fields, _ := definition["fields"].([]interface{})
+		for _, field := range fields {
+			field, _ := field.(map[string]interface{})
+			name, _ := field["name"].(string)
+			if _, ok := field["default"]; ok {
+				continue
+			}
+			if _, ok := record[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	// primitive types with attributes, i.e. logical types
+	if definition != nil {
+		return s.matches(definition["type"], namespace, value)
+	}
+	return false
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Generic form of the value, as encoding/json would encode it: nil, bool,
+// int64, float64, string, []byte, []interface{} and map[string]interface{}.
+// Only json marshalers are encoded as json
+func toGeneric(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface || v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.IsNil() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		return fromJSONMarshaler(v.Interface().(json.Marshaler))
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && v.Addr().Type().Implements(jsonMarshalerType) {
+		return fromJSONMarshaler(v.Addr().Interface().(json.Marshaler))
+	}
+	if v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil
+		}
+		return string(text)
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return toGeneric(v.Elem())
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(data), v)
+			return data
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = toGeneric(v.Index(i))
+		}
+		return items
+	case reflect.Map:
+		values := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			values[mapKey(iter.Key())] = toGeneric(iter.Value())
+		}
+		return values
+	case reflect.Struct:
+		values := map[string]interface{}{}
+		addStructFields(values, v)
+		return values
+	}
+	return nil
+}
+
+func fromJSONMarshaler(marshaler json.Marshaler) interface{} {
+	data, err := marshaler.MarshalJSON()
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+	return value
+}
+
+func mapKey(key reflect.Value) string {
+	if key.Kind() == reflect.String {
+		return key.String()
+	}
+	if key.Type().Implements(textMarshalerType) {
+		text, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+		if err == nil {
+			return string(text)
+		}
+	}
+	return fmt.Sprint(key.Interface())
+}
+
+// Exported fields by their json name, fields of embedded structs without
+// json name are promoted
+func addStructFields(values map[string]interface{}, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		fieldValue := v.Field(i)
+		if field.Anonymous && name == "" {
+			embedded := fieldValue
+			if embedded.Kind() == reflect.Ptr {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addStructFields(values, embedded)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.Contains(","+options+",", ",omitempty,") && fieldValue.IsZero() && fieldValue.Kind() != reflect.Struct {
+			continue
+		}
+		values[name] = toGeneric(fieldValue)
+	}
+}
@@ -0,0 +1,214 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const testClusterSchema = `{
+  "type": "record",
+  "name": "ClusterState",
+  "namespace": "io.openshift.assisted",
+  "fields": [
+    {"name": "name", "type": "string"},
+    {"name": "payload", "type": {
+      "type": "record",
+      "name": "Cluster",
+      "fields": [
+        {"name": "id", "type": "string"},
+        {"name": "host_count", "type": "long"},
+        {"name": "cpu_usage", "type": "double"},
+        {"name": "ha", "type": "boolean"},
+        {"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["installing", "installed"]}},
+        {"name": "tags", "type": {"type": "array", "items": "string"}},
+        {"name": "labels", "type": {"type": "map", "values": "string"}},
+        {"name": "parent", "type": ["null", "Cluster"], "default": null},
+        {"name": "org_id", "type": ["null", "string"], "default": null},
+        {"name": "kind", "type": "string", "default": "Cluster"}
+      ]
+    }}
+  ]
+}`
+
+type testCluster struct {
+	ID        string            `json:"id"`
+	HostCount int64             `json:"host_count"`
+	CPUUsage  float64           `json:"cpu_usage"`
+	HA        bool              `json:"ha"`
+	Status    string            `json:"status"`
+	Tags      []string          `json:"tags"`
+	Labels    map[string]string `json:"labels"`
+	Parent    *testCluster      `json:"parent"`
+	OrgID     *string           `json:"org_id,omitempty"`
+	Kind      string            `json:"kind,omitempty"`
+}
+
+type testClusterState struct {
+	Name    string      `json:"name"`
+	Payload testCluster `json:"payload"`
+}
+
+var _ = Describe("Avro codec", func() {
+	var (
+		ctx          context.Context
+		ctrl         *gomock.Controller
+		mockRegistry *MockSchemaRegistry
+		codec        *AvroCodec
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		ctrl = gomock.NewController(GinkgoT())
+		mockRegistry = NewMockSchemaRegistry(ctrl)
+		codec = NewAvroCodec(mockRegistry, "events-value")
+	})
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	When("encoding a simple record", func() {
+		It("should follow the avro binary encoding", func() {
+			schema := &Schema{ID: 7, Schema: `{"type":"record","name":"Foo","fields":[{"name":"id","type":"long"},{"name":"name","type":"string"}]}`}
+			mockRegistry.EXPECT().GetLatestSchema(ctx, "events-value").Return(schema, nil)
+
+			data, err := codec.Marshal(ctx, map[string]interface{}{"id": -2, "name": "foo"})
+			Expect(err).To(BeNil())
+			Expect(data).To(Equal([]byte{0, 0, 0, 0, 7, 0x03, 0x06, 'f', 'o', 'o'}))
+		})
+	})
+
+	When("encoding nested records, unions and collections", func() {
+		It("should decode the same value", func() {
+			schema := &Schema{ID: 42, Schema: testClusterSchema}
+			mockRegistry.EXPECT().GetLatestSchema(ctx, "events-value").Return(schema, nil)
+			mockRegistry.EXPECT().GetSchemaByID(ctx, 42).Return(schema, nil)
+			orgID := "16020201"
+			value := testClusterState{
+				Name: "ClusterState",
+				Payload: testCluster{
+					ID:        "391d46b5-169b-4ffb-bce4-43ebdfe66b5c",
+					HostCount: 1 << 40,
+					CPUUsage:  0.75,
+					HA:        true,
+					Status:    "installed",
+					Tags:      []string{"foo", "bar"},
+					Labels:    map[string]string{"env": "prod", "region": "eu"},
+					Parent: &testCluster{
+						ID:     "parent",
+						Status: "installing",
+						Tags:   []string{},
+						Labels: map[string]string{},
+					},
+					OrgID: &orgID,
+				},
+			}
+
+			data, err := codec.Marshal(ctx, value)
+			Expect(err).To(BeNil())
+			Expect(IsFramed(data)).To(BeTrue())
+
+			decoded := testClusterState{}
+			Expect(codec.Unmarshal(ctx, data, &decoded)).To(Succeed())
+			value.Payload.Kind = "Cluster"
+			value.Payload.Parent.Kind = "Cluster"
+			Expect(decoded).To(Equal(value))
+		})
+	})
+
+	When("encoding structs with embedded fields and json marshalers", func() {
+		It("should read them the way encoding/json does", func() {
+			type base struct {
+				ID string `json:"id"`
+			}
+			type event struct {
+				base
+				CreatedAt time.Time `json:"created_at"`
+				Internal  string    `json:"-"`
+			}
+			schema := &Schema{ID: 9, Schema: `{"type":"record","name":"Event","fields":[{"name":"id","type":"string"},{"name":"created_at","type":["null","string"]}]}`}
+			mockRegistry.EXPECT().GetLatestSchema(ctx, "events-value").Return(schema, nil)
+			mockRegistry.EXPECT().GetSchemaByID(ctx, 9).Return(schema, nil)
+			createdAt := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+
+			data, err := codec.Marshal(ctx, event{base: base{ID: "foo"}, CreatedAt: createdAt, Internal: "bar"})
+			Expect(err).To(BeNil())
+
+			decoded := event{}
+			Expect(codec.Unmarshal(ctx, data, &decoded)).To(Succeed())
+			Expect(decoded).To(Equal(event{base: base{ID: "foo"}, CreatedAt: createdAt}))
+		})
+	})
+
+	When("the value does not match the schema", func() {
+		It("should fail", func() {
+			mockRegistry.EXPECT().GetLatestSchema(ctx, "events-value").Return(&Schema{ID: 42, Schema: testClusterSchema}, nil)
+
+			_, err := codec.Marshal(ctx, map[string]interface{}{"name": "ClusterState", "payload": map[string]interface{}{"id": 1}})
+			Expect(err).To(MatchError(ContainSubstring(`record "io.openshift.assisted.Cluster"`)))
+		})
+	})
+
+	When("the schema is not avro", func() {
+		It("should fail", func() {
+			mockRegistry.EXPECT().GetSchemaByID(ctx, 3).Return(&Schema{ID: 3, SchemaType: SchemaTypeProtobuf, Schema: `syntax = "proto3";`}, nil)
+
+			err := codec.Unmarshal(ctx, frame(3, []byte{0}), &testClusterState{})
+			Expect(err).To(MatchError(ContainSubstring("is PROTOBUF")))
+		})
+	})
+
+	When("the value is not framed", func() {
+		It("should fail", func() {
+			err := codec.Unmarshal(ctx, []byte(`{"name":"ClusterState"}`), &testClusterState{})
+			Expect(err).To(MatchError(ContainSubstring("wire format")))
+		})
+	})
+
+	When("the codec is configured from env", func() {
+		var tmpdir string
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = os.MkdirTemp("", ".registry-")
+			Expect(err).To(BeNil())
+		})
+		AfterEach(func() {
+			os.Unsetenv("KAFKA_VALUE_CODEC")
+			os.Unsetenv("SCHEMA_REGISTRY_FILE")
+			os.RemoveAll(tmpdir)
+		})
+		It("should resolve the subject from the topic", func() {
+			registryFile := filepath.Join(tmpdir, "schemas.json")
+			Expect(os.WriteFile(registryFile, []byte(`[{"id":1,"subject":"events-value","version":1,"schema":"\"string\""}]`), 0600)).To(Succeed())
+			os.Setenv("KAFKA_VALUE_CODEC", "avro")
+			os.Setenv("SCHEMA_REGISTRY_FILE", registryFile)
+
+			envCodec, err := NewCodecFromEnv("events")
+			Expect(err).To(BeNil())
+			Expect(envCodec.Name()).To(Equal(CodecAvro))
+			data, err := envCodec.Marshal(ctx, "foo")
+			Expect(err).To(BeNil())
+			Expect(data).To(Equal([]byte{0, 0, 0, 0, 1, 0x06, 'f', 'o', 'o'}))
+		})
+		It("should require a schema registry", func() {
+			os.Setenv("KAFKA_VALUE_CODEC", "avro")
+			_, err := NewCodecFromEnv("events")
+			Expect(err).To(MatchError(ContainSubstring("SCHEMA_REGISTRY_URL")))
+		})
+		It("should select protobuf", func() {
+			registryFile := filepath.Join(tmpdir, "schemas.json")
+			Expect(os.WriteFile(registryFile, []byte(`[]`), 0600)).To(Succeed())
+			os.Setenv("KAFKA_VALUE_CODEC", "protobuf")
+			os.Setenv("SCHEMA_REGISTRY_FILE", registryFile)
+
+			envCodec, err := NewCodecFromEnv("events")
+			Expect(err).To(BeNil())
+			Expect(envCodec.Name()).To(Equal(CodecProtobuf))
+			Expect(envCodec.ContentType()).To(Equal(ProtobufMimeType))
+		})
+	})
+})
@@ -0,0 +1,96 @@
+package stream
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+type BackoffConfig struct {
+	InitialInterval time.Duration `envconfig:"KAFKA_RETRY_INITIAL_INTERVAL" default:"500ms"`
+	// intervals are not capped when negative
+	MaxInterval time.Duration `envconfig:"KAFKA_RETRY_MAX_INTERVAL" default:"30s"`
+	Multiplier  float64       `envconfig:"KAFKA_RETRY_MULTIPLIER" default:"2"`
+	// each interval is randomized within [interval*(1-factor), interval*(1+factor)],
+	// not randomized when negative
+	RandomizationFactor float64 `envconfig:"KAFKA_RETRY_RANDOMIZATION_FACTOR" default:"0.5"`
+	// no retries are attempted once this time has elapsed since the first attempt
+	MaxElapsedTime time.Duration `envconfig:"KAFKA_RETRY_MAX_ELAPSED_TIME" default:"2m"`
+}
+
+// Jittered exponential backoff
+type ExponentialBackoff struct {
+	config    BackoffConfig
+	interval  time.Duration
+	startTime time.Time
+}
+
+// Zero fields, i.e. of configs not read from env, take their env defaults
+func NewExponentialBackoff(config BackoffConfig) *ExponentialBackoff {
+	if config.InitialInterval <= 0 {
+		config.InitialInterval = 500 * time.Millisecond
+	}
+	if config.MaxInterval == 0 {
+		config.MaxInterval = 30 * time.Second
+	}
+	if config.Multiplier <= 0 {
+		config.Multiplier = 2
+	}
+	if config.RandomizationFactor == 0 {
+		config.RandomizationFactor = 0.5
+	}
+	if config.MaxElapsedTime <= 0 {
+		config.MaxElapsedTime = 2 * time.Minute
+	}
+	return &ExponentialBackoff{
+		config:    config,
+		interval:  config.InitialInterval,
+		startTime: time.Now(),
+	}
+}
+
+// Returns how long to wait before the next attempt, and false when the max
+// elapsed time would be exceeded by waiting
+func (b *ExponentialBackoff) Next() (time.Duration, bool) {
+	wait := randomize(b.interval, b.config.RandomizationFactor)
+	if time.Since(b.startTime)+wait > b.config.MaxElapsedTime {
+		return 0, false
+	}
+	next := time.Duration(float64(b.interval) * b.config.Multiplier)
+	if b.config.MaxInterval > 0 && next > b.config.MaxInterval {
+		next = b.config.MaxInterval
+	}
+	b.interval = next
+	return wait, true
+}
+
+// Calls fn until it succeeds, retrying with exponential backoff until the max
+// elapsed time is exceeded or ctx is done. Returns the last error
+func RetryWithBackoff(ctx context.Context, config BackoffConfig, fn func() error) error {
+	backoff := NewExponentialBackoff(config)
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		wait, ok := backoff.Next()
+		if !ok {
+			return err
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func randomize(interval time.Duration, factor float64) time.Duration {
+	if factor <= 0 {
+		return interval
+	}
+	delta := factor * float64(interval)
+	min := float64(interval) - delta
+	max := float64(interval) + delta
+	return time.Duration(min + rand.Float64()*(max-min))
+}
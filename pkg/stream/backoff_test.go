@@ -0,0 +1,124 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Exponential backoff", func() {
+	It("should grow intervals up to the max interval", func() {
+		backoff := NewExponentialBackoff(BackoffConfig{
+			InitialInterval:     time.Second,
+			MaxInterval:         3 * time.Second,
+			Multiplier:          2,
+			RandomizationFactor: -1,
+			MaxElapsedTime:      time.Hour,
+		})
+		waits := []time.Duration{}
+		for i := 0; i < 4; i++ {
+			wait, ok := backoff.Next()
+			Expect(ok).To(BeTrue())
+			waits = append(waits, wait)
+		}
+		Expect(waits).To(Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}))
+	})
+
+	It("should randomize intervals", func() {
+		backoff := NewExponentialBackoff(BackoffConfig{
+			InitialInterval:     time.Second,
+			Multiplier:          1,
+			RandomizationFactor: 0.5,
+			MaxElapsedTime:      time.Hour,
+		})
+		for i := 0; i < 20; i++ {
+			wait, ok := backoff.Next()
+			Expect(ok).To(BeTrue())
+			Expect(wait).To(BeNumerically(">=", 500*time.Millisecond))
+			Expect(wait).To(BeNumerically("<=", 1500*time.Millisecond))
+		}
+	})
+
+	It("should take the env defaults for unset fields", func() {
+		backoff := NewExponentialBackoff(BackoffConfig{})
+		wait, ok := backoff.Next()
+		Expect(ok).To(BeTrue())
+		Expect(wait).To(BeNumerically(">=", 250*time.Millisecond))
+		Expect(wait).To(BeNumerically("<=", 750*time.Millisecond))
+		Expect(backoff.config).To(Equal(BackoffConfig{
+			InitialInterval:     500 * time.Millisecond,
+			MaxInterval:         30 * time.Second,
+			Multiplier:          2,
+			RandomizationFactor: 0.5,
+			MaxElapsedTime:      2 * time.Minute,
+		}))
+	})
+
+	It("should stop once max elapsed time would be exceeded", func() {
+		backoff := NewExponentialBackoff(BackoffConfig{
+			InitialInterval: time.Minute,
+			Multiplier:      2,
+			MaxElapsedTime:  30 * time.Second,
+		})
+		_, ok := backoff.Next()
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Retrying with backoff", func() {
+	config := BackoffConfig{
+		InitialInterval: time.Millisecond,
+		Multiplier:      1,
+		MaxElapsedTime:  50 * time.Millisecond,
+	}
+
+	It("should retry until it succeeds", func() {
+		attempts := 0
+		err := RetryWithBackoff(context.Background(), config, func() error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("kafka unavailable")
+			}
+			return nil
+		})
+		Expect(err).To(BeNil())
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("should return the last error once max elapsed time is exceeded", func() {
+		err := RetryWithBackoff(context.Background(), config, func() error {
+			return errors.New("kafka unavailable")
+		})
+		Expect(err).To(MatchError("kafka unavailable"))
+	})
+
+	It("should stop when the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts := 0
+		err := RetryWithBackoff(ctx, BackoffConfig{InitialInterval: time.Hour, MaxElapsedTime: 2 * time.Hour}, func() error {
+			attempts++
+			cancel()
+			return errors.New("kafka unavailable")
+		})
+		Expect(err).To(MatchError("kafka unavailable"))
+		Expect(attempts).To(Equal(1))
+	})
+})
+
+var _ = Describe("Retryable errors", func() {
+	It("should be recognized when wrapped", func() {
+		err := fmt.Errorf("failed to store event: %w", NewRetryableError(errors.New("timeout")))
+		Expect(IsRetryable(err)).To(BeTrue())
+		Expect(err.Error()).To(Equal("failed to store event: timeout"))
+	})
+
+	It("should not consider other errors retryable", func() {
+		Expect(IsRetryable(errors.New("unknown event name"))).To(BeFalse())
+		Expect(IsRetryable(nil)).To(BeFalse())
+		Expect(NewRetryableError(nil)).To(BeNil())
+	})
+})
@@ -0,0 +1,162 @@
+package stream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+const (
+	// set on messages whose value is a claim check instead of the payload
+	HeaderClaimCheck = "x-claim-check"
+
+	digestAlgorithm = "sha256"
+)
+
+var blobRefRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+type ClaimCheckConfig struct {
+	// directory shared by producers and consumers where oversized payloads
+	// are stored, claim check is disabled when empty
+	ClaimCheckDir string `envconfig:"KAFKA_CLAIM_CHECK_DIR" default:""`
+	// payloads larger than this are offloaded to the blob store
+	ClaimCheckThresholdBytes int `envconfig:"KAFKA_CLAIM_CHECK_THRESHOLD_BYTES" default:"900000"`
+}
+
+//go:generate mockgen -source=claim_check.go -package=stream -destination=mock_claim_check.go
+
+type BlobStore interface {
+	// Stores data, returning a reference to get it back
+	Put(ctx context.Context, data []byte) (string, error)
+	Get(ctx context.Context, ref string) ([]byte, error)
+}
+
+// Message value replacing an offloaded payload
+type ClaimCheck struct {
+	Ref    string `json:"ref"`
+	Digest string `json:"digest"`
+	Size   int    `json:"size"`
+}
+
+// Returns the blob store configured in env, nil when claim check is disabled
+func newBlobStore(config *KafkaConfig) (BlobStore, error) {
+	if config.ClaimCheckDir == "" {
+		return nil, nil
+	}
+	return NewFileBlobStore(config.ClaimCheckDir)
+}
+
+// Content addressed blob store on a filesystem, blobs are named after the
+// sha256 digest of their content
+type FileBlobStore struct {
+	dir string
+}
+
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create blob store directory %s: %w", dir, err)
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+func (s *FileBlobStore) Put(ctx context.Context, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	ref := hex.EncodeToString(sum[:])
+	filename := filepath.Join(s.dir, ref)
+	if _, err := os.Stat(filename); err == nil {
+		return ref, nil
+	}
+	tmp, err := os.CreateTemp(s.dir, ".blob-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+func (s *FileBlobStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	if !blobRefRegexp.MatchString(ref) {
+		return nil, fmt.Errorf("invalid blob reference %s", ref)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, ref))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("blob %s not found", ref)
+	}
+	if err != nil {
+		return nil, NewRetryableError(err)
+	}
+	return data, nil
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return digestAlgorithm + ":" + hex.EncodeToString(sum[:])
+}
+
+// Stores value in the blob store, returning the claim check to send instead
+func checkIn(ctx context.Context, store BlobStore, value []byte) ([]byte, error) {
+	ref, err := store.Put(ctx, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store payload in blob store: %w", err)
+	}
+	return json.Marshal(&ClaimCheck{
+		Ref:    ref,
+		Digest: digest(value),
+		Size:   len(value),
+	})
+}
+
+func IsClaimCheck(msg *kafka.Message) bool {
+	_, ok := GetHeader(msg, HeaderClaimCheck)
+	return ok
+}
+
+// Returns a copy of the message with the offloaded payload as value and
+// without the claim check header
+func checkOut(ctx context.Context, store BlobStore, msg *kafka.Message) (*kafka.Message, error) {
+	if store == nil {
+		return nil, fmt.Errorf("message is a claim check but no blob store is configured")
+	}
+	claimCheck := &ClaimCheck{}
+	if err := json.Unmarshal(msg.Value, claimCheck); err != nil {
+		return nil, fmt.Errorf("failed to decode claim check: %w", err)
+	}
+	value, err := store.Get(ctx, claimCheck.Ref)
+	if err != nil {
+		return nil, err
+	}
+	if digest(value) != claimCheck.Digest {
+		return nil, fmt.Errorf("digest of blob %s does not match %s", claimCheck.Ref, claimCheck.Digest)
+	}
+	rehydrated := *msg
+	rehydrated.Value = value
+	rehydrated.Headers = make([]kafka.Header, 0, len(msg.Headers))
+	for _, header := range msg.Headers {
+		if header.Key != HeaderClaimCheck {
+			rehydrated.Headers = append(rehydrated.Headers, header)
+		}
+	}
+	return &rehydrated, nil
+}
@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Claim check", func() {
+	var (
+		ctx       context.Context
+		ctrl      *gomock.Controller
+		logger    *logrus.Logger
+		tmpdir    string
+		blobStore *FileBlobStore
+	)
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		ctrl = gomock.NewController(GinkgoT())
+		logger = logrus.New()
+		logger.Out = io.Discard
+		tmpdir, err = os.MkdirTemp("", ".blobs-")
+		Expect(err).To(BeNil())
+		blobStore, err = NewFileBlobStore(filepath.Join(tmpdir, "blobs"))
+		Expect(err).To(BeNil())
+	})
+	AfterEach(func() {
+		ctrl.Finish()
+		os.RemoveAll(tmpdir)
+	})
+
+	When("storing blobs on the filesystem", func() {
+		It("should address them by content", func() {
+			ref, err := blobStore.Put(ctx, []byte("foobar"))
+			Expect(err).To(BeNil())
+			sameRef, err := blobStore.Put(ctx, []byte("foobar"))
+			Expect(err).To(BeNil())
+			Expect(sameRef).To(Equal(ref))
+
+			data, err := blobStore.Get(ctx, ref)
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("foobar"))
+		})
+		It("should reject references outside the store", func() {
+			_, err := blobStore.Get(ctx, "../../etc/passwd")
+			Expect(err).To(MatchError(ContainSubstring("invalid blob reference")))
+		})
+	})
+
+	When("writing values", func() {
+		var (
+			mockProducer *MockProducer
+			writer       *KafkaWriter
+			written      kafka.Message
+		)
+		BeforeEach(func() {
+			mockProducer = NewMockProducer(ctrl)
+			writer = &KafkaWriter{
+				producer:            mockProducer,
+				logger:              logger,
+				blobStore:           blobStore,
+				claimCheckThreshold: 100,
+			}
+			mockProducer.EXPECT().WriteMessages(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, msgs ...kafka.Message) error {
+					written = msgs[0]
+					return nil
+				}).Times(1)
+		})
+		It("should send small values as they are", func() {
+			Expect(writer.Write(ctx, []byte("foo"), map[string]string{"id": "foo"})).To(Succeed())
+			Expect(IsClaimCheck(&written)).To(BeFalse())
+			Expect(written.Value).To(MatchJSON(`{"id":"foo"}`))
+		})
+		It("should offload large values and rehydrate them when reading", func() {
+			value := map[string]string{"id": "foo", "hosts": strings.Repeat("x", 200)}
+			Expect(writer.Write(ctx, []byte("foo"), value)).To(Succeed())
+			Expect(IsClaimCheck(&written)).To(BeTrue())
+			claimCheck := &ClaimCheck{}
+			Expect(json.Unmarshal(written.Value, claimCheck)).To(Succeed())
+			Expect(claimCheck.Digest).To(HavePrefix("sha256:"))
+			Expect(claimCheck.Size).To(BeNumerically(">", 200))
+
+			reader := &KafkaReader{blobStore: blobStore}
+			var processed *kafka.Message
+			err := reader.withClaimCheck(func(ctx context.Context, msg *kafka.Message) error {
+				processed = msg
+				return nil
+			})(ctx, &written)
+			Expect(err).To(BeNil())
+			Expect(processed.Value).To(MatchJSON(`{"id":"foo","hosts":"` + strings.Repeat("x", 200) + `"}`))
+			Expect(IsClaimCheck(processed)).To(BeFalse())
+			// original message is left untouched
+			Expect(IsClaimCheck(&written)).To(BeTrue())
+		})
+	})
+
+	When("reading a claim check", func() {
+		var reader *KafkaReader
+		BeforeEach(func() {
+			reader = &KafkaReader{blobStore: blobStore}
+		})
+		newClaimCheck := func(claimCheck ClaimCheck) *kafka.Message {
+			value, err := json.Marshal(claimCheck)
+			Expect(err).To(BeNil())
+			return &kafka.Message{
+				Value:   value,
+				Headers: []kafka.Header{{Key: HeaderClaimCheck, Value: []byte(digestAlgorithm)}},
+			}
+		}
+		notCalled := func(ctx context.Context, msg *kafka.Message) error {
+			return errors.New("should not be called")
+		}
+		It("should fail when the digest does not match", func() {
+			ref, err := blobStore.Put(ctx, []byte("foobar"))
+			Expect(err).To(BeNil())
+			msg := newClaimCheck(ClaimCheck{Ref: ref, Digest: digest([]byte("tampered")), Size: 6})
+
+			err = reader.withClaimCheck(notCalled)(ctx, msg)
+			Expect(err).To(MatchError(ContainSubstring("does not match")))
+			Expect(IsRetryable(err)).To(BeFalse())
+		})
+		It("should fail when the blob does not exist", func() {
+			msg := newClaimCheck(ClaimCheck{Ref: strings.Repeat("a", 64), Digest: digest([]byte("foobar")), Size: 6})
+
+			err := reader.withClaimCheck(notCalled)(ctx, msg)
+			Expect(err).To(MatchError(ContainSubstring("not found")))
+		})
+		It("should fail when no blob store is configured", func() {
+			reader.blobStore = nil
+			msg := newClaimCheck(ClaimCheck{Ref: strings.Repeat("a", 64)})
+
+			err := reader.withClaimCheck(notCalled)(ctx, msg)
+			Expect(err).To(MatchError(ContainSubstring("no blob store")))
+		})
+	})
+})
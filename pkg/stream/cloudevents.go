@@ -0,0 +1,199 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/google/uuid"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+const (
+	CloudEventsSpecVersion = "1.0"
+
+	// CloudEvents Kafka protocol binding, see
+	// https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/bindings/kafka-protocol-binding.md
+	HeaderContentType             = "content-type"
+	CloudEventsHeaderPrefix       = "ce_"
+	CloudEventsStructuredMimeType = "application/cloudevents+json"
+	JSONMimeType                  = "application/json"
+
+	// extension attribute carrying the notification stream metadata, json encoded
+	CloudEventsMetadataExtension = "metadata"
+)
+
+type CloudEventsMode int
+
+const (
+	// whole event encoded in the message value
+	CloudEventsStructured CloudEventsMode = iota
+	// attributes encoded as headers, message value is the event data
+	CloudEventsBinary
+)
+
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Metadata        string          `json:"metadata,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// Subject identifying the notified resource, i.e. clusters/<id>/hosts/<id>
+func cloudEventSubject(notifiable Notifiable) string {
+	segments := []string{}
+	ids := []struct {
+		collection string
+		id         *strfmt.UUID
+	}{
+		{"clusters", notifiable.GetClusterID()},
+		{"infra-envs", notifiable.GetInfraEnvID()},
+		{"hosts", notifiable.GetHostID()},
+	}
+	for _, resource := range ids {
+		if resource.id != nil {
+			segments = append(segments, resource.collection, resource.id.String())
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Event without data, its content type is the one data will be encoded with
+func newCloudEvent(source string, notifiable Notifiable, metadata interface{}, now time.Time, dataContentType string) (*CloudEvent, error) {
+	event := &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              uuid.NewString(),
+		Source:          source,
+		Type:            notifiable.NotificationType(),
+		Subject:         cloudEventSubject(notifiable),
+		Time:            &now,
+		DataContentType: dataContentType,
+	}
+	if metadata != nil {
+		encodedMetadata, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, err
+		}
+		event.Metadata = string(encodedMetadata)
+	}
+	return event, nil
+}
+
+// Attributes as binary mode headers, data is not included
+func (e *CloudEvent) headers() []kafka.Header {
+	attributes := []struct {
+		name  string
+		value string
+	}{
+		{"specversion", e.SpecVersion},
+		{"id", e.ID},
+		{"source", e.Source},
+		{"type", e.Type},
+		{"subject", e.Subject},
+		{CloudEventsMetadataExtension, e.Metadata},
+	}
+	headers := []kafka.Header{
+		{Key: HeaderContentType, Value: []byte(e.DataContentType)},
+	}
+	for _, attribute := range attributes {
+		if attribute.value != "" {
+			headers = append(headers, kafka.Header{Key: CloudEventsHeaderPrefix + attribute.name, Value: []byte(attribute.value)})
+		}
+	}
+	if e.Time != nil {
+		headers = append(headers, kafka.Header{Key: CloudEventsHeaderPrefix + "time", Value: []byte(e.Time.Format(time.RFC3339Nano))})
+	}
+	return headers
+}
+
+// Decodes metadata extension attribute, nil when missing
+func (e *CloudEvent) DecodeMetadata() (map[string]interface{}, error) {
+	if e.Metadata == "" {
+		return nil, nil
+	}
+	metadata := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(e.Metadata), &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode cloud event metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// Parses a message encoded as CloudEvent in either structured or binary mode.
+// Returns false when the message is not a CloudEvent.
+func ParseCloudEvent(msg *kafka.Message) (*CloudEvent, bool, error) {
+	if specVersion, ok := GetHeader(msg, CloudEventsHeaderPrefix+"specversion"); ok {
+		event, err := parseBinaryCloudEvent(msg, specVersion)
+		return event, true, err
+	}
+	contentType, _ := GetHeader(msg, HeaderContentType)
+	if !strings.HasPrefix(contentType, CloudEventsStructuredMimeType) && !looksLikeStructuredCloudEvent(msg.Value) {
+		return nil, false, nil
+	}
+	event := &CloudEvent{}
+	if err := json.Unmarshal(msg.Value, event); err != nil {
+		return nil, true, fmt.Errorf("failed to decode structured cloud event: %w", err)
+	}
+	if err := event.validate(); err != nil {
+		return nil, true, err
+	}
+	return event, true, nil
+}
+
+// structured mode messages without content-type header are detected by the
+// presence of the specversion attribute
+func looksLikeStructuredCloudEvent(value []byte) bool {
+	if !bytes.Contains(value, []byte(`"specversion"`)) {
+		return false
+	}
+	attributes := struct {
+		SpecVersion *string `json:"specversion"`
+	}{}
+	return json.Unmarshal(value, &attributes) == nil && attributes.SpecVersion != nil
+}
+
+func parseBinaryCloudEvent(msg *kafka.Message, specVersion string) (*CloudEvent, error) {
+	attribute := func(name string) string {
+		value, _ := GetHeader(msg, CloudEventsHeaderPrefix+name)
+		return value
+	}
+	contentType, _ := GetHeader(msg, HeaderContentType)
+	event := &CloudEvent{
+		SpecVersion:     specVersion,
+		ID:              attribute("id"),
+		Source:          attribute("source"),
+		Type:            attribute("type"),
+		Subject:         attribute("subject"),
+		DataContentType: contentType,
+		Metadata:        attribute(CloudEventsMetadataExtension),
+		Data:            msg.Value,
+	}
+	if eventTime := attribute("time"); eventTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, eventTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cloud event time %s: %w", eventTime, err)
+		}
+		event.Time = &t
+	}
+	if err := event.validate(); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (e *CloudEvent) validate() error {
+	if e.SpecVersion != CloudEventsSpecVersion {
+		return fmt.Errorf("unsupported cloud event specversion %s", e.SpecVersion)
+	}
+	if e.ID == "" || e.Source == "" || e.Type == "" {
+		return fmt.Errorf("cloud event is missing required attributes (id: %s, source: %s, type: %s)", e.ID, e.Source, e.Type)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("CloudEvents encoding", func() {
+	var (
+		ctx            context.Context
+		ctrl           *gomock.Controller
+		logger         *logrus.Logger
+		mockWriter     *MockEventStreamWriter
+		mockNotifiable *MockNotifiable
+		clusterID      strfmt.UUID
+		hostID         strfmt.UUID
+		now            time.Time
+		metadata       map[string]interface{}
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		ctrl = gomock.NewController(GinkgoT())
+		logger = logrus.New()
+		logger.Out = io.Discard
+		clusterID = strfmt.UUID("f8c8a5b7-8c2c-4d6c-9d5b-0b8c8c0e2c11")
+		hostID = strfmt.UUID("9d3bce0c-4c4a-4e2b-8a6b-2f0e3e5d6c7b")
+		now = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+		metadata = map[string]interface{}{"versions": map[string]interface{}{"SelfVersion": "v1"}}
+		mockWriter = NewMockEventStreamWriter(ctrl)
+		mockNotifiable = NewMockNotifiable(ctrl)
+		mockNotifiable.EXPECT().GetClusterID().Return(&clusterID).AnyTimes()
+		mockNotifiable.EXPECT().GetInfraEnvID().Return(nil).AnyTimes()
+		mockNotifiable.EXPECT().GetHostID().Return(&hostID).AnyTimes()
+		mockNotifiable.EXPECT().NotificationType().Return("HostState").AnyTimes()
+		mockNotifiable.EXPECT().Payload().Return(map[string]string{"id": hostID.String()}).AnyTimes()
+		mockWriter.EXPECT().ContentType().Return(JSONMimeType).AnyTimes()
+	})
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	// captures the message the notification stream would produce
+	notify := func(mode CloudEventsMode) *kafka.Message {
+		msg := &kafka.Message{}
+		mockWriter.EXPECT().Write(ctx, []byte(clusterID.String()), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, key []byte, value interface{}, headers ...kafka.Header) error {
+				encodedValue, err := json.Marshal(value)
+				Expect(err).To(BeNil())
+				msg.Key = key
+				msg.Value = encodedValue
+				msg.Headers = headers
+				return nil
+			}).Times(1)
+		notificationStream := NewNotificationStream(mockWriter, logger, metadata, WithCloudEvents("/assisted-service", mode))
+		notificationStream.now = func() time.Time { return now }
+		Expect(notificationStream.Notify(ctx, mockNotifiable)).To(Succeed())
+		return msg
+	}
+
+	expectEvent := func(event *CloudEvent) {
+		Expect(event.SpecVersion).To(Equal("1.0"))
+		Expect(event.ID).NotTo(BeEmpty())
+		Expect(event.Source).To(Equal("/assisted-service"))
+		Expect(event.Type).To(Equal("HostState"))
+		Expect(event.Subject).To(Equal("clusters/" + clusterID.String() + "/hosts/" + hostID.String()))
+		Expect(event.Time.Equal(now)).To(BeTrue())
+		Expect(event.DataContentType).To(Equal(JSONMimeType))
+		Expect(event.Data).To(MatchJSON(`{"id":"` + hostID.String() + `"}`))
+		decodedMetadata, err := event.DecodeMetadata()
+		Expect(err).To(BeNil())
+		Expect(decodedMetadata).To(Equal(metadata))
+	}
+
+	When("using structured mode", func() {
+		It("should encode the whole event in the value", func() {
+			msg := notify(CloudEventsStructured)
+			contentType, _ := GetHeader(msg, HeaderContentType)
+			Expect(contentType).To(Equal(CloudEventsStructuredMimeType))
+			Expect(msg.Value).To(ContainSubstring(`"specversion":"1.0"`))
+
+			event, ok, err := ParseCloudEvent(msg)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+			expectEvent(event)
+		})
+		It("should be detected without content-type header", func() {
+			msg := notify(CloudEventsStructured)
+			msg.Headers = nil
+
+			event, ok, err := ParseCloudEvent(msg)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+			expectEvent(event)
+		})
+	})
+
+	When("using binary mode", func() {
+		It("should encode attributes as headers and data as value", func() {
+			msg := notify(CloudEventsBinary)
+			Expect(msg.Value).To(MatchJSON(`{"id":"` + hostID.String() + `"}`))
+			eventType, _ := GetHeader(msg, "ce_type")
+			Expect(eventType).To(Equal("HostState"))
+			notificationType, _ := GetHeader(msg, HeaderNotificationType)
+			Expect(notificationType).To(Equal("HostState"))
+
+			event, ok, err := ParseCloudEvent(msg)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+			expectEvent(event)
+		})
+		It("should set the content type and value from the writer codec", func() {
+			ctrl = gomock.NewController(GinkgoT())
+			mockWriter = NewMockEventStreamWriter(ctrl)
+			mockWriter.EXPECT().ContentType().Return(AvroMimeType).Times(1)
+			mockWriter.EXPECT().Write(ctx, []byte(clusterID.String()), map[string]string{"id": hostID.String()}, gomock.Any()).DoAndReturn(
+				func(ctx context.Context, key []byte, value interface{}, headers ...kafka.Header) error {
+					contentType, _ := GetHeader(&kafka.Message{Headers: headers}, HeaderContentType)
+					Expect(contentType).To(Equal(AvroMimeType))
+					return nil
+				}).Times(1)
+			notificationStream := NewNotificationStream(mockWriter, logger, metadata, WithCloudEvents("/assisted-service", CloudEventsBinary))
+			Expect(notificationStream.Notify(ctx, mockNotifiable)).To(Succeed())
+		})
+		It("should fail when required attributes are missing", func() {
+			msg := &kafka.Message{Headers: []kafka.Header{
+				{Key: "ce_specversion", Value: []byte("1.0")},
+				{Key: "ce_type", Value: []byte("HostState")},
+			}}
+			_, ok, err := ParseCloudEvent(msg)
+			Expect(ok).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("missing required attributes")))
+		})
+	})
+
+	When("the message is a legacy envelope", func() {
+		It("should not be parsed as cloud event", func() {
+			msg := &kafka.Message{Value: []byte(`{"name":"HostState","payload":{"id":"foo"}}`)}
+			_, ok, err := ParseCloudEvent(msg)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,123 @@
+package stream
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+const (
+	CodecJSON     = "json"
+	CodecAvro     = "avro"
+	CodecProtobuf = "protobuf"
+
+	AvroMimeType     = "application/avro"
+	ProtobufMimeType = "application/protobuf"
+
+	// confluent wire format: magic byte followed by big endian schema ID
+	wireFormatMagicByte  byte = 0
+	wireFormatHeaderSize      = 5
+)
+
+// Serializes message values
+type Codec interface {
+	Name() string
+	// media type of encoded values, i.e. for the content-type header
+	ContentType() string
+	Marshal(ctx context.Context, value interface{}) ([]byte, error)
+	Unmarshal(ctx context.Context, data []byte, value interface{}) error
+}
+
+type CodecConfig struct {
+	ValueCodec string `envconfig:"KAFKA_VALUE_CODEC" default:"json"`
+	// subject of the value schema, defaults to <topic>-value
+	ValueSubject string `envconfig:"KAFKA_VALUE_SUBJECT" default:""`
+	// path of the protobuf message type of values within their schema
+	ValueMessageIndexes    []int  `envconfig:"KAFKA_VALUE_MESSAGE_INDEXES" default:""`
+	SchemaRegistryURL      string `envconfig:"SCHEMA_REGISTRY_URL" default:""`
+	SchemaRegistryUsername string `envconfig:"SCHEMA_REGISTRY_USERNAME" default:""`
+	SchemaRegistryPassword string `envconfig:"SCHEMA_REGISTRY_PASSWORD" default:""`
+	// file backed registry, used instead of SCHEMA_REGISTRY_URL when set
+	SchemaRegistryFile string `envconfig:"SCHEMA_REGISTRY_FILE" default:""`
+}
+
+// Returns the codec for values of the given topic configured in env. Values
+// of the protobuf codec must be generated message types
+func NewCodecFromEnv(topic string) (Codec, error) {
+	config := &CodecConfig{}
+	err := envconfig.Process("", config)
+	if err != nil {
+		return nil, err
+	}
+	if config.ValueCodec == CodecJSON {
+		return &JSONCodec{}, nil
+	}
+	registry, err := newSchemaRegistry(config)
+	if err != nil {
+		return nil, err
+	}
+	subject := config.ValueSubject
+	if subject == "" {
+		subject = topic + "-value"
+	}
+	switch config.ValueCodec {
+	case CodecAvro:
+		return NewAvroCodec(registry, subject), nil
+	case CodecProtobuf:
+		return NewProtobufCodec(registry, subject, config.ValueMessageIndexes...), nil
+	}
+	return nil, fmt.Errorf("codec %s not implemented", config.ValueCodec)
+}
+
+func newSchemaRegistry(config *CodecConfig) (SchemaRegistry, error) {
+	if config.SchemaRegistryFile != "" {
+		return NewFileSchemaRegistry(config.SchemaRegistryFile)
+	}
+	if config.SchemaRegistryURL == "" {
+		return nil, fmt.Errorf("SCHEMA_REGISTRY_URL is required for codec %s", config.ValueCodec)
+	}
+	return NewHTTPSchemaRegistry(config.SchemaRegistryURL, config.SchemaRegistryUsername, config.SchemaRegistryPassword), nil
+}
+
+// Plain json values, without schema framing
+type JSONCodec struct{}
+
+func (c *JSONCodec) Name() string {
+	return CodecJSON
+}
+
+func (c *JSONCodec) ContentType() string {
+	return JSONMimeType
+}
+
+func (c *JSONCodec) Marshal(ctx context.Context, value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (c *JSONCodec) Unmarshal(ctx context.Context, data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+// Whether data starts with the schema registry wire format header. Json
+// values never start with the magic byte
+func IsFramed(data []byte) bool {
+	return len(data) >= wireFormatHeaderSize && data[0] == wireFormatMagicByte
+}
+
+func frame(schemaID int, payload []byte) []byte {
+	data := make([]byte, wireFormatHeaderSize, wireFormatHeaderSize+len(payload))
+	data[0] = wireFormatMagicByte
+	binary.BigEndian.PutUint32(data[1:], uint32(schemaID))
+	return append(data, payload...)
+}
+
+// Returns schema ID and payload of framed data
+func unframe(data []byte) (int, []byte, error) {
+	if !IsFramed(data) {
+		return 0, nil, fmt.Errorf("value is not in schema registry wire format")
+	}
+	return int(binary.BigEndian.Uint32(data[1:wireFormatHeaderSize])), data[wireFormatHeaderSize:], nil
+}
@@ -0,0 +1,94 @@
+package stream
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/kelseyhightower/envconfig"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DeadLetterHeaderTopic     = "x-original-topic"
+	DeadLetterHeaderPartition = "x-original-partition"
+	DeadLetterHeaderOffset    = "x-original-offset"
+	DeadLetterHeaderError     = "x-error"
+	DeadLetterHeaderAttempts  = "x-attempts"
+)
+
+//go:generate mockgen -source=dead_letter.go -package=stream -destination=mock_dead_letter.go
+
+type DeadLetterQueue interface {
+	Send(ctx context.Context, msg *kafka.Message, cause error, attempts int) error
+	Close()
+}
+
+type KafkaDeadLetterQueue struct {
+	producer Producer
+	logger   *logrus.Logger
+	topic    string
+}
+
+// Returns a dead letter queue if KAFKA_DEAD_LETTER_TOPIC is set, nil otherwise
+func NewDeadLetterQueueFromEnv(logger *logrus.Logger) (DeadLetterQueue, error) {
+	config := &KafkaConfig{}
+	err := envconfig.Process("", config)
+	if err != nil {
+		return nil, err
+	}
+	if config.DeadLetterTopic == "" {
+		return nil, nil
+	}
+	p, err := newProducer(config, config.DeadLetterTopic)
+	if err != nil {
+		return nil, err
+	}
+	return NewKafkaDeadLetterQueue(logger, p, config.DeadLetterTopic), nil
+}
+
+func NewKafkaDeadLetterQueue(logger *logrus.Logger, producer Producer, topic string) *KafkaDeadLetterQueue {
+	return &KafkaDeadLetterQueue{
+		producer: producer,
+		logger:   logger,
+		topic:    topic,
+	}
+}
+
+// Publishes the failed message as is, adding headers describing its origin and
+// the failure
+func (q *KafkaDeadLetterQueue) Send(ctx context.Context, msg *kafka.Message, cause error, attempts int) error {
+	errorText := ""
+	if cause != nil {
+		errorText = cause.Error()
+	}
+	headers := append([]kafka.Header{}, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: DeadLetterHeaderTopic, Value: []byte(msg.Topic)},
+		kafka.Header{Key: DeadLetterHeaderPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: DeadLetterHeaderOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: DeadLetterHeaderError, Value: []byte(errorText)},
+		kafka.Header{Key: DeadLetterHeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+	)
+	deadLetter := kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	}
+
+	q.logger.WithFields(logrus.Fields{
+		"topic":          q.topic,
+		"original_topic": msg.Topic,
+		"partition":      msg.Partition,
+		"offset":         msg.Offset,
+		"key":            string(msg.Key),
+		"attempts":       attempts,
+		"error":          errorText,
+	}).Warning("sending message to dead letter topic")
+
+	return q.producer.WriteMessages(ctx, deadLetter)
+}
+
+func (q *KafkaDeadLetterQueue) Close() {
+	q.producer.Close()
+}
@@ -0,0 +1,67 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Dead letter queue", func() {
+	var (
+		ctx          context.Context
+		ctrl         *gomock.Controller
+		mockProducer *MockProducer
+		queue        *KafkaDeadLetterQueue
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		ctrl = gomock.NewController(GinkgoT())
+		logger := logrus.New()
+		logger.Out = io.Discard
+		mockProducer = NewMockProducer(ctrl)
+		queue = NewKafkaDeadLetterQueue(logger, mockProducer, "dead-letters")
+	})
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+	When("sending a failed message", func() {
+		It("should publish it with headers describing the failure", func() {
+			msg := &kafka.Message{
+				Topic:     "events",
+				Partition: 2,
+				Offset:    42,
+				Key:       []byte("foobar"),
+				Value:     []byte(`{"name":"Unknown"}`),
+				Headers: []kafka.Header{
+					{Key: "service", Value: []byte("assisted-installer")},
+				},
+			}
+			mockProducer.EXPECT().WriteMessages(ctx, gomock.Any()).Times(1).DoAndReturn(
+				func(ctx context.Context, msgs ...kafka.Message) error {
+					Expect(msgs).To(HaveLen(1))
+					Expect(msgs[0].Key).To(Equal(msg.Key))
+					Expect(msgs[0].Value).To(Equal(msg.Value))
+					Expect(msgs[0].Headers).To(ConsistOf(
+						kafka.Header{Key: "service", Value: []byte("assisted-installer")},
+						kafka.Header{Key: DeadLetterHeaderTopic, Value: []byte("events")},
+						kafka.Header{Key: DeadLetterHeaderPartition, Value: []byte("2")},
+						kafka.Header{Key: DeadLetterHeaderOffset, Value: []byte("42")},
+						kafka.Header{Key: DeadLetterHeaderError, Value: []byte("unknown event name")},
+						kafka.Header{Key: DeadLetterHeaderAttempts, Value: []byte("3")},
+					))
+					return nil
+				})
+
+			err := queue.Send(ctx, msg, errors.New("unknown event name"), 3)
+			Expect(err).To(BeNil())
+			// original message is not modified
+			Expect(msg.Headers).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,34 @@
+package stream
+
+import (
+	"errors"
+)
+
+// Processing failed because of a transient condition (i.e. a datastore being
+// unavailable): the same message may be processed successfully if retried.
+// Any other error is considered permanent
+type RetryableError struct {
+	err error
+}
+
+func NewRetryableError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryableError{
+		err: err,
+	}
+}
+
+func (e *RetryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.err
+}
+
+func IsRetryable(err error) bool {
+	var retryableErr *RetryableError
+	return errors.As(err, &retryableErr)
+}
@@ -0,0 +1,197 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// file, directory or "-" (stdin) to read events from instead of kafka
+	FileReaderInputEnv = "EVENT_STREAM_INPUT"
+	StdinInput         = "-"
+
+	FileReaderHeaderSource = "x-replay-source"
+	FileReaderHeaderIndex  = "x-replay-index"
+)
+
+// Reads JSON envelopes, either newline delimited or pretty printed one after
+// the other, from a file, every file in a directory (in lexical order) or
+// stdin. Each envelope is turned into a kafka message, with the source as
+// topic, position as offset and the cluster ID as key, so that processors
+// and acks behave as when reading from kafka
+type FileReader struct {
+	logger        *logrus.Logger
+	input         string
+	stdin         io.Reader
+	ackChannel    chan kafka.Message
+	quitChannel   chan struct{}
+	offsetTracker *OffsetTracker
+}
+
+func NewFileReader(logger *logrus.Logger, input string, ackChannel chan kafka.Message) *FileReader {
+	return &FileReader{
+		logger:        logger,
+		input:         input,
+		stdin:         os.Stdin,
+		ackChannel:    ackChannel,
+		quitChannel:   make(chan struct{}),
+		offsetTracker: NewOffsetTracker(),
+	}
+}
+
+func (r *FileReader) Close(ctx context.Context) {
+	close(r.quitChannel)
+}
+
+// Processes every envelope in the input, returns once the input is exhausted
+func (r *FileReader) Consume(ctx context.Context, processMessageFn func(ctx context.Context, msg *kafka.Message) error) error {
+	go r.listenForAcks()
+	sources, err := r.getSources()
+	if err != nil {
+		return err
+	}
+	processed := 0
+	for _, source := range sources {
+		n, err := r.consumeSource(ctx, source, processMessageFn)
+		processed += n
+		if errors.Is(err, errConsumerClosed) {
+			r.logger.Info("stop consuming")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	r.logger.WithFields(logrus.Fields{
+		"input":     r.input,
+		"processed": processed,
+		"pending":   r.offsetTracker.Pending(),
+	}).Info("input consumed")
+	return nil
+}
+
+// Number of messages processed and not acked yet
+func (r *FileReader) Pending() int {
+	return r.offsetTracker.Pending()
+}
+
+func (r *FileReader) getSources() ([]string, error) {
+	if r.input == StdinInput {
+		return []string{StdinInput}, nil
+	}
+	info, err := os.Stat(r.input)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{r.input}, nil
+	}
+	entries, err := os.ReadDir(r.input)
+	if err != nil {
+		return nil, err
+	}
+	sources := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			sources = append(sources, filepath.Join(r.input, entry.Name()))
+		}
+	}
+	sort.Strings(sources)
+	return sources, nil
+}
+
+func (r *FileReader) consumeSource(ctx context.Context, source string, processMessageFn func(ctx context.Context, msg *kafka.Message) error) (int, error) {
+	in := r.stdin
+	if source != StdinInput {
+		file, err := os.Open(source)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		in = file
+	}
+	r.logger.WithField("source", source).Info("reading events")
+
+	decoder := json.NewDecoder(in)
+	var offset int64
+	for {
+		select {
+		case <-r.quitChannel:
+			return int(offset), errConsumerClosed
+		default:
+		}
+		var value json.RawMessage
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return int(offset), nil
+		}
+		if err != nil {
+			return int(offset), fmt.Errorf("failed to decode envelope %d from %s: %w", offset, source, err)
+		}
+		msg := newReplayMessage(source, offset, value)
+		r.offsetTracker.Track(msg)
+		if err := processMessageFn(ctx, &msg); err != nil {
+			select {
+			case <-r.quitChannel:
+				return int(offset), errConsumerClosed
+			default:
+			}
+			r.logger.WithError(err).WithFields(logrus.Fields{
+				"source": source,
+				"offset": offset,
+				"key":    string(msg.Key),
+			}).Error("error processing message")
+			return int(offset), err
+		}
+		offset++
+	}
+}
+
+func (r *FileReader) listenForAcks() {
+	for msg := range r.ackChannel {
+		r.offsetTracker.Ack(msg)
+	}
+}
+
+func newReplayMessage(source string, offset int64, value json.RawMessage) kafka.Message {
+	compacted := &bytes.Buffer{}
+	if err := json.Compact(compacted, value); err != nil {
+		compacted = bytes.NewBuffer(value)
+	}
+	return kafka.Message{
+		Topic:     source,
+		Partition: 0,
+		Offset:    offset,
+		Key:       []byte(getEnvelopeKey(compacted.Bytes())),
+		Value:     compacted.Bytes(),
+		Time:      time.Now(),
+		Headers: []kafka.Header{
+			{Key: FileReaderHeaderSource, Value: []byte(source)},
+			{Key: FileReaderHeaderIndex, Value: []byte(strconv.FormatInt(offset, 10))},
+		},
+	}
+}
+
+// Messages are keyed by cluster ID, which is the resource ID for cluster states
+func getEnvelopeKey(value []byte) string {
+	if clusterID := gjson.GetBytes(value, "payload.cluster_id"); clusterID.Type == gjson.String {
+		return clusterID.String()
+	}
+	if gjson.GetBytes(value, "name").String() == "ClusterState" {
+		return gjson.GetBytes(value, "payload.id").String()
+	}
+	return ""
+}
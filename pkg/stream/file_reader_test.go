@@ -0,0 +1,122 @@
+package stream
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Reading events from files", func() {
+	var (
+		ctx        context.Context
+		logger     *logrus.Logger
+		ackChannel chan kafka.Message
+		tmpdir     string
+		processed  []kafka.Message
+		collect    func(ctx context.Context, msg *kafka.Message) error
+	)
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		logger = logrus.New()
+		logger.Out = io.Discard
+		ackChannel = make(chan kafka.Message, 10)
+		tmpdir, err = os.MkdirTemp("", ".replay-")
+		Expect(err).To(BeNil())
+		processed = []kafka.Message{}
+		collect = func(ctx context.Context, msg *kafka.Message) error {
+			processed = append(processed, *msg)
+			return nil
+		}
+	})
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	writeFile := func(name string, content string) string {
+		filename := filepath.Join(tmpdir, name)
+		Expect(os.WriteFile(filename, []byte(content), 0600)).To(Succeed())
+		return filename
+	}
+
+	When("reading newline delimited envelopes", func() {
+		It("should synthesize key, offset and headers", func() {
+			filename := writeFile("events.jsonl",
+				`{"name":"ClusterState","payload":{"id":"cluster-1"}}`+"\n"+
+					`{"name":"Event","payload":{"cluster_id":"cluster-1","message":"foo"}}`+"\n")
+			reader := NewFileReader(logger, filename, ackChannel)
+
+			Expect(reader.Consume(ctx, collect)).To(Succeed())
+			Expect(processed).To(HaveLen(2))
+			Expect(string(processed[0].Key)).To(Equal("cluster-1"))
+			Expect(string(processed[1].Key)).To(Equal("cluster-1"))
+			Expect(processed[1].Topic).To(Equal(filename))
+			Expect(processed[1].Offset).To(BeEquivalentTo(1))
+			Expect(processed[1].Headers).To(ContainElements(
+				kafka.Header{Key: FileReaderHeaderSource, Value: []byte(filename)},
+				kafka.Header{Key: FileReaderHeaderIndex, Value: []byte("1")},
+			))
+			Expect(string(processed[1].Value)).To(Equal(`{"name":"Event","payload":{"cluster_id":"cluster-1","message":"foo"}}`))
+		})
+	})
+
+	When("reading a directory of pretty printed envelopes", func() {
+		It("should read every file in order", func() {
+			writeFile("b_event.json", `{
+    "name": "Event",
+    "payload": {
+        "cluster_id": "my-cluster-id"
+    }
+}`)
+			writeFile("a_state.json", `{
+    "name": "HostState",
+    "payload": {
+        "id": "my-host-id",
+        "cluster_id": "my-cluster-id"
+    }
+}
+{"name":"InfraEnv","payload":{"id":"my-infra-env-id","cluster_id":"my-cluster-id"}}`)
+			reader := NewFileReader(logger, tmpdir, ackChannel)
+
+			Expect(reader.Consume(ctx, collect)).To(Succeed())
+			Expect(processed).To(HaveLen(3))
+			Expect(string(processed[0].Value)).To(ContainSubstring(`"HostState"`))
+			Expect(string(processed[1].Value)).To(ContainSubstring(`"InfraEnv"`))
+			Expect(string(processed[2].Value)).To(ContainSubstring(`"Event"`))
+			for _, msg := range processed {
+				Expect(string(msg.Key)).To(Equal("my-cluster-id"))
+			}
+		})
+	})
+
+	When("reading from stdin", func() {
+		It("should process every envelope and track acks", func() {
+			reader := NewFileReader(logger, StdinInput, ackChannel)
+			reader.stdin = strings.NewReader(`{"name":"Event","payload":{"cluster_id":"c1"}} {"name":"Event","payload":{"cluster_id":"c2"}}`)
+
+			Expect(reader.Consume(ctx, func(ctx context.Context, msg *kafka.Message) error {
+				if string(msg.Key) == "c1" {
+					ackChannel <- *msg
+				}
+				return nil
+			})).To(Succeed())
+			Eventually(reader.Pending).Should(Equal(1))
+		})
+	})
+
+	When("the input is not valid json", func() {
+		It("should return an error", func() {
+			filename := writeFile("broken.json", `{"name":"Event"`)
+			reader := NewFileReader(logger, filename, ackChannel)
+
+			Expect(reader.Consume(ctx, collect)).To(MatchError(ContainSubstring("broken.json")))
+		})
+	})
+})
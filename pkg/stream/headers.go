@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"context"
+	"regexp"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+const (
+	HeaderNotificationType = "x-notification-type"
+	HeaderClusterID        = "x-cluster-id"
+	HeaderInfraEnvID       = "x-infra-env-id"
+	HeaderHostID           = "x-host-id"
+	HeaderSchemaVersion    = "x-schema-version"
+	// W3C trace context headers
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+
+	// version of the envelope schema produced by NotificationStream
+	SchemaVersion = "1"
+)
+
+var traceParentRegexp = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+type traceContextKey struct{}
+
+// W3C trace context propagated through message headers
+type TraceContext struct {
+	TraceParent string
+	TraceState  string
+}
+
+func (t TraceContext) IsValid() bool {
+	return traceParentRegexp.MatchString(t.TraceParent)
+}
+
+func (t TraceContext) headers() []kafka.Header {
+	headers := []kafka.Header{
+		{Key: HeaderTraceParent, Value: []byte(t.TraceParent)},
+	}
+	if t.TraceState != "" {
+		headers = append(headers, kafka.Header{Key: HeaderTraceState, Value: []byte(t.TraceState)})
+	}
+	return headers
+}
+
+// Returns a copy of ctx carrying the trace context, which is then written as
+// headers of messages produced with it
+func ContextWithTraceContext(ctx context.Context, traceContext TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, traceContext)
+}
+
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	traceContext, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	if !ok || !traceContext.IsValid() {
+		return TraceContext{}, false
+	}
+	return traceContext, true
+}
+
+func TraceContextFromMessage(msg *kafka.Message) (TraceContext, bool) {
+	traceParent, _ := GetHeader(msg, HeaderTraceParent)
+	traceState, _ := GetHeader(msg, HeaderTraceState)
+	traceContext := TraceContext{
+		TraceParent: traceParent,
+		TraceState:  traceState,
+	}
+	if !traceContext.IsValid() {
+		return TraceContext{}, false
+	}
+	return traceContext, true
+}
+
+// Returns a copy of ctx carrying the trace context of the message, if any
+func ContextFromMessage(ctx context.Context, msg *kafka.Message) context.Context {
+	if traceContext, ok := TraceContextFromMessage(msg); ok {
+		return ContextWithTraceContext(ctx, traceContext)
+	}
+	return ctx
+}
+
+// Returns the value of the last header with the given key
+func GetHeader(msg *kafka.Message, key string) (string, bool) {
+	for i := len(msg.Headers) - 1; i >= 0; i-- {
+		if msg.Headers[i].Key == key {
+			return string(msg.Headers[i].Value), true
+		}
+	}
+	return "", false
+}
+
+func hasHeader(headers []kafka.Header, key string) bool {
+	for _, header := range headers {
+		if header.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package stream
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Message headers", func() {
+	var (
+		ctx          context.Context
+		ctrl         *gomock.Controller
+		logger       *logrus.Logger
+		traceContext TraceContext
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		ctrl = gomock.NewController(GinkgoT())
+		logger = logrus.New()
+		logger.Out = io.Discard
+		traceContext = TraceContext{
+			TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			TraceState:  "vendor=foobar",
+		}
+	})
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	When("writing a message", func() {
+		var (
+			mockProducer *MockProducer
+			writer       *KafkaWriter
+			written      []kafka.Message
+		)
+		BeforeEach(func() {
+			mockProducer = NewMockProducer(ctrl)
+			writer = &KafkaWriter{producer: mockProducer, logger: logger}
+			mockProducer.EXPECT().WriteMessages(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, msgs ...kafka.Message) error {
+					written = msgs
+					return nil
+				}).Times(1)
+		})
+		It("should add the given headers", func() {
+			err := writer.Write(ctx, []byte("foo"), "bar", kafka.Header{Key: "service", Value: []byte("assisted-installer")})
+			Expect(err).To(BeNil())
+			Expect(written).To(HaveLen(1))
+			Expect(written[0].Headers).To(ConsistOf(kafka.Header{Key: "service", Value: []byte("assisted-installer")}))
+		})
+		It("should add the trace context of ctx", func() {
+			err := writer.Write(ContextWithTraceContext(ctx, traceContext), []byte("foo"), "bar")
+			Expect(err).To(BeNil())
+			Expect(written[0].Headers).To(ConsistOf(
+				kafka.Header{Key: HeaderTraceParent, Value: []byte(traceContext.TraceParent)},
+				kafka.Header{Key: HeaderTraceState, Value: []byte(traceContext.TraceState)},
+			))
+			writtenTraceContext, ok := TraceContextFromMessage(&written[0])
+			Expect(ok).To(BeTrue())
+			Expect(writtenTraceContext).To(Equal(traceContext))
+		})
+		It("should not override an explicit traceparent header", func() {
+			traceParent := kafka.Header{Key: HeaderTraceParent, Value: []byte("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")}
+			err := writer.Write(ContextWithTraceContext(ctx, traceContext), []byte("foo"), "bar", traceParent)
+			Expect(err).To(BeNil())
+			Expect(written[0].Headers).To(ConsistOf(traceParent))
+		})
+	})
+
+	When("notifying", func() {
+		It("should describe the notification in the headers", func() {
+			clusterID := strfmt.UUID("f8c8a5b7-8c2c-4d6c-9d5b-0b8c8c0e2c11")
+			hostID := strfmt.UUID("9d3bce0c-4c4a-4e2b-8a6b-2f0e3e5d6c7b")
+			mockWriter := NewMockEventStreamWriter(ctrl)
+			mockNotifiable := NewMockNotifiable(ctrl)
+			mockNotifiable.EXPECT().GetClusterID().Return(&clusterID).AnyTimes()
+			mockNotifiable.EXPECT().GetInfraEnvID().Return(nil).AnyTimes()
+			mockNotifiable.EXPECT().GetHostID().Return(&hostID).AnyTimes()
+			mockNotifiable.EXPECT().NotificationType().Return("HostState").AnyTimes()
+			mockNotifiable.EXPECT().Payload().Return(map[string]string{"id": hostID.String()}).AnyTimes()
+			mockWriter.EXPECT().Write(ctx, []byte(clusterID.String()), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, key []byte, value interface{}, headers ...kafka.Header) error {
+					Expect(headers).To(Equal([]kafka.Header{
+						{Key: HeaderNotificationType, Value: []byte("HostState")},
+						{Key: HeaderSchemaVersion, Value: []byte(SchemaVersion)},
+						{Key: HeaderClusterID, Value: []byte(clusterID.String())},
+						{Key: HeaderHostID, Value: []byte(hostID.String())},
+						{Key: "service", Value: []byte("assisted-installer")},
+					}))
+					return nil
+				}).Times(1)
+
+			notificationStream := NewNotificationStream(mockWriter, logger, nil)
+			err := notificationStream.Notify(ctx, mockNotifiable, kafka.Header{Key: "service", Value: []byte("assisted-installer")})
+			Expect(err).To(BeNil())
+		})
+	})
+
+	When("reading headers", func() {
+		It("should return the last value of the header", func() {
+			msg := &kafka.Message{Headers: []kafka.Header{
+				{Key: "foo", Value: []byte("bar")},
+				{Key: "foo", Value: []byte("baz")},
+			}}
+			value, ok := GetHeader(msg, "foo")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal("baz"))
+			_, ok = GetHeader(msg, "missing")
+			Expect(ok).To(BeFalse())
+		})
+		It("should ignore an invalid traceparent", func() {
+			msg := &kafka.Message{Headers: []kafka.Header{
+				{Key: HeaderTraceParent, Value: []byte("not-a-traceparent")},
+			}}
+			_, ok := TraceContextFromMessage(msg)
+			Expect(ok).To(BeFalse())
+			Expect(ContextFromMessage(ctx, msg)).To(Equal(ctx))
+		})
+	})
+})
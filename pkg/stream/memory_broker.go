@@ -0,0 +1,246 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// In-process fake of a kafka cluster, providing producers and consumer group
+// members behind the Producer and Consumer interfaces, to run readers and
+// writers end to end in tests
+type MemoryBroker struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	// committed offsets by group, topic and partition
+	committed map[string]map[string]map[int]int64
+	// closed and replaced on every write, to wake up fetching consumers
+	changed chan struct{}
+}
+
+type memoryTopic struct {
+	partitions [][]kafka.Message
+	next       int
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		topics:    map[string]*memoryTopic{},
+		committed: map[string]map[string]map[int]int64{},
+		changed:   make(chan struct{}),
+	}
+}
+
+// Creates topic with the given number of partitions, if it does not exist
+func (b *MemoryBroker) CreateTopic(topic string, partitions int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.createTopic(topic, partitions)
+}
+
+func (b *MemoryBroker) createTopic(topic string, partitions int) *memoryTopic {
+	if t, ok := b.topics[topic]; ok {
+		return t
+	}
+	if partitions < 1 {
+		partitions = 1
+	}
+	t := &memoryTopic{partitions: make([][]kafka.Message, partitions)}
+	b.topics[topic] = t
+	return t
+}
+
+// Messages of all partitions of the topic, ordered by partition and offset
+func (b *MemoryBroker) Messages(topic string) []kafka.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages := []kafka.Message{}
+	if t, ok := b.topics[topic]; ok {
+		for _, partition := range t.partitions {
+			messages = append(messages, partition...)
+		}
+	}
+	return messages
+}
+
+// Next offset to consume committed by the group, -1 if nothing was committed
+func (b *MemoryBroker) CommittedOffset(groupID string, topic string, partition int) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	offset, ok := b.committed[groupID][topic][partition]
+	if !ok {
+		return -1
+	}
+	return offset
+}
+
+// Returns a producer writing to topic, created with one partition if missing
+func (b *MemoryBroker) Producer(topic string) *MemoryProducer {
+	return &MemoryProducer{broker: b, topic: topic}
+}
+
+// Returns a member of the consumer group, starting from committed offsets.
+// Partitions are not balanced across members of the same group.
+func (b *MemoryBroker) Consumer(topic string, groupID string) *MemoryConsumer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.createTopic(topic, 1)
+	positions := make([]int64, len(t.partitions))
+	for partition := range positions {
+		if offset, ok := b.committed[groupID][topic][partition]; ok {
+			positions[partition] = offset
+		}
+	}
+	return &MemoryConsumer{
+		broker:    b,
+		topic:     topic,
+		groupID:   groupID,
+		positions: positions,
+		closed:    make(chan struct{}),
+	}
+}
+
+func (b *MemoryBroker) write(topic string, msgs []kafka.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.createTopic(topic, 1)
+	for _, msg := range msgs {
+		partition := t.partition(msg.Key)
+		msg.Topic = topic
+		msg.Partition = partition
+		msg.Offset = int64(len(t.partitions[partition]))
+		msg.Headers = append([]kafka.Header{}, msg.Headers...)
+		if msg.Time.IsZero() {
+			msg.Time = time.Now()
+		}
+		t.partitions[partition] = append(t.partitions[partition], msg)
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Messages with the same key go to the same partition, messages without key
+// are distributed round robin
+func (t *memoryTopic) partition(key []byte) int {
+	if len(key) == 0 {
+		t.next = (t.next + 1) % len(t.partitions)
+		return t.next
+	}
+	hash := fnv.New32a()
+	hash.Write(key)
+	return int(hash.Sum32() % uint32(len(t.partitions)))
+}
+
+type MemoryProducer struct {
+	broker *MemoryBroker
+	topic  string
+	closed atomic.Bool
+}
+
+func (p *MemoryProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if p.closed.Load() {
+		return io.ErrClosedPipe
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.broker.write(p.topic, msgs)
+	return nil
+}
+
+func (p *MemoryProducer) Close() error {
+	p.closed.Store(true)
+	return nil
+}
+
+type MemoryConsumer struct {
+	broker    *MemoryBroker
+	topic     string
+	groupID   string
+	positions []int64
+	next      int
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Returns the next message of any partition, blocking until one is available.
+// Returns io.EOF once closed, like kafka-go readers
+func (c *MemoryConsumer) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		c.broker.mu.Lock()
+		msg, ok := c.nextMessage()
+		changed := c.broker.changed
+		c.broker.mu.Unlock()
+		if ok {
+			return msg, nil
+		}
+		select {
+		case <-c.closed:
+			return kafka.Message{}, io.EOF
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (c *MemoryConsumer) nextMessage() (kafka.Message, bool) {
+	select {
+	case <-c.closed:
+		return kafka.Message{}, false
+	default:
+	}
+	partitions := c.broker.topics[c.topic].partitions
+	for i := 0; i < len(partitions); i++ {
+		partition := (c.next + i) % len(partitions)
+		if partition >= len(c.positions) {
+			continue
+		}
+		if c.positions[partition] < int64(len(partitions[partition])) {
+			msg := partitions[partition][c.positions[partition]]
+			msg.Headers = append([]kafka.Header{}, msg.Headers...)
+			c.positions[partition]++
+			c.next = (partition + 1) % len(partitions)
+			return msg, true
+		}
+	}
+	return kafka.Message{}, false
+}
+
+// Commits the offset following each message, offsets never move backwards
+func (c *MemoryConsumer) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	for _, msg := range msgs {
+		if msg.Topic != c.topic {
+			return fmt.Errorf("message of topic %s committed by consumer of %s", msg.Topic, c.topic)
+		}
+		groups, ok := c.broker.committed[c.groupID]
+		if !ok {
+			groups = map[string]map[int]int64{}
+			c.broker.committed[c.groupID] = groups
+		}
+		partitions, ok := groups[c.topic]
+		if !ok {
+			partitions = map[int]int64{}
+			groups[c.topic] = partitions
+		}
+		if msg.Offset+1 > partitions[msg.Partition] {
+			partitions[msg.Partition] = msg.Offset + 1
+		}
+	}
+	return nil
+}
+
+func (c *MemoryConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
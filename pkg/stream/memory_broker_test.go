@@ -0,0 +1,150 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("In-memory broker", func() {
+	var (
+		ctx    context.Context
+		broker *MemoryBroker
+		logger *logrus.Logger
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		broker = NewMemoryBroker()
+		broker.CreateTopic("events", 3)
+		logger = logrus.New()
+		logger.Out = io.Discard
+	})
+
+	It("should keep messages with the same key in the same partition, in order", func() {
+		producer := broker.Producer("events")
+		for i := 0; i < 5; i++ {
+			err := producer.WriteMessages(ctx,
+				kafka.Message{Key: []byte("cluster-1"), Value: []byte{byte(i)}},
+				kafka.Message{Key: []byte("cluster-2"), Value: []byte{byte(i)}},
+			)
+			Expect(err).To(BeNil())
+		}
+
+		byKey := map[string][]kafka.Message{}
+		for _, msg := range broker.Messages("events") {
+			byKey[string(msg.Key)] = append(byKey[string(msg.Key)], msg)
+		}
+		for _, messages := range byKey {
+			Expect(messages).To(HaveLen(5))
+			for i, msg := range messages {
+				Expect(msg.Topic).To(Equal("events"))
+				Expect(msg.Partition).To(Equal(messages[0].Partition))
+				Expect(msg.Value).To(Equal([]byte{byte(i)}))
+				Expect(msg.Offset).To(BeNumerically(">=", i))
+			}
+		}
+	})
+
+	It("should deliver headers and resume consumers from committed offsets", func() {
+		producer := broker.Producer("events")
+		headers := []kafka.Header{{Key: HeaderNotificationType, Value: []byte("Event")}}
+		Expect(producer.WriteMessages(ctx,
+			kafka.Message{Key: []byte("a"), Value: []byte("1"), Headers: headers},
+			kafka.Message{Key: []byte("a"), Value: []byte("2"), Headers: headers},
+		)).To(BeNil())
+
+		consumer := broker.Consumer("events", "group")
+		first, err := consumer.FetchMessage(ctx)
+		Expect(err).To(BeNil())
+		Expect(first.Value).To(Equal([]byte("1")))
+		notificationType, ok := GetHeader(&first, HeaderNotificationType)
+		Expect(ok).To(BeTrue())
+		Expect(notificationType).To(Equal("Event"))
+		Expect(consumer.CommitMessages(ctx, first)).To(BeNil())
+		Expect(consumer.Close()).To(BeNil())
+		Expect(broker.CommittedOffset("group", "events", first.Partition)).To(Equal(first.Offset + 1))
+
+		consumer = broker.Consumer("events", "group")
+		second, err := consumer.FetchMessage(ctx)
+		Expect(err).To(BeNil())
+		Expect(second.Value).To(Equal([]byte("2")))
+
+		other := broker.Consumer("events", "other-group")
+		msg, err := other.FetchMessage(ctx)
+		Expect(err).To(BeNil())
+		Expect(msg.Value).To(Equal([]byte("1")))
+	})
+
+	It("should unblock fetching consumers on write and close", func() {
+		consumer := broker.Consumer("events", "group")
+		fetched := make(chan kafka.Message, 1)
+		go func() {
+			defer GinkgoRecover()
+			msg, err := consumer.FetchMessage(ctx)
+			Expect(err).To(BeNil())
+			fetched <- msg
+		}()
+		Consistently(fetched, "50ms").ShouldNot(Receive())
+		Expect(broker.Producer("events").WriteMessages(ctx, kafka.Message{Value: []byte("foo")})).To(BeNil())
+		Eventually(fetched).Should(Receive())
+
+		Expect(consumer.Close()).To(BeNil())
+		_, err := consumer.FetchMessage(ctx)
+		Expect(errors.Is(err, io.EOF)).To(BeTrue())
+	})
+
+	When("consumed by a kafka reader", func() {
+		It("should process all messages and commit them once acked", func() {
+			producer := broker.Producer("events")
+			for i := 0; i < 30; i++ {
+				key := []byte{byte('a' + i%4)}
+				Expect(producer.WriteMessages(ctx, kafka.Message{Key: key, Value: []byte{byte(i)}})).To(BeNil())
+			}
+
+			for _, workers := range []int{1, 4} {
+				groupID := "group"
+				if workers > 1 {
+					groupID = "concurrent-group"
+				}
+				ackChannel := make(chan kafka.Message)
+				config := &KafkaConfig{ConsumerWorkers: workers, ConsumerWorkerQueueSize: 10}
+				reader := NewKafkaReaderFromConsumer(logger, broker.Consumer("events", groupID), config, ackChannel, nil)
+
+				var mu sync.Mutex
+				processed := 0
+				done := make(chan error)
+				go func() {
+					done <- reader.Consume(ctx, func(ctx context.Context, msg *kafka.Message) error {
+						mu.Lock()
+						processed++
+						mu.Unlock()
+						ackChannel <- *msg
+						return nil
+					})
+				}()
+
+				Eventually(func() int64 {
+					var total int64
+					for partition := 0; partition < 3; partition++ {
+						if offset := broker.CommittedOffset(groupID, "events", partition); offset > 0 {
+							total += offset
+						}
+					}
+					return total
+				}, 5*time.Second).Should(Equal(int64(30)))
+				reader.Close(ctx)
+				Eventually(done).Should(Receive(BeNil()))
+				mu.Lock()
+				Expect(processed).To(Equal(30))
+				mu.Unlock()
+			}
+		})
+	})
+})
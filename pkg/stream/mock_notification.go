@@ -10,6 +10,7 @@ import (
 
 	strfmt "github.com/go-openapi/strfmt"
 	gomock "github.com/golang/mock/gomock"
+	kafka_go "github.com/segmentio/kafka-go"
 )
 
 // MockNotifiable is a mock of Notifiable interface.
@@ -141,15 +142,20 @@ func (mr *MockNotifierMockRecorder) Close() *gomock.Call {
 }
 
 // Notify mocks base method.
-func (m *MockNotifier) Notify(ctx context.Context, notifiable Notifiable) error {
+func (m *MockNotifier) Notify(ctx context.Context, notifiable Notifiable, headers ...kafka_go.Header) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Notify", ctx, notifiable)
+	varargs := []interface{}{ctx, notifiable}
+	for _, a := range headers {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Notify", varargs...)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Notify indicates an expected call of Notify.
-func (mr *MockNotifierMockRecorder) Notify(ctx, notifiable interface{}) *gomock.Call {
+func (mr *MockNotifierMockRecorder) Notify(ctx, notifiable interface{}, headers ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockNotifier)(nil).Notify), ctx, notifiable)
+	varargs := append([]interface{}{ctx, notifiable}, headers...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockNotifier)(nil).Notify), varargs...)
 }
@@ -103,16 +103,69 @@ func (mr *MockEventStreamWriterMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockEventStreamWriter)(nil).Close))
 }
 
+// ContentType mocks base method.
+func (m *MockEventStreamWriter) ContentType() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ContentType")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// ContentType indicates an expected call of ContentType.
+func (mr *MockEventStreamWriterMockRecorder) ContentType() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContentType", reflect.TypeOf((*MockEventStreamWriter)(nil).ContentType))
+}
+
+// Encode mocks base method.
+func (m *MockEventStreamWriter) Encode(ctx context.Context, value interface{}) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Encode", ctx, value)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Encode indicates an expected call of Encode.
+func (mr *MockEventStreamWriterMockRecorder) Encode(ctx, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Encode", reflect.TypeOf((*MockEventStreamWriter)(nil).Encode), ctx, value)
+}
+
 // Write mocks base method.
-func (m *MockEventStreamWriter) Write(ctx context.Context, key []byte, value interface{}) error {
+func (m *MockEventStreamWriter) Write(ctx context.Context, key []byte, value interface{}, headers ...kafka.Header) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Write", ctx, key, value)
+	varargs := []interface{}{ctx, key, value}
+	for _, a := range headers {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Write", varargs...)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Write indicates an expected call of Write.
-func (mr *MockEventStreamWriterMockRecorder) Write(ctx, key, value interface{}) *gomock.Call {
+func (mr *MockEventStreamWriterMockRecorder) Write(ctx, key, value interface{}, headers ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, key, value}, headers...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockEventStreamWriter)(nil).Write), varargs...)
+}
+
+// WriteEncoded mocks base method.
+func (m *MockEventStreamWriter) WriteEncoded(ctx context.Context, key, encodedValue []byte, headers ...kafka.Header) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, key, encodedValue}
+	for _, a := range headers {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "WriteEncoded", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteEncoded indicates an expected call of WriteEncoded.
+func (mr *MockEventStreamWriterMockRecorder) WriteEncoded(ctx, key, encodedValue interface{}, headers ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockEventStreamWriter)(nil).Write), ctx, key, value)
+	varargs := append([]interface{}{ctx, key, encodedValue}, headers...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteEncoded", reflect.TypeOf((*MockEventStreamWriter)(nil).WriteEncoded), varargs...)
 }
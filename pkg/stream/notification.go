@@ -2,11 +2,16 @@ package stream
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/go-openapi/strfmt"
 	//"github.com/openshift/assisted-service/internal/common"
+	kafka "github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,7 +26,7 @@ type Notifiable interface {
 }
 
 type Notifier interface {
-	Notify(ctx context.Context, notifiable Notifiable) error
+	Notify(ctx context.Context, notifiable Notifiable, headers ...kafka.Header) error
 	Close()
 }
 
@@ -31,22 +36,68 @@ type Envelope struct {
 	Metadata interface{}
 }
 
+var errOutboxPending = errors.New("older notifications are pending in outbox")
+
 type NotificationStream struct {
-	metadata interface{}
-	writer   EventStreamWriter
-	log      logrus.FieldLogger
+	metadata          interface{}
+	writer            EventStreamWriter
+	log               logrus.FieldLogger
+	cloudEvents       bool
+	cloudEventsMode   CloudEventsMode
+	cloudEventsSource string
+	now               func() time.Time
+
+	outbox              Outbox
+	outboxRetryInterval time.Duration
+	// serializes replays, notifications are written without it
+	outboxMu sync.Mutex
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type NotificationStreamOption func(*NotificationStream)
+
+// Encode notifications as CloudEvents in the given kafka binding mode instead
+// of Envelope. Metadata is sent as the json encoded metadata extension attribute
+func WithCloudEvents(source string, mode CloudEventsMode) NotificationStreamOption {
+	return func(s *NotificationStream) {
+		s.cloudEvents = true
+		s.cloudEventsSource = source
+		s.cloudEventsMode = mode
+	}
 }
 
-func NewNotificationStream(writer EventStreamWriter, logger logrus.FieldLogger, metadata interface{}) *NotificationStream {
-	return &NotificationStream{
+// Persist notifications failing to be written to the outbox, they are replayed
+// in order every retryInterval. Without retryInterval, they are replayed before
+// writing any new notification instead
+func WithOutbox(outbox Outbox, retryInterval time.Duration) NotificationStreamOption {
+	return func(s *NotificationStream) {
+		s.outbox = outbox
+		s.outboxRetryInterval = retryInterval
+	}
+}
+
+func NewNotificationStream(writer EventStreamWriter, logger logrus.FieldLogger, metadata interface{}, opts ...NotificationStreamOption) *NotificationStream {
+	s := &NotificationStream{
 		writer:   writer,
 		metadata: metadata,
 		log:      logger,
+		now:      time.Now,
 	}
-
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.outbox != nil && s.writer != nil && s.outboxRetryInterval > 0 {
+		s.quit = make(chan struct{})
+		s.wg.Add(1)
+		go s.retryOutbox()
+	}
+	return s
 }
 
-func (s *NotificationStream) Notify(ctx context.Context, notifiable Notifiable) error {
+// Writes the notifiable wrapped in an envelope. Messages carry headers
+// describing the notification, followed by the given ones
+func (s *NotificationStream) Notify(ctx context.Context, notifiable Notifiable, headers ...kafka.Header) error {
 	if s.writer == nil {
 		return nil
 	}
@@ -59,25 +110,167 @@ func (s *NotificationStream) Notify(ctx context.Context, notifiable Notifiable)
 		key = clusterID.String()
 	}
 
-	envelope := &Envelope{
-		Name:     notifiable.NotificationType(),
-		Payload:  notifiable.Payload(),
-		Metadata: s.metadata,
+	value, encodingHeaders, err := s.encode(notifiable)
+	if err != nil {
+		s.log.WithError(err).WithFields(logrus.Fields{
+			"type":       notifiable.NotificationType(),
+			"cluster_id": clusterID,
+		}).Warn("failed to encode notification for resource")
+		return err
 	}
+	headers = append(append(notificationHeaders(notifiable), encodingHeaders...), headers...)
+	logger := s.log.WithFields(logrus.Fields{
+		"type":         notifiable.NotificationType(),
+		"cluster_id":   clusterID,
+		"infra_env_id": notifiable.GetInfraEnvID(),
+		"host_id":      notifiable.GetHostID(),
+	})
 
-	if err := s.writer.Write(ctx, []byte(key), envelope); err != nil {
-		s.log.WithError(err).WithFields(logrus.Fields{
-			"type":         notifiable.NotificationType(),
-			"cluster_id":   clusterID,
-			"infra_env_id": notifiable.GetInfraEnvID(),
-			"host_id":      notifiable.GetHostID(),
-		}).Warn("failed to stream notification for resource")
+	if s.outbox != nil {
+		return s.writeThroughOutbox(ctx, logger, []byte(key), value, headers)
+	}
+	if err := s.writer.Write(ctx, []byte(key), value, headers...); err != nil {
+		logger.WithError(err).Warn("failed to stream notification for resource")
 		return err
 	}
 	return nil
 }
 
+// Writes the notification unless older ones are still pending in the outbox,
+// in which case it's appended after them to keep ordering. Pending ones are
+// only replayed here when not retried in background, so that notifying doesn't
+// block on the writer while it's unavailable
+func (s *NotificationStream) writeThroughOutbox(ctx context.Context, logger logrus.FieldLogger, key []byte, value interface{}, headers []kafka.Header) error {
+	encodedValue, err := s.writer.Encode(ctx, value)
+	if err != nil {
+		return err
+	}
+	if s.outbox.Stats().Pending > 0 {
+		err = errOutboxPending
+		if s.quit == nil {
+			err = s.flushOutbox(ctx)
+		}
+	}
+	if err == nil {
+		err = s.writer.WriteEncoded(ctx, key, encodedValue, headers...)
+		if err == nil {
+			return nil
+		}
+	}
+	entry := OutboxEntry{
+		Key:     key,
+		Value:   encodedValue,
+		Headers: withTraceContext(ctx, headers),
+	}
+	if appendErr := s.outbox.Append(entry); appendErr != nil {
+		logger.WithError(appendErr).WithField("write_error", err).Error("failed to stream notification for resource and to store it in outbox")
+		return appendErr
+	}
+	logger.WithError(err).Warn("failed to stream notification for resource, stored in outbox")
+	return nil
+}
+
+func (s *NotificationStream) flushOutbox(ctx context.Context) error {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+	return s.outbox.Replay(func(entry OutboxEntry) error {
+		return s.writer.WriteEncoded(ctx, entry.Key, entry.Value, entry.Headers...)
+	})
+}
+
+func (s *NotificationStream) retryOutbox() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.outboxRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			if s.outbox.Stats().Pending > 0 {
+				if err := s.flushOutbox(context.Background()); err != nil {
+					s.log.WithError(err).WithField("pending", s.outbox.Stats().Pending).Warn("failed to replay outbox")
+				}
+			}
+		}
+	}
+}
+
+// Stats of the outbox, zero when no outbox is configured
+func (s *NotificationStream) OutboxStats() OutboxStats {
+	if s.outbox == nil {
+		return OutboxStats{}
+	}
+	return s.outbox.Stats()
+}
+
+// Returns the message value and the headers required by the encoding
+func (s *NotificationStream) encode(notifiable Notifiable) (interface{}, []kafka.Header, error) {
+	if !s.cloudEvents {
+		return &Envelope{
+			Name:     notifiable.NotificationType(),
+			Payload:  notifiable.Payload(),
+			Metadata: s.metadata,
+		}, nil, nil
+	}
+	if s.cloudEventsMode == CloudEventsBinary {
+		// data is the value, encoded with the writer codec
+		event, err := newCloudEvent(s.cloudEventsSource, notifiable, s.metadata, s.now().UTC(), s.writer.ContentType())
+		if err != nil {
+			return nil, nil, err
+		}
+		return notifiable.Payload(), event.headers(), nil
+	}
+	event, err := newCloudEvent(s.cloudEventsSource, notifiable, s.metadata, s.now().UTC(), JSONMimeType)
+	if err != nil {
+		return nil, nil, err
+	}
+	event.Data, err = json.Marshal(notifiable.Payload())
+	if err != nil {
+		return nil, nil, err
+	}
+	return event, []kafka.Header{{Key: HeaderContentType, Value: []byte(CloudEventsStructuredMimeType)}}, nil
+}
+
+func notificationHeaders(notifiable Notifiable) []kafka.Header {
+	headers := []kafka.Header{
+		{Key: HeaderNotificationType, Value: []byte(notifiable.NotificationType())},
+		{Key: HeaderSchemaVersion, Value: []byte(SchemaVersion)},
+	}
+	ids := []struct {
+		key string
+		id  *strfmt.UUID
+	}{
+		{HeaderClusterID, notifiable.GetClusterID()},
+		{HeaderInfraEnvID, notifiable.GetInfraEnvID()},
+		{HeaderHostID, notifiable.GetHostID()},
+	}
+	for _, resource := range ids {
+		if resource.id != nil {
+			headers = append(headers, kafka.Header{Key: resource.key, Value: []byte(resource.id.String())})
+		}
+	}
+	return headers
+}
+
+// Stops retrying and flushes the outbox before closing the writer. Entries
+// still failing are kept on disk and replayed by the next stream using it
 func (s *NotificationStream) Close() {
+	if s.quit != nil {
+		close(s.quit)
+		s.wg.Wait()
+	}
+	if s.outbox != nil {
+		if s.writer != nil && s.outbox.Stats().Pending > 0 {
+			if err := s.flushOutbox(context.Background()); err != nil {
+				s.log.WithError(err).WithField("pending", s.outbox.Stats().Pending).Warn("failed to flush outbox")
+			}
+		}
+		s.log.WithField("stats", s.outbox.Stats()).Info("closing outbox")
+		if err := s.outbox.Close(); err != nil {
+			s.log.WithError(err).Error("failed to close outbox")
+		}
+	}
 	if s.writer != nil {
 		s.writer.Close()
 	}
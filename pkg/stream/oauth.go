@@ -0,0 +1,155 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go/sasl"
+)
+
+const (
+	SaslMechanismOAuthBearer = "OAUTHBEARER"
+
+	oauthTokenRequestTimeout = 10 * time.Second
+)
+
+// OAuth client credentials settings, client ID and secret are read from
+// KAFKA_CLIENT_ID and KAFKA_CLIENT_SECRET
+type OAuthConfig struct {
+	OAuthTokenURL string   `envconfig:"KAFKA_OAUTH_TOKEN_URL" default:""`
+	OAuthScopes   []string `envconfig:"KAFKA_OAUTH_SCOPES" default:""`
+	// tokens are refreshed when they are going to expire within this margin
+	OAuthTokenRefreshMargin time.Duration `envconfig:"KAFKA_OAUTH_TOKEN_REFRESH_MARGIN" default:"1m"`
+}
+
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Fetches tokens with the OAuth client credentials grant, caching them until
+// they are about to expire
+type ClientCredentialsTokenSource struct {
+	tokenURL      string
+	clientID      string
+	clientSecret  string
+	scopes        []string
+	refreshMargin time.Duration
+	httpClient    *http.Client
+	now           func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func NewClientCredentialsTokenSource(tokenURL, clientID, clientSecret string, scopes []string, refreshMargin time.Duration) *ClientCredentialsTokenSource {
+	return &ClientCredentialsTokenSource{
+		tokenURL:      tokenURL,
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		scopes:        scopes,
+		refreshMargin: refreshMargin,
+		httpClient:    &http.Client{Timeout: oauthTokenRequestTimeout},
+		now:           time.Now,
+	}
+}
+
+// Returns cached token, or fetches a new one if missing or about to expire
+func (s *ClientCredentialsTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && s.now().Add(s.refreshMargin).Before(s.expiry) {
+		return s.token, nil
+	}
+	token, expiresIn, err := s.fetchToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	s.expiry = s.now().Add(expiresIn)
+	return s.token, nil
+}
+
+func (s *ClientCredentialsTokenSource) fetchToken(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to request oauth token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read oauth token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("oauth token request failed with status %d: %s", resp.StatusCode, body)
+	}
+	token := &tokenResponse{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return "", 0, fmt.Errorf("failed to decode oauth token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, fmt.Errorf("oauth token response has no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported oauth token type %s", token.TokenType)
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+// SASL OAUTHBEARER mechanism (RFC 7628) authenticating with tokens from the
+// given source
+type OAuthBearerMechanism struct {
+	tokenSource TokenSource
+}
+
+func NewOAuthBearerMechanism(tokenSource TokenSource) *OAuthBearerMechanism {
+	return &OAuthBearerMechanism{
+		tokenSource: tokenSource,
+	}
+}
+
+func (m *OAuthBearerMechanism) Name() string {
+	return SaslMechanismOAuthBearer
+}
+
+func (m *OAuthBearerMechanism) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	token, err := m.tokenSource.Token(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, []byte("n,,\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+// Server replies with an empty message on success, or with an error challenge
+func (m *OAuthBearerMechanism) Next(ctx context.Context, challenge []byte) (bool, []byte, error) {
+	if len(challenge) > 0 {
+		return false, nil, fmt.Errorf("OAUTHBEARER authentication failed: %s", challenge)
+	}
+	return true, nil, nil
+}
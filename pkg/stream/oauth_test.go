@@ -0,0 +1,114 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OAuth bearer authentication", func() {
+	var (
+		ctx         context.Context
+		server      *httptest.Server
+		requests    int32
+		status      int
+		expiresIn   int
+		now         time.Time
+		tokenSource *ClientCredentialsTokenSource
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		atomic.StoreInt32(&requests, 0)
+		status = http.StatusOK
+		expiresIn = 300
+		now = time.Now()
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&requests, 1)
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal(http.MethodPost))
+			Expect(r.ParseForm()).To(Succeed())
+			Expect(r.PostForm.Get("grant_type")).To(Equal("client_credentials"))
+			Expect(r.PostForm.Get("scope")).To(Equal("kafka openid"))
+			user, password, ok := r.BasicAuth()
+			Expect(ok).To(BeTrue())
+			Expect(user).To(Equal("my-client"))
+			Expect(password).To(Equal("my-secret"))
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+		}))
+		tokenSource = NewClientCredentialsTokenSource(server.URL, "my-client", "my-secret", []string{"kafka", "openid"}, time.Minute)
+		tokenSource.now = func() time.Time { return now }
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+	When("requesting a token", func() {
+		It("should cache it until it is about to expire", func() {
+			token, err := tokenSource.Token(ctx)
+			Expect(err).To(BeNil())
+			Expect(token).To(Equal("token-1"))
+
+			now = now.Add(3 * time.Minute)
+			token, err = tokenSource.Token(ctx)
+			Expect(err).To(BeNil())
+			Expect(token).To(Equal("token-1"))
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(1))
+
+			// within the refresh margin
+			now = now.Add(90 * time.Second)
+			token, err = tokenSource.Token(ctx)
+			Expect(err).To(BeNil())
+			Expect(token).To(Equal("token-2"))
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(2))
+		})
+		It("should fail when the token endpoint fails", func() {
+			status = http.StatusUnauthorized
+			_, err := tokenSource.Token(ctx)
+			Expect(err).To(MatchError(ContainSubstring("status 401")))
+		})
+	})
+	When("authenticating", func() {
+		It("should send the token as initial response", func() {
+			mechanism := NewOAuthBearerMechanism(tokenSource)
+			Expect(mechanism.Name()).To(Equal("OAUTHBEARER"))
+			sess, ir, err := mechanism.Start(ctx)
+			Expect(err).To(BeNil())
+			Expect(string(ir)).To(Equal("n,,\x01auth=Bearer token-1\x01\x01"))
+
+			done, _, err := sess.Next(ctx, []byte{})
+			Expect(err).To(BeNil())
+			Expect(done).To(BeTrue())
+		})
+		It("should fail when the server returns an error challenge", func() {
+			mechanism := NewOAuthBearerMechanism(tokenSource)
+			sess, _, err := mechanism.Start(ctx)
+			Expect(err).To(BeNil())
+
+			_, _, err = sess.Next(ctx, []byte(`{"status":"invalid_token"}`))
+			Expect(err).To(MatchError(ContainSubstring("invalid_token")))
+		})
+		It("should be configured from kafka config", func() {
+			config := &KafkaConfig{
+				ClientID:      "my-client",
+				ClientSecret:  "my-secret",
+				SaslMechanism: SaslMechanismOAuthBearer,
+			}
+			_, err := getMechanism(config)
+			Expect(err).To(MatchError(ContainSubstring("KAFKA_OAUTH_TOKEN_URL")))
+
+			config.OAuthTokenURL = server.URL
+			config.OAuthScopes = []string{"kafka", "openid"}
+			mechanism, err := getMechanism(config)
+			Expect(err).To(BeNil())
+			_, ir, err := mechanism.Start(ctx)
+			Expect(err).To(BeNil())
+			Expect(string(ir)).To(ContainSubstring("auth=Bearer token-1"))
+		})
+	})
+})
@@ -0,0 +1,203 @@
+package stream
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Header of the copies of a message acked separately, with the id of their
+// group and its size
+const HeaderAckGroup = "x-ack-group"
+
+var lastAckGroupID atomic.Uint64
+
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+type partitionOffsets struct {
+	// offsets fetched and not committable yet, in fetch order
+	pending []int64
+	// offsets fetched and not acked yet
+	inFlight map[int64]struct{}
+	// acked messages waiting for lower offsets to be acked
+	acked map[int64]kafka.Message
+	// copies not acked yet of each ack group of in-flight offsets
+	groups map[int64]map[string]int
+}
+
+type PartitionOffsetStats struct {
+	Topic     string
+	Partition int
+	// messages fetched whose offset cannot be committed yet
+	Pending int
+	// messages fetched and not acked yet
+	InFlight int
+	// lowest offset holding back the commit, -1 if none
+	LowestPending int64
+}
+
+// Tracks fetched offsets per partition, so that only the highest offset
+// below which every message has been acked gets committed, no matter the
+// order acks arrive in
+type OffsetTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionOffsets
+}
+
+func NewOffsetTracker() *OffsetTracker {
+	return &OffsetTracker{
+		partitions: map[topicPartition]*partitionOffsets{},
+	}
+}
+
+func newPartitionOffsets() *partitionOffsets {
+	return &partitionOffsets{
+		pending:  []int64{},
+		inFlight: map[int64]struct{}{},
+		acked:    map[int64]kafka.Message{},
+		groups:   map[int64]map[string]int{},
+	}
+}
+
+// Registers a fetched message. Messages must be tracked in fetch order; an
+// offset lower or equal than the last tracked one for the same partition
+// (i.e. after a rebalance or an offset reset) discards previous state for it
+func (t *OffsetTracker) Track(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	offsets, ok := t.partitions[key]
+	if !ok || (len(offsets.pending) > 0 && msg.Offset <= offsets.pending[len(offsets.pending)-1]) {
+		offsets = newPartitionOffsets()
+		t.partitions[key] = offsets
+	}
+	offsets.pending = append(offsets.pending, msg.Offset)
+	offsets.inFlight[msg.Offset] = struct{}{}
+}
+
+// Marks a message as processed. Returns the message to commit, if acking it
+// made the contiguous range of acked offsets grow. Acks for messages not
+// being tracked (i.e. duplicated acks) are ignored, copies of an ack group
+// only ack the message once all of them are
+func (t *OffsetTracker) Ack(msg kafka.Message) (kafka.Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	offsets, ok := t.partitions[key]
+	if !ok {
+		return kafka.Message{}, false
+	}
+	if _, ok := offsets.inFlight[msg.Offset]; !ok {
+		return kafka.Message{}, false
+	}
+	if id, size, ok := getAckGroup(&msg); ok {
+		groups, ok := offsets.groups[msg.Offset]
+		if !ok {
+			groups = map[string]int{}
+			offsets.groups[msg.Offset] = groups
+		}
+		remaining, ok := groups[id]
+		if !ok {
+			remaining = size
+		}
+		if remaining--; remaining > 0 {
+			groups[id] = remaining
+			return kafka.Message{}, false
+		}
+	}
+	delete(offsets.inFlight, msg.Offset)
+	delete(offsets.groups, msg.Offset)
+	offsets.acked[msg.Offset] = msg
+
+	var commit kafka.Message
+	found := false
+	for len(offsets.pending) > 0 {
+		acked, ok := offsets.acked[offsets.pending[0]]
+		if !ok {
+			break
+		}
+		delete(offsets.acked, offsets.pending[0])
+		offsets.pending = offsets.pending[1:]
+		commit = acked
+		found = true
+	}
+	return commit, found
+}
+
+// Total number of fetched messages whose offset cannot be committed yet
+func (t *OffsetTracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := 0
+	for _, offsets := range t.partitions {
+		pending += len(offsets.pending)
+	}
+	return pending
+}
+
+func (t *OffsetTracker) Stats() []PartitionOffsetStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := []PartitionOffsetStats{}
+	for key, offsets := range t.partitions {
+		lowestPending := int64(-1)
+		if len(offsets.pending) > 0 {
+			lowestPending = offsets.pending[0]
+		}
+		stats = append(stats, PartitionOffsetStats{
+			Topic:         key.topic,
+			Partition:     key.partition,
+			Pending:       len(offsets.pending),
+			InFlight:      len(offsets.inFlight),
+			LowestPending: lowestPending,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Topic != stats[j].Topic {
+			return stats[i].Topic < stats[j].Topic
+		}
+		return stats[i].Partition < stats[j].Partition
+	})
+	return stats
+}
+
+// Copies of the message for writes acked separately: the message is acked once
+// every copy is. Copies of a failed attempt never complete their group, so
+// they don't ack retries of the message
+func SplitAck(msg *kafka.Message, writes int) []*kafka.Message {
+	if writes < 2 {
+		return []*kafka.Message{msg}
+	}
+	group := fmt.Sprintf("%d/%d", lastAckGroupID.Add(1), writes)
+	copies := make([]*kafka.Message, writes)
+	for i := range copies {
+		copied := *msg
+		copied.Headers = append(msg.Headers[:len(msg.Headers):len(msg.Headers)], kafka.Header{Key: HeaderAckGroup, Value: []byte(group)})
+		copies[i] = &copied
+	}
+	return copies
+}
+
+func getAckGroup(msg *kafka.Message) (string, int, bool) {
+	group, ok := GetHeader(msg, HeaderAckGroup)
+	if !ok {
+		return "", 0, false
+	}
+	id, sizeValue, ok := strings.Cut(group, "/")
+	if !ok {
+		return "", 0, false
+	}
+	size, err := strconv.Atoi(sizeValue)
+	if err != nil {
+		return "", 0, false
+	}
+	return id, size, true
+}
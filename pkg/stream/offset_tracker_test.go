@@ -0,0 +1,143 @@
+package stream
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+var _ = Describe("Offset tracker", func() {
+	var (
+		tracker *OffsetTracker
+	)
+	message := func(partition int, offset int64) kafka.Message {
+		return kafka.Message{Topic: "events", Partition: partition, Offset: offset}
+	}
+	BeforeEach(func() {
+		tracker = NewOffsetTracker()
+	})
+
+	When("acks arrive in order", func() {
+		It("should commit every acked message", func() {
+			tracker.Track(message(0, 1))
+			tracker.Track(message(0, 2))
+
+			commit, ok := tracker.Ack(message(0, 1))
+			Expect(ok).To(BeTrue())
+			Expect(commit.Offset).To(BeEquivalentTo(1))
+
+			commit, ok = tracker.Ack(message(0, 2))
+			Expect(ok).To(BeTrue())
+			Expect(commit.Offset).To(BeEquivalentTo(2))
+			Expect(tracker.Pending()).To(Equal(0))
+		})
+	})
+
+	When("acks arrive out of order", func() {
+		It("should only commit the highest contiguous acked offset", func() {
+			tracker.Track(message(0, 1))
+			tracker.Track(message(0, 2))
+			tracker.Track(message(0, 5))
+
+			_, ok := tracker.Ack(message(0, 5))
+			Expect(ok).To(BeFalse())
+			_, ok = tracker.Ack(message(0, 2))
+			Expect(ok).To(BeFalse())
+			Expect(tracker.Pending()).To(Equal(3))
+			Expect(tracker.Stats()).To(ConsistOf(PartitionOffsetStats{
+				Topic:         "events",
+				Partition:     0,
+				Pending:       3,
+				InFlight:      1,
+				LowestPending: 1,
+			}))
+
+			commit, ok := tracker.Ack(message(0, 1))
+			Expect(ok).To(BeTrue())
+			Expect(commit.Offset).To(BeEquivalentTo(5))
+			Expect(tracker.Pending()).To(Equal(0))
+		})
+	})
+
+	When("tracking several partitions", func() {
+		It("should track them independently", func() {
+			tracker.Track(message(0, 10))
+			tracker.Track(message(1, 3))
+			tracker.Track(message(1, 4))
+
+			commit, ok := tracker.Ack(message(1, 3))
+			Expect(ok).To(BeTrue())
+			Expect(commit.Partition).To(Equal(1))
+			Expect(commit.Offset).To(BeEquivalentTo(3))
+
+			Expect(tracker.Stats()).To(Equal([]PartitionOffsetStats{
+				{Topic: "events", Partition: 0, Pending: 1, InFlight: 1, LowestPending: 10},
+				{Topic: "events", Partition: 1, Pending: 1, InFlight: 1, LowestPending: 4},
+			}))
+		})
+	})
+
+	When("a message is acked twice or was never tracked", func() {
+		It("should ignore the ack", func() {
+			tracker.Track(message(0, 1))
+			_, ok := tracker.Ack(message(0, 1))
+			Expect(ok).To(BeTrue())
+
+			_, ok = tracker.Ack(message(0, 1))
+			Expect(ok).To(BeFalse())
+			_, ok = tracker.Ack(message(3, 1))
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	When("a message is split in an ack group", func() {
+		It("should only ack it once every copy is acked", func() {
+			msg := message(0, 1)
+			tracker.Track(msg)
+			copies := SplitAck(&msg, 2)
+			Expect(copies).To(HaveLen(2))
+
+			_, ok := tracker.Ack(*copies[0])
+			Expect(ok).To(BeFalse())
+			_, ok = tracker.Ack(*copies[1])
+			Expect(ok).To(BeTrue())
+			Expect(tracker.Pending()).To(Equal(0))
+		})
+
+		It("should not count copies of another attempt", func() {
+			msg := message(0, 1)
+			tracker.Track(msg)
+			failed := SplitAck(&msg, 2)
+			retried := SplitAck(&msg, 2)
+
+			_, ok := tracker.Ack(*failed[0])
+			Expect(ok).To(BeFalse())
+			_, ok = tracker.Ack(*retried[0])
+			Expect(ok).To(BeFalse())
+			_, ok = tracker.Ack(*retried[1])
+			Expect(ok).To(BeTrue())
+		})
+
+		It("should still ack it right away when the message itself is acked", func() {
+			msg := message(0, 1)
+			tracker.Track(msg)
+			SplitAck(&msg, 2)
+
+			_, ok := tracker.Ack(msg)
+			Expect(ok).To(BeTrue())
+		})
+	})
+
+	When("the partition is rewound", func() {
+		It("should discard previous state for it", func() {
+			tracker.Track(message(0, 7))
+			tracker.Track(message(0, 8))
+			tracker.Track(message(0, 3))
+			Expect(tracker.Pending()).To(Equal(1))
+
+			commit, ok := tracker.Ack(message(0, 3))
+			Expect(ok).To(BeTrue())
+			Expect(commit.Offset).To(BeEquivalentTo(3))
+		})
+	})
+})
@@ -0,0 +1,266 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/kelseyhightower/envconfig"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+var ErrOutboxFull = errors.New("outbox is full")
+
+type OutboxConfig struct {
+	OutboxPath string `envconfig:"NOTIFICATION_OUTBOX_PATH" default:""`
+	// max size of pending entries on disk, new entries are rejected when reached
+	OutboxMaxBytes int64 `envconfig:"NOTIFICATION_OUTBOX_MAX_BYTES" default:"104857600"`
+}
+
+// Message which could not be written, value is already encoded with the
+// writer codec and replayed as is
+type OutboxEntry struct {
+	Key     []byte         `json:"key"`
+	Value   []byte         `json:"value"`
+	Headers []kafka.Header `json:"headers,omitempty"`
+}
+
+type OutboxStats struct {
+	Pending      int
+	PendingBytes int64
+	Appended     uint64
+	Replayed     uint64
+	Rejected     uint64
+}
+
+//go:generate mockgen -source=outbox.go -package=stream -destination=mock_outbox.go
+
+type Outbox interface {
+	Append(entry OutboxEntry) error
+	// Calls fn on pending entries in order, stopping at the first failure.
+	// Delivered entries are removed. Entries appended meanwhile are kept after
+	// them, appending doesn't wait for fn
+	Replay(fn func(entry OutboxEntry) error) error
+	Stats() OutboxStats
+	Close() error
+}
+
+// Append-only file outbox, one json encoded entry per line. Pending entries are
+// kept in memory as well and the file is rewritten when entries are replayed.
+type FileOutbox struct {
+	// held while replaying, mu only while updating entries
+	replayMu sync.Mutex
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	maxBytes int64
+	entries  [][]byte
+	size     int64
+	stats    OutboxStats
+}
+
+// Returns an outbox if NOTIFICATION_OUTBOX_PATH is set, nil otherwise
+func NewFileOutboxFromEnv() (Outbox, error) {
+	config := &OutboxConfig{}
+	err := envconfig.Process("", config)
+	if err != nil {
+		return nil, err
+	}
+	if config.OutboxPath == "" {
+		return nil, nil
+	}
+	outbox, err := NewFileOutbox(config.OutboxPath, config.OutboxMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return outbox, nil
+}
+
+// Opens the outbox at path, entries left pending by a previous run are loaded
+func NewFileOutbox(path string, maxBytes int64) (*FileOutbox, error) {
+	o := &FileOutbox{
+		path:     path,
+		maxBytes: maxBytes,
+	}
+	complete, err := o.load()
+	if err != nil {
+		return nil, err
+	}
+	// entries are appended after the last complete line
+	if !complete {
+		if err := o.rewrite(); err != nil {
+			return nil, err
+		}
+		return o, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox %s: %w", path, err)
+	}
+	o.file = file
+	return o, nil
+}
+
+// Loads pending entries, returns whether the file only holds complete ones
+func (o *FileOutbox) load() (bool, error) {
+	file, err := os.Open(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open outbox %s: %w", o.path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to open outbox %s: %w", o.path, err)
+	}
+	complete := true
+	var read int64
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), int(MaxBytes))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		read += int64(len(line)) + 1
+		// a partially written last line is discarded
+		if !json.Valid(line) {
+			complete = false
+			continue
+		}
+		o.push(append([]byte{}, line...))
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	// the last line is missing its newline
+	if read != info.Size() {
+		complete = false
+	}
+	return complete, nil
+}
+
+func (o *FileOutbox) push(line []byte) {
+	o.entries = append(o.entries, line)
+	o.size += int64(len(line)) + 1
+}
+
+// Persists the entry, synced to disk before returning
+func (o *FileOutbox) Append(entry OutboxEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.maxBytes > 0 && o.size+int64(len(line))+1 > o.maxBytes {
+		o.stats.Rejected++
+		return ErrOutboxFull
+	}
+	if _, err := o.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append to outbox: %w", err)
+	}
+	if err := o.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync outbox: %w", err)
+	}
+	o.push(line)
+	o.stats.Appended++
+	return nil
+}
+
+func (o *FileOutbox) Replay(fn func(entry OutboxEntry) error) error {
+	o.replayMu.Lock()
+	defer o.replayMu.Unlock()
+	// entries are only removed while replaying, appending doesn't change them
+	o.mu.Lock()
+	pending := o.entries
+	o.mu.Unlock()
+
+	delivered := 0
+	var replayErr error
+	for _, line := range pending {
+		entry := OutboxEntry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			replayErr = fmt.Errorf("failed to decode outbox entry: %w", err)
+			break
+		}
+		if err := fn(entry); err != nil {
+			replayErr = err
+			break
+		}
+		delivered++
+	}
+	if delivered == 0 {
+		return replayErr
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stats.Replayed += uint64(delivered)
+	remaining := o.entries[delivered:]
+	o.entries = nil
+	o.size = 0
+	for _, line := range remaining {
+		o.push(line)
+	}
+	if err := o.rewrite(); err != nil {
+		return err
+	}
+	return replayErr
+}
+
+// Replaces the file with pending entries
+func (o *FileOutbox) rewrite() error {
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to rewrite outbox: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriter(tmp)
+	for _, line := range o.entries {
+		writer.Write(line)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to rewrite outbox: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync outbox: %w", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return fmt.Errorf("failed to rewrite outbox: %w", err)
+	}
+	if o.file != nil {
+		o.file.Close()
+	}
+	file, err := os.OpenFile(o.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open outbox %s: %w", o.path, err)
+	}
+	o.file = file
+	return nil
+}
+
+func (o *FileOutbox) Stats() OutboxStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	stats := o.stats
+	stats.Pending = len(o.entries)
+	stats.PendingBytes = o.size
+	return stats
+}
+
+func (o *FileOutbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.file.Sync(); err != nil {
+		o.file.Close()
+		return err
+	}
+	return o.file.Close()
+}
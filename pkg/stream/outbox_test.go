@@ -0,0 +1,335 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Outbox", func() {
+	var (
+		tmpdir string
+		path   string
+	)
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = os.MkdirTemp("", ".outbox-")
+		Expect(err).To(BeNil())
+		path = filepath.Join(tmpdir, "outbox.jsonl")
+	})
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	newEntry := func(key string) OutboxEntry {
+		return OutboxEntry{
+			Key:     []byte(key),
+			Value:   []byte(`{"name":"` + key + `"}`),
+			Headers: []kafka.Header{{Key: HeaderNotificationType, Value: []byte("ClusterState")}},
+		}
+	}
+	replayKeys := func(outbox *FileOutbox, failAt int) ([]string, error) {
+		keys := []string{}
+		err := outbox.Replay(func(entry OutboxEntry) error {
+			if len(keys) == failAt {
+				return errors.New("kafka unavailable")
+			}
+			keys = append(keys, string(entry.Key))
+			return nil
+		})
+		return keys, err
+	}
+
+	When("replaying entries", func() {
+		It("should deliver them in order and keep the failed ones", func() {
+			outbox, err := NewFileOutbox(path, 0)
+			Expect(err).To(BeNil())
+			for _, key := range []string{"a", "b", "c"} {
+				Expect(outbox.Append(newEntry(key))).To(Succeed())
+			}
+
+			keys, err := replayKeys(outbox, 1)
+			Expect(err).To(MatchError("kafka unavailable"))
+			Expect(keys).To(Equal([]string{"a"}))
+			stats := outbox.Stats()
+			Expect(stats.Pending).To(Equal(2))
+			Expect(stats.Appended).To(BeEquivalentTo(3))
+			Expect(stats.Replayed).To(BeEquivalentTo(1))
+
+			keys, err = replayKeys(outbox, -1)
+			Expect(err).To(BeNil())
+			Expect(keys).To(Equal([]string{"b", "c"}))
+			Expect(outbox.Stats().Pending).To(BeZero())
+			Expect(outbox.Stats().PendingBytes).To(BeZero())
+			Expect(outbox.Close()).To(Succeed())
+		})
+	})
+
+	When("the outbox is reopened", func() {
+		It("should load pending entries", func() {
+			outbox, err := NewFileOutbox(path, 0)
+			Expect(err).To(BeNil())
+			Expect(outbox.Append(newEntry("a"))).To(Succeed())
+			Expect(outbox.Append(newEntry("b"))).To(Succeed())
+			_, err = replayKeys(outbox, 1)
+			Expect(err).To(HaveOccurred())
+			Expect(outbox.Append(newEntry("c"))).To(Succeed())
+			Expect(outbox.Close()).To(Succeed())
+
+			outbox, err = NewFileOutbox(path, 0)
+			Expect(err).To(BeNil())
+			Expect(outbox.Stats().Pending).To(Equal(2))
+			keys, err := replayKeys(outbox, -1)
+			Expect(err).To(BeNil())
+			Expect(keys).To(Equal([]string{"b", "c"}))
+			Expect(outbox.Close()).To(Succeed())
+		})
+	})
+
+	When("the last line was partially written", func() {
+		It("should append new entries after the complete ones", func() {
+			line, err := json.Marshal(newEntry("a"))
+			Expect(err).To(BeNil())
+			Expect(os.WriteFile(path, append(append(line, '\n'), line[:len(line)/2]...), 0600)).To(Succeed())
+
+			outbox, err := NewFileOutbox(path, 0)
+			Expect(err).To(BeNil())
+			Expect(outbox.Stats().Pending).To(Equal(1))
+			Expect(outbox.Append(newEntry("b"))).To(Succeed())
+			Expect(outbox.Close()).To(Succeed())
+
+			outbox, err = NewFileOutbox(path, 0)
+			Expect(err).To(BeNil())
+			keys, err := replayKeys(outbox, -1)
+			Expect(err).To(BeNil())
+			Expect(keys).To(Equal([]string{"a", "b"}))
+			Expect(outbox.Close()).To(Succeed())
+		})
+	})
+
+	When("the outbox is full", func() {
+		It("should reject new entries", func() {
+			line, err := json.Marshal(newEntry("a"))
+			Expect(err).To(BeNil())
+			outbox, err := NewFileOutbox(path, int64(len(line)+1))
+			Expect(err).To(BeNil())
+			Expect(outbox.Append(newEntry("a"))).To(Succeed())
+			Expect(outbox.Append(newEntry("b"))).To(MatchError(ErrOutboxFull))
+			Expect(outbox.Stats().Rejected).To(BeEquivalentTo(1))
+			Expect(outbox.Close()).To(Succeed())
+		})
+	})
+
+	When("notifying through an outbox", func() {
+		var (
+			ctx          context.Context
+			ctrl         *gomock.Controller
+			mockWriter   *MockEventStreamWriter
+			notification *NotificationStream
+			outbox       *FileOutbox
+			written      []string
+		)
+		BeforeEach(func() {
+			var err error
+			ctx = context.Background()
+			ctrl = gomock.NewController(GinkgoT())
+			logger := logrus.New()
+			logger.Out = io.Discard
+			mockWriter = NewMockEventStreamWriter(ctrl)
+			outbox, err = NewFileOutbox(path, 0)
+			Expect(err).To(BeNil())
+			notification = NewNotificationStream(mockWriter, logger, nil, WithOutbox(outbox, 0))
+			written = []string{}
+			mockWriter.EXPECT().Encode(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, value interface{}) ([]byte, error) {
+					return json.Marshal(value)
+				}).AnyTimes()
+		})
+		AfterEach(func() {
+			ctrl.Finish()
+		})
+
+		notifiable := func(id string) Notifiable {
+			clusterID := strfmt.UUID(id)
+			mockNotifiable := NewMockNotifiable(ctrl)
+			mockNotifiable.EXPECT().GetClusterID().Return(&clusterID).AnyTimes()
+			mockNotifiable.EXPECT().GetInfraEnvID().Return(nil).AnyTimes()
+			mockNotifiable.EXPECT().GetHostID().Return(nil).AnyTimes()
+			mockNotifiable.EXPECT().NotificationType().Return("ClusterState").AnyTimes()
+			mockNotifiable.EXPECT().Payload().Return(map[string]string{"id": id}).AnyTimes()
+			return mockNotifiable
+		}
+		writeSucceeds := func() *gomock.Call {
+			return mockWriter.EXPECT().WriteEncoded(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, key []byte, encodedValue []byte, headers ...kafka.Header) error {
+					written = append(written, string(key))
+					return nil
+				})
+		}
+		writeFails := func() *gomock.Call {
+			return mockWriter.EXPECT().WriteEncoded(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("kafka unavailable"))
+		}
+
+		It("should store failed notifications and replay them in order", func() {
+			gomock.InOrder(
+				writeFails(),
+				// outbox replay fails, second notification is queued after the first one
+				writeFails(),
+				writeSucceeds().Times(3),
+			)
+			Expect(notification.Notify(ctx, notifiable("a"))).To(Succeed())
+			Expect(notification.Notify(ctx, notifiable("b"))).To(Succeed())
+			Expect(notification.OutboxStats().Pending).To(Equal(2))
+
+			Expect(notification.Notify(ctx, notifiable("c"))).To(Succeed())
+			Expect(written).To(Equal([]string{"a", "b", "c"}))
+			Expect(notification.OutboxStats().Pending).To(BeZero())
+			Expect(notification.OutboxStats().Replayed).To(BeEquivalentTo(2))
+		})
+
+		It("should replay values encoded by the writer codec unchanged", func() {
+			schema := &Schema{ID: 3, Schema: `{"type":"record","name":"Envelope","fields":[
+				{"name":"Name","type":"string"},
+				{"name":"Payload","type":{"type":"map","values":"string"}},
+				{"name":"Metadata","type":["null","string"],"default":null}]}`}
+			mockRegistry := NewMockSchemaRegistry(ctrl)
+			mockRegistry.EXPECT().GetLatestSchema(gomock.Any(), "notifications-value").Return(schema, nil).AnyTimes()
+			mockProducer := NewMockProducer(ctrl)
+			logger := logrus.New()
+			logger.Out = io.Discard
+			writer := &KafkaWriter{
+				producer: mockProducer,
+				logger:   logger,
+				codec:    NewAvroCodec(mockRegistry, "notifications-value"),
+			}
+			notification = NewNotificationStream(writer, logger, nil, WithOutbox(outbox, 0))
+			var failed kafka.Message
+			produced := []kafka.Message{}
+			gomock.InOrder(
+				mockProducer.EXPECT().WriteMessages(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, msgs ...kafka.Message) error {
+						failed = msgs[0]
+						return errors.New("kafka unavailable")
+					}),
+				mockProducer.EXPECT().WriteMessages(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, msgs ...kafka.Message) error {
+						produced = append(produced, msgs...)
+						return nil
+					}).Times(2),
+			)
+			Expect(notification.Notify(ctx, notifiable("a"))).To(Succeed())
+			Expect(IsFramed(failed.Value)).To(BeTrue())
+
+			Expect(notification.Notify(ctx, notifiable("b"))).To(Succeed())
+			Expect(produced).To(HaveLen(2))
+			Expect(string(produced[0].Key)).To(Equal("a"))
+			Expect(produced[0].Value).To(Equal(failed.Value))
+			Expect(produced[0].Headers).To(Equal(failed.Headers))
+		})
+
+		It("should flush pending notifications on close", func() {
+			gomock.InOrder(
+				writeFails(),
+				writeSucceeds(),
+			)
+			mockWriter.EXPECT().Close().Times(1)
+			Expect(notification.Notify(ctx, notifiable("a"))).To(Succeed())
+
+			notification.Close()
+			Expect(written).To(Equal([]string{"a"}))
+			content, err := os.ReadFile(path)
+			Expect(err).To(BeNil())
+			Expect(content).To(BeEmpty())
+		})
+
+		It("should queue notifications behind pending ones without replaying them when retried in background", func() {
+			logger := logrus.New()
+			logger.Out = io.Discard
+			notification = NewNotificationStream(mockWriter, logger, nil, WithOutbox(outbox, time.Hour))
+			gomock.InOrder(
+				writeFails(),
+				writeSucceeds().Times(2),
+			)
+			mockWriter.EXPECT().Close().Times(1)
+			Expect(notification.Notify(ctx, notifiable("a"))).To(Succeed())
+			Expect(notification.Notify(ctx, notifiable("b"))).To(Succeed())
+			Expect(notification.OutboxStats().Pending).To(Equal(2))
+
+			notification.Close()
+			Expect(written).To(Equal([]string{"a", "b"}))
+		})
+
+		It("should not wait for other notifications being written", func() {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			mockWriter.EXPECT().WriteEncoded(gomock.Any(), []byte("a"), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, key []byte, encodedValue []byte, headers ...kafka.Header) error {
+					close(started)
+					<-release
+					return nil
+				})
+			mockWriter.EXPECT().WriteEncoded(gomock.Any(), []byte("b"), gomock.Any(), gomock.Any()).Return(nil)
+			notified := make(chan error, 1)
+			go func() {
+				notified <- notification.Notify(ctx, notifiable("a"))
+			}()
+			Eventually(started).Should(BeClosed())
+
+			Expect(notification.Notify(ctx, notifiable("b"))).To(Succeed())
+			close(release)
+			Eventually(notified).Should(Receive(BeNil()))
+		})
+
+		It("should keep pending notifications on disk when flushing on close fails", func() {
+			writeFails().Times(2)
+			mockWriter.EXPECT().Close().Times(1)
+			Expect(notification.Notify(ctx, notifiable("a"))).To(Succeed())
+
+			notification.Close()
+			reopened, err := NewFileOutbox(path, 0)
+			Expect(err).To(BeNil())
+			Expect(reopened.Stats().Pending).To(Equal(1))
+			Expect(reopened.Close()).To(Succeed())
+		})
+	})
+
+	When("no outbox is configured in env", func() {
+		It("should notify without outbox", func() {
+			os.Unsetenv("NOTIFICATION_OUTBOX_PATH")
+			outbox, err := NewFileOutboxFromEnv()
+			Expect(err).To(BeNil())
+			Expect(outbox).To(BeNil())
+
+			ctrl := gomock.NewController(GinkgoT())
+			defer ctrl.Finish()
+			logger := logrus.New()
+			logger.Out = io.Discard
+			mockWriter := NewMockEventStreamWriter(ctrl)
+			mockWriter.EXPECT().Write(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+			mockWriter.EXPECT().Close().Times(1)
+			notification := NewNotificationStream(mockWriter, logger, nil, WithOutbox(outbox, time.Hour))
+			clusterID := strfmt.UUID("a")
+			mockNotifiable := NewMockNotifiable(ctrl)
+			mockNotifiable.EXPECT().GetClusterID().Return(&clusterID).AnyTimes()
+			mockNotifiable.EXPECT().GetInfraEnvID().Return(nil).AnyTimes()
+			mockNotifiable.EXPECT().GetHostID().Return(nil).AnyTimes()
+			mockNotifiable.EXPECT().NotificationType().Return("ClusterState").AnyTimes()
+			mockNotifiable.EXPECT().Payload().Return(nil).AnyTimes()
+
+			Expect(notification.Notify(context.Background(), mockNotifiable)).To(Succeed())
+			Expect(notification.OutboxStats()).To(BeZero())
+			notification.Close()
+		})
+	})
+})
@@ -0,0 +1,124 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// Implemented by generated protobuf message types
+type ProtoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type ProtoUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+// Protobuf values framed with the schema ID and message indexes of the
+// confluent wire format. Values must be generated message types, the codec
+// only resolves schema IDs and does not validate messages against them
+type ProtobufCodec struct {
+	registry SchemaRegistry
+	subject  string
+	// path of the message type within the schema, first message by default
+	messageIndexes []int
+}
+
+func NewProtobufCodec(registry SchemaRegistry, subject string, messageIndexes ...int) *ProtobufCodec {
+	return &ProtobufCodec{
+		registry:       registry,
+		subject:        subject,
+		messageIndexes: messageIndexes,
+	}
+}
+
+func (c *ProtobufCodec) Name() string {
+	return CodecProtobuf
+}
+
+func (c *ProtobufCodec) ContentType() string {
+	return ProtobufMimeType
+}
+
+func (c *ProtobufCodec) Marshal(ctx context.Context, value interface{}) ([]byte, error) {
+	message, ok := value.(ProtoMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a protobuf message", value)
+	}
+	schema, err := c.registry.GetLatestSchema(ctx, c.subject)
+	if err != nil {
+		return nil, err
+	}
+	if schema.Type() != SchemaTypeProtobuf {
+		return nil, fmt.Errorf("schema %d is %s, not %s", schema.ID, schema.Type(), SchemaTypeProtobuf)
+	}
+	payload, err := message.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	writeMessageIndexes(buf, c.messageIndexes)
+	buf.Write(payload)
+	return frame(schema.ID, buf.Bytes()), nil
+}
+
+func (c *ProtobufCodec) Unmarshal(ctx context.Context, data []byte, value interface{}) error {
+	message, ok := value.(ProtoUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%T is not a protobuf message", value)
+	}
+	schemaID, payload, err := unframe(data)
+	if err != nil {
+		return err
+	}
+	schema, err := c.registry.GetSchemaByID(ctx, schemaID)
+	if err != nil {
+		return err
+	}
+	if schema.Type() != SchemaTypeProtobuf {
+		return fmt.Errorf("schema %d is %s, not %s", schema.ID, schema.Type(), SchemaTypeProtobuf)
+	}
+	reader := bytes.NewReader(payload)
+	if _, err := readMessageIndexes(reader); err != nil {
+		return err
+	}
+	return message.Unmarshal(payload[len(payload)-reader.Len():])
+}
+
+// Message indexes are a zigzag varint count followed by the indexes, [0] is
+// written as a single 0 byte
+func writeMessageIndexes(w *bytes.Buffer, indexes []int) {
+	if len(indexes) == 0 || (len(indexes) == 1 && indexes[0] == 0) {
+		w.Write(binary.AppendVarint(nil, 0))
+		return
+	}
+	buf := binary.AppendVarint(nil, int64(len(indexes)))
+	for _, index := range indexes {
+		buf = binary.AppendVarint(buf, int64(index))
+	}
+	w.Write(buf)
+}
+
+func readMessageIndexes(r *bytes.Reader) ([]int, error) {
+	count, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message indexes: %w", err)
+	}
+	if count == 0 {
+		return []int{0}, nil
+	}
+	if count < 0 || count > int64(r.Len()) {
+		return nil, fmt.Errorf("invalid message indexes count %d", count)
+	}
+	indexes := make([]int, 0, count)
+	for i := int64(0); i < count; i++ {
+		index, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message indexes: %w", err)
+		}
+		indexes = append(indexes, int(index))
+	}
+	return indexes, nil
+}
@@ -0,0 +1,85 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// message with a single string field 1, as generated code would encode it
+type testProtoMessage struct {
+	ID string
+}
+
+func (m *testProtoMessage) Marshal() ([]byte, error) {
+	return append([]byte{0x0a, byte(len(m.ID))}, m.ID...), nil
+}
+
+func (m *testProtoMessage) Unmarshal(data []byte) error {
+	if len(data) < 2 || data[0] != 0x0a || int(data[1]) != len(data)-2 {
+		return fmt.Errorf("invalid message")
+	}
+	m.ID = string(data[2:])
+	return nil
+}
+
+var _ = Describe("Protobuf codec", func() {
+	var (
+		ctx          context.Context
+		ctrl         *gomock.Controller
+		mockRegistry *MockSchemaRegistry
+		schema       *Schema
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		ctrl = gomock.NewController(GinkgoT())
+		mockRegistry = NewMockSchemaRegistry(ctrl)
+		schema = &Schema{ID: 9, SchemaType: SchemaTypeProtobuf, Schema: `syntax = "proto3"; message Cluster { string id = 1; }`}
+	})
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	When("encoding the first message of the schema", func() {
+		It("should write a single message index byte", func() {
+			codec := NewProtobufCodec(mockRegistry, "events-value")
+			mockRegistry.EXPECT().GetLatestSchema(ctx, "events-value").Return(schema, nil)
+			mockRegistry.EXPECT().GetSchemaByID(ctx, 9).Return(schema, nil)
+
+			data, err := codec.Marshal(ctx, &testProtoMessage{ID: "foo"})
+			Expect(err).To(BeNil())
+			Expect(data).To(Equal([]byte{0, 0, 0, 0, 9, 0, 0x0a, 3, 'f', 'o', 'o'}))
+
+			decoded := &testProtoMessage{}
+			Expect(codec.Unmarshal(ctx, data, decoded)).To(Succeed())
+			Expect(decoded.ID).To(Equal("foo"))
+		})
+	})
+
+	When("encoding a nested message", func() {
+		It("should write the message indexes", func() {
+			codec := NewProtobufCodec(mockRegistry, "events-value", 1, 0)
+			mockRegistry.EXPECT().GetLatestSchema(ctx, "events-value").Return(schema, nil)
+			mockRegistry.EXPECT().GetSchemaByID(ctx, 9).Return(schema, nil)
+
+			data, err := codec.Marshal(ctx, &testProtoMessage{ID: "foo"})
+			Expect(err).To(BeNil())
+			Expect(data[5:8]).To(Equal([]byte{4, 2, 0}))
+
+			decoded := &testProtoMessage{}
+			Expect(codec.Unmarshal(ctx, data, decoded)).To(Succeed())
+			Expect(decoded.ID).To(Equal("foo"))
+		})
+	})
+
+	When("the value is not a protobuf message", func() {
+		It("should fail", func() {
+			codec := NewProtobufCodec(mockRegistry, "events-value")
+			_, err := codec.Marshal(ctx, map[string]string{"id": "foo"})
+			Expect(err).To(MatchError(ContainSubstring("not a protobuf message")))
+		})
+	})
+})
@@ -2,16 +2,13 @@ package stream
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	kafka "github.com/segmentio/kafka-go"
-	"github.com/segmentio/kafka-go/sasl"
-	"github.com/segmentio/kafka-go/sasl/plain"
-	"github.com/segmentio/kafka-go/sasl/scram"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,16 +17,41 @@ const (
 	MaxBytes = 10e6 // 10MB
 )
 
+var errConsumerClosed = errors.New("consumer closed")
+
+//go:generate mockgen -source=reader.go -package=stream -destination=mock_reader.go
+
 type EventStreamReader interface {
 	Consume(ctx context.Context, processMessageFn func(ctx context.Context, msg *kafka.Message) error) error
+	Close(ctx context.Context)
+}
+
+// Processes messages in order, returning how many of them were processed
+// before failing
+type ProcessBatchFn func(ctx context.Context, msgs []*kafka.Message) (int, error)
+
+// Readers able to hand over messages in batches
+type BatchEventStreamReader interface {
+	EventStreamReader
+	ConsumeBatches(ctx context.Context, processBatchFn ProcessBatchFn) error
+}
+
+// mocking kafka-go reader for testing
+type Consumer interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
 }
 
 type KafkaReader struct {
-	quitChannel chan struct{}
-	logger      *logrus.Logger
-	kafkaReader *kafka.Reader
-	config      *KafkaConfig
-	ackChannel  chan kafka.Message
+	quitChannel     chan struct{}
+	logger          *logrus.Logger
+	kafkaReader     Consumer
+	config          *KafkaConfig
+	ackChannel      chan kafka.Message
+	deadLetterQueue DeadLetterQueue
+	offsetTracker   *OffsetTracker
+	blobStore       BlobStore
 }
 
 type KafkaConfig struct {
@@ -40,22 +62,27 @@ type KafkaConfig struct {
 	Topic            string `envconfig:"KAFKA_EVENT_STREAM_TOPIC" required:"true"`
 	GroupID          string `envconfig:"KAFKA_GROUP_ID" required:"true"`
 	DestinationTopic string `envconfig:"KAFKA_EVENT_STREAM_TOPIC_DESTINATION" default:""`
+	DeadLetterTopic  string `envconfig:"KAFKA_DEAD_LETTER_TOPIC" default:""`
+	// max number of times a message failing with a retryable error is
+	// processed before it's considered failed. Permanent errors are not retried
+	MaxProcessingAttempts int `envconfig:"KAFKA_MAX_PROCESSING_ATTEMPTS" default:"10"`
+	BackoffConfig
+	// messages with the same key are processed sequentially, different keys
+	// are processed in parallel by up to this number of workers
+	ConsumerWorkers         int `envconfig:"KAFKA_CONSUMER_WORKERS" default:"1"`
+	ConsumerWorkerQueueSize int `envconfig:"KAFKA_CONSUMER_WORKER_QUEUE_SIZE" default:"100"`
+	// messages waiting for a worker are handed over in batches of up to this
+	// size, when consuming batches. Batches are disabled with 1, the default
+	ConsumerBatchSize int `envconfig:"KAFKA_CONSUMER_BATCH_SIZE" default:"1"`
+	TLSConfig
+	OAuthConfig
+	ClaimCheckConfig
 }
 
-func getMechanism(envConfig *KafkaConfig) (sasl.Mechanism, error) {
-	if envConfig.SaslMechanism == "PLAIN" {
-		return &plain.Mechanism{
-			Username: envConfig.ClientID,
-			Password: envConfig.ClientSecret,
-		}, nil
-	}
-	if envConfig.SaslMechanism == "SCRAM" {
-		return scram.Mechanism(scram.SHA512, envConfig.ClientID, envConfig.ClientSecret)
-	}
-	return nil, fmt.Errorf("SASL Mechanism %s not implemented", envConfig.SaslMechanism)
-
-}
-func NewKafkaReader(logger *logrus.Logger, ackChannel chan kafka.Message) (*KafkaReader, error) {
+// Creates a reader from env. When deadLetterQueue is not nil, messages failing
+// processing after all attempts are sent to it and acknowledged, otherwise
+// the failure is returned by Consume.
+func NewKafkaReader(logger *logrus.Logger, ackChannel chan kafka.Message, deadLetterQueue DeadLetterQueue) (*KafkaReader, error) {
 	envConfig := &KafkaConfig{}
 	err := envconfig.Process("", envConfig)
 	if err != nil {
@@ -66,6 +93,8 @@ func NewKafkaReader(logger *logrus.Logger, ackChannel chan kafka.Message) (*Kafk
 		"bootstrap_server": envConfig.BootstrapServer,
 		"topic":            envConfig.Topic,
 		"group_id":         envConfig.GroupID,
+		"dead_letter":      deadLetterQueue != nil,
+		"workers":          envConfig.ConsumerWorkers,
 	}).Printf("connecting to kafka")
 
 	config := kafka.ReaderConfig{
@@ -76,29 +105,38 @@ func NewKafkaReader(logger *logrus.Logger, ackChannel chan kafka.Message) (*Kafk
 		GroupID:        envConfig.GroupID,
 		CommitInterval: time.Second * 10,
 	}
-	if envConfig.ClientID != "" && envConfig.ClientSecret != "" {
-		mechanism, err := getMechanism(envConfig)
-		if err != nil {
-			return nil, err
-		}
-		dialer := &kafka.Dialer{
+	mechanism, tlsConfig, err := getSecurity(envConfig)
+	if err != nil {
+		return nil, err
+	}
+	if mechanism != nil || tlsConfig != nil {
+		config.Dialer = &kafka.Dialer{
 			SASLMechanism: mechanism,
-			TLS:           &tls.Config{},
+			TLS:           tlsConfig,
 		}
-		config.Dialer = dialer
-		logger.WithFields(logrus.Fields{
-			"mechanism": mechanism.Name(),
-			"user":      envConfig.ClientID,
-		}).Printf("using TLS connection")
+		logSecurity(logger, envConfig, mechanism, tlsConfig)
+	}
+	blobStore, err := newBlobStore(envConfig)
+	if err != nil {
+		return nil, err
 	}
 	kafkaReader := kafka.NewReader(config)
+	reader := NewKafkaReaderFromConsumer(logger, kafkaReader, envConfig, ackChannel, deadLetterQueue)
+	reader.blobStore = blobStore
+	return reader, nil
+}
+
+// Creates a reader consuming from the given consumer, i.e. a MemoryConsumer
+func NewKafkaReaderFromConsumer(logger *logrus.Logger, consumer Consumer, config *KafkaConfig, ackChannel chan kafka.Message, deadLetterQueue DeadLetterQueue) *KafkaReader {
 	return &KafkaReader{
-		quitChannel: make(chan struct{}),
-		logger:      logger,
-		kafkaReader: kafkaReader,
-		config:      envConfig,
-		ackChannel:  ackChannel,
-	}, nil
+		quitChannel:     make(chan struct{}),
+		logger:          logger,
+		kafkaReader:     consumer,
+		config:          config,
+		ackChannel:      ackChannel,
+		deadLetterQueue: deadLetterQueue,
+		offsetTracker:   NewOffsetTracker(),
+	}
 }
 
 func (r *KafkaReader) Close(ctx context.Context) {
@@ -108,6 +146,12 @@ func (r *KafkaReader) Close(ctx context.Context) {
 
 func (r *KafkaReader) Consume(ctx context.Context, processMessageFn func(ctx context.Context, msg *kafka.Message) error) error {
 	go r.listenForCommit(ctx)
+	processMessageFn = r.withClaimCheck(processMessageFn)
+	if r.consumerWorkers() > 1 {
+		return r.consumeConcurrently(ctx, func(ctx context.Context, cancel context.CancelFunc, queue chan kafka.Message, errChannel chan error) {
+			r.consumeQueue(ctx, cancel, queue, errChannel, processMessageFn)
+		})
+	}
 	for {
 		select {
 		case <-r.quitChannel:
@@ -120,10 +164,19 @@ func (r *KafkaReader) Consume(ctx context.Context, processMessageFn func(ctx con
 				"key":    msg.Key,
 			}
 			if err != nil {
+				if r.isClosed() {
+					r.logger.Info("stop consuming")
+					return nil
+				}
 				return err
 			}
+			r.offsetTracker.Track(msg)
 			r.logger.WithFields(fields).Debug("processing message")
-			err = processMessageFn(ctx, &msg)
+			err = r.processMessage(ctx, &msg, processMessageFn)
+			if errors.Is(err, errConsumerClosed) {
+				r.logger.Info("stop consuming")
+				return nil
+			}
 			if err != nil {
 				r.logger.WithError(err).WithFields(fields).Error("error processing message")
 				return err
@@ -134,15 +187,125 @@ func (r *KafkaReader) Consume(ctx context.Context, processMessageFn func(ctx con
 	}
 }
 
+// Process message, retrying with exponential backoff while it fails with a
+// retryable error, up to the configured number of attempts and elapsed time.
+// If it still fails and a dead letter queue is configured, the message is sent
+// there and acked.
+func (r *KafkaReader) processMessage(ctx context.Context, msg *kafka.Message, processMessageFn func(ctx context.Context, msg *kafka.Message) error) error {
+	return r.retryMessage(ctx, msg, processMessageFn, 0, nil)
+}
+
+// Processes message as processMessage does, given the number of attempts it
+// already failed, and the error of the last one
+func (r *KafkaReader) retryMessage(ctx context.Context, msg *kafka.Message, processMessageFn func(ctx context.Context, msg *kafka.Message) error, attempts int, err error) error {
+	backoff := NewExponentialBackoff(r.backoffConfig())
+	for {
+		if attempts > 0 {
+			// messages failing while shutting down are consumed again
+			if r.isClosed() {
+				return errConsumerClosed
+			}
+			logger := r.logger.WithError(err).WithFields(logrus.Fields{
+				"offset":    msg.Offset,
+				"key":       msg.Key,
+				"attempt":   attempts,
+				"retryable": IsRetryable(err),
+			})
+			if !IsRetryable(err) || attempts >= r.maxProcessingAttempts() {
+				logger.Warn("failed processing message")
+				break
+			}
+			wait, ok := backoff.Next()
+			if !ok {
+				logger.Warn("failed processing message, max retry time elapsed")
+				break
+			}
+			logger.WithField("wait", wait).Warn("failed processing message, retrying")
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				if r.isClosed() {
+					return errConsumerClosed
+				}
+				return ctx.Err()
+			case <-r.quitChannel:
+				return errConsumerClosed
+			}
+		}
+		attempts++
+		err = processMessageFn(ContextFromMessage(ctx, msg), msg)
+		if err == nil {
+			return nil
+		}
+	}
+	if r.deadLetterQueue == nil {
+		return err
+	}
+	if dlqErr := r.deadLetterQueue.Send(ctx, msg, err, attempts); dlqErr != nil {
+		return fmt.Errorf("failed to send message to dead letter queue: %w (processing error: %s)", dlqErr, err)
+	}
+	r.ackChannel <- *msg
+	return nil
+}
+
+// Processors receive claim check messages with the offloaded payload as value.
+// Messages are acked by offset, so acking the rehydrated copy is fine
+func (r *KafkaReader) withClaimCheck(processMessageFn func(ctx context.Context, msg *kafka.Message) error) func(ctx context.Context, msg *kafka.Message) error {
+	return func(ctx context.Context, msg *kafka.Message) error {
+		if !IsClaimCheck(msg) {
+			return processMessageFn(ctx, msg)
+		}
+		rehydrated, err := checkOut(ctx, r.blobStore, msg)
+		if err != nil {
+			return err
+		}
+		return processMessageFn(ctx, rehydrated)
+	}
+}
+
+func (r *KafkaReader) backoffConfig() BackoffConfig {
+	if r.config == nil {
+		return BackoffConfig{}
+	}
+	return r.config.BackoffConfig
+}
+
+func (r *KafkaReader) maxProcessingAttempts() int {
+	if r.config == nil || r.config.MaxProcessingAttempts < 1 {
+		return 1
+	}
+	return r.config.MaxProcessingAttempts
+}
+
+// Commits acked messages. Acks can arrive in any order, only the highest
+// contiguous acked offset of each partition is committed
 func (r *KafkaReader) listenForCommit(ctx context.Context) {
 	for msg := range r.ackChannel {
+		commitMsg, ok := r.offsetTracker.Ack(msg)
+		if !ok {
+			r.logger.WithFields(logrus.Fields{
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+				"key":       msg.Key,
+				"pending":   r.offsetTracker.Pending(),
+			}).Debug("message acked, waiting for lower offsets")
+			continue
+		}
 		fields := logrus.Fields{
-			"offset": msg.Offset,
-			"key":    msg.Key,
+			"partition": commitMsg.Partition,
+			"offset":    commitMsg.Offset,
+			"key":       commitMsg.Key,
+			"pending":   r.offsetTracker.Pending(),
 		}
-		if err := r.kafkaReader.CommitMessages(ctx, msg); err != nil {
+		if err := r.kafkaReader.CommitMessages(ctx, commitMsg); err != nil {
 			r.logger.WithError(err).WithFields(fields).Error("error committing message")
+			continue
 		}
 		r.logger.WithFields(fields).Debug("message committed")
 	}
 }
+
+// Per partition state of offsets fetched and not committed yet
+func (r *KafkaReader) OffsetStats() []PartitionOffsetStats {
+	return r.offsetTracker.Stats()
+}
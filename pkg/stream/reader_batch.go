@@ -0,0 +1,138 @@
+package stream
+
+import (
+	"context"
+	"errors"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+func (r *KafkaReader) consumerBatchSize() int {
+	if r.config == nil || r.config.ConsumerBatchSize < 1 {
+		return 1
+	}
+	return r.config.ConsumerBatchSize
+}
+
+// Consume messages in batches of those fetched and waiting for a worker, so
+// batches only form during backlogs and add no latency otherwise. Messages of
+// a failed batch, from the first one not processed, are processed one by one
+// with the retries and dead letter queue of Consume. The batch counts as the
+// first attempt of the message it failed at
+func (r *KafkaReader) ConsumeBatches(ctx context.Context, processBatchFn ProcessBatchFn) error {
+	processMessageFn := func(ctx context.Context, msg *kafka.Message) error {
+		_, err := processBatchFn(ctx, []*kafka.Message{msg})
+		return err
+	}
+	if r.consumerBatchSize() == 1 {
+		return r.Consume(ctx, processMessageFn)
+	}
+	go r.listenForCommit(ctx)
+	processMessageFn = r.withClaimCheck(processMessageFn)
+	processBatchFn = r.withClaimCheckBatch(processBatchFn)
+	return r.consumeConcurrently(ctx, func(ctx context.Context, cancel context.CancelFunc, queue chan kafka.Message, errChannel chan error) {
+		r.consumeQueueInBatches(ctx, cancel, queue, errChannel, processBatchFn, processMessageFn)
+	})
+}
+
+func (r *KafkaReader) consumeQueueInBatches(ctx context.Context, cancel context.CancelFunc, queue chan kafka.Message, errChannel chan error, processBatchFn ProcessBatchFn, processMessageFn func(ctx context.Context, msg *kafka.Message) error) {
+	for msg := range queue {
+		// after a failure, drain the queue without processing
+		if ctx.Err() != nil {
+			continue
+		}
+		batch := r.fillBatch(queue, msg)
+		fields := logrus.Fields{
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+			"size":      len(batch),
+		}
+		r.logger.WithFields(fields).Debug("processing batch")
+		err := r.processBatch(ctx, batch, processBatchFn, processMessageFn)
+		if errors.Is(err, errConsumerClosed) {
+			continue
+		}
+		if err != nil {
+			r.logger.WithError(err).WithFields(fields).Error("error processing batch")
+			errChannel <- err
+			cancel()
+			continue
+		}
+		r.logger.WithFields(fields).Debug("batch processed")
+	}
+}
+
+// Batch of the message and those already waiting in the queue, up to the
+// batch size
+func (r *KafkaReader) fillBatch(queue chan kafka.Message, first kafka.Message) []*kafka.Message {
+	batch := []*kafka.Message{&first}
+	for len(batch) < r.consumerBatchSize() {
+		select {
+		case msg, ok := <-queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, &msg)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (r *KafkaReader) processBatch(ctx context.Context, batch []*kafka.Message, processBatchFn ProcessBatchFn, processMessageFn func(ctx context.Context, msg *kafka.Message) error) error {
+	processed, err := processBatchFn(ctx, batch)
+	if err == nil {
+		return nil
+	}
+	// the batch counts as an attempt of the failed message. When it's not
+	// known, the whole batch is retried, with all the attempts of its messages
+	attempts := 1
+	if processed < 0 || processed >= len(batch) {
+		processed = 0
+		attempts = 0
+	}
+	r.logger.WithError(err).WithFields(logrus.Fields{
+		"partition": batch[processed].Partition,
+		"offset":    batch[processed].Offset,
+		"processed": processed,
+		"size":      len(batch),
+	}).Warn("failed processing batch, processing remaining messages one by one")
+	if err = r.retryMessage(ctx, batch[processed], processMessageFn, attempts, err); err != nil {
+		return err
+	}
+	for _, msg := range batch[processed+1:] {
+		if err = r.processMessage(ctx, msg, processMessageFn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rehydrates claim check messages of the batch. When one fails, messages
+// before it are processed and it's left to be retried
+func (r *KafkaReader) withClaimCheckBatch(processBatchFn ProcessBatchFn) ProcessBatchFn {
+	return func(ctx context.Context, msgs []*kafka.Message) (int, error) {
+		rehydrated := make([]*kafka.Message, 0, len(msgs))
+		for _, msg := range msgs {
+			if !IsClaimCheck(msg) {
+				rehydrated = append(rehydrated, msg)
+				continue
+			}
+			checkedOut, err := checkOut(ctx, r.blobStore, msg)
+			if err != nil {
+				if len(rehydrated) == 0 {
+					return 0, err
+				}
+				processed, batchErr := processBatchFn(ctx, rehydrated)
+				if batchErr != nil {
+					return processed, batchErr
+				}
+				return processed, err
+			}
+			rehydrated = append(rehydrated, checkedOut)
+		}
+		return processBatchFn(ctx, rehydrated)
+	}
+}
@@ -0,0 +1,190 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Consuming messages in batches", func() {
+	var (
+		ctx        context.Context
+		cancel     context.CancelFunc
+		logger     *logrus.Logger
+		ackChannel chan kafka.Message
+		messages   []kafka.Message
+		offsets    []int64
+	)
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		logger = logrus.New()
+		logger.Out = io.Discard
+		ackChannel = make(chan kafka.Message, 100)
+		messages = []kafka.Message{}
+		offsets = []int64{}
+		for i := 0; i < 10; i++ {
+			messages = append(messages, kafka.Message{
+				Topic:  "events",
+				Offset: int64(i),
+				Key:    []byte("cluster"),
+			})
+			offsets = append(offsets, int64(i))
+		}
+	})
+	AfterEach(func() {
+		cancel()
+	})
+
+	newReader := func(config *KafkaConfig) *KafkaReader {
+		config.BackoffConfig = BackoffConfig{
+			InitialInterval: time.Millisecond,
+			MaxInterval:     time.Millisecond,
+			Multiplier:      2,
+			MaxElapsedTime:  time.Minute,
+		}
+		return &KafkaReader{
+			quitChannel:   make(chan struct{}),
+			logger:        logger,
+			kafkaReader:   newSliceConsumer(messages),
+			config:        config,
+			ackChannel:    ackChannel,
+			offsetTracker: NewOffsetTracker(),
+		}
+	}
+
+	It("should hand over waiting messages in order, up to the batch size", func() {
+		reader := newReader(&KafkaConfig{MaxProcessingAttempts: 1, ConsumerWorkers: 1, ConsumerWorkerQueueSize: 10, ConsumerBatchSize: 4})
+		var mu sync.Mutex
+		batchSizes := []int{}
+		processed := []int64{}
+		err := reader.ConsumeBatches(ctx, func(ctx context.Context, msgs []*kafka.Message) (int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(batchSizes) == 0 {
+				// let the other messages queue up
+				time.Sleep(50 * time.Millisecond)
+			}
+			batchSizes = append(batchSizes, len(msgs))
+			for _, msg := range msgs {
+				processed = append(processed, msg.Offset)
+			}
+			if len(processed) == len(messages) {
+				reader.Close(ctx)
+			}
+			return len(msgs), nil
+		})
+		Expect(err).To(BeNil())
+		Expect(processed).To(Equal(offsets))
+		Expect(batchSizes).To(ContainElement(4))
+		for _, size := range batchSizes {
+			Expect(size).To(BeNumerically("<=", 4))
+		}
+	})
+
+	It("should retry the rest of a failed batch one message at a time", func() {
+		reader := newReader(&KafkaConfig{MaxProcessingAttempts: 2, ConsumerWorkers: 1, ConsumerWorkerQueueSize: 10, ConsumerBatchSize: 10})
+		var mu sync.Mutex
+		failedBatchSize := 0
+		var failedBatchLastOffset int64
+		retried := []int{}
+		processed := []int64{}
+		err := reader.ConsumeBatches(ctx, func(ctx context.Context, msgs []*kafka.Message) (int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(processed) == 0 {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if failedBatchSize > 0 && msgs[0].Offset <= failedBatchLastOffset {
+				retried = append(retried, len(msgs))
+			}
+			for i, msg := range msgs {
+				if msg.Offset == 3 && failedBatchSize == 0 {
+					failedBatchSize = len(msgs)
+					failedBatchLastOffset = msgs[len(msgs)-1].Offset
+					return i, NewRetryableError(errors.New("redis unavailable"))
+				}
+				processed = append(processed, msg.Offset)
+			}
+			if len(processed) == len(messages) {
+				reader.Close(ctx)
+			}
+			return len(msgs), nil
+		})
+		Expect(err).To(BeNil())
+		Expect(processed).To(Equal(offsets))
+		Expect(failedBatchSize).To(BeNumerically(">", 1))
+		// from the failed message to the end of its batch
+		Expect(retried).To(HaveLen(int(failedBatchLastOffset - 2)))
+		Expect(retried).To(HaveEach(1))
+	})
+
+	It("should retry the whole failed batch one message at a time when its progress is unknown", func() {
+		reader := newReader(&KafkaConfig{MaxProcessingAttempts: 1, ConsumerWorkers: 1, ConsumerWorkerQueueSize: 10, ConsumerBatchSize: 10})
+		var mu sync.Mutex
+		var failedBatch []int64
+		processed := []int64{}
+		err := reader.ConsumeBatches(ctx, func(ctx context.Context, msgs []*kafka.Message) (int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if failedBatch == nil {
+				time.Sleep(50 * time.Millisecond)
+				failedBatch = []int64{}
+				for _, msg := range msgs {
+					failedBatch = append(failedBatch, msg.Offset)
+				}
+				return -1, NewRetryableError(errors.New("redis unavailable"))
+			}
+			for _, msg := range msgs {
+				processed = append(processed, msg.Offset)
+			}
+			if len(processed) == len(messages) {
+				reader.Close(ctx)
+			}
+			return len(msgs), nil
+		})
+		Expect(err).To(BeNil())
+		Expect(len(failedBatch)).To(BeNumerically(">", 1))
+		Expect(processed).To(Equal(offsets))
+	})
+
+	It("should count the failed batch as an attempt of the message it failed at", func() {
+		reader := newReader(&KafkaConfig{MaxProcessingAttempts: 2, ConsumerWorkers: 1, ConsumerWorkerQueueSize: 10, ConsumerBatchSize: 10})
+		var mu sync.Mutex
+		attempts := 0
+		err := reader.ConsumeBatches(ctx, func(ctx context.Context, msgs []*kafka.Message) (int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			for i, msg := range msgs {
+				if msg.Offset == 0 {
+					attempts++
+					return i, NewRetryableError(errors.New("redis unavailable"))
+				}
+			}
+			return len(msgs), nil
+		})
+		Expect(err).To(MatchError(ContainSubstring("redis unavailable")))
+		Expect(attempts).To(Equal(2))
+	})
+
+	It("should process messages one at a time when batches are disabled", func() {
+		reader := newReader(&KafkaConfig{MaxProcessingAttempts: 1, ConsumerBatchSize: 1})
+		processed := []int64{}
+		err := reader.ConsumeBatches(ctx, func(ctx context.Context, msgs []*kafka.Message) (int, error) {
+			Expect(msgs).To(HaveLen(1))
+			processed = append(processed, msgs[0].Offset)
+			if len(processed) == len(messages) {
+				reader.Close(ctx)
+			}
+			return 1, nil
+		})
+		Expect(err).To(BeNil())
+		Expect(processed).To(Equal(offsets))
+	})
+})
@@ -0,0 +1,142 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Processes the messages of a worker queue until it's closed. After a failure,
+// sent to errChannel, ctx is cancelled and the queue drained
+type queueConsumer func(ctx context.Context, cancel context.CancelFunc, queue chan kafka.Message, errChannel chan error)
+
+func (r *KafkaReader) consumerWorkers() int {
+	if r.config == nil || r.config.ConsumerWorkers < 1 {
+		return 1
+	}
+	return r.config.ConsumerWorkers
+}
+
+func (r *KafkaReader) consumerWorkerQueueSize() int {
+	if r.config == nil || r.config.ConsumerWorkerQueueSize < 0 {
+		return 0
+	}
+	return r.config.ConsumerWorkerQueueSize
+}
+
+// Consume messages with a pool of workers. Each key is always dispatched to
+// the same worker, so messages sharing a key (cluster ID) are processed in
+// order, while different keys are processed in parallel. The first processing
+// failure stops consumption and is returned, once in-flight messages are done
+func (r *KafkaReader) consumeConcurrently(ctx context.Context, consumeQueue queueConsumer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := r.consumerWorkers()
+	errChannel := make(chan error, workers)
+	queues := make([]chan kafka.Message, workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan kafka.Message, r.consumerWorkerQueueSize())
+		wg.Add(1)
+		go func(queue chan kafka.Message) {
+			defer wg.Done()
+			consumeQueue(ctx, cancel, queue, errChannel)
+		}(queues[i])
+	}
+	stopWorkers := func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}
+
+	for {
+		select {
+		case <-r.quitChannel:
+			stopWorkers()
+			r.logger.Info("stop consuming")
+			return nil
+		case err := <-errChannel:
+			stopWorkers()
+			return err
+		default:
+		}
+		msg, err := r.kafkaReader.FetchMessage(ctx)
+		if err != nil {
+			stopWorkers()
+			if r.isClosed() {
+				r.logger.Info("stop consuming")
+				return nil
+			}
+			if errors.Is(err, context.Canceled) {
+				select {
+				case workerErr := <-errChannel:
+					return workerErr
+				default:
+				}
+			}
+			return err
+		}
+		r.offsetTracker.Track(msg)
+		select {
+		case queues[workerIndex(&msg, workers)] <- msg:
+		case err := <-errChannel:
+			stopWorkers()
+			return err
+		case <-r.quitChannel:
+			stopWorkers()
+			r.logger.Info("stop consuming")
+			return nil
+		}
+	}
+}
+
+func (r *KafkaReader) isClosed() bool {
+	select {
+	case <-r.quitChannel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *KafkaReader) consumeQueue(ctx context.Context, cancel context.CancelFunc, queue chan kafka.Message, errChannel chan error, processMessageFn func(ctx context.Context, msg *kafka.Message) error) {
+	for msg := range queue {
+		// after a failure, drain the queue without processing
+		if ctx.Err() != nil {
+			continue
+		}
+		fields := logrus.Fields{
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+			"key":       msg.Key,
+		}
+		r.logger.WithFields(fields).Debug("processing message")
+		err := r.processMessage(ctx, &msg, processMessageFn)
+		if errors.Is(err, errConsumerClosed) {
+			continue
+		}
+		if err != nil {
+			r.logger.WithError(err).WithFields(fields).Error("error processing message")
+			errChannel <- err
+			cancel()
+			continue
+		}
+		r.logger.WithFields(fields).Debug("message processed")
+	}
+}
+
+// Messages without key are spread by partition, which preserves their order
+func workerIndex(msg *kafka.Message, workers int) int {
+	if len(msg.Key) == 0 {
+		return msg.Partition % workers
+	}
+	hash := fnv.New32a()
+	hash.Write(msg.Key)
+	return int(hash.Sum32() % uint32(workers))
+}
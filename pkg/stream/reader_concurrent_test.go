@@ -0,0 +1,164 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// returns given messages, then blocks until closed or context is done
+type sliceConsumer struct {
+	messages chan kafka.Message
+	closed   chan struct{}
+}
+
+func newSliceConsumer(messages []kafka.Message) *sliceConsumer {
+	c := &sliceConsumer{
+		messages: make(chan kafka.Message, len(messages)),
+		closed:   make(chan struct{}),
+	}
+	for _, msg := range messages {
+		c.messages <- msg
+	}
+	return c
+}
+
+func (c *sliceConsumer) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg := <-c.messages:
+		return msg, nil
+	case <-c.closed:
+		return kafka.Message{}, io.EOF
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (c *sliceConsumer) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	return nil
+}
+
+func (c *sliceConsumer) Close() error {
+	close(c.closed)
+	return nil
+}
+
+// keys dispatched each to a different worker
+func getKeysForDistinctWorkers(workers int) [][]byte {
+	keys := [][]byte{}
+	used := map[int]bool{}
+	for i := 0; len(keys) < workers; i++ {
+		key := []byte(fmt.Sprintf("cluster-%d", i))
+		idx := workerIndex(&kafka.Message{Key: key}, workers)
+		if !used[idx] {
+			used[idx] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+var _ = Describe("Consuming messages concurrently", func() {
+	var (
+		ctx        context.Context
+		cancel     context.CancelFunc
+		logger     *logrus.Logger
+		ackChannel chan kafka.Message
+		messages   []kafka.Message
+	)
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		logger = logrus.New()
+		logger.Out = io.Discard
+		ackChannel = make(chan kafka.Message, 100)
+		messages = []kafka.Message{}
+		keys := getKeysForDistinctWorkers(3)
+		for i := 0; i < 30; i++ {
+			messages = append(messages, kafka.Message{
+				Topic:  "events",
+				Offset: int64(i),
+				Key:    keys[i%3],
+			})
+		}
+	})
+	AfterEach(func() {
+		cancel()
+	})
+
+	newReader := func(consumer Consumer) *KafkaReader {
+		return &KafkaReader{
+			quitChannel:   make(chan struct{}),
+			logger:        logger,
+			kafkaReader:   consumer,
+			config:        &KafkaConfig{MaxProcessingAttempts: 1, ConsumerWorkers: 3, ConsumerWorkerQueueSize: 5},
+			ackChannel:    ackChannel,
+			offsetTracker: NewOffsetTracker(),
+		}
+	}
+
+	It("should process messages with the same key in order", func() {
+		reader := newReader(newSliceConsumer(messages))
+		var mu sync.Mutex
+		processed := map[string][]int64{}
+		count := 0
+		err := reader.Consume(ctx, func(ctx context.Context, msg *kafka.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			processed[string(msg.Key)] = append(processed[string(msg.Key)], msg.Offset)
+			count++
+			if count == len(messages) {
+				reader.Close(ctx)
+			}
+			return nil
+		})
+		Expect(err).To(BeNil())
+		Expect(processed).To(HaveLen(3))
+		for key, offsets := range processed {
+			Expect(offsets).To(HaveLen(10), key)
+			for i := 1; i < len(offsets); i++ {
+				Expect(offsets[i]).To(BeNumerically(">", offsets[i-1]))
+			}
+		}
+	})
+
+	It("should process different keys in parallel", func() {
+		reader := newReader(newSliceConsumer(messages[:3]))
+		started := make(chan struct{}, 3)
+		release := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- reader.Consume(ctx, func(ctx context.Context, msg *kafka.Message) error {
+				started <- struct{}{}
+				<-release
+				return nil
+			})
+		}()
+		// all three keys are being processed at the same time
+		for i := 0; i < 3; i++ {
+			Eventually(started).Should(Receive())
+		}
+		close(release)
+		reader.Close(ctx)
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("should stop and return the first processing error", func() {
+		reader := newReader(newSliceConsumer(messages))
+		processingErr := errors.New("poison message")
+		err := reader.Consume(ctx, func(ctx context.Context, msg *kafka.Message) error {
+			if msg.Offset == 4 {
+				return processingErr
+			}
+			return nil
+		})
+		Expect(err).To(Equal(processingErr))
+	})
+})
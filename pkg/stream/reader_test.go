@@ -0,0 +1,271 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Consuming messages", func() {
+	var (
+		ctx             context.Context
+		ctrl            *gomock.Controller
+		logger          *logrus.Logger
+		mockConsumer    *MockConsumer
+		mockDeadLetter  *MockDeadLetterQueue
+		ackChannel      chan kafka.Message
+		committed       chan kafka.Message
+		msg             kafka.Message
+		errStopFetching error
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		ctrl = gomock.NewController(GinkgoT())
+		logger = logrus.New()
+		logger.Out = io.Discard
+		mockConsumer = NewMockConsumer(ctrl)
+		mockDeadLetter = NewMockDeadLetterQueue(ctrl)
+		ackChannel = make(chan kafka.Message, 10)
+		committed = make(chan kafka.Message, 10)
+		msg = kafka.Message{Topic: "events", Partition: 0, Offset: 7, Key: []byte("foobar")}
+		errStopFetching = errors.New("stop fetching")
+	})
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	newReader := func(deadLetterQueue DeadLetterQueue) *KafkaReader {
+		return &KafkaReader{
+			quitChannel: make(chan struct{}),
+			logger:      logger,
+			kafkaReader: mockConsumer,
+			config: &KafkaConfig{
+				MaxProcessingAttempts: 3,
+				BackoffConfig: BackoffConfig{
+					InitialInterval: time.Millisecond,
+					MaxInterval:     time.Millisecond,
+					Multiplier:      2,
+					MaxElapsedTime:  time.Minute,
+				},
+			},
+			ackChannel:      ackChannel,
+			deadLetterQueue: deadLetterQueue,
+			offsetTracker:   NewOffsetTracker(),
+		}
+	}
+
+	// fetch one message, then stop consuming
+	expectFetch := func() {
+		gomock.InOrder(
+			mockConsumer.EXPECT().FetchMessage(gomock.Any()).Return(msg, nil),
+			mockConsumer.EXPECT().FetchMessage(gomock.Any()).Return(kafka.Message{}, errStopFetching).MaxTimes(1),
+		)
+		mockConsumer.EXPECT().CommitMessages(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+			func(ctx context.Context, msgs ...kafka.Message) error {
+				for _, m := range msgs {
+					committed <- m
+				}
+				return nil
+			})
+	}
+
+	When("processing keeps failing and no dead letter queue is configured", func() {
+		It("should return the processing error after all attempts", func() {
+			attempts := 0
+			processingErr := NewRetryableError(errors.New("redis unavailable"))
+			reader := newReader(nil)
+			expectFetch()
+
+			err := reader.Consume(ctx, func(ctx context.Context, m *kafka.Message) error {
+				attempts++
+				return processingErr
+			})
+			Expect(err).To(Equal(processingErr))
+			Expect(attempts).To(Equal(3))
+		})
+	})
+
+	When("processing keeps failing and a dead letter queue is configured", func() {
+		It("should send the message to the dead letter queue, ack it and keep consuming", func() {
+			processingErr := NewRetryableError(errors.New("redis unavailable"))
+			reader := newReader(mockDeadLetter)
+			expectFetch()
+			mockDeadLetter.EXPECT().Send(gomock.Any(), &msg, processingErr, 3).Times(1).Return(nil)
+
+			err := reader.Consume(ctx, func(ctx context.Context, m *kafka.Message) error {
+				return processingErr
+			})
+			Expect(err).To(Equal(errStopFetching))
+			Eventually(committed).Should(Receive(Equal(msg)))
+		})
+	})
+
+	When("processing fails with a permanent error", func() {
+		It("should not retry it", func() {
+			attempts := 0
+			processingErr := errors.New("unknown event name")
+			reader := newReader(mockDeadLetter)
+			expectFetch()
+			mockDeadLetter.EXPECT().Send(gomock.Any(), &msg, processingErr, 1).Times(1).Return(nil)
+
+			err := reader.Consume(ctx, func(ctx context.Context, m *kafka.Message) error {
+				attempts++
+				return processingErr
+			})
+			Expect(err).To(Equal(errStopFetching))
+			Expect(attempts).To(Equal(1))
+			Eventually(committed).Should(Receive(Equal(msg)))
+		})
+	})
+
+	When("the backoff is not configured", func() {
+		It("should still retry", func() {
+			attempts := 0
+			reader := newReader(nil)
+			reader.config.MaxProcessingAttempts = 2
+			reader.config.BackoffConfig = BackoffConfig{}
+			expectFetch()
+
+			err := reader.Consume(ctx, func(ctx context.Context, m *kafka.Message) error {
+				attempts++
+				if attempts == 1 {
+					return NewRetryableError(errors.New("redis unavailable"))
+				}
+				return nil
+			})
+			Expect(err).To(Equal(errStopFetching))
+			Expect(attempts).To(Equal(2))
+		})
+	})
+
+	When("retrying would exceed the max elapsed time", func() {
+		It("should stop retrying", func() {
+			attempts := 0
+			reader := newReader(nil)
+			reader.config.BackoffConfig.InitialInterval = time.Hour
+			expectFetch()
+
+			err := reader.Consume(ctx, func(ctx context.Context, m *kafka.Message) error {
+				attempts++
+				return NewRetryableError(errors.New("redis unavailable"))
+			})
+			Expect(IsRetryable(err)).To(BeTrue())
+			Expect(attempts).To(Equal(1))
+		})
+	})
+
+	When("the reader is closed while waiting to retry", func() {
+		It("should stop consuming without error", func() {
+			reader := newReader(nil)
+			reader.config.BackoffConfig.InitialInterval = 10 * time.Second
+			reader.config.BackoffConfig.MaxElapsedTime = time.Hour
+			expectFetch()
+
+			err := reader.Consume(ctx, func(ctx context.Context, m *kafka.Message) error {
+				close(reader.quitChannel)
+				return NewRetryableError(errors.New("redis unavailable"))
+			})
+			Expect(err).To(BeNil())
+			Consistently(committed, "100ms").ShouldNot(Receive())
+		})
+	})
+
+	When("the reader is closed while processing", func() {
+		It("should not send the message to the dead letter queue", func() {
+			reader := newReader(mockDeadLetter)
+			expectFetch()
+			mockDeadLetter.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			err := reader.Consume(ctx, func(ctx context.Context, m *kafka.Message) error {
+				close(reader.quitChannel)
+				return context.Canceled
+			})
+			Expect(err).To(BeNil())
+			Consistently(committed, "100ms").ShouldNot(Receive())
+		})
+	})
+
+	When("processing succeeds after a failure", func() {
+		It("should not send the message to the dead letter queue", func() {
+			attempts := 0
+			reader := newReader(mockDeadLetter)
+			expectFetch()
+			mockDeadLetter.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			err := reader.Consume(ctx, func(ctx context.Context, m *kafka.Message) error {
+				attempts++
+				if attempts == 1 {
+					return NewRetryableError(errors.New("transient"))
+				}
+				return nil
+			})
+			Expect(err).To(Equal(errStopFetching))
+			Expect(attempts).To(Equal(2))
+		})
+	})
+
+	When("the dead letter queue cannot be written", func() {
+		It("should return an error and not ack the message", func() {
+			reader := newReader(mockDeadLetter)
+			expectFetch()
+			mockDeadLetter.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("kafka down"))
+
+			err := reader.Consume(ctx, func(ctx context.Context, m *kafka.Message) error {
+				return errors.New("poison message")
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err).NotTo(Equal(errStopFetching))
+			Consistently(committed, "100ms").ShouldNot(Receive())
+		})
+	})
+
+	When("the message carries a trace context", func() {
+		It("should pass it to the processor through the context", func() {
+			traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+			msg.Headers = []kafka.Header{{Key: HeaderTraceParent, Value: []byte(traceParent)}}
+			reader := newReader(nil)
+			expectFetch()
+
+			var traceContext TraceContext
+			err := reader.Consume(ctx, func(ctx context.Context, m *kafka.Message) error {
+				traceContext, _ = TraceContextFromContext(ctx)
+				return nil
+			})
+			Expect(err).To(Equal(errStopFetching))
+			Expect(traceContext.TraceParent).To(Equal(traceParent))
+		})
+	})
+
+	When("acks arrive out of order", func() {
+		It("should commit only the highest contiguous acked offset", func() {
+			reader := newReader(nil)
+			first := kafka.Message{Topic: "events", Offset: 8}
+			second := kafka.Message{Topic: "events", Offset: 9}
+			reader.offsetTracker.Track(first)
+			reader.offsetTracker.Track(second)
+
+			mockConsumer.EXPECT().CommitMessages(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, msgs ...kafka.Message) error {
+					for _, m := range msgs {
+						committed <- m
+					}
+					return nil
+				}).Times(1)
+
+			ackChannel <- second
+			ackChannel <- first
+			close(ackChannel)
+			reader.listenForCommit(ctx)
+
+			Expect(<-committed).To(Equal(second))
+			Expect(committed).To(BeEmpty())
+		})
+	})
+})
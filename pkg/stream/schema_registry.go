@@ -0,0 +1,193 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+	SchemaTypeJSON     = "JSON"
+
+	schemaRegistryContentType = "application/vnd.schemaregistry.v1+json"
+	schemaRegistryTimeout     = 10 * time.Second
+	// latest version of a subject is looked up again after this time, to pick
+	// up schema evolutions
+	latestSchemaCacheTTL = 5 * time.Minute
+)
+
+type Schema struct {
+	ID      int    `json:"id"`
+	Subject string `json:"subject,omitempty"`
+	Version int    `json:"version,omitempty"`
+	// AVRO when empty, as in the schema registry API
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+func (s *Schema) Type() string {
+	if s.SchemaType == "" {
+		return SchemaTypeAvro
+	}
+	return s.SchemaType
+}
+
+//go:generate mockgen -source=schema_registry.go -package=stream -destination=mock_schema_registry.go
+
+type SchemaRegistry interface {
+	GetSchemaByID(ctx context.Context, id int) (*Schema, error)
+	GetLatestSchema(ctx context.Context, subject string) (*Schema, error)
+}
+
+// Client of the confluent schema registry REST API, schemas are cached
+type HTTPSchemaRegistry struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu        sync.Mutex
+	byID      map[int]*Schema
+	latest    map[string]*Schema
+	latestAge map[string]time.Time
+}
+
+func NewHTTPSchemaRegistry(baseURL, username, password string) *HTTPSchemaRegistry {
+	return &HTTPSchemaRegistry{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		username:   username,
+		password:   password,
+		httpClient: &http.Client{Timeout: schemaRegistryTimeout},
+		now:        time.Now,
+		byID:       map[int]*Schema{},
+		latest:     map[string]*Schema{},
+		latestAge:  map[string]time.Time{},
+	}
+}
+
+func (r *HTTPSchemaRegistry) GetSchemaByID(ctx context.Context, id int) (*Schema, error) {
+	r.mu.Lock()
+	schema, ok := r.byID[id]
+	r.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+	schema = &Schema{}
+	if err := r.get(ctx, fmt.Sprintf("/schemas/ids/%d", id), schema); err != nil {
+		return nil, err
+	}
+	schema.ID = id
+	r.mu.Lock()
+	r.byID[id] = schema
+	r.mu.Unlock()
+	return schema, nil
+}
+
+func (r *HTTPSchemaRegistry) GetLatestSchema(ctx context.Context, subject string) (*Schema, error) {
+	r.mu.Lock()
+	schema, ok := r.latest[subject]
+	fetchedAt := r.latestAge[subject]
+	r.mu.Unlock()
+	if ok && r.now().Sub(fetchedAt) < latestSchemaCacheTTL {
+		return schema, nil
+	}
+	schema = &Schema{}
+	if err := r.get(ctx, fmt.Sprintf("/subjects/%s/versions/latest", url.PathEscape(subject)), schema); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.latest[subject] = schema
+	r.latestAge[subject] = r.now()
+	r.byID[schema.ID] = schema
+	r.mu.Unlock()
+	return schema, nil
+}
+
+func (r *HTTPSchemaRegistry) get(ctx context.Context, path string, value interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", schemaRegistryContentType)
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return NewRetryableError(fmt.Errorf("schema registry request failed: %w", err))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxBytes))
+	if err != nil {
+		return NewRetryableError(fmt.Errorf("failed to read schema registry response: %w", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("schema registry request %s failed with status %d: %s", path, resp.StatusCode, body)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return NewRetryableError(err)
+		}
+		return err
+	}
+	if err := json.Unmarshal(body, value); err != nil {
+		return fmt.Errorf("failed to decode schema registry response: %w", err)
+	}
+	return nil
+}
+
+// Registry stand-in reading schemas from a json file containing a list of
+// schemas, for local runs and tests
+type FileSchemaRegistry struct {
+	byID   map[int]*Schema
+	latest map[string]*Schema
+}
+
+func NewFileSchemaRegistry(filename string) (*FileSchemaRegistry, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema registry file %s: %w", filename, err)
+	}
+	schemas := []*Schema{}
+	if err := json.Unmarshal(content, &schemas); err != nil {
+		return nil, fmt.Errorf("failed to decode schema registry file %s: %w", filename, err)
+	}
+	r := &FileSchemaRegistry{
+		byID:   map[int]*Schema{},
+		latest: map[string]*Schema{},
+	}
+	for _, schema := range schemas {
+		if _, ok := r.byID[schema.ID]; ok {
+			return nil, fmt.Errorf("duplicate schema id %d in %s", schema.ID, filename)
+		}
+		r.byID[schema.ID] = schema
+		if latest, ok := r.latest[schema.Subject]; !ok || latest.Version < schema.Version {
+			r.latest[schema.Subject] = schema
+		}
+	}
+	return r, nil
+}
+
+func (r *FileSchemaRegistry) GetSchemaByID(ctx context.Context, id int) (*Schema, error) {
+	schema, ok := r.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("schema id %d not found", id)
+	}
+	return schema, nil
+}
+
+func (r *FileSchemaRegistry) GetLatestSchema(ctx context.Context, subject string) (*Schema, error) {
+	schema, ok := r.latest[subject]
+	if !ok {
+		return nil, fmt.Errorf("subject %s not found", subject)
+	}
+	return schema, nil
+}
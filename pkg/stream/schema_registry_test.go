@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schema registry", func() {
+	var ctx context.Context
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	When("using the registry API", func() {
+		var (
+			server   *httptest.Server
+			requests int32
+			registry *HTTPSchemaRegistry
+		)
+		BeforeEach(func() {
+			atomic.StoreInt32(&requests, 0)
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				user, password, _ := r.BasicAuth()
+				if user != "user" || password != "secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				switch r.URL.Path {
+				case "/subjects/events-value/versions/latest":
+					w.Write([]byte(`{"subject":"events-value","id":3,"version":2,"schema":"\"string\""}`))
+				case "/schemas/ids/1":
+					w.Write([]byte(`{"schemaType":"PROTOBUF","schema":"syntax = \"proto3\";"}`))
+				case "/schemas/ids/500":
+					w.WriteHeader(http.StatusInternalServerError)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"error_code":40403,"message":"Schema not found"}`))
+				}
+			}))
+			registry = NewHTTPSchemaRegistry(server.URL+"/", "user", "secret")
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+		It("should fetch and cache the latest schema of a subject", func() {
+			schema, err := registry.GetLatestSchema(ctx, "events-value")
+			Expect(err).To(BeNil())
+			Expect(schema.ID).To(Equal(3))
+			Expect(schema.Type()).To(Equal(SchemaTypeAvro))
+
+			_, err = registry.GetLatestSchema(ctx, "events-value")
+			Expect(err).To(BeNil())
+			// cached by ID as well
+			_, err = registry.GetSchemaByID(ctx, 3)
+			Expect(err).To(BeNil())
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(1))
+		})
+		It("should fetch schemas by ID", func() {
+			schema, err := registry.GetSchemaByID(ctx, 1)
+			Expect(err).To(BeNil())
+			Expect(schema.ID).To(Equal(1))
+			Expect(schema.Type()).To(Equal(SchemaTypeProtobuf))
+		})
+		It("should fail with a permanent error when the schema does not exist", func() {
+			_, err := registry.GetSchemaByID(ctx, 2)
+			Expect(err).To(MatchError(ContainSubstring("Schema not found")))
+			Expect(IsRetryable(err)).To(BeFalse())
+		})
+		It("should fail with a retryable error when the registry fails", func() {
+			_, err := registry.GetSchemaByID(ctx, 500)
+			Expect(IsRetryable(err)).To(BeTrue())
+		})
+	})
+
+	When("using a file backed registry", func() {
+		It("should resolve the latest version of each subject", func() {
+			tmpdir, err := os.MkdirTemp("", ".registry-")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(tmpdir)
+			filename := filepath.Join(tmpdir, "schemas.json")
+			Expect(os.WriteFile(filename, []byte(`[
+				{"id": 1, "subject": "events-value", "version": 1, "schema": "\"string\""},
+				{"id": 5, "subject": "events-value", "version": 3, "schema": "\"long\""},
+				{"id": 2, "subject": "events-value", "version": 2, "schema": "\"int\""}
+			]`), 0600)).To(Succeed())
+
+			registry, err := NewFileSchemaRegistry(filename)
+			Expect(err).To(BeNil())
+			schema, err := registry.GetLatestSchema(ctx, "events-value")
+			Expect(err).To(BeNil())
+			Expect(schema.ID).To(Equal(5))
+			schema, err = registry.GetSchemaByID(ctx, 2)
+			Expect(err).To(BeNil())
+			Expect(schema.Schema).To(Equal(`"int"`))
+			_, err = registry.GetSchemaByID(ctx, 3)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,127 @@
+package stream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	SaslMechanismPlain       = "PLAIN"
+	SaslMechanismScram       = "SCRAM"
+	SaslMechanismScramSHA256 = "SCRAM-SHA-256"
+	SaslMechanismScramSHA512 = "SCRAM-SHA-512"
+)
+
+// TLS settings shared by readers and writers. TLS is enabled when explicitly
+// requested, when any certificate is configured, or when SASL credentials are
+// set, to keep the previous behaviour of never sending credentials in clear.
+type TLSConfig struct {
+	TLSEnabled    bool   `envconfig:"KAFKA_TLS_ENABLED" default:"false"`
+	TLSCAFile     string `envconfig:"KAFKA_TLS_CA_FILE" default:""`
+	TLSCertFile   string `envconfig:"KAFKA_TLS_CERT_FILE" default:""`
+	TLSKeyFile    string `envconfig:"KAFKA_TLS_KEY_FILE" default:""`
+	TLSServerName string `envconfig:"KAFKA_TLS_SERVER_NAME" default:""`
+}
+
+func hasCredentials(config *KafkaConfig) bool {
+	return config.ClientID != "" && config.ClientSecret != ""
+}
+
+// Returns the SASL mechanism to authenticate with, nil when no credentials are set
+func getMechanism(config *KafkaConfig) (sasl.Mechanism, error) {
+	if !hasCredentials(config) {
+		return nil, nil
+	}
+	switch strings.ToUpper(config.SaslMechanism) {
+	case SaslMechanismPlain:
+		return &plain.Mechanism{
+			Username: config.ClientID,
+			Password: config.ClientSecret,
+		}, nil
+	case SaslMechanismScram, SaslMechanismScramSHA512:
+		return scram.Mechanism(scram.SHA512, config.ClientID, config.ClientSecret)
+	case SaslMechanismScramSHA256:
+		return scram.Mechanism(scram.SHA256, config.ClientID, config.ClientSecret)
+	case SaslMechanismOAuthBearer:
+		if config.OAuthTokenURL == "" {
+			return nil, fmt.Errorf("KAFKA_OAUTH_TOKEN_URL is required for SASL Mechanism %s", config.SaslMechanism)
+		}
+		tokenSource := NewClientCredentialsTokenSource(
+			config.OAuthTokenURL,
+			config.ClientID,
+			config.ClientSecret,
+			config.OAuthScopes,
+			config.OAuthTokenRefreshMargin,
+		)
+		return NewOAuthBearerMechanism(tokenSource), nil
+	}
+	return nil, fmt.Errorf("SASL Mechanism %s not implemented", config.SaslMechanism)
+}
+
+// Returns the TLS configuration to connect with, nil when TLS is not enabled
+func getTLSConfig(config *KafkaConfig) (*tls.Config, error) {
+	if !config.TLSEnabled && !hasCredentials(config) && config.TLSCAFile == "" && config.TLSCertFile == "" {
+		return nil, nil
+	}
+	// let config pick default root CA unless a CA bundle is given
+	tlsConfig := &tls.Config{
+		ServerName: config.TLSServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.TLSCAFile != "" {
+		pem, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %w", config.TLSCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in CA file %s", config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+			return nil, fmt.Errorf("both KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE are required for client authentication")
+		}
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Returns SASL mechanism and TLS configuration, either can be nil
+func getSecurity(config *KafkaConfig) (sasl.Mechanism, *tls.Config, error) {
+	mechanism, err := getMechanism(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig, err := getTLSConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mechanism, tlsConfig, nil
+}
+
+func logSecurity(logger *logrus.Logger, config *KafkaConfig, mechanism sasl.Mechanism, tlsConfig *tls.Config) {
+	fields := logrus.Fields{
+		"tls":         tlsConfig != nil,
+		"ca_file":     config.TLSCAFile,
+		"client_cert": config.TLSCertFile,
+	}
+	if mechanism != nil {
+		fields["mechanism"] = mechanism.Name()
+		fields["user"] = config.ClientID
+	}
+	logger.WithFields(fields).Printf("using secure connection")
+}
@@ -0,0 +1,156 @@
+package stream
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+func writePEM(filename string, blockType string, der []byte) {
+	content := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	Expect(os.WriteFile(filename, content, 0600)).To(Succeed())
+}
+
+// writes a self signed CA and a client certificate signed by it, returns
+// CA, certificate and key filenames
+func generateCertificates(dir string) (string, string, string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	Expect(err).To(BeNil())
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caTemplate, &clientKey.PublicKey, caKey)
+	Expect(err).To(BeNil())
+	clientKeyDER, err := x509.MarshalECPrivateKey(clientKey)
+	Expect(err).To(BeNil())
+
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	writePEM(caFile, "CERTIFICATE", caDER)
+	writePEM(certFile, "CERTIFICATE", clientDER)
+	writePEM(keyFile, "EC PRIVATE KEY", clientKeyDER)
+	return caFile, certFile, keyFile
+}
+
+var _ = Describe("Kafka connection security", func() {
+	var (
+		config *KafkaConfig
+		tmpdir string
+	)
+	BeforeEach(func() {
+		var err error
+		config = &KafkaConfig{
+			BootstrapServer: "localhost:9092",
+			SaslMechanism:   SaslMechanismPlain,
+		}
+		tmpdir, err = os.MkdirTemp("", ".certs-")
+		Expect(err).To(BeNil())
+	})
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+	When("neither credentials nor TLS are configured", func() {
+		It("should connect in plain text", func() {
+			mechanism, tlsConfig, err := getSecurity(config)
+			Expect(err).To(BeNil())
+			Expect(mechanism).To(BeNil())
+			Expect(tlsConfig).To(BeNil())
+
+			producer, err := newProducer(config, "foobar")
+			Expect(err).To(BeNil())
+			Expect(producer.(*kafka.Writer).Transport).To(BeNil())
+		})
+	})
+	When("credentials are configured", func() {
+		BeforeEach(func() {
+			config.ClientID = "user"
+			config.ClientSecret = "secret"
+		})
+		DescribeTable("should pick the SASL mechanism",
+			func(configured string, expected string) {
+				config.SaslMechanism = configured
+				mechanism, tlsConfig, err := getSecurity(config)
+				Expect(err).To(BeNil())
+				Expect(mechanism.Name()).To(Equal(expected))
+				Expect(tlsConfig).NotTo(BeNil())
+			},
+			Entry("plain", "PLAIN", "PLAIN"),
+			Entry("scram defaults to SHA-512", "SCRAM", "SCRAM-SHA-512"),
+			Entry("scram SHA-512", "SCRAM-SHA-512", "SCRAM-SHA-512"),
+			Entry("scram SHA-256", "scram-sha-256", "SCRAM-SHA-256"),
+		)
+		It("should fail with an unknown mechanism", func() {
+			config.SaslMechanism = "GSSAPI"
+			_, _, err := getSecurity(config)
+			Expect(err).To(MatchError(ContainSubstring("GSSAPI")))
+		})
+	})
+	When("TLS is enabled without credentials", func() {
+		It("should use TLS without SASL", func() {
+			config.TLSEnabled = true
+			config.TLSServerName = "kafka.example.com"
+			mechanism, tlsConfig, err := getSecurity(config)
+			Expect(err).To(BeNil())
+			Expect(mechanism).To(BeNil())
+			Expect(tlsConfig.ServerName).To(Equal("kafka.example.com"))
+			Expect(tlsConfig.RootCAs).To(BeNil())
+
+			producer, err := newProducer(config, "foobar")
+			Expect(err).To(BeNil())
+			transport := producer.(*kafka.Writer).Transport.(*kafka.Transport)
+			Expect(transport.SASL).To(BeNil())
+			Expect(transport.TLS).To(Equal(tlsConfig))
+		})
+	})
+	When("mutual TLS is configured", func() {
+		It("should load the CA bundle and the client certificate", func() {
+			config.TLSCAFile, config.TLSCertFile, config.TLSKeyFile = generateCertificates(tmpdir)
+			tlsConfig, err := getTLSConfig(config)
+			Expect(err).To(BeNil())
+			Expect(tlsConfig.RootCAs).NotTo(BeNil())
+			Expect(tlsConfig.Certificates).To(HaveLen(1))
+		})
+		It("should fail when the key is missing", func() {
+			_, config.TLSCertFile, _ = generateCertificates(tmpdir)
+			_, err := getTLSConfig(config)
+			Expect(err).To(MatchError(ContainSubstring("KAFKA_TLS_KEY_FILE")))
+		})
+		It("should fail when the CA bundle has no certificates", func() {
+			config.TLSCAFile = filepath.Join(tmpdir, "empty.crt")
+			Expect(os.WriteFile(config.TLSCAFile, []byte("not a certificate"), 0600)).To(Succeed())
+			_, err := getTLSConfig(config)
+			Expect(err).To(MatchError(ContainSubstring("no valid certificates")))
+		})
+	})
+})
@@ -2,8 +2,6 @@ package stream
 
 import (
 	"context"
-	"crypto/tls"
-	"encoding/json"
 	"strings"
 	"time"
 
@@ -26,72 +24,137 @@ type Producer interface {
 }
 
 type EventStreamWriter interface {
-	Write(ctx context.Context, key []byte, value interface{}) error
+	Write(ctx context.Context, key []byte, value interface{}, headers ...kafka.Header) error
+	// Encodes value the way Write does, to be written later with WriteEncoded
+	Encode(ctx context.Context, value interface{}) ([]byte, error)
+	// Writes a value returned by Encode as is
+	WriteEncoded(ctx context.Context, key []byte, encodedValue []byte, headers ...kafka.Header) error
+	// Media type of encoded values
+	ContentType() string
 	Close()
 }
 
 type KafkaWriter struct {
 	producer Producer
 	logger   *logrus.Logger
+	codec    Codec
+	// when set, values larger than the threshold are offloaded to it
+	blobStore           BlobStore
+	claimCheckThreshold int
 }
 
-func (w *KafkaWriter) Write(ctx context.Context, key []byte, value interface{}) error {
-	encodedValue, err := json.Marshal(value)
+// Writes value encoded with the writer codec, json by default, with the given
+// headers. Trace context carried by ctx is added as headers, unless already given
+func (w *KafkaWriter) Write(ctx context.Context, key []byte, value interface{}, headers ...kafka.Header) error {
+	encodedValue, err := w.Encode(ctx, value)
+	if err != nil {
+		return err
+	}
+	return w.WriteEncoded(ctx, key, encodedValue, headers...)
+}
+
+func (w *KafkaWriter) Encode(ctx context.Context, value interface{}) ([]byte, error) {
+	codec := w.valueCodec()
+	encodedValue, err := codec.Marshal(ctx, value)
 	if err != nil {
 		w.logger.WithError(err).WithFields(logrus.Fields{
 			"value": value,
-		}).Error("failed to encode json")
+			"codec": codec.Name(),
+		}).Error("failed to encode value")
 
-		return err
+		return nil, err
+	}
+	return encodedValue, nil
+}
+
+func (w *KafkaWriter) WriteEncoded(ctx context.Context, key []byte, encodedValue []byte, headers ...kafka.Header) error {
+	var err error
+	if w.blobStore != nil && len(encodedValue) > w.claimCheckThreshold {
+		size := len(encodedValue)
+		encodedValue, err = checkIn(ctx, w.blobStore, encodedValue)
+		if err != nil {
+			w.logger.WithError(err).WithField("size", size).Error("failed to offload value")
+			return err
+		}
+		headers = append(append([]kafka.Header{}, headers...), kafka.Header{Key: HeaderClaimCheck, Value: []byte(digestAlgorithm)})
+		w.logger.WithFields(logrus.Fields{
+			"key":  string(key),
+			"size": size,
+		}).Debug("value offloaded to blob store")
 	}
 	msg := kafka.Message{
-		Key:   key,
-		Value: encodedValue,
+		Key:     key,
+		Value:   encodedValue,
+		Headers: withTraceContext(ctx, headers),
 	}
 
-	w.logger.WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"msg": msg,
-	}).Warning("sending notification")
-
+	}
 	if kafkaWriter, ok := w.producer.(*kafka.Writer); ok {
-		w.logger.WithFields(logrus.Fields{
-			"msg":   msg,
-			"topic": kafkaWriter.Topic,
-		}).Warning("sending notification")
-
+		fields["topic"] = kafkaWriter.Topic
 	}
+	w.logger.WithFields(fields).Debug("sending notification")
+
 	// If Async is true, this will always return nil
 	return w.producer.WriteMessages(ctx, msg)
 }
 
+func (w *KafkaWriter) ContentType() string {
+	return w.valueCodec().ContentType()
+}
+
+func (w *KafkaWriter) valueCodec() Codec {
+	if w.codec == nil {
+		return &JSONCodec{}
+	}
+	return w.codec
+}
+
+func withTraceContext(ctx context.Context, headers []kafka.Header) []kafka.Header {
+	traceContext, ok := TraceContextFromContext(ctx)
+	if !ok || hasHeader(headers, HeaderTraceParent) {
+		return headers
+	}
+	return append(append([]kafka.Header{}, headers...), traceContext.headers()...)
+}
+
 func (w *KafkaWriter) Close() {
 	w.producer.Close()
 }
 
-func newProducer(config *KafkaConfig) (Producer, error) {
+func newProducer(config *KafkaConfig, topic string) (Producer, error) {
 	brokers := strings.Split(config.BootstrapServer, ",")
 	writer := &kafka.Writer{
 		Addr:         kafka.TCP(brokers...),
-		Topic:        config.DestinationTopic,
+		Topic:        topic,
 		Balancer:     &kafka.ReferenceHash{},
 		Compression:  compress.Gzip,
 		Async:        false,
 		WriteTimeout: WriteTimeout,
 	}
-	mechanism, err := getMechanism(config)
+	mechanism, tlsConfig, err := getSecurity(config)
 	if err != nil {
 		return nil, err
 	}
-	if mechanism != nil {
+	if mechanism != nil || tlsConfig != nil {
 		writer.Transport = &kafka.Transport{
 			SASL: mechanism,
-			// let config pick default root CA, but define it to force TLS
-			TLS: &tls.Config{},
+			TLS:  tlsConfig,
 		}
 	}
 	return writer, nil
 }
 
+// Creates a json writer producing to the given producer, i.e. a MemoryProducer
+func NewKafkaWriterFromProducer(logger *logrus.Logger, producer Producer) *KafkaWriter {
+	return &KafkaWriter{
+		producer: producer,
+		logger:   logger,
+		codec:    &JSONCodec{},
+	}
+}
+
 func NewWriter(logger *logrus.Logger) (*KafkaWriter, error) {
 	config := &KafkaConfig{}
 	err := envconfig.Process("", config)
@@ -99,12 +162,23 @@ func NewWriter(logger *logrus.Logger) (*KafkaWriter, error) {
 		return nil, err
 	}
 
-	p, err := newProducer(config)
+	p, err := newProducer(config, config.DestinationTopic)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := NewCodecFromEnv(config.DestinationTopic)
+	if err != nil {
+		return nil, err
+	}
+	blobStore, err := newBlobStore(config)
 	if err != nil {
 		return nil, err
 	}
 	return &KafkaWriter{
-		producer: p,
-		logger:   logger,
+		producer:            p,
+		logger:              logger,
+		codec:               codec,
+		blobStore:           blobStore,
+		claimCheckThreshold: config.ClaimCheckThresholdBytes,
 	}, nil
 }